@@ -13,11 +13,19 @@ func useFlags(cfg *app.Config) error {
 		ServerAddress   string
 		FileStoragePath string
 		DatabaseDSN     string
+		TLSCertFile     string
+		TLSKeyFile      string
+		RedirectAddress string
+		GRPCAddress     string
 	}{}
 	flag.StringVar(&flagConfig.ServerAddress, "a", "", "Server listen address in the form of host:port")
 	flag.StringVar(&flagConfig.BaseURL, "b", "", "Base URL for short links")
 	flag.StringVar(&flagConfig.FileStoragePath, "f", "", "File path to persistent URL database storage")
 	flag.StringVar(&flagConfig.DatabaseDSN, "d", "", "Database connection DSN")
+	flag.StringVar(&flagConfig.TLSCertFile, "tls-cert", "", "TLS certificate file; enables HTTPS together with -tls-key")
+	flag.StringVar(&flagConfig.TLSKeyFile, "tls-key", "", "TLS private key file")
+	flag.StringVar(&flagConfig.RedirectAddress, "http-redirect", "", "Listen address for HTTP to HTTPS redirects")
+	flag.StringVar(&flagConfig.GRPCAddress, "grpc", "", "gRPC server listen address in the form of host:port")
 	flag.Parse()
 	// Указанные значения настроек из CLI-аргументов имеют преимущество перед одноименными environment переменными
 	if flagConfig.BaseURL != "" {
@@ -36,5 +44,17 @@ func useFlags(cfg *app.Config) error {
 	if flagConfig.DatabaseDSN != "" {
 		cfg.DatabaseDSN = flagConfig.DatabaseDSN
 	}
+	if flagConfig.TLSCertFile != "" {
+		cfg.TLSCertFile = flagConfig.TLSCertFile
+	}
+	if flagConfig.TLSKeyFile != "" {
+		cfg.TLSKeyFile = flagConfig.TLSKeyFile
+	}
+	if flagConfig.RedirectAddress != "" {
+		cfg.HTTPRedirectAddress = flagConfig.RedirectAddress
+	}
+	if flagConfig.GRPCAddress != "" {
+		cfg.GRPCAddress = flagConfig.GRPCAddress
+	}
 	return nil
 }
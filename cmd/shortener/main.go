@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 
 	"github.com/sergeii/practikum-go-url-shortener/internal/app"
 	"github.com/sergeii/practikum-go-url-shortener/internal/router"
+	"github.com/sergeii/practikum-go-url-shortener/internal/rpc"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/http/server"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
@@ -15,14 +22,72 @@ func main() {
 		log.Fatalf("failed to init app due to %s\n", err)
 	}
 	defer shortener.Close()
+	// записи вне контекста запросов, в том числе через стандартный log, пишем тем же логгером
+	logging.SetDefault(shortener.Logger)
 
 	rtr := router.New(shortener)
 	svr := &http.Server{
 		Addr:    shortener.Config.ServerAddress,
 		Handler: rtr,
 	}
-	err = server.Start(svr, server.WithShutdownTimeout(shortener.Config.ServerShutdownTimeout))
+	opts := []server.Option{
+		server.WithLogger(shortener.Logger),
+		server.WithShutdownTimeout(shortener.Config.ServerShutdownTimeout),
+		server.WithDrainHook(shortener.Health.SetShuttingDown),
+		server.WithDrainDelay(shortener.Config.ServerDrainDelay),
+		server.WithHTTP2(server.HTTP2Settings{
+			Disabled:             !shortener.Config.HTTP2Enabled,
+			MaxConcurrentStreams: shortener.Config.HTTP2MaxConcurrentStreams,
+			IdleTimeout:          shortener.Config.HTTP2IdleTimeout,
+		}),
+	}
+	if shortener.Config.TLSCertFile != "" {
+		opts = append(opts, server.WithTLS(shortener.Config.TLSCertFile, shortener.Config.TLSKeyFile))
+	}
+	if shortener.Config.HTTPRedirectAddress != "" {
+		opts = append(opts, server.WithRedirectFromHTTP(shortener.Config.HTTPRedirectAddress))
+	}
+	if shortener.Config.GRPCAddress != "" {
+		grpcServer, err := startGRPC(shortener)
+		if err != nil {
+			shortener.Logger.WithError(err).Fatal("failed to start grpc server")
+		}
+		// gRPC сервер останавливаем раньше приложения, чтобы текущие вызовы успели завершиться
+		opts = append(opts, server.WithShutdownHook(func(ctx context.Context) error {
+			return rpc.GracefulStop(ctx, grpcServer)
+		}))
+	}
+	opts = append(opts, server.WithShutdownHook(shortener.Shutdown))
+	err = server.Start(svr, opts...)
+	if err != nil {
+		shortener.Logger.WithError(err).Error("server exited prematurely")
+	}
+}
+
+// startGRPC запускает gRPC сервер в фоне. При настроенном HTTPS gRPC использует тот же сертификат
+func startGRPC(shortener *app.App) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if shortener.Config.TLSCertFile != "" {
+		reloader, err := server.NewCertReloader(shortener.Config.TLSCertFile, shortener.Config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		reloader.WithLogger(shortener.Logger.WithField("server", "grpc"))
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		})))
+	}
+	listener, err := net.Listen("tcp", shortener.Config.GRPCAddress)
 	if err != nil {
-		log.Printf("Server exited prematurely: %s\n", err)
+		return nil, err
 	}
+	grpcServer := rpc.New(shortener, opts...)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			shortener.Logger.WithError(err).Error("grpc server exited prematurely")
+		}
+	}()
+	shortener.Logger.WithField("address", shortener.Config.GRPCAddress).Info("started grpc server")
+	return grpcServer, nil
 }
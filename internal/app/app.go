@@ -2,43 +2,156 @@ package app
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sergeii/practikum-go-url-shortener/internal/health"
+	"github.com/sergeii/practikum-go-url-shortener/internal/imports"
+	"github.com/sergeii/practikum-go-url-shortener/internal/jobs"
+	"github.com/sergeii/practikum-go-url-shortener/internal/metrics"
+	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/internal/tracing"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/oidc"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/keyring"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/url/shortener"
 	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/sirupsen/logrus"
 )
 
 const SecretKeyLength = 32
 
+// Суффиксы файлов с учетными записями и рабочими пространствами, хранящихся рядом с файлом ссылок
+const (
+	UsersFileSuffix      = ".users"
+	WorkspacesFileSuffix = ".workspaces"
+	APIKeysFileSuffix    = ".apikeys"
+)
+
+const (
+	QueueMemory   = "memory"
+	QueueDatabase = "database"
+)
+
+var ErrUnknownQueue = errors.New("unknown background queue type")
+var ErrQueueRequiresDatabase = errors.New("database background queue requires database dsn")
+var ErrInvalidQueueConfig = errors.New("invalid background queue config")
+var ErrInvalidTLSConfig = errors.New("invalid TLS config")
+var ErrInvalidOIDCConfig = errors.New("invalid oidc config")
+var ErrInvalidCookiePolicy = errors.New("invalid auth cookie policy")
+
 type Config struct {
-	BaseURL                     url.URL       `env:"BASE_URL" envDefault:"http://localhost:8080/"`
-	ServerAddress               string        `env:"SERVER_ADDRESS" envDefault:"localhost:8080"`
-	ServerShutdownTimeout       time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT" envDefault:"5s"`
-	FileStoragePath             string        `env:"FILE_STORAGE_PATH"`
+	BaseURL               url.URL       `env:"BASE_URL" envDefault:"http://localhost:8080/"`
+	ServerAddress         string        `env:"SERVER_ADDRESS" envDefault:"localhost:8080"`
+	ServerShutdownTimeout time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT" envDefault:"5s"`
+	// сколько ждать после сигнала остановки, прежде чем перестать принимать запросы,
+	// чтобы балансировщик успел увидеть неготовность сервиса
+	ServerDrainDelay   time.Duration `env:"SERVER_DRAIN_DELAY" envDefault:"0s"`
+	HealthCheckTimeout time.Duration `env:"HEALTH_CHECK_TIMEOUT" envDefault:"1s"`
+	// при указании сертификата и ключа сервер работает по HTTPS
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+	// адрес дополнительного HTTP сервера, перенаправляющего запросы на HTTPS
+	HTTPRedirectAddress       string        `env:"HTTP_REDIRECT_ADDRESS"`
+	HTTP2Enabled              bool          `env:"HTTP2_ENABLED" envDefault:"true"`
+	HTTP2MaxConcurrentStreams uint32        `env:"HTTP2_MAX_CONCURRENT_STREAMS" envDefault:"250"`
+	HTTP2IdleTimeout          time.Duration `env:"HTTP2_IDLE_TIMEOUT" envDefault:"2m"`
+	// адрес gRPC сервера; пустое значение отключает gRPC API
+	GRPCAddress     string `env:"GRPC_ADDRESS" envDefault:"localhost:3200"`
+	FileStoragePath string `env:"FILE_STORAGE_PATH"`
+	// секретный ключ в виде hex-строки, либо набор ключей id1:hex1,id2:hex2, где первый ключ подписывает токены,
+	// а остальные принимаются при проверке. Файл с ключами (по одному id:hex на строку) имеет приоритет
 	SecretKey                   string        `env:"SECRET_KEY"`
+	SecretKeysFile              string        `env:"SECRET_KEYS_FILE"`
 	DatabaseDSN                 string        `env:"DATABASE_DSN"`
 	DatabaseConnectTimeout      time.Duration `env:"DATABASE_CONNECT_TIMEOUT" envDefault:"1s"`
 	DatabaseQueryTimeout        time.Duration `env:"DATABASE_QUERY_TIMEOUT" envDefault:"1s"`
 	BackgroundWorkerConcurrency int           `env:"BACKGROUND_WORKER_CONCURRENCY" envDefault:"1"`
 	BackgroundJobTimeout        time.Duration `env:"BACKGROUND_JOB_TIMEOUT" envDefault:"1s"`
 	BackgroundEnqueueTimeout    time.Duration `env:"BACKGROUND_ENQUEUE_TIMEOUT" envDefault:"2s"`
+	BackgroundQueue             string        `env:"BACKGROUND_QUEUE" envDefault:"memory"`
+	BackgroundPollInterval      time.Duration `env:"BACKGROUND_POLL_INTERVAL" envDefault:"1s"`
+	BackgroundMaxZombies        int           `env:"BACKGROUND_MAX_ZOMBIES" envDefault:"100"`
+	// именованные очереди пула в формате name:concurrency[:priority]
+	BackgroundQueues           []string      `env:"BACKGROUND_QUEUES" envSeparator:"," envDefault:"deletes:1:10,imports:1:0"`
+	DeleteBatchSize            int           `env:"DELETE_BATCH_SIZE" envDefault:"1000"`
+	DeleteBatchWindow          time.Duration `env:"DELETE_BATCH_WINDOW" envDefault:"50ms"`
+	BackgroundJobHistorySize   int           `env:"BACKGROUND_JOB_HISTORY_SIZE" envDefault:"1000"`
+	BackgroundDeadJobsSize     int           `env:"BACKGROUND_DEAD_JOBS_SIZE" envDefault:"1000"`
+	BackgroundRetryMaxAttempts int           `env:"BACKGROUND_RETRY_MAX_ATTEMPTS" envDefault:"5"`
+	BackgroundRetryBackoff     time.Duration `env:"BACKGROUND_RETRY_BACKOFF" envDefault:"200ms"`
+	BackgroundRetryMaxBackoff  time.Duration `env:"BACKGROUND_RETRY_MAX_BACKOFF" envDefault:"30s"`
+	BackgroundRetryJitter      float64       `env:"BACKGROUND_RETRY_JITTER" envDefault:"0.2"`
+	ImportChunkSize            int           `env:"IMPORT_CHUNK_SIZE" envDefault:"1000"`
+	ImportJobTimeout           time.Duration `env:"IMPORT_JOB_TIMEOUT" envDefault:"10m"`
+	ImportMaxBodySize          int64         `env:"IMPORT_MAX_BODY_SIZE" envDefault:"104857600"`
+	ImportHistorySize          int           `env:"IMPORT_HISTORY_SIZE" envDefault:"1000"`
+	LogLevel                   string        `env:"LOG_LEVEL" envDefault:"info"`
+	LogFormat                  string        `env:"LOG_FORMAT" envDefault:"json"`
+	// экспортер трейсов: none, otlp или memory (для тестов)
+	TracingExporter    string  `env:"TRACING_EXPORTER" envDefault:"none"`
+	TracingEndpoint    string  `env:"TRACING_OTLP_ENDPOINT" envDefault:"localhost:4318"`
+	TracingInsecure    bool    `env:"TRACING_OTLP_INSECURE" envDefault:"false"`
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+	// срок действия токенов пользователей и за сколько до его истечения токен перевыпускается
+	AuthTokenTTL           time.Duration `env:"AUTH_TOKEN_TTL" envDefault:"720h"`
+	AuthTokenRefreshBefore time.Duration `env:"AUTH_TOKEN_REFRESH_BEFORE" envDefault:"168h"`
+	// принимать ли бессрочные куки прежнего формата, заменяя их токенами, и до какого момента (RFC 3339)
+	AuthAcceptLegacyCookies bool      `env:"AUTH_ACCEPT_LEGACY_COOKIES" envDefault:"false"`
+	AuthLegacyCookiesUntil  time.Time `env:"AUTH_LEGACY_COOKIES_UNTIL"`
+	// атрибуты авторизационной куки; Secure по умолчанию (auto) устанавливается, если BaseURL использует https
+	AuthCookieDomain   string `env:"AUTH_COOKIE_DOMAIN"`
+	AuthCookiePath     string `env:"AUTH_COOKIE_PATH" envDefault:"/"`
+	AuthCookieSecure   string `env:"AUTH_COOKIE_SECURE" envDefault:"auto"`
+	AuthCookieHTTPOnly bool   `env:"AUTH_COOKIE_HTTP_ONLY" envDefault:"true"`
+	AuthCookieSameSite string `env:"AUTH_COOKIE_SAME_SITE" envDefault:"lax"`
+	// вход через провайдер OIDC (SSO); выключен, если не задан OIDC_ISSUER
+	OIDCIssuer       string `env:"OIDC_ISSUER"`
+	OIDCClientID     string `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string `env:"OIDC_CLIENT_SECRET"`
+	// адрес возврата от провайдера; по умолчанию - BaseURL с путем auth/oidc/callback
+	OIDCRedirectURL string        `env:"OIDC_REDIRECT_URL"`
+	OIDCScopes      []string      `env:"OIDC_SCOPES" envDefault:"openid,email"`
+	OIDCTimeout     time.Duration `env:"OIDC_TIMEOUT" envDefault:"10s"`
 }
 
 type App struct {
-	Config    *Config
-	Storage   storage.URLStorer
-	Shortener shortener.Shortener
-	DB        *pgxpool.Pool
-	Jobs      *background.Pool
-	SecretKey []byte
+	Config      *Config
+	Logger      *logrus.Logger
+	Storage     storage.URLStorer
+	APIKeys     storage.APIKeyStorer
+	Users       storage.UserStorer
+	Workspaces  storage.WorkspaceStorer
+	Shortener   shortener.Shortener
+	DB          *pgxpool.Pool
+	Jobs        background.Queue
+	LocalJobs   *background.Pool // пул для джобов, привязанных к текущему процессу (например, к локальным файлам)
+	RetryPolicy background.RetryPolicy
+	// Deleter удаляет ссылки пользователей, по возможности объединяя запросы разных пользователей в пачки
+	Deleter   jobs.URLDeleter
+	coalescer *jobs.DeleteCoalescer
+	Metrics   *metrics.Metrics
+	Tracing   *tracing.Tracing
+	// Health проверяет готовность сервиса принимать запросы
+	Health *health.Checker
+	// Scheduler ставит в очередь повторяющиеся джобы обслуживания
+	Scheduler *background.Scheduler
+	Imports   *imports.Registry
+	// Keys - ключи для подписи токенов пользователей
+	Keys *keyring.Keyring
+	// Tokens выпускает и проверяет токены пользователей
+	Tokens *middleware.Tokens
+	// OIDC - провайдер для входа через SSO; nil, если вход не настроен
+	OIDC *oidc.Provider
 }
 
 type Override func(*Config) error
@@ -57,6 +170,23 @@ func New(overrides ...Override) (*App, error) {
 		}
 	}
 
+	if err := validateTLS(&cfg); err != nil {
+		return nil, err
+	}
+	cookiePolicy, err := configureCookiePolicy(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider, err := configureOIDC(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure logger due to %w", err)
+	}
+
 	if cfg.DatabaseDSN != "" {
 		pgpool, err := configureDatabase(&cfg)
 		if err != nil {
@@ -65,72 +195,351 @@ func New(overrides ...Override) (*App, error) {
 		db = pgpool
 	}
 
-	store, err := configureStorage(&cfg, db)
+	appMetrics := metrics.New()
+	if db != nil {
+		appMetrics.RegisterDB(db)
+	}
+
+	store, err := configureStorage(&cfg, db, logger)
 	if err != nil {
 		return nil, fmt.Errorf("unable to configure storage due to %w", err)
 	}
+	appTracing, err := tracing.New(context.Background(), tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		Insecure:    cfg.TracingInsecure,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure tracing due to %w", err)
+	}
+	backendName := storageBackendName(&cfg, db)
+	store = appTracing.InstrumentStorage(appMetrics.InstrumentStorage(store, backendName), backendName)
 
-	secretKey, err := configureSecretKey(&cfg)
+	apiKeys, err := configureAPIKeys(&cfg, db)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure api key storage due to %w", err)
+	}
+
+	users, err := configureUsers(&cfg, db)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure user storage due to %w", err)
+	}
+
+	workspaces, err := configureWorkspaces(&cfg, db)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure workspace storage due to %w", err)
+	}
+
+	keys, err := configureKeyring(&cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to configure secret key due to %w", err)
 	}
+	if keys.IsEphemeral() {
+		logger.Warn(
+			"!!! no SECRET_KEY or SECRET_KEYS_FILE configured, using a random key: " +
+				"all user tokens will be invalidated on restart and will not be accepted by other replicas !!!",
+		)
+	}
+
+	retryPolicy := configureRetryPolicy(&cfg)
+	var deleter jobs.URLDeleter = store
+	coalescer := configureDeleteCoalescer(&cfg, store)
+	if coalescer != nil {
+		deleter = coalescer
+	}
+	localJobs, err := configureJobPool(&cfg, appMetrics, appTracing, logger)
+	if err != nil {
+		if coalescer != nil {
+			coalescer.Close()
+		}
+		return nil, fmt.Errorf("unable to configure job pool due to %w", err)
+	}
+	jobQueue, err := configureJobQueue(&cfg, db, deleter, retryPolicy, localJobs, appMetrics, appTracing, logger)
+	if err != nil {
+		localJobs.Close()
+		if coalescer != nil {
+			coalescer.Close()
+		}
+		return nil, fmt.Errorf("unable to configure job queue due to %w", err)
+	}
 
 	app := &App{
-		Storage:   store,
-		Shortener: shortener.NewRandShortener(),
-		Config:    &cfg,
-		DB:        db,
-		Jobs:      configureJobPool(&cfg),
-		SecretKey: secretKey,
+		Storage:     store,
+		APIKeys:     apiKeys,
+		Users:       users,
+		Workspaces:  workspaces,
+		Shortener:   shortener.NewRandShortener(),
+		Config:      &cfg,
+		Logger:      logger,
+		DB:          db,
+		Jobs:        jobQueue,
+		LocalJobs:   localJobs,
+		RetryPolicy: retryPolicy,
+		Deleter:     deleter,
+		coalescer:   coalescer,
+		Imports:     imports.NewRegistry(cfg.ImportHistorySize),
+		Keys:        keys,
+		Tokens: middleware.NewTokens(
+			keys,
+			middleware.WithTokenTTL(cfg.AuthTokenTTL),
+			middleware.WithTokenRefresh(cfg.AuthTokenRefreshBefore),
+			middleware.WithLegacyCookies(cfg.AuthAcceptLegacyCookies),
+			middleware.WithLegacyCookiesUntil(cfg.AuthLegacyCookiesUntil),
+			middleware.WithCookiePolicy(cookiePolicy),
+		),
+		OIDC:    oidcProvider,
+		Metrics: appMetrics,
+		Tracing: appTracing,
+	}
+	appMetrics.RegisterQueue(localJobs, jobQueue)
+	scheduler, err := configureScheduler(&cfg, db, jobQueue)
+	if err != nil {
+		app.Close()
+		return nil, fmt.Errorf("unable to configure scheduler due to %w", err)
 	}
+	app.Scheduler = scheduler.WithLogger(logger)
+	app.Scheduler.Start()
+	app.Health = configureHealth(&cfg, app)
 	return app, nil
 }
 
 func (app *App) Cleanup() {
 	app.Storage.Cleanup()
+	app.APIKeys.Cleanup()
+	app.Users.Cleanup()
+	app.Workspaces.Cleanup()
+}
+
+// Shutdown корректно останавливает фоновые задачи, давая принятым джобам завершиться до истечения контекста
+func (app *App) Shutdown(ctx context.Context) error {
+	app.Scheduler.Close()
+	queues := []background.Queue{app.Jobs}
+	if app.Jobs != background.Queue(app.LocalJobs) {
+		queues = append(queues, app.LocalJobs)
+	}
+	var shutdownErr error
+	for _, queue := range queues {
+		abandoned, err := queue.Shutdown(ctx)
+		if abandoned > 0 {
+			app.Logger.WithField("abandoned", abandoned).Warn("abandoned background jobs on shutdown")
+		}
+		if err != nil {
+			shutdownErr = fmt.Errorf("failed to drain background jobs due to %w", err)
+		}
+	}
+	// спаны, накопленные в том числе джобами, завершившимися при остановке, отправляются в Close
+	return shutdownErr
 }
 
 func (app *App) Close() {
-	// приостанавливаем выполнение фоновых задач
+	// приостанавливаем постановку и выполнение фоновых задач
+	if app.Scheduler != nil {
+		app.Scheduler.Close()
+	}
 	app.Jobs.Close()
+	if app.Jobs != background.Queue(app.LocalJobs) {
+		app.LocalJobs.Close()
+	}
+	// сбрасываем накопленные запросы на удаление
+	if app.coalescer != nil {
+		app.coalescer.Close()
+	}
 	// корректно завершаем работу с хранилищем
 	if err := app.Storage.Close(); err != nil {
-		log.Printf("failed to close storage %s due to %s; possible data loss", app.Storage, err)
+		app.Logger.WithError(err).Error("failed to close storage; possible data loss")
 	}
 	// закрываем подключения к бд
 	if app.DB != nil {
 		app.DB.Close()
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), app.Config.ServerShutdownTimeout)
+	defer cancel()
+	if err := app.Tracing.Shutdown(ctx); err != nil {
+		app.Logger.WithError(err).Error("failed to flush traces")
+	}
+}
+
+// validateTLS проверяет, что сертификат и ключ указаны вместе,
+// а перенаправление на HTTPS включено только при работе сервера по HTTPS
+func validateTLS(cfg *Config) error {
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("%w: both certificate and key files are required", ErrInvalidTLSConfig)
+	}
+	if cfg.HTTPRedirectAddress != "" && cfg.TLSCertFile == "" {
+		return fmt.Errorf("%w: redirect to HTTPS requires certificate", ErrInvalidTLSConfig)
+	}
+	return nil
+}
+
+// configureCookiePolicy собирает атрибуты авторизационной куки. Если не указано иное,
+// кука получает флаг Secure при работе сервиса по HTTPS
+func configureCookiePolicy(cfg *Config) (middleware.CookiePolicy, error) {
+	policy := middleware.DefaultCookiePolicy(cfg.BaseURL.Scheme == "https")
+	policy.Domain = cfg.AuthCookieDomain
+	policy.Path = cfg.AuthCookiePath
+	policy.HTTPOnly = cfg.AuthCookieHTTPOnly
+	switch strings.ToLower(cfg.AuthCookieSecure) {
+	case "auto", "":
+	case "true":
+		policy.Secure = true
+	case "false":
+		policy.Secure = false
+	default:
+		return policy, fmt.Errorf("%w: unknown secure mode %s", ErrInvalidCookiePolicy, cfg.AuthCookieSecure)
+	}
+	sameSite, err := parseSameSite(cfg.AuthCookieSameSite)
+	if err != nil {
+		return policy, err
+	}
+	// браузеры отвергают куки SameSite=None без флага Secure
+	if sameSite == http.SameSiteNoneMode && !policy.Secure {
+		return policy, fmt.Errorf("%w: SameSite=None requires secure cookie", ErrInvalidCookiePolicy)
+	}
+	policy.SameSite = sameSite
+	return policy, nil
+}
+
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown SameSite mode %s", ErrInvalidCookiePolicy, value)
+	}
+}
+
+// configureHealth регистрирует проверки компонентов, от которых зависит готовность сервиса
+func configureHealth(cfg *Config, app *App) *health.Checker {
+	checker := health.NewChecker(cfg.HealthCheckTimeout)
+	checker.Register("storage", app.Storage.Ping)
+	checker.Register("jobs", func(context.Context) error {
+		return app.Jobs.CheckSaturation()
+	})
+	if app.Jobs != background.Queue(app.LocalJobs) {
+		checker.Register("local_jobs", func(context.Context) error {
+			return app.LocalJobs.CheckSaturation()
+		})
+	}
+	if app.DB != nil {
+		tables := []string{"urls", "api_keys", "users", "workspaces", "workspace_members"}
+		// таблицы очереди и расписания создаются, только если очередь хранится в бд, см. configureScheduler
+		if cfg.BackgroundQueue == QueueDatabase {
+			tables = append(tables, "jobs", "schedule_leases")
+		}
+		checker.Register("migrations", health.TablesExist(app.DB, tables...))
+	}
+	return checker
+}
+
+// storageBackendName возвращает название хранилища для метрик
+func storageBackendName(cfg *Config, db *pgxpool.Pool) string {
+	switch {
+	case db != nil:
+		return "database"
+	case cfg.FileStoragePath != "":
+		return "file"
+	default:
+		return "memory"
+	}
 }
 
 // configureStorage инициализирует тип хранилища
 // в зависимости от настроек сервиса, заданных переменными окружения
-func configureStorage(cfg *Config, db *pgxpool.Pool) (storage.URLStorer, error) {
+func configureStorage(cfg *Config, db *pgxpool.Pool, logger logrus.FieldLogger) (storage.URLStorer, error) {
 	if db != nil {
 		return storage.NewDatabaseURLStorerBackend(db, cfg.DatabaseQueryTimeout)
 	}
 	if cfg.FileStoragePath != "" {
-		return storage.NewFileURLStorerBackend(cfg.FileStoragePath)
+		return storage.NewFileURLStorerBackend(cfg.FileStoragePath, storage.WithFileLogger(logger))
 	}
 	return storage.NewLocmemURLStorerBackend(), nil
 }
 
-// configureSecretKey декодирует в слайс байт секретный ключ приложения,
-// установленный environment переменной в виде hex-строки
-// В случае отсутствия ключа, его значение генерируется рандомно
-func configureSecretKey(cfg *Config) ([]byte, error) {
-	if cfg.SecretKey != "" {
-		confKey, err := hex.DecodeString(cfg.SecretKey)
-		if err != nil {
-			return nil, err
+// configureAPIKeys выбирает хранилище ключей API по тому же принципу, что и configureUsers
+func configureAPIKeys(cfg *Config, db *pgxpool.Pool) (storage.APIKeyStorer, error) {
+	if db != nil {
+		return storage.NewDatabaseAPIKeyStorerBackend(db, cfg.DatabaseQueryTimeout)
+	}
+	if cfg.FileStoragePath != "" {
+		return storage.NewFileAPIKeyStorerBackend(cfg.FileStoragePath + APIKeysFileSuffix)
+	}
+	return storage.NewLocmemAPIKeyStorerBackend(), nil
+}
+
+// configureUsers выбирает хранилище учетных записей: в бд при ее наличии, в файле рядом с файлом ссылок
+// при хранении ссылок в файле, иначе в памяти
+func configureUsers(cfg *Config, db *pgxpool.Pool) (storage.UserStorer, error) {
+	if db != nil {
+		return storage.NewDatabaseUserStorerBackend(db, cfg.DatabaseQueryTimeout)
+	}
+	if cfg.FileStoragePath != "" {
+		return storage.NewFileUserStorerBackend(cfg.FileStoragePath + UsersFileSuffix)
+	}
+	return storage.NewLocmemUserStorerBackend(), nil
+}
+
+// configureWorkspaces выбирает хранилище рабочих пространств по тому же принципу, что и configureUsers
+func configureWorkspaces(cfg *Config, db *pgxpool.Pool) (storage.WorkspaceStorer, error) {
+	if db != nil {
+		return storage.NewDatabaseWorkspaceStorerBackend(db, cfg.DatabaseQueryTimeout)
+	}
+	if cfg.FileStoragePath != "" {
+		return storage.NewFileWorkspaceStorerBackend(cfg.FileStoragePath + WorkspacesFileSuffix)
+	}
+	return storage.NewLocmemWorkspaceStorerBackend(), nil
+}
+
+// configureOIDC настраивает вход через провайдер OIDC, если задан его адрес
+func configureOIDC(cfg *Config) (*oidc.Provider, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, nil // nolint:nilnil
+	}
+	if cfg.OIDCClientID == "" {
+		return nil, fmt.Errorf("%w: client id is required", ErrInvalidOIDCConfig)
+	}
+	if cfg.OIDCRedirectURL != "" {
+		if redirectURL, err := url.Parse(cfg.OIDCRedirectURL); err != nil || !redirectURL.IsAbs() {
+			return nil, fmt.Errorf("%w: redirect url must be absolute", ErrInvalidOIDCConfig)
 		}
-		return confKey, nil
 	}
-	randKey := make([]byte, SecretKeyLength)
-	if _, err := rand.Read(randKey); err != nil {
-		return nil, err
+	return oidc.New(oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		Scopes:       cfg.OIDCScopes,
+		HTTPClient:   &http.Client{Timeout: cfg.OIDCTimeout},
+		ClockSkew:    time.Minute,
+	}), nil
+}
+
+// OIDCCallbackURL возвращает адрес, на который провайдер OIDC возвращает пользователя после входа
+func (cfg *Config) OIDCCallbackURL() string {
+	if cfg.OIDCRedirectURL != "" {
+		return cfg.OIDCRedirectURL
+	}
+	callbackURL := &url.URL{
+		Scheme: cfg.BaseURL.Scheme,
+		Host:   cfg.BaseURL.Host,
+		Path:   strings.TrimRight(cfg.BaseURL.Path, "/") + "/auth/oidc/callback",
 	}
-	return randKey, nil
+	return callbackURL.String()
+}
+
+// configureKeyring загружает набор секретных ключей приложения из файла, либо из environment переменной
+// В случае отсутствия ключей, генерируется случайный ключ, живущий до перезапуска процесса
+func configureKeyring(cfg *Config) (*keyring.Keyring, error) {
+	if cfg.SecretKeysFile != "" {
+		return keyring.Load(cfg.SecretKeysFile)
+	}
+	if cfg.SecretKey != "" {
+		return keyring.Parse(cfg.SecretKey)
+	}
+	return keyring.Ephemeral(SecretKeyLength)
 }
 
 // configureDatabase подготавливет пул соединений для работы с базой данных
@@ -145,10 +554,117 @@ func configureDatabase(cfg *Config) (*pgxpool.Pool, error) {
 }
 
 // configureJobPool подготавливает пул для выполнения фоновых задач
-func configureJobPool(cfg *Config) *background.Pool {
+func configureJobPool(
+	cfg *Config, observer background.JobObserver, tracer background.JobTracer, logger logrus.FieldLogger,
+) (*background.Pool, error) {
+	queues, err := parseQueues(cfg.BackgroundQueues)
+	if err != nil {
+		return nil, err
+	}
 	return background.NewPool(background.PoolConfig{
-		Concurrency:   cfg.BackgroundWorkerConcurrency,
-		DoJobTimeout:  cfg.BackgroundJobTimeout,
-		AddJobTimeout: cfg.BackgroundEnqueueTimeout,
-	})
+		Concurrency:    cfg.BackgroundWorkerConcurrency,
+		DoJobTimeout:   cfg.BackgroundJobTimeout,
+		AddJobTimeout:  cfg.BackgroundEnqueueTimeout,
+		HistorySize:    cfg.BackgroundJobHistorySize,
+		DeadLetterSize: cfg.BackgroundDeadJobsSize,
+		MaxZombies:     cfg.BackgroundMaxZombies,
+		Queues:         queues,
+		Observer:       observer,
+		Tracer:         tracer,
+		Logger:         logger,
+	}), nil
+}
+
+// parseQueues разбирает описания именованных очередей вида name:concurrency[:priority]
+func parseQueues(specs []string) ([]background.QueueConfig, error) {
+	queues := make([]background.QueueConfig, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueueConfig, spec)
+		}
+		queue := background.QueueConfig{Name: parts[0]}
+		concurrency, err := strconv.Atoi(parts[1])
+		if err != nil || concurrency < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueueConfig, spec)
+		}
+		queue.Concurrency = concurrency
+		if len(parts) == 3 {
+			if queue.Priority, err = strconv.Atoi(parts[2]); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidQueueConfig, spec)
+			}
+		}
+		queues = append(queues, queue)
+	}
+	return queues, nil
+}
+
+// configureJobQueue выбирает очередь для фоновых задач: по умолчанию задачи выполняются пулом в памяти,
+// но при наличии бд их можно хранить в долговременной очереди, переживающей перезапуск сервиса
+func configureJobQueue(
+	cfg *Config, db *pgxpool.Pool, deleter jobs.URLDeleter, retry background.RetryPolicy,
+	local *background.Pool, observer background.JobObserver, tracer background.JobTracer, logger logrus.FieldLogger,
+) (background.Queue, error) {
+	switch cfg.BackgroundQueue {
+	case QueueMemory:
+		return local, nil
+	case QueueDatabase:
+		if db == nil {
+			return nil, ErrQueueRequiresDatabase
+		}
+		return background.NewDurableQueue(db, background.DurableQueueConfig{
+			Concurrency:   cfg.BackgroundWorkerConcurrency,
+			DoJobTimeout:  cfg.BackgroundJobTimeout,
+			AddJobTimeout: cfg.BackgroundEnqueueTimeout,
+			PollInterval:  cfg.BackgroundPollInterval,
+			MaxZombies:    cfg.BackgroundMaxZombies,
+			Observer:      observer,
+			Tracer:        tracer,
+			Logger:        logger,
+		}, jobs.Handlers(deleter, retry)...)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQueue, cfg.BackgroundQueue)
+	}
+}
+
+// configureScheduler создает планировщик повторяющихся джобов.
+// При очереди в бд каждый запуск по расписанию выполняет только одна из реплик сервиса.
+// Джобы очереди в памяти не видны другим репликам, поэтому в этом случае каждая реплика ведет свое расписание
+func configureScheduler(cfg *Config, db *pgxpool.Pool, queue background.Queue) (*background.Scheduler, error) {
+	var locker background.Locker
+	if db != nil && cfg.BackgroundQueue == QueueDatabase {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DatabaseQueryTimeout)
+		defer cancel()
+		dbLocker, err := background.NewDatabaseLocker(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		locker = dbLocker
+	}
+	return background.NewScheduler(queue, locker, cfg.BackgroundEnqueueTimeout)
+}
+
+// configureDeleteCoalescer включает объединение запросов на удаление ссылок в пачки,
+// если задано ненулевое окно накопления. Сброс пачки ограничен таймаутом запроса к бд
+func configureDeleteCoalescer(cfg *Config, store storage.URLStorer) *jobs.DeleteCoalescer {
+	if cfg.DeleteBatchWindow <= 0 || cfg.DeleteBatchSize <= 0 {
+		return nil
+	}
+	return jobs.NewDeleteCoalescer(store, cfg.DeleteBatchSize, cfg.DeleteBatchWindow, cfg.DatabaseQueryTimeout)
+}
+
+// configureRetryPolicy подготавливает политику повтора фоновых задач, работающих с хранилищем.
+// Повторяются только временные ошибки хранилища
+func configureRetryPolicy(cfg *Config) background.RetryPolicy {
+	return background.RetryPolicy{
+		MaxAttempts: cfg.BackgroundRetryMaxAttempts,
+		Backoff:     cfg.BackgroundRetryBackoff,
+		MaxBackoff:  cfg.BackgroundRetryMaxBackoff,
+		Jitter:      cfg.BackgroundRetryJitter,
+		Retryable:   storage.IsRetryable,
+	}
 }
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/apikey"
+)
+
+type contextKey int
+
+const userContextKey contextKey = 0
+
+const UserIDLength = 8 // in bytes
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// Разрешения ключей API. Пользователь, аутентифицированный по токену, обладает всеми разрешениями
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Типы пользователей, различающиеся способом входа. Тип записывается в токен пользователя,
+// у токенов, выпущенных до появления типов, и у кук прежнего формата тип не известен
+const (
+	UserAnonymous = "anonymous"
+	UserAccount   = "account"
+	UserSSO       = "sso"
+)
+
+// User - пользователь, от имени которого выполняется запрос, независимо от транспорта
+type User struct {
+	ID string
+	// Kind - тип пользователя; пустой, если не известен
+	Kind string
+	// APIKeyID - идентификатор ключа API, по которому аутентифицирован пользователь
+	APIKeyID string
+	// Scopes - разрешения ключа API
+	Scopes []string
+}
+
+// HasScope сообщает, разрешено ли пользователю действие
+func (user *User) HasScope(scope string) bool {
+	if user.APIKeyID == "" {
+		return true
+	}
+	for _, s := range user.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUser генерирует уникальный идентификатор пользователя
+// и возвращает анонимного пользователя с вновь созданным идентификатором
+func NewUser() (*User, error) {
+	randomID := make([]byte, UserIDLength)
+	if _, err := rand.Read(randomID); err != nil {
+		return nil, err
+	}
+	return &User{ID: hex.EncodeToString(randomID), Kind: UserAnonymous}, nil
+}
+
+// NewContext возвращает копию контекста с аутентифицированным пользователем
+func NewContext(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// FromContext возвращает пользователя, аутентифицированного в контексте запроса
+func FromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userContextKey).(*User)
+	return user, ok
+}
+
+// APIKeyVerifier проверяет предъявленный клиентом ключ API и возвращает его владельца.
+// Для неизвестного, отозванного или неверного ключа возвращает ErrInvalidAPIKey
+type APIKeyVerifier interface {
+	VerifyAPIKey(context.Context, string) (*User, error)
+}
+
+// BearerToken возвращает токен из значения заголовка Authorization вида Bearer <токен>
+func BearerToken(authorization string) (string, bool) {
+	const bearer = "Bearer "
+	if len(authorization) <= len(bearer) || !strings.EqualFold(authorization[:len(bearer)], bearer) {
+		return "", false
+	}
+	token := strings.TrimSpace(authorization[len(bearer):])
+	return token, token != ""
+}
+
+// isAPIKey сообщает, что токен является ключом API, а не токеном пользователя
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apikey.Prefix+"_")
+}
+
+// APIKeyFromAuthorization возвращает ключ API из значения заголовка Authorization вида Bearer <ключ>.
+// Ключом считается только токен с префиксом ключей API
+func APIKeyFromAuthorization(authorization string) (string, bool) {
+	token, ok := BearerToken(authorization)
+	if !ok || !isAPIKey(token) {
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,65 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUserIsAnonymous(t *testing.T) {
+	user, err := auth.NewUser()
+	require.NoError(t, err)
+	assert.Len(t, user.ID, auth.UserIDLength*2)
+	assert.Equal(t, auth.UserAnonymous, user.Kind)
+	other, _ := auth.NewUser()
+	assert.NotEqual(t, user.ID, other.ID)
+}
+
+func TestUserFromContext(t *testing.T) {
+	_, ok := auth.FromContext(context.TODO())
+	assert.False(t, ok)
+	user := &auth.User{ID: "user1"}
+	got, ok := auth.FromContext(auth.NewContext(context.TODO(), user))
+	require.True(t, ok)
+	assert.Same(t, user, got)
+}
+
+func TestUserHasScope(t *testing.T) {
+	tokenUser := &auth.User{ID: "user1"}
+	assert.True(t, tokenUser.HasScope(auth.ScopeRead))
+	assert.True(t, tokenUser.HasScope(auth.ScopeWrite))
+	keyUser := &auth.User{ID: "user1", APIKeyID: "key1", Scopes: []string{auth.ScopeRead}}
+	assert.True(t, keyUser.HasScope(auth.ScopeRead))
+	assert.False(t, keyUser.HasScope(auth.ScopeWrite))
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		token    string
+		isAPIKey bool
+	}{
+		{"user token", "Bearer foo.bar", "foo.bar", false},
+		{"case insensitive", "bearer foo.bar", "foo.bar", false},
+		{"api key", "Bearer sk_abc_def", "sk_abc_def", true},
+		{"empty token", "Bearer  ", "", false},
+		{"other scheme", "Basic Zm9vOmJhcg==", "", false},
+		{"no header", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, ok := auth.BearerToken(tt.header)
+			assert.Equal(t, tt.token, token)
+			assert.Equal(t, tt.token != "", ok)
+			key, ok := auth.APIKeyFromAuthorization(tt.header)
+			assert.Equal(t, tt.isAPIKey, ok)
+			if tt.isAPIKey {
+				assert.Equal(t, tt.token, key)
+			}
+		})
+	}
+}
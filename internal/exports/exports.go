@@ -0,0 +1,138 @@
+package exports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+	FormatCSV    Format = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// csvHeader - заголовок csv-файла, колонки которого соответствуют полям Item
+var csvHeader = []string{"short_url", "original_url", "created_at", "is_deleted"}
+
+// Item - экспортируемая ссылка пользователя
+type Item struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	IsDeleted   bool      `json:"is_deleted"`
+}
+
+// ItemWriter поточно записывает экспортируемые ссылки в выбранном формате.
+// После записи всех ссылок необходимо вызвать Close для завершения документа
+type ItemWriter interface {
+	Write(Item) error
+	Close() error
+}
+
+// NewWriter возвращает писателя для указанного формата экспорта
+func NewWriter(format Format, w io.Writer) (ItemWriter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{w: w, encoder: json.NewEncoder(w)}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvWriter{writer: csv.NewWriter(w)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// ContentType возвращает mime-тип документа для указанного формата экспорта
+func ContentType(format Format) string {
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// jsonWriter пишет ссылки в виде json-массива, не накапливая их в памяти
+type jsonWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+func (jw *jsonWriter) Write(item Item) error {
+	delim := ","
+	if jw.count == 0 {
+		delim = "["
+	}
+	if _, err := io.WriteString(jw.w, delim); err != nil {
+		return err
+	}
+	jw.count++
+	return jw.encoder.Encode(&item)
+}
+
+func (jw *jsonWriter) Close() error {
+	closing := "]\n"
+	if jw.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(jw.w, closing)
+	return err
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (nw *ndjsonWriter) Write(item Item) error {
+	return nw.encoder.Encode(&item)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (cw *csvWriter) Write(item Item) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	return cw.writer.Write([]string{
+		item.ShortURL,
+		item.OriginalURL,
+		item.CreatedAt.UTC().Format(time.RFC3339),
+		strconv.FormatBool(item.IsDeleted),
+	})
+}
+
+func (cw *csvWriter) Close() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// writeHeader пишет заголовок перед первой строкой (или в пустой документ)
+func (cw *csvWriter) writeHeader() error {
+	if cw.headerWritten {
+		return nil
+	}
+	cw.headerWritten = true
+	return cw.writer.Write(csvHeader)
+}
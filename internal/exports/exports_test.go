@@ -0,0 +1,90 @@
+package exports_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/exports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritersEncodeItems(t *testing.T) {
+	createdAt := time.Date(2022, 3, 1, 12, 30, 0, 0, time.UTC)
+	items := []exports.Item{
+		{ShortURL: "http://localhost/foo", OriginalURL: "https://go.dev/", CreatedAt: createdAt},
+		{ShortURL: "http://localhost/bar", OriginalURL: "https://ya.ru/?q=a,b", CreatedAt: createdAt, IsDeleted: true},
+	}
+	tests := []struct {
+		name   string
+		format exports.Format
+		items  []exports.Item
+		want   string
+	}{
+		{
+			name:   "json",
+			format: exports.FormatJSON,
+			items:  items,
+			want: `[{"short_url":"http://localhost/foo","original_url":"https://go.dev/",` +
+				`"created_at":"2022-03-01T12:30:00Z","is_deleted":false}` + "\n" +
+				`,{"short_url":"http://localhost/bar","original_url":"https://ya.ru/?q=a,b",` +
+				`"created_at":"2022-03-01T12:30:00Z","is_deleted":true}` + "\n]\n",
+		},
+		{
+			name:   "empty json",
+			format: exports.FormatJSON,
+			want:   "[]\n",
+		},
+		{
+			name:   "ndjson",
+			format: exports.FormatNDJSON,
+			items:  items,
+			want: `{"short_url":"http://localhost/foo","original_url":"https://go.dev/",` +
+				`"created_at":"2022-03-01T12:30:00Z","is_deleted":false}` + "\n" +
+				`{"short_url":"http://localhost/bar","original_url":"https://ya.ru/?q=a,b",` +
+				`"created_at":"2022-03-01T12:30:00Z","is_deleted":true}` + "\n",
+		},
+		{
+			name:   "empty ndjson",
+			format: exports.FormatNDJSON,
+			want:   "",
+		},
+		{
+			name:   "csv",
+			format: exports.FormatCSV,
+			items:  items,
+			want: "short_url,original_url,created_at,is_deleted\n" +
+				"http://localhost/foo,https://go.dev/,2022-03-01T12:30:00Z,false\n" +
+				"http://localhost/bar,\"https://ya.ru/?q=a,b\",2022-03-01T12:30:00Z,true\n",
+		},
+		{
+			name:   "empty csv",
+			format: exports.FormatCSV,
+			want:   "short_url,original_url,created_at,is_deleted\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := exports.NewWriter(tt.format, buf)
+			require.NoError(t, err)
+			for _, item := range tt.items {
+				require.NoError(t, writer.Write(item))
+			}
+			require.NoError(t, writer.Close())
+			assert.Equal(t, tt.want, buf.String())
+			if tt.format == exports.FormatJSON {
+				decoded := make([]exports.Item, 0)
+				assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+				assert.Len(t, decoded, len(tt.items))
+			}
+		})
+	}
+}
+
+func TestNewWriterUnknownFormat(t *testing.T) {
+	_, err := exports.NewWriter("xml", &bytes.Buffer{})
+	assert.ErrorIs(t, err, exports.ErrUnknownFormat)
+}
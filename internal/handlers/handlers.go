@@ -1,57 +1,88 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
-	"net/url"
-	"strings"
+	"os"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sergeii/practikum-go-url-shortener/internal/app"
-	"github.com/sergeii/practikum-go-url-shortener/internal/jobs"
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/exports"
+	"github.com/sergeii/practikum-go-url-shortener/internal/health"
+	"github.com/sergeii/practikum-go-url-shortener/internal/imports"
 	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/http/resp"
-	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
 )
 
+var ErrImportBodyTooLarge = errors.New("import body is too large")
+
 type Handler struct {
-	App *app.App
+	App     *app.App
+	Service *service.Service
 }
 
-func (handler Handler) constructShortURL(shortID string) *url.URL {
-	// Мы возвращаем короткую ссылку используя настройки базового URL сервиса
-	baseURL := handler.App.Config.BaseURL
-	shortURLPath := strings.TrimRight(baseURL.Path, "/") + "/" + shortID
-	return &url.URL{
-		Scheme: baseURL.Scheme,
-		Host:   baseURL.Host,
-		Path:   shortURLPath,
+// writeServiceError отвечает клиенту http-статусом, соответствующим ошибке сервиса
+func writeServiceError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrEmptyURL),
+		errors.Is(err, service.ErrEmptyBatch),
+		errors.Is(err, service.ErrInvalidShortID),
+		errors.Is(err, service.ErrInvalidScope),
+		errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrInvalidWorkspace),
+		errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrInvalidMember),
+		errors.Is(err, service.ErrUnknownMember):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrWorkspaceForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, service.ErrInvalidCredentials):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrEmailTaken),
+		errors.Is(err, service.ErrLastOwner):
+		status = http.StatusConflict
+	case errors.Is(err, service.ErrURLNotFound),
+		errors.Is(err, service.ErrJobNotFound),
+		errors.Is(err, service.ErrImportNotFound),
+		errors.Is(err, service.ErrAPIKeyNotFound),
+		errors.Is(err, service.ErrWorkspaceNotFound),
+		errors.Is(err, service.ErrMemberNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrURLDeleted):
+		status = http.StatusGone
+	case errors.Is(err, service.ErrQueueUnavailable):
+		// не удалось добавить задачу в очередь в разумное время. Очередь полна?
+		// просим клиента попробовать еще раз
+		status = http.StatusServiceUnavailable
 	}
+	http.Error(w, err.Error(), status)
 }
 
-func (handler Handler) shortenAndSaveLongURL(longURL string, r *http.Request) (*url.URL, bool, error) {
-	var userID string
-	if user, ok := r.Context().Value(middleware.AuthContextKey).(*middleware.AuthUser); ok {
-		userID = user.ID
-	}
-	created := true
-	// Получаем короткий идентификатор для ссылки и кладем пару в хранилище
-	proposedShortID := handler.App.Shortener.Shorten(longURL)
-	// При попытке сохранить уже сокращенный урл можем получить конфликт
-	// и актуальный для этой ссылки короткий идентификатор
-	actualShortID, err := handler.App.Storage.Set(r.Context(), proposedShortID, longURL, userID)
+// urlOwner возвращает владельца ссылок, с которыми работает запрос: рабочее пространство из query-параметра
+// workspace, если у пользователя в нем есть роль не ниже role, либо самого пользователя
+func (handler Handler) urlOwner(w http.ResponseWriter, r *http.Request, role string) (string, bool) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return "", false
+	}
+	owner, err := handler.Service.URLOwner(r.Context(), user.ID, r.URL.Query().Get("workspace"), role)
 	if err != nil {
-		if errors.Is(err, storage.ErrURLAlreadyExists) {
-			created = false
-		} else {
-			return nil, false, err
-		}
+		writeServiceError(w, err)
+		return "", false
 	}
-	shortURL := handler.constructShortURL(actualShortID)
-	return shortURL, created, nil
+	return owner, true
 }
 
 // ShortenURL принимает на вход произвольный URL в теле запроса и создает для него "короткую" версию,
@@ -60,30 +91,30 @@ func (handler Handler) shortenAndSaveLongURL(longURL string, r *http.Request) (*
 // В случае отстуствия валидного URL в теле запроса вернет ошибку 400
 // В случае наличия в хранилище сокращаемой ссылки возвращает статус 409
 // и ранее сокращенную ссылку в теле ответа
+// С query-параметром workspace ссылка создается в рабочем пространстве, для чего нужна роль не ниже editor
 func (handler Handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
+	owner, ok := handler.urlOwner(w, r, service.RoleEditor)
+	if !ok {
+		return
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	// Пытаемся получить длинный url из тела запроса
-	longURL := string(body)
-	if longURL == "" {
-		http.Error(w, "please provide a url to shorten", http.StatusBadRequest)
+	result, err := handler.Service.Shorten(r.Context(), owner, string(body))
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 	respStatus := http.StatusCreated
 	// В случае конфликта при сохранении отдаем статус 409 и возвращаем короткий URL для ссылки, сохраненной ранее
-	shortURL, created, err := handler.shortenAndSaveLongURL(longURL, r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if !created {
+	if !result.Created {
 		respStatus = http.StatusConflict
 	}
 	w.WriteHeader(respStatus)
-	w.Write([]byte(shortURL.String())) // nolint:errcheck
+	w.Write([]byte(result.ShortURL)) // nolint:errcheck
 }
 
 // ExpandURL перенаправляет пользователя, перешедшего по короткой ссылке, на оригинальный "длинный" URL.
@@ -92,23 +123,10 @@ func (handler Handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 func (handler Handler) ExpandURL(w http.ResponseWriter, r *http.Request) {
 	// Пытаемся получить id короткой ссылки из пути
 	// и найти по нему длинную ссылку, которую затем возвращаем в виде 307 редиректа
-	shortURLID := chi.URLParam(r, "slug")
-	if shortURLID == "" {
-		http.Error(w, "Invalid short url", http.StatusBadRequest)
-		return
-	}
-	longURL, err := handler.App.Storage.Get(r.Context(), shortURLID)
+	longURL, err := handler.Service.Expand(r.Context(), chi.URLParam(r, "slug"))
 	if err != nil {
-		switch {
-		case errors.Is(err, storage.ErrURLNotFound):
-			// Короткая ссылка не найдена в хранилище - ожидаемое поведение, возвращаем 404
-			http.Error(w, err.Error(), http.StatusNotFound)
-		case errors.Is(err, storage.ErrURLIsDeleted):
-			http.Error(w, "url is deleted", http.StatusGone)
-		default:
-			// Другая проблема с хранилищем
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		// Короткая ссылка не найдена в хранилище - ожидаемое поведение, возвращаем 404; удаленная - 410
+		writeServiceError(w, err)
 		return
 	}
 	http.Redirect(w, r, longURL, http.StatusTemporaryRedirect)
@@ -121,43 +139,42 @@ func (handler Handler) ExpandURL(w http.ResponseWriter, r *http.Request) {
 // В случае наличия в хранилище сокращаемой ссылки возвращает статус 409
 // и ранее сокращенную ссылку в ответе
 func (handler Handler) APIShortenURL(w http.ResponseWriter, r *http.Request) {
+	owner, ok := handler.urlOwner(w, r, service.RoleEditor)
+	if !ok {
+		return
+	}
 	var shortenReq APIShortenRequest
 	// Получили невалидный json
 	if err := json.NewDecoder(r.Body).Decode(&shortenReq); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// Значение параметра невалидно
-	if shortenReq.URL == "" {
-		http.Error(w, "please provide a url to shorten", http.StatusBadRequest)
+	result, err := handler.Service.Shorten(r.Context(), owner, shortenReq.URL)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 	respStatus := http.StatusCreated
 	// В случае конфликта при сохранении отдаем статус 409 и возвращаем короткий URL для ссылки, сохраненной ранее
-	shortURL, created, err := handler.shortenAndSaveLongURL(shortenReq.URL, r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if !created {
+	if !result.Created {
 		respStatus = http.StatusConflict
 	}
-	resultItem := APIShortenResult{Result: shortURL.String()}
+	resultItem := APIShortenResult{Result: result.ShortURL}
 	resp.JSONResponse(&resultItem, w, respStatus)
 }
 
 // GetUserURLs возвращает полный список всех ссылок, сокращенных текущим пользователем.
 // Ссылки возвращаются парами Длинный URL + Короткий URL
 // В случае отсутствия ссылок у пользователя, возвращается статус 204 без тела ответа
+// С query-параметром workspace возвращает ссылки рабочего пространства, в котором состоит пользователь
 func (handler Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value(middleware.AuthContextKey).(*middleware.AuthUser)
+	owner, ok := handler.urlOwner(w, r, service.RoleViewer)
 	if !ok {
-		http.Error(w, "not authenticated", http.StatusForbidden)
 		return
 	}
-	items, err := handler.App.Storage.GetURLsByUserID(r.Context(), user.ID)
+	items, err := handler.Service.UserURLs(r.Context(), owner)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 	// Не найдено ни одной ссылки для текущего пользователя - возвращаем 204
@@ -167,45 +184,119 @@ func (handler Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	}
 	// Серилизуем полученный результат
 	jsonItems := make([]APIUserURLItem, 0, len(items))
-	for shortID, longURL := range items {
-		item := APIUserURLItem{
-			ShortURL:    handler.constructShortURL(shortID).String(),
-			OriginalURL: longURL,
-		}
-		jsonItems = append(jsonItems, item)
+	for _, item := range items {
+		jsonItems = append(jsonItems, APIUserURLItem{ShortURL: item.ShortURL, OriginalURL: item.OriginalURL})
 	}
 	resp.JSONResponse(&jsonItems, w, http.StatusOK)
 }
 
+// ExportUserURLs поточно выгружает все ссылки текущего пользователя, включая удаленные,
+// в формате, заданном query-параметром format (csv|ndjson|json, по умолчанию json).
+// Ссылки вычитываются из хранилища курсором, не накапливаясь в памяти целиком
+// В случае неизвестного формата возвращает ошибку 400
+func (handler Handler) ExportUserURLs(w http.ResponseWriter, r *http.Request) {
+	owner, ok := handler.urlOwner(w, r, service.RoleViewer)
+	if !ok {
+		return
+	}
+	format := exports.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = exports.FormatJSON
+	}
+	writer, err := exports.NewWriter(format, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", exports.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+	exported := 0
+	err = handler.Service.ExportUserURLs(r.Context(), owner, func(item exports.Item) error {
+		exported++
+		return writer.Write(item)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).WithError(err).Error("failed to export urls")
+		// пока клиенту ничего не отправлено, еще можно сообщить об ошибке
+		if exported == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		// иначе часть ответа уже отправлена, и сменить статус мы не можем.
+		// Обрываем выгрузку, оставляя документ незавершенным
+	}
+}
+
+// DeleteUserURLs ставит в очередь удаление ссылок текущего пользователя из списка коротких идентификаторов
+// и возвращает 202 со статусом джоба. С query-параметром workspace удаляет ссылки рабочего пространства,
+// для чего нужна роль не ниже editor
 func (handler Handler) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 	var userShortIDs []string
-	user, ok := r.Context().Value(middleware.AuthContextKey).(*middleware.AuthUser)
+	user, ok := auth.FromContext(r.Context())
 	if !ok {
 		http.Error(w, "not authenticated", http.StatusForbidden)
 		return
 	}
+	owner, ok := handler.urlOwner(w, r, service.RoleEditor)
+	if !ok {
+		return
+	}
 	// Получили невалидный json
 	if err := json.NewDecoder(r.Body).Decode(&userShortIDs); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	backgroundJob := jobs.DeleteUserURLs(handler.App.Storage, user.ID, userShortIDs...)
-	if err := handler.App.Jobs.Add(r.Context(), backgroundJob); err != nil {
-		// не удалось добавить задачу в очередь в разумное время. Очередь полна?
-		// просим клиента попробовать еще раз, вернув ему 503
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	// если очередь переполнена, просим клиента попробовать еще раз, вернув ему 503
+	jobID, err := handler.Service.DeleteUserURLs(r.Context(), owner, userShortIDs)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte("OK")) // nolint:errcheck
+	// возвращаем клиенту идентификатор джоба, по которому он сможет узнать результат удаления
+	w.Header().Set("Location", "/api/jobs/"+jobID)
+	handler.writeJobStatus(w, r, user.ID, jobID, http.StatusAccepted)
+}
+
+// GetJobStatus возвращает статус фоновой задачи, поставленной в очередь текущим пользователем:
+// queued, running, succeeded или failed, а также результат ее выполнения (например, количество удаленных ссылок)
+// В случае неизвестной задачи (или задачи другого пользователя) возвращает 404
+func (handler Handler) GetJobStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return
+	}
+	status, err := handler.Service.JobStatus(r.Context(), user.ID, chi.URLParam(r, "jobID"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	result := newAPIJobStatus(status)
+	resp.JSONResponse(&result, w, http.StatusOK)
+}
+
+func (handler Handler) writeJobStatus(
+	w http.ResponseWriter, r *http.Request, userID, jobID string, respStatus int,
+) {
+	status, err := handler.Service.JobStatus(r.Context(), userID, jobID)
+	if err != nil {
+		// статус мог быть вытеснен из истории, если пул очень загружен, либо недоступно хранилище очереди.
+		// Джоб уже в очереди, поэтому отвечаем клиенту как ни в чем не бывало
+		status = background.JobStatus{ID: jobID, State: background.JobQueued}
+	}
+	result := newAPIJobStatus(status)
+	resp.JSONResponse(&result, w, respStatus)
 }
 
 // Ping проверяет статус хранилища и возвращает 200 OK в случае успешной проверки
 // В случае наличия проблем с подключением или ошибкой, связанной с превышением времени ожидания ответа,
 // возвращает ошибку 500
 func (handler Handler) Ping(w http.ResponseWriter, r *http.Request) {
-	if err := handler.App.Storage.Ping(r.Context()); err != nil {
-		log.Printf("failed to ping storage because of %s\n", err)
+	if err := handler.Service.Ping(r.Context()); err != nil {
+		logging.FromContext(r.Context()).WithError(err).Error("failed to ping storage")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -213,6 +304,29 @@ func (handler Handler) Ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK")) // nolint:errcheck
 }
 
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// Healthz сообщает, что процесс сервиса жив и способен обрабатывать запросы.
+// Состояние зависимостей сервиса не проверяется, для этого есть Readyz
+func (handler Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	resp.JSONResponse(&HealthStatus{Status: health.StatusOK}, w, http.StatusOK)
+}
+
+// Readyz проверяет готовность сервиса принимать трафик: доступность хранилища,
+// наличие места в очереди фоновых задач, наличие таблиц в бд и отсутствие остановки сервиса.
+// Возвращает отчет по каждому компоненту и 503 Service Unavailable, если хотя бы один из них не готов
+func (handler Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := handler.App.Health.Check(r.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+		logging.FromContext(r.Context()).WithField("components", report.Components).Warn("service is not ready")
+	}
+	resp.JSONResponse(&report, w, status)
+}
+
 // APIShortenBatch принимает список URL для сокращения.
 // Список для сокращения представляет собой список пар URL - Correlation ID
 // При успешном выполнении операции возвращает список сокращенных ссылок
@@ -220,9 +334,8 @@ func (handler Handler) Ping(w http.ResponseWriter, r *http.Request) {
 // Correlation ID для каждой ссылки соответствует значению длинной ссылки,
 // которое предоставил клиент в запросе
 func (handler Handler) APIShortenBatch(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value(middleware.AuthContextKey).(*middleware.AuthUser)
+	owner, ok := handler.urlOwner(w, r, service.RoleEditor)
 	if !ok {
-		http.Error(w, "not authenticated", http.StatusForbidden)
 		return
 	}
 	shortenBatchReq := make([]APIShortenBatchRequestItem, 0)
@@ -230,40 +343,294 @@ func (handler Handler) APIShortenBatch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// Собираем список для массовой вставки
-	batchItems := make([]storage.BatchItem, 0, len(shortenBatchReq))
-	correlationIDs := make(map[string]string)
+	batchItems := make([]service.BatchItem, 0, len(shortenBatchReq))
 	for _, reqItem := range shortenBatchReq {
-		if reqItem.OriginalURL == "" {
-			continue
-		}
-		shortID := handler.App.Shortener.Shorten(reqItem.OriginalURL)
-		batchItem := storage.BatchItem{ShortID: shortID, LongURL: reqItem.OriginalURL, UserID: user.ID}
-		batchItems = append(batchItems, batchItem)
-		// запоминаем соответствие correlation и сокращаемой ссылки для последующего ответа
-		correlationIDs[reqItem.CorrelationID] = reqItem.OriginalURL
+		batchItems = append(batchItems, service.BatchItem{
+			CorrelationID: reqItem.CorrelationID,
+			OriginalURL:   reqItem.OriginalURL,
+		})
 	}
-	// Проверяем список на пустоту здесь, поскольку некоторые урлы могли быть отсеяны при валидации
-	if len(batchItems) == 0 {
-		http.Error(w, "please provide a list of urls to shorten", http.StatusBadRequest)
+	results, err := handler.Service.ShortenBatch(r.Context(), owner, batchItems)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
+	shortenBatchRes := make([]APIShortenBatchResultItem, 0, len(results))
+	for _, result := range results {
+		shortenBatchRes = append(shortenBatchRes, APIShortenBatchResultItem{
+			CorrelationID: result.CorrelationID,
+			ShortURL:      result.ShortURL,
+		})
+	}
+	resp.JSONResponse(&shortenBatchRes, w, http.StatusCreated)
+}
 
-	resultItems, err := handler.App.Storage.SaveBatch(r.Context(), batchItems)
+// APIImportURLs принимает поток ссылок для массового сокращения в формате ndjson или csv.
+// Формат задается query-параметром format (ndjson|csv), либо определяется по заголовку Content-Type
+// Тело запроса поточно сохраняется во временный файл, после чего импорт выполняется в фоне
+// пачками через SaveBatch. В случае успеха возвращает 202 и идентификатор импорта,
+// по которому прогресс можно узнать в GET /api/import/{id}
+// Ответ отправляется только после загрузки всего тела, поэтому его размер ограничен IMPORT_MAX_BODY_SIZE:
+// тело, размер которого по Content-Length превышает лимит, отклоняется с 413 без чтения,
+// а тело без Content-Length - как только превысит лимит
+// В случае неизвестного формата возвращает 415
+// Если импорт не удалось поставить в очередь, возвращает 503
+// С query-параметром workspace ссылки импортируются в рабочее пространство, для чего нужна роль не ниже editor
+func (handler Handler) APIImportURLs(w http.ResponseWriter, r *http.Request) {
+	owner, ok := handler.urlOwner(w, r, service.RoleEditor)
+	if !ok {
+		return
+	}
+	format, ok := detectImportFormat(r)
+	if !ok {
+		http.Error(w, "please provide links in ndjson or csv format", http.StatusUnsupportedMediaType)
+		return
+	}
+	filename, status, err := handler.spoolImportBody(r)
 	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	// дальнейшая судьба временного файла - забота сервиса
+	progress, err := handler.Service.ImportURLs(r.Context(), owner, format, filename)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/import/"+progress.ID)
+	result := newAPIImportProgress(progress)
+	resp.JSONResponse(&result, w, http.StatusAccepted)
+}
+
+// APIGetImport возвращает прогресс импорта, запущенного текущим пользователем
+// либо в одном из его рабочих пространств
+// В случае неизвестного импорта (или импорта другого пользователя) возвращает 404
+func (handler Handler) APIGetImport(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return
+	}
+	progress, err := handler.Service.ImportProgress(r.Context(), user.ID, chi.URLParam(r, "importID"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	result := newAPIImportProgress(progress)
+	resp.JSONResponse(&result, w, http.StatusOK)
+}
+
+// CreateAPIKey выпускает текущему пользователю ключ API для серверных клиентов.
+// Принимает json со списком разрешений ключа (read, write). В случае успеха возвращает 201 и ключ,
+// который больше нигде не будет показан. Управлять ключами можно только по куке, но не по ключу API
+func (handler Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := keyManager(w, r)
+	if !ok {
+		return
+	}
+	var createReq APICreateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := handler.Service.CreateAPIKey(r.Context(), user.ID, createReq.Scopes)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	result := newAPIKeyItem(key)
+	resp.JSONResponse(&result, w, http.StatusCreated)
+}
+
+// GetAPIKeys возвращает действующие ключи API текущего пользователя без самих ключей
+func (handler Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := keyManager(w, r)
+	if !ok {
+		return
+	}
+	keys, err := handler.Service.APIKeys(r.Context(), user.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	items := make([]APIKeyItem, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, newAPIKeyItem(key))
+	}
+	resp.JSONResponse(&items, w, http.StatusOK)
+}
+
+// RevokeAPIKey отзывает ключ API текущего пользователя и возвращает 204
+// В случае неизвестного ключа (или ключа другого пользователя) возвращает 404
+func (handler Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := keyManager(w, r)
+	if !ok {
+		return
+	}
+	if err := handler.Service.RevokeAPIKey(r.Context(), user.ID, chi.URLParam(r, "keyID")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyManager возвращает пользователя, которому разрешено управлять ключами API.
+// Запрет на управление ключами по ключу не дает утекшему ключу выпустить себе замену
+func keyManager(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return nil, false
+	}
+	if user.APIKeyID != "" {
+		http.Error(w, "api keys cannot be managed with an api key", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// Signup регистрирует учетную запись по email и паролю из json. Ссылки текущего анонимного пользователя
+// переходят к учетной записи. В случае успеха возвращает 201 и выдает клиенту токен учетной записи
+// В случае невалидного email или слишком короткого пароля возвращает 400, занятого email - 409
+func (handler Handler) Signup(w http.ResponseWriter, r *http.Request) {
+	handler.enterAccount(w, r, handler.Service.Signup, http.StatusCreated)
+}
+
+// Login выдает клиенту токен учетной записи по email и паролю из json,
+// передавая ей ссылки текущего анонимного пользователя. В случае неверных данных возвращает 401
+func (handler Handler) Login(w http.ResponseWriter, r *http.Request) {
+	handler.enterAccount(w, r, handler.Service.Login, http.StatusOK)
+}
+
+// Logout удаляет авторизационную куку. Следующий запрос клиента получит нового анонимного пользователя
+func (handler Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	middleware.ClearToken(w, handler.App.Tokens)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type accountFunc func(
+	ctx context.Context, current *auth.User, email, password string,
+) (service.Account, error)
+
+func (handler Handler) enterAccount(w http.ResponseWriter, r *http.Request, enter accountFunc, status int) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return
+	}
+	// иначе владелец ключа API мог бы передать свои ссылки чужой учетной записи
+	if user.APIKeyID != "" {
+		http.Error(w, "accounts cannot be accessed with an api key", http.StatusForbidden)
+		return
+	}
+	var credentials APICredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	account, err := enter(r.Context(), user, credentials.Email, credentials.Password)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	accountUser := &auth.User{ID: account.ID, Kind: auth.UserAccount}
+	if err := middleware.IssueToken(w, handler.App.Tokens, accountUser); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	shortenBatchRes := make([]APIShortenBatchResultItem, 0, len(resultItems))
-	// Собираем ответ для клиента, учитывая дубли
-	for correlationID, originalURL := range correlationIDs {
-		actualShortID := resultItems[originalURL]
-		resultItem := APIShortenBatchResultItem{
-			CorrelationID: correlationID,
-			ShortURL:      handler.constructShortURL(actualShortID).String(),
+	result := APIAccount{ID: account.ID, Email: account.Email, MergedURLs: account.MergedURLs}
+	resp.JSONResponse(&result, w, status)
+}
+
+// spoolImportBody сохраняет тело запроса во временный файл, не считывая его целиком в память
+// Возвращает путь до файла, либо ошибку с подходящим для нее http-статусом
+func (handler Handler) spoolImportBody(r *http.Request) (string, int, error) {
+	maxSize := handler.App.Config.ImportMaxBodySize
+	if r.ContentLength > maxSize {
+		return "", http.StatusRequestEntityTooLarge, ErrImportBodyTooLarge
+	}
+	file, err := os.CreateTemp("", "shortener-import-*")
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	defer file.Close()
+	// читаем на байт больше лимита, чтобы отличить тело максимального размера от превышающего его
+	written, err := io.Copy(file, io.LimitReader(r.Body, maxSize+1))
+	if err == nil && written > maxSize {
+		err = ErrImportBodyTooLarge
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		os.Remove(file.Name()) // nolint:errcheck
+		if errors.Is(err, ErrImportBodyTooLarge) {
+			return "", http.StatusRequestEntityTooLarge, err
 		}
-		shortenBatchRes = append(shortenBatchRes, resultItem)
+		return "", http.StatusBadRequest, err
 	}
+	return file.Name(), 0, nil
+}
 
-	resp.JSONResponse(&shortenBatchRes, w, http.StatusCreated)
+func detectImportFormat(r *http.Request) (imports.Format, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		switch imports.Format(format) {
+		case imports.FormatNDJSON, imports.FormatCSV:
+			return imports.Format(format), true
+		default:
+			return "", false
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", false
+	}
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl":
+		return imports.FormatNDJSON, true
+	case "text/csv":
+		return imports.FormatCSV, true
+	default:
+		return "", false
+	}
+}
+
+func newAPIJobStatus(status background.JobStatus) APIJobStatus {
+	result := APIJobStatus{
+		ID:         status.ID,
+		Name:       status.Name,
+		Status:     string(status.State),
+		Attempts:   status.Attempts,
+		Result:     status.Value,
+		EnqueuedAt: status.EnqueuedAt,
+	}
+	if status.Err != nil {
+		result.Error = status.Err.Error()
+	}
+	if !status.StartedAt.IsZero() {
+		result.StartedAt = &status.StartedAt
+	}
+	if !status.FinishedAt.IsZero() {
+		result.FinishedAt = &status.FinishedAt
+	}
+	return result
+}
+
+func newAPIImportProgress(progress imports.Progress) APIImportProgress {
+	result := APIImportProgress{
+		ID:         progress.ID,
+		Status:     string(progress.Status),
+		Processed:  progress.Processed,
+		Created:    progress.Created,
+		Duplicates: progress.Duplicates,
+		Skipped:    progress.Skipped,
+	}
+	if progress.Err != nil {
+		result.Error = progress.Err.Error()
+	}
+	return result
+}
+
+func newAPIKeyItem(key service.APIKey) APIKeyItem {
+	return APIKeyItem{ID: key.ID, Key: key.Key, Scopes: key.Scopes, CreatedAt: key.CreatedAt}
 }
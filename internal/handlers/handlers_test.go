@@ -2,35 +2,45 @@ package handlers_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/random"
 
 	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/internal/exports"
 	"github.com/sergeii/practikum-go-url-shortener/internal/handlers"
+	"github.com/sergeii/practikum-go-url-shortener/internal/health"
 	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
 	"github.com/sergeii/practikum-go-url-shortener/internal/router"
-	"github.com/sergeii/practikum-go-url-shortener/pkg/security/sign"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/internal/tracing"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/keyring"
+	oidctest "github.com/sergeii/practikum-go-url-shortener/pkg/testing/oidc"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
-func setAuthCookie(r *http.Request, secretKey []byte, userID string) *http.Cookie {
-	signer := sign.New(secretKey)
-	signature64 := signer.Sign64([]byte(userID))
-	cookieValue := userID + ":" + signature64
-	cookieExpiresAt := time.Now().Add(middleware.AuthUserCookieExpiration)
+func setAuthCookie(r *http.Request, keys *keyring.Keyring, userID string) *http.Cookie {
+	cookieValue, cookieExpiresAt, _ := middleware.NewTokens(keys).Issue(&auth.User{ID: userID})
 	cookie := &http.Cookie{
 		Name:    middleware.AuthUserCookieName,
 		Value:   cookieValue,
@@ -464,6 +474,104 @@ func TestExpandEndpointRequiresShortURLID(t *testing.T) {
 	assert.Equal(t, 405, resp.StatusCode)
 }
 
+func TestExpandEndpointDoesNotCreateUsers(t *testing.T) {
+	// любой токен считается истекающим
+	ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.AuthTokenRefreshBefore = cfg.AuthTokenTTL
+		return nil
+	})
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint: errcheck
+
+	resp, _ := doTestRequest(t, ts, http.MethodGet, "/go", nil)
+	resp.Body.Close()
+	assert.Equal(t, 307, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+
+	// истекающий токен пользователя при этом перевыпускается
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/go", nil)
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "user1"))
+	resp, err := ts.Client().Transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 307, resp.StatusCode)
+	require.Len(t, resp.Cookies(), 1)
+	user, _, err := shortener.Tokens.Authenticate(resp.Cookies()[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "user1", user.ID)
+}
+
+func TestAuthCookieAttributes(t *testing.T) {
+	httpsBaseURL := func(cfg *app.Config) error {
+		baseURL, err := url.Parse("https://short.example/")
+		cfg.BaseURL = *baseURL
+		return err
+	}
+	tests := []struct {
+		name       string
+		overrides  []app.Override
+		wantSecure bool
+		wantSite   http.SameSite
+		wantDomain string
+	}{
+		{"http base url", nil, false, http.SameSiteLaxMode, ""},
+		{"https base url", []app.Override{httpsBaseURL}, true, http.SameSiteLaxMode, ""},
+		{
+			"explicit policy",
+			[]app.Override{func(cfg *app.Config) error {
+				cfg.AuthCookieSecure = "true"
+				cfg.AuthCookieSameSite = "strict"
+				cfg.AuthCookieDomain = "short.example"
+				return nil
+			}},
+			true, http.SameSiteStrictMode, "short.example",
+		},
+		{
+			"secure disabled for https",
+			[]app.Override{httpsBaseURL, func(cfg *app.Config) error {
+				cfg.AuthCookieSecure = "false"
+				return nil
+			}},
+			false, http.SameSiteLaxMode, "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, _ := prepareTestServer(t, tt.overrides...)
+			resp, _ := doTestRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://go.dev/"))
+			resp.Body.Close()
+			require.Equal(t, 201, resp.StatusCode)
+			require.Len(t, resp.Cookies(), 1)
+			cookie := resp.Cookies()[0]
+			assert.Equal(t, middleware.AuthUserCookieName, cookie.Name)
+			assert.True(t, cookie.HttpOnly)
+			assert.Equal(t, "/", cookie.Path)
+			assert.Equal(t, tt.wantSecure, cookie.Secure)
+			assert.Equal(t, tt.wantSite, cookie.SameSite)
+			assert.Equal(t, tt.wantDomain, cookie.Domain)
+		})
+	}
+}
+
+func TestInvalidCookiePolicyIsRejected(t *testing.T) {
+	for _, override := range []app.Override{
+		func(cfg *app.Config) error {
+			cfg.AuthCookieSameSite = "none"
+			return nil
+		},
+		func(cfg *app.Config) error {
+			cfg.AuthCookieSameSite = "sometimes"
+			return nil
+		},
+		func(cfg *app.Config) error {
+			cfg.AuthCookieSecure = "maybe"
+			return nil
+		},
+	} {
+		_, err := app.New(override)
+		assert.ErrorIs(t, err, app.ErrInvalidCookiePolicy)
+	}
+}
+
 func TestExpandEndpointRequiresProperID(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -511,7 +619,7 @@ func TestExpandEndpointRequiresProperID(t *testing.T) {
 func TestSetAndGetUserURLS(t *testing.T) {
 	ts, shortener := prepareTestServer(t)
 	testURLs := []string{"https://ya.ru", "https://go.dev/"}
-	authCookie := setAuthCookie(nil, shortener.SecretKey, "user1")
+	authCookie := setAuthCookie(nil, shortener.Keys, "user1")
 	for _, testURL := range testURLs {
 		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/", strings.NewReader(testURL))
 		req.AddCookie(authCookie)
@@ -584,7 +692,7 @@ func TestGetUserURLs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls", nil)
 			if tt.userID != "" {
-				setAuthCookie(req, shortener.SecretKey, tt.userID)
+				setAuthCookie(req, shortener.Keys, tt.userID)
 			}
 			resp, _ := http.DefaultClient.Do(req)
 			body, _ := ioutil.ReadAll(resp.Body)
@@ -613,6 +721,85 @@ func TestPingEndpointOK(t *testing.T) {
 	assert.Equal(t, 200, resp.StatusCode)
 }
 
+func TestHealthzEndpointOK(t *testing.T) {
+	ts, _ := prepareTestServer(t)
+	resp, body := doTestRequest(t, ts, http.MethodGet, "/healthz", nil)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+}
+
+func TestReadyzEndpointReportsComponents(t *testing.T) {
+	ts, _ := prepareTestServer(t)
+	resp, body := doTestRequest(t, ts, http.MethodGet, "/readyz", nil)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	var report health.Report
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, "ok", report.Status)
+	for _, name := range []string{"storage", "jobs", "shutdown"} {
+		require.Contains(t, report.Components, name)
+		assert.Equal(t, "ok", report.Components[name].Status)
+		assert.Empty(t, report.Components[name].Error)
+	}
+}
+
+func TestReadyzEndpointUnavailableOnShutdown(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	shortener.Health.SetShuttingDown()
+
+	resp, body := doTestRequest(t, ts, http.MethodGet, "/readyz", nil)
+	resp.Body.Close()
+	require.Equal(t, 503, resp.StatusCode)
+	var report health.Report
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, "unavailable", report.Status)
+	assert.Equal(t, "unavailable", report.Components["shutdown"].Status)
+	assert.Equal(t, "ok", report.Components["storage"].Status)
+	// живость процесса от остановки не зависит
+	resp, _ = doTestRequest(t, ts, http.MethodGet, "/healthz", nil)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestReadyzEndpointUnavailableWhenJobQueueIsSaturated(t *testing.T) {
+	// без воркеров очередь не в состоянии принять ни одного джоба
+	ts, _ := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.BackgroundWorkerConcurrency = 0
+		cfg.BackgroundQueues = nil
+		return nil
+	})
+	resp, body := doTestRequest(t, ts, http.MethodGet, "/readyz", nil)
+	resp.Body.Close()
+	require.Equal(t, 503, resp.StatusCode)
+	var report health.Report
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, "unavailable", report.Components["jobs"].Status)
+	assert.Contains(t, report.Components["jobs"].Error, "job queue is saturated")
+}
+
+func TestMetricsEndpointExposesServiceMetrics(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint:errcheck
+
+	resp, _ := doTestRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://go.dev/"))
+	resp.Body.Close()
+	resp, _ = doTestRequest(t, ts, http.MethodPost, "/", strings.NewReader("https://ya.ru/"))
+	resp.Body.Close()
+	resp, _ = doTestRequest(t, ts, http.MethodGet, "/go", nil)
+	resp.Body.Close()
+
+	resp, body := doTestRequest(t, ts, http.MethodGet, "/metrics", nil)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, body, "shortener_urls_shortened_total 1")
+	assert.Contains(t, body, "shortener_urls_conflicts_total 1")
+	assert.Contains(t, body, "shortener_redirects_total 1")
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/{slug:[a-zA-Z0-9_-]+}",status="307"} 1`)
+	assert.Contains(t, body, `shortener_jobs_queue_depth{queue="deletes"} 0`)
+	assert.Contains(t, body, `shortener_storage_operation_duration_seconds_count{backend="memory",operation="get",outcome="ok"} 1`)
+}
+
 func TestAPIShortenBatchRequest(t *testing.T) {
 	ts, shortener := prepareTestServer(t)
 	shortener.Storage.Set(context.TODO(), "go", "https://go.dev", "user100500") // nolint:errcheck
@@ -698,13 +885,25 @@ func TestAPIDeleteUserURLs(t *testing.T) {
 	shortener.Storage.Set(ctx, "ya", "https://ya.ru", "u3")                 // nolint: errcheck
 	shortener.Storage.Set(ctx, "bar", "https://practicum.yandex.ru/", "u1") // nolint: errcheck
 
-	authCookie := setAuthCookie(nil, shortener.SecretKey, "u1")
+	authCookie := setAuthCookie(nil, shortener.Keys, "u1")
 	reqJSON, _ := json.Marshal([]string{"wiki", "go", "foo", "ya", "bar", "unknown"}) // nolint:errchkjson
 	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/user/urls", bytes.NewReader(reqJSON))
 	req.AddCookie(authCookie)
 	resp, _ := http.DefaultClient.Do(req)
+	var accepted handlers.APIJobStatus
+	json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
 	resp.Body.Close()
 	assert.Equal(t, 202, resp.StatusCode)
+	assert.NotEmpty(t, accepted.ID)
+	assert.Equal(t, "/api/jobs/"+accepted.ID, resp.Header.Get("Location"))
+
+	// дожидаемся выполнения удаления и проверяем его результат
+	status := waitForJob(t, ts, authCookie, accepted.ID)
+	assert.Equal(t, "succeeded", status.Status)
+	assert.Equal(t, map[string]interface{}{"deleted": float64(3)}, status.Result)
+	assert.Empty(t, status.Error)
+	assert.NotNil(t, status.StartedAt)
+	assert.NotNil(t, status.FinishedAt)
 
 	expected := map[string]int{
 		"wiki": 410,
@@ -720,6 +919,36 @@ func TestAPIDeleteUserURLs(t *testing.T) {
 	}
 }
 
+func TestAPIDeleteUserURLsJobContinuesRequestTrace(t *testing.T) {
+	ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.TracingExporter = tracing.ExporterMemory
+		return nil
+	})
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "u1") // nolint: errcheck
+
+	authCookie := setAuthCookie(nil, shortener.Keys, "u1")
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/user/urls", strings.NewReader(`["go"]`))
+	req.AddCookie(authCookie)
+	resp, _ := http.DefaultClient.Do(req)
+	var accepted handlers.APIJobStatus
+	json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
+	resp.Body.Close()
+	require.Equal(t, 202, resp.StatusCode)
+	status := waitForJob(t, ts, authCookie, accepted.ID)
+	require.Equal(t, "succeeded", status.Status)
+
+	spansByName := make(map[string]tracetest.SpanStub)
+	for _, span := range shortener.Tracing.Spans() {
+		spansByName[span.Name] = span
+	}
+	requestSpan, ok := spansByName["DELETE /api/user/urls"]
+	require.True(t, ok)
+	jobSpan, ok := spansByName["job delete user URLs"]
+	require.True(t, ok)
+	assert.Equal(t, requestSpan.SpanContext.TraceID(), jobSpan.SpanContext.TraceID())
+	assert.Equal(t, requestSpan.SpanContext.SpanID(), jobSpan.Parent.SpanID())
+}
+
 func TestAPIDeleteUserURLsHandleBadRequest(t *testing.T) {
 	ts, _ := prepareTestServer(t)
 
@@ -777,15 +1006,878 @@ func TestAPIDeleteUserURLsQueueIsFullError(t *testing.T) {
 	// эмулируем полную очередь, заблокировав навечно запись в канал из-за отсутствия воркеров
 	ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
 		cfg.BackgroundWorkerConcurrency = 0
+		cfg.BackgroundQueues = nil
 		return nil
 	})
 
 	shortener.Storage.Set(context.TODO(), "wiki", "https://wikipedia.org/", "u1") // nolint: errcheck
 
 	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/user/urls", strings.NewReader(`["wiki"]`))
-	authCookie := setAuthCookie(nil, shortener.SecretKey, "u1")
+	authCookie := setAuthCookie(nil, shortener.Keys, "u1")
 	req.AddCookie(authCookie)
 	resp, _ := http.DefaultClient.Do(req)
 	resp.Body.Close()
 	assert.Equal(t, 503, resp.StatusCode)
 }
+
+func waitForImport(t *testing.T, ts *httptest.Server, cookie *http.Cookie, importID string) handlers.APIImportProgress {
+	var progress handlers.APIImportProgress
+	require.Eventually(t, func() bool {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/import/"+importID, nil)
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode)
+		json.NewDecoder(resp.Body).Decode(&progress) // nolint:errcheck
+		return progress.Status == "succeeded" || progress.Status == "failed"
+	}, time.Second, time.Millisecond*10)
+	return progress
+}
+
+func TestAPIImportURLs(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "ndjson by content type",
+			contentType: "application/x-ndjson",
+			body: `{"original_url": "https://go.dev/"}` + "\n" +
+				`{"original_url": "https://ya.ru/"}` + "\n" +
+				`{"original_url": ""}` + "\n" +
+				`{"original_url": "https://example.com/"}` + "\n",
+		},
+		{
+			name:        "csv by content type",
+			contentType: "text/csv; charset=utf-8",
+			body:        "original_url\nhttps://go.dev/\nhttps://ya.ru/\n\"\"\nhttps://example.com/\n",
+		},
+		{
+			name:  "csv by query",
+			query: "?format=csv",
+			body:  "https://go.dev/\nhttps://ya.ru/\n,foo\nhttps://example.com/\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
+				cfg.ImportChunkSize = 2
+				return nil
+			})
+			shortener.Storage.Set(ctx, "go", "https://go.dev/", "u2") // nolint: errcheck
+
+			authCookie := setAuthCookie(nil, shortener.Keys, "u1")
+			req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/import"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.AddCookie(authCookie)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			var accepted handlers.APIImportProgress
+			json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
+			resp.Body.Close()
+			require.Equal(t, 202, resp.StatusCode)
+			assert.NotEmpty(t, accepted.ID)
+			assert.Equal(t, "/api/import/"+accepted.ID, resp.Header.Get("Location"))
+
+			progress := waitForImport(t, ts, authCookie, accepted.ID)
+			assert.Equal(t, "succeeded", progress.Status)
+			assert.Equal(t, 4, progress.Processed)
+			assert.Equal(t, 2, progress.Created)
+			assert.Equal(t, 1, progress.Duplicates)
+			assert.Equal(t, 1, progress.Skipped)
+			assert.Empty(t, progress.Error)
+
+			items, _ := shortener.Storage.GetURLsByUserID(ctx, "u1")
+			longURLs := make([]string, 0, len(items))
+			for _, longURL := range items {
+				longURLs = append(longURLs, longURL)
+			}
+			assert.ElementsMatch(t, []string{"https://ya.ru/", "https://example.com/"}, longURLs)
+		})
+	}
+}
+
+func TestAPIImportURLsIsVisibleToOwnerOnly(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/import?format=csv", strings.NewReader("https://go.dev/"))
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "u1"))
+	resp, _ := http.DefaultClient.Do(req)
+	var accepted handlers.APIImportProgress
+	json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
+	resp.Body.Close()
+	require.Equal(t, 202, resp.StatusCode)
+
+	waitForImport(t, ts, setAuthCookie(nil, shortener.Keys, "u1"), accepted.ID)
+
+	for _, path := range []string{"/api/import/" + accepted.ID, "/api/import/unknown"} {
+		req, _ = http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.AddCookie(setAuthCookie(nil, shortener.Keys, "u2"))
+		resp, _ = http.DefaultClient.Do(req)
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+}
+
+func TestAPIImportURLsHandlesBadRequest(t *testing.T) {
+	ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.ImportMaxBodySize = 32
+		return nil
+	})
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		want        int
+	}{
+		{
+			name:        "no format",
+			contentType: "",
+			body:        "https://go.dev/",
+			want:        415,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "application/json",
+			body:        `[{"original_url": "https://go.dev/"}]`,
+			want:        415,
+		},
+		{
+			name:  "unsupported format",
+			query: "?format=xml",
+			body:  "<url>https://go.dev/</url>",
+			want:  415,
+		},
+		{
+			name:  "body is too large",
+			query: "?format=csv",
+			body:  "https://go.dev/\nhttps://practicum.yandex.ru/\n",
+			want:  413,
+		},
+		{
+			name:  "body of max size",
+			query: "?format=csv",
+			body:  strings.Repeat("a", 32),
+			want:  202,
+		},
+	}
+	authCookie := setAuthCookie(nil, shortener.Keys, "u1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/import"+tt.query, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			req.AddCookie(authCookie)
+			resp, _ := http.DefaultClient.Do(req)
+			var accepted handlers.APIImportProgress
+			json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
+			resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+			// принятый импорт должен завершиться до того, как тест удалит хранилище
+			if resp.StatusCode == 202 {
+				waitForImport(t, ts, authCookie, accepted.ID)
+			}
+		})
+	}
+}
+
+func TestAPIImportURLsRejectsTooLargeContentLengthWithoutReading(t *testing.T) {
+	ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.ImportMaxBodySize = 32
+		return nil
+	})
+	// попытка прочитать тело закончилась бы ошибкой и статусом 400
+	body := iotest.ErrReader(errors.New("body must not be read"))
+	req := httptest.NewRequest(http.MethodPost, "/api/import?format=csv", body)
+	req.ContentLength = 1024
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "u1"))
+	w := httptest.NewRecorder()
+	ts.Config.Handler.ServeHTTP(w, req)
+	assert.Equal(t, 413, w.Code)
+}
+
+func TestAPIImportURLsQueueIsFullError(t *testing.T) {
+	ts, _ := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.BackgroundWorkerConcurrency = 0
+		cfg.BackgroundQueues = nil
+		cfg.BackgroundEnqueueTimeout = time.Millisecond * 10
+		return nil
+	})
+	resp, _ := doTestRequest(t, ts, http.MethodPost, "/api/import?format=csv", strings.NewReader("https://go.dev/"))
+	resp.Body.Close()
+	assert.Equal(t, 503, resp.StatusCode)
+}
+
+func TestExportUserURLs(t *testing.T) {
+	ctx := context.TODO()
+	ts, shortener := prepareTestServer(t)
+	shortener.Storage.Set(ctx, "go", "https://go.dev/", "u1")         // nolint: errcheck
+	shortener.Storage.Set(ctx, "ya", "https://ya.ru/", "u1")          // nolint: errcheck
+	shortener.Storage.Set(ctx, "imdb", "https://www.imdb.com/", "u2") // nolint: errcheck
+	shortener.Storage.DeleteUserURLs(ctx, "u1", "ya")                 // nolint: errcheck
+	baseURL := strings.TrimRight(shortener.Config.BaseURL.String(), "/")
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		check       func(t *testing.T, body string)
+	}{
+		{
+			name:        "json by default",
+			query:       "",
+			contentType: "application/json",
+			check: func(t *testing.T, body string) {
+				items := make([]exports.Item, 0)
+				require.NoError(t, json.Unmarshal([]byte(body), &items))
+				require.Len(t, items, 2)
+				assert.Equal(t, baseURL+"/go", items[0].ShortURL)
+				assert.Equal(t, "https://go.dev/", items[0].OriginalURL)
+				assert.False(t, items[0].IsDeleted)
+				assert.False(t, items[0].CreatedAt.IsZero())
+				assert.Equal(t, baseURL+"/ya", items[1].ShortURL)
+				assert.True(t, items[1].IsDeleted)
+			},
+		},
+		{
+			name:        "ndjson",
+			query:       "?format=ndjson",
+			contentType: "application/x-ndjson",
+			check: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSpace(body), "\n")
+				require.Len(t, lines, 2)
+				var item exports.Item
+				require.NoError(t, json.Unmarshal([]byte(lines[1]), &item))
+				assert.Equal(t, "https://ya.ru/", item.OriginalURL)
+				assert.True(t, item.IsDeleted)
+			},
+		},
+		{
+			name:        "csv",
+			query:       "?format=csv",
+			contentType: "text/csv; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSpace(body), "\n")
+				require.Len(t, lines, 3)
+				assert.Equal(t, "short_url,original_url,created_at,is_deleted", lines[0])
+				assert.True(t, strings.HasPrefix(lines[1], baseURL+"/go,https://go.dev/,"))
+				assert.True(t, strings.HasSuffix(lines[2], ",true"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls/export"+tt.query, nil)
+			req.AddCookie(setAuthCookie(nil, shortener.Keys, "u1"))
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.Equal(t, 200, resp.StatusCode)
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+			tt.check(t, string(body))
+		})
+	}
+}
+
+func TestExportUserURLsSupportsGzip(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "u1") // nolint: errcheck
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls/export?format=ndjson", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "u1"))
+	// транспорт не должен распаковывать ответ самостоятельно
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	gzReader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(gzReader)
+	assert.Contains(t, string(body), `"original_url":"https://go.dev/"`)
+}
+
+func TestExportUserURLsHandlesEmptyListAndBadFormat(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls/export?format=json", nil)
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "u1"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "[]\n", string(body))
+
+	resp, _ = doTestRequest(t, ts, http.MethodGet, "/api/user/urls/export?format=xml", nil)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func waitForJob(t *testing.T, ts *httptest.Server, cookie *http.Cookie, jobID string) handlers.APIJobStatus {
+	var status handlers.APIJobStatus
+	require.Eventually(t, func() bool {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/jobs/"+jobID, nil)
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode)
+		json.NewDecoder(resp.Body).Decode(&status) // nolint:errcheck
+		return status.Status == "succeeded" || status.Status == "failed"
+	}, time.Second, time.Millisecond*10)
+	return status
+}
+
+func TestGetJobStatusIsVisibleToOwnerOnly(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	shortener.Storage.Set(context.TODO(), "wiki", "https://wikipedia.org/", "u1") // nolint: errcheck
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/user/urls", strings.NewReader(`["wiki"]`))
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "u1"))
+	resp, _ := http.DefaultClient.Do(req)
+	var accepted handlers.APIJobStatus
+	json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
+	resp.Body.Close()
+	require.Equal(t, 202, resp.StatusCode)
+
+	status := waitForJob(t, ts, setAuthCookie(nil, shortener.Keys, "u1"), accepted.ID)
+	assert.Equal(t, map[string]interface{}{"deleted": float64(1)}, status.Result)
+
+	for _, path := range []string{"/api/jobs/" + accepted.ID, "/api/jobs/unknown"} {
+		req, _ = http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.AddCookie(setAuthCookie(nil, shortener.Keys, "u2"))
+		resp, _ = http.DefaultClient.Do(req)
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	cookie := setAuthCookie(nil, shortener.Keys, "user1")
+	doWithAuth := func(method, path, body string, auth func(*http.Request)) (*http.Response, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		auth(req)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+	withCookie := func(req *http.Request) { req.AddCookie(cookie) }
+
+	resp, body := doWithAuth(http.MethodPost, "/api/keys", `{"scopes":["read"]}`, withCookie)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created handlers.APIKeyItem
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	require.NotEmpty(t, created.Key)
+	withKey := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+created.Key) }
+
+	// ключ аутентифицирует владельца, не выдавая анонимной куки
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint:errcheck
+	resp, body = doWithAuth(http.MethodGet, "/api/user/urls", "", withKey)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+	assert.Contains(t, body, "https://go.dev/")
+
+	// ключ только на чтение не позволяет сокращать ссылки
+	resp, _ = doWithAuth(http.MethodPost, "/api/shorten", `{"url":"https://yandex.ru/"}`, withKey)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	// и управлять ключами
+	resp, _ = doWithAuth(http.MethodGet, "/api/keys", "", withKey)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body = doWithAuth(http.MethodGet, "/api/keys", "", withCookie)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, body, created.Key)
+	assert.Contains(t, body, created.ID)
+
+	resp, _ = doWithAuth(http.MethodDelete, "/api/keys/"+created.ID, "", withCookie)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = doWithAuth(http.MethodGet, "/api/user/urls", "", withKey)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = doWithAuth(http.MethodDelete, "/api/keys/"+created.ID, "", withCookie)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWriteAPIKeyShortensForOwner(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/keys", strings.NewReader(`{"scopes":["write"]}`))
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "user1"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var created handlers.APIKeyItem
+	json.NewDecoder(resp.Body).Decode(&created) // nolint:errcheck
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/api/shorten", strings.NewReader(`{"url":"https://go.dev/"}`))
+	req.Header.Set("X-API-Key", created.Key)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	items, _ := shortener.Storage.GetURLsByUserID(context.TODO(), "user1")
+	assert.Len(t, items, 1)
+
+	resp, _ = doTestRequest(t, ts, http.MethodPost, "/api/keys", strings.NewReader(`{"scopes":["admin"]}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAccountLifecycle(t *testing.T) {
+	ts, _ := prepareTestServer(t)
+	// newBrowser возвращает клиента, хранящего куки между запросами
+	newBrowser := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		return &http.Client{Jar: jar}
+	}
+	do := func(client *http.Client, method, path, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+	const credentials = `{"email":"gopher@go.dev","password":"correct horse"}`
+
+	laptop := newBrowser()
+	resp, _ := do(laptop, http.MethodPost, "/api/shorten", `{"url":"https://go.dev/"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// при регистрации ссылки анонима переходят к учетной записи
+	resp, body := do(laptop, http.MethodPost, "/api/user/signup", credentials)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var account handlers.APIAccount
+	require.NoError(t, json.Unmarshal([]byte(body), &account))
+	assert.Equal(t, "gopher@go.dev", account.Email)
+	assert.Equal(t, 1, account.MergedURLs)
+	require.Len(t, resp.Cookies(), 1)
+	assert.Equal(t, resp.Cookies()[0].Value, resp.Header.Get(middleware.AuthTokenHeader))
+
+	resp, _ = do(newBrowser(), http.MethodPost, "/api/user/signup", credentials)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// после выхода браузер становится новым анонимом
+	resp, _ = do(laptop, http.MethodPost, "/api/user/logout", "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(laptop, http.MethodGet, "/api/user/urls", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// вход с другого устройства открывает доступ к ссылкам
+	phone := newBrowser()
+	resp, _ = do(phone, http.MethodPost, "/api/user/login", `{"email":"gopher@go.dev","password":"wrong horse"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, body = do(phone, http.MethodPost, "/api/user/login", credentials)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, account.ID)
+	resp, body = do(phone, http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "https://go.dev/")
+}
+
+func TestAccountSurvivesRestartWithFileStorage(t *testing.T) {
+	fileStoragePath := filepath.Join(t.TempDir(), "urls.json")
+	// start запускает сервис поверх одного и того же файла, без бд
+	start := func() (*httptest.Server, *app.App) {
+		shortener, err := app.New(func(cfg *app.Config) error {
+			cfg.FileStoragePath = fileStoragePath
+			cfg.DatabaseDSN = ""
+			return nil
+		})
+		require.NoError(t, err)
+		return httptest.NewServer(router.New(shortener)), shortener
+	}
+	do := func(ts *httptest.Server, client *http.Client, method, path, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+	newBrowser := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		return &http.Client{Jar: jar}
+	}
+	const credentials = `{"email":"gopher@go.dev","password":"correct horse"}`
+
+	ts, shortener := start()
+	browser := newBrowser()
+	resp, _ := do(ts, browser, http.MethodPost, "/api/shorten", `{"url":"https://go.dev/"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, body := do(ts, browser, http.MethodPost, "/api/user/signup", credentials)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var account handlers.APIAccount
+	require.NoError(t, json.Unmarshal([]byte(body), &account))
+	require.Equal(t, 1, account.MergedURLs)
+	ts.Close()
+	shortener.Close()
+
+	// после перезапуска учетная запись на месте, и ссылки, переданные ей, не потеряны
+	ts, shortener = start()
+	defer shortener.Cleanup()
+	defer shortener.Close()
+	defer ts.Close()
+	browser = newBrowser()
+	resp, body = do(ts, browser, http.MethodPost, "/api/user/login", credentials)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, account.ID)
+	resp, body = do(ts, browser, http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "https://go.dev/")
+}
+
+func prepareOIDCTestServer(t *testing.T) (*httptest.Server, *oidctest.Provider) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	ts, shortener := prepareTestServer(t, func(cfg *app.Config) error {
+		cfg.OIDCIssuer = idp.Issuer()
+		cfg.OIDCClientID = "shortener"
+		cfg.OIDCClientSecret = "s3cr3t"
+		return nil
+	})
+	// провайдер должен вернуть пользователя на адрес тестового сервера
+	baseURL, err := url.Parse(ts.URL + "/")
+	require.NoError(t, err)
+	shortener.Config.BaseURL = *baseURL
+	return ts, idp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ts, idp := prepareOIDCTestServer(t)
+	// login проходит вход через провайдера в новом браузере, останавливаясь на главной странице сервиса
+	login := func() (*http.Client, *http.Response) {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		browser := &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Path == "/" {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		}
+		resp, err := browser.Get(ts.URL + "/auth/oidc/login")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return browser, resp
+	}
+	userURLs := func(browser *http.Client) (int, string) {
+		resp, err := browser.Get(ts.URL + "/api/user/urls")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	laptop, resp := login()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, ts.URL+"/", resp.Header.Get("Location"))
+	assert.NotEmpty(t, resp.Header.Get(middleware.AuthTokenHeader))
+	resp, err := laptop.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://go.dev/"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// тот же пользователь провайдера получает доступ к своим ссылкам с другого устройства
+	phone, _ := login()
+	status, body := userURLs(phone)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "https://go.dev/")
+
+	// а другой пользователь провайдера - нет
+	idp.SetUser("rustacean", "rustacean@rust-lang.org")
+	other, _ := login()
+	status, _ = userURLs(other)
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestOIDCUserKeepsURLsOnPasswordLogin(t *testing.T) {
+	ts, _ := prepareOIDCTestServer(t)
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	// браузер проходит вход через провайдера, останавливаясь на главной странице сервиса
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Path == "/" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	do := func(method, path, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := browser.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+	const credentials = `{"email":"gopher@go.dev","password":"correct horse"}`
+	resp, _ := do(http.MethodPost, "/api/user/signup", credentials)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// браузер входит через провайдера и сокращает ссылку от имени пользователя SSO
+	resp, _ = do(http.MethodGet, "/auth/oidc/login", "")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	resp, _ = do(http.MethodPost, "/api/shorten", `{"url":"https://go.dev/"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// вход по паролю в том же браузере не забирает ссылки пользователя SSO
+	resp, body := do(http.MethodPost, "/api/user/login", credentials)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var account handlers.APIAccount
+	require.NoError(t, json.Unmarshal([]byte(body), &account))
+	assert.Equal(t, 0, account.MergedURLs)
+	resp, _ = do(http.MethodGet, "/api/user/urls", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = do(http.MethodGet, "/auth/oidc/login", "")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	resp, body = do(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "https://go.dev/")
+}
+
+func TestOIDCCallbackRejectsInvalidState(t *testing.T) {
+	ts, _ := prepareOIDCTestServer(t)
+	noRedirects := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, _ := doTestRequest(t, ts, http.MethodGet, "/auth/oidc/login", nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	require.Len(t, resp.Cookies(), 1)
+	stateCookie := resp.Cookies()[0]
+	assert.Equal(t, handlers.OIDCStateCookieName, stateCookie.Name)
+	assert.True(t, stateCookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	state := authURL.Query().Get("state")
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"no cookie", "?code=foo&state=" + state, nil, http.StatusBadRequest},
+		{"state mismatch", "?code=foo&state=forged", stateCookie, http.StatusBadRequest},
+		{
+			"tampered cookie",
+			"?code=foo&state=" + state,
+			&http.Cookie{Name: handlers.OIDCStateCookieName, Value: "e30." + strings.SplitN(stateCookie.Value, ".", 2)[1]},
+			http.StatusBadRequest,
+		},
+		{"provider denied", "?error=access_denied&state=" + state, stateCookie, http.StatusUnauthorized},
+		{"unknown code", "?code=foo&state=" + state, stateCookie, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/auth/oidc/callback"+tt.query, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			resp, err := noRedirects.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get(middleware.AuthTokenHeader))
+		})
+	}
+}
+
+func TestOIDCLoginDisabledByDefault(t *testing.T) {
+	ts, _ := prepareTestServer(t)
+	for _, path := range []string{"/auth/oidc/login", "/auth/oidc/callback"} {
+		resp, _ := doTestRequest(t, ts, http.MethodGet, path, nil)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
+
+func TestWorkspaceSharedLinks(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	cookies := map[string]*http.Cookie{}
+	for _, userID := range []string{"owner1", "editor1", "viewer1", "stranger"} {
+		cookies[userID] = setAuthCookie(nil, shortener.Keys, userID)
+		// в рабочие пространства добавляются только зарегистрированные пользователи
+		record := storage.UserRecord{ID: userID, Email: userID + "@go.dev"}
+		require.NoError(t, shortener.Users.SaveUser(context.TODO(), record))
+	}
+	do := func(userID, method, path, body string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.AddCookie(cookies[userID])
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+
+	resp, body := do("owner1", http.MethodPost, "/api/workspaces", `{"name":"Marketing"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var workspace handlers.APIWorkspace
+	require.NoError(t, json.Unmarshal([]byte(body), &workspace))
+	assert.Equal(t, "owner", workspace.Role)
+	wsPath := "/api/workspaces/" + workspace.ID
+	wsQuery := "?workspace=" + workspace.ID
+
+	resp, _ = do("owner1", http.MethodPut, wsPath+"/members/editor1", `{"role":"editor"}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("owner1", http.MethodPut, wsPath+"/members/viewer1", `{"role":"viewer"}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("editor1", http.MethodPut, wsPath+"/members/stranger", `{"role":"viewer"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = do("owner1", http.MethodPut, wsPath+"/members/stranger", `{"role":"admin"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do("owner1", http.MethodPut, wsPath+"/members/strnager", `{"role":"viewer"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = do("viewer1", http.MethodGet, wsPath+"/members", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var members []handlers.APIWorkspaceMember
+	require.NoError(t, json.Unmarshal([]byte(body), &members))
+	assert.Len(t, members, 3)
+	resp, body = do("viewer1", http.MethodGet, "/api/workspaces", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"role":"viewer"`)
+
+	// ссылки пространства создают редакторы, но не зрители
+	resp, _ = do("editor1", http.MethodPost, "/api/shorten"+wsQuery, `{"url":"https://go.dev/"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do("viewer1", http.MethodPost, "/api/shorten"+wsQuery, `{"url":"https://ya.ru/"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = do("editor1", http.MethodPost, "/api/shorten", `{"url":"https://ya.ru/"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// ссылки пространства видят все участники, но не посторонние; личные ссылки остаются личными
+	for _, userID := range []string{"owner1", "editor1", "viewer1"} {
+		resp, body = do(userID, http.MethodGet, "/api/user/urls"+wsQuery, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode, userID)
+		assert.Contains(t, body, "https://go.dev/", userID)
+		assert.NotContains(t, body, "https://ya.ru/", userID)
+	}
+	resp, _ = do("stranger", http.MethodGet, "/api/user/urls"+wsQuery, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, body = do("editor1", http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, body, "https://go.dev/")
+
+	var shortURLs []handlers.APIUserURLItem
+	_, body = do("viewer1", http.MethodGet, "/api/user/urls"+wsQuery, "")
+	require.NoError(t, json.Unmarshal([]byte(body), &shortURLs))
+	shortID := shortURLs[0].ShortURL[strings.LastIndex(shortURLs[0].ShortURL, "/")+1:]
+
+	// удалять ссылки пространства могут редакторы, а статус удаления видят все участники
+	resp, _ = do("viewer1", http.MethodDelete, "/api/user/urls"+wsQuery, `["`+shortID+`"]`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = do("stranger", http.MethodDelete, "/api/user/urls"+wsQuery, `["`+shortID+`"]`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, body = do("editor1", http.MethodDelete, "/api/user/urls"+wsQuery, `["`+shortID+`"]`)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var accepted handlers.APIJobStatus
+	require.NoError(t, json.Unmarshal([]byte(body), &accepted))
+	status := waitForJob(t, ts, cookies["viewer1"], accepted.ID)
+	assert.Equal(t, map[string]interface{}{"deleted": float64(1)}, status.Result)
+	resp, _ = do("owner1", http.MethodGet, "/api/user/urls"+wsQuery, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// исключенный участник теряет доступ, последнего владельца исключить нельзя
+	resp, _ = do("owner1", http.MethodDelete, wsPath+"/members/viewer1", "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("viewer1", http.MethodGet, "/api/user/urls"+wsQuery, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do("owner1", http.MethodDelete, wsPath+"/members/owner1", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestWorkspaceImportURLs(t *testing.T) {
+	ctx := context.TODO()
+	ts, shortener := prepareTestServer(t)
+	cookies := map[string]*http.Cookie{}
+	for _, userID := range []string{"owner1", "editor1", "viewer1", "stranger"} {
+		cookies[userID] = setAuthCookie(nil, shortener.Keys, userID)
+		record := storage.UserRecord{ID: userID, Email: userID + "@go.dev"}
+		require.NoError(t, shortener.Users.SaveUser(ctx, record))
+	}
+	svc := service.New(shortener)
+	workspace, err := svc.CreateWorkspace(ctx, "owner1", "Marketing")
+	require.NoError(t, err)
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "editor1", service.RoleEditor))
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "viewer1", service.RoleViewer))
+	importURLs := func(userID, query string) *http.Response {
+		body := strings.NewReader("https://go.dev/\nhttps://ya.ru/\n")
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/import?format=csv"+query, body)
+		req.AddCookie(cookies[userID])
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	wsQuery := "&workspace=" + workspace.ID
+
+	// импортировать в пространство могут редакторы, но не зрители и не посторонние
+	resp := importURLs("viewer1", wsQuery)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = importURLs("stranger", wsQuery)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = importURLs("editor1", wsQuery)
+	var accepted handlers.APIImportProgress
+	json.NewDecoder(resp.Body).Decode(&accepted) // nolint:errcheck
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// прогресс импорта видят участники пространства, но не посторонние
+	progress := waitForImport(t, ts, cookies["viewer1"], accepted.ID)
+	assert.Equal(t, "succeeded", progress.Status)
+	assert.Equal(t, 2, progress.Created)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/import/"+accepted.ID, nil)
+	req.AddCookie(cookies["stranger"])
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// ссылки достаются пространству, а не импортировавшему их пользователю
+	items, _ := shortener.Storage.GetURLsByUserID(ctx, workspace.ID)
+	assert.Len(t, items, 2)
+	items, _ = shortener.Storage.GetURLsByUserID(ctx, "editor1")
+	assert.Len(t, items, 0)
+}
+
+func TestWorkspacesCannotBeManagedWithAPIKey(t *testing.T) {
+	ts, shortener := prepareTestServer(t)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/keys", strings.NewReader(`{"scopes":["read","write"]}`))
+	req.AddCookie(setAuthCookie(nil, shortener.Keys, "user1"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var key handlers.APIKeyItem
+	json.NewDecoder(resp.Body).Decode(&key) // nolint:errcheck
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/api/workspaces", strings.NewReader(`{"name":"Marketing"}`))
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
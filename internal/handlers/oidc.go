@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/oidc"
+)
+
+const (
+	OIDCStateCookieName = "oidc_state"
+	oidcStateCookiePath = "/auth/oidc/"
+	oidcStateTTL        = 10 * time.Minute
+)
+
+var ErrInvalidOIDCState = errors.New("invalid or expired login state")
+
+// oidcState - параметры входа, которые клиент хранит в подписанной куке, пока находится на стороне провайдера
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expires  int64  `json:"exp"`
+}
+
+// OIDCLogin перенаправляет пользователя на страницу входа провайдера OIDC, сохраняя параметры входа
+// в подписанной куке до его возвращения. Если вход через провайдер не настроен, возвращает 404
+func (handler Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := handler.App.OIDC
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	state := oidcState{Expires: time.Now().Add(oidcStateTTL).Unix()}
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		*value = random
+	}
+	authURL, err := provider.AuthCodeURL(
+		r.Context(), handler.App.Config.OIDCCallbackURL(), state.State, state.Nonce, state.Verifier,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).WithError(err).Error("oidc provider is unavailable")
+		http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	if err := handler.setOIDCState(w, state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback принимает пользователя, вернувшегося от провайдера OIDC с кодом авторизации,
+// выдает ему токен пользователя, соответствующего subject провайдера, и перенаправляет на главную страницу
+// В случае отсутствия или несовпадения параметров входа возвращает 400,
+// отказа провайдера или невалидного id token - 401
+func (handler Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := handler.App.OIDC
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// параметры входа одноразовые
+	state, err := handler.getOIDCState(r)
+	handler.clearOIDCState(w)
+	query := r.URL.Query()
+	if err != nil || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		http.Error(w, ErrInvalidOIDCState.Error(), http.StatusBadRequest)
+		return
+	}
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "identity provider denied login: "+reason, http.StatusUnauthorized)
+		return
+	}
+	claims, err := provider.Exchange(
+		r.Context(), query.Get("code"), handler.App.Config.OIDCCallbackURL(), state.Verifier, state.Nonce,
+	)
+	if err != nil {
+		writeOIDCError(w, r, err)
+		return
+	}
+	user := handler.Service.SSOUser(r.Context(), claims)
+	if err := middleware.IssueToken(w, handler.App.Tokens, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, handler.App.Config.BaseURL.String(), http.StatusFound)
+}
+
+func writeOIDCError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).WithError(err).Warn("failed to log in with oidc")
+	switch {
+	case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrUnknownSigningKey):
+		http.Error(w, "invalid id token", http.StatusUnauthorized)
+	case errors.Is(err, oidc.ErrTokenExchange):
+		// например, код авторизации просрочен или уже использован
+		http.Error(w, "unable to complete login", http.StatusUnauthorized)
+	default:
+		http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+	}
+}
+
+// setOIDCState сохраняет параметры входа в куке, подписанной активным ключом сервиса
+func (handler Handler) setOIDCState(w http.ResponseWriter, state oidcState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature := handler.App.Keys.Signer().SignPrefixed([]byte(payload))
+	handler.writeOIDCStateCookie(w, payload+"."+signature, time.Unix(state.Expires, 0))
+	return nil
+}
+
+func (handler Handler) getOIDCState(r *http.Request) (oidcState, error) {
+	var state oidcState
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil {
+		return state, ErrInvalidOIDCState
+	}
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return state, ErrInvalidOIDCState
+	}
+	if err := handler.App.Keys.Verifier().Verify([]byte(parts[0]), parts[1]); err != nil {
+		return state, ErrInvalidOIDCState
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return state, ErrInvalidOIDCState
+	}
+	if err := json.Unmarshal(data, &state); err != nil || time.Now().Unix() > state.Expires {
+		return oidcState{}, ErrInvalidOIDCState
+	}
+	return state, nil
+}
+
+func (handler Handler) clearOIDCState(w http.ResponseWriter) {
+	handler.writeOIDCStateCookie(w, "", time.Unix(0, 0))
+}
+
+// writeOIDCStateCookie выставляет куку с параметрами входа. Кука должна дойти до сервиса при переходе
+// со страницы провайдера, поэтому SameSite не может быть строже Lax
+func (handler Handler) writeOIDCStateCookie(w http.ResponseWriter, value string, expires time.Time) {
+	policy := handler.App.Tokens.CookiePolicy()
+	cookie := &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    value,
+		Path:     oidcStateCookiePath,
+		Domain:   policy.Domain,
+		Expires:  expires,
+		Secure:   policy.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
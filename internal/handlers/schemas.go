@@ -1,5 +1,7 @@
 package handlers
 
+import "time"
+
 type APIShortenRequest struct {
 	URL string `json:"url"` // Оригинальный длинный URL, требующий укорачивания
 }
@@ -22,3 +24,68 @@ type APIShortenBatchResultItem struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
 }
+
+type APIJobStatus struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Status     string      `json:"status"`
+	Attempts   int         `json:"attempts"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	EnqueuedAt time.Time   `json:"enqueued_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+type APIImportProgress struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Processed  int    `json:"processed"`
+	Created    int    `json:"created"`
+	Duplicates int    `json:"duplicates"`
+	Skipped    int    `json:"skipped"`
+	Error      string `json:"error,omitempty"`
+}
+
+type APICreateKeyRequest struct {
+	Scopes []string `json:"scopes"`
+}
+
+type APIKeyItem struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key,omitempty"` // Ключ показывается только при создании
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APICredentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type APIAccount struct {
+	ID         string `json:"id"`
+	Email      string `json:"email"`
+	MergedURLs int    `json:"merged_urls"`
+}
+
+type APICreateWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type APIWorkspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"` // Роль текущего пользователя в рабочем пространстве
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIWorkspaceMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type APIWorkspaceMember struct {
+	UserID  string    `json:"user_id"`
+	Role    string    `json:"role"`
+	AddedAt time.Time `json:"added_at"`
+}
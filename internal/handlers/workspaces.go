@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/http/resp"
+)
+
+// CreateWorkspace создает рабочее пространство с названием из json, владельцем которого становится
+// текущий пользователь. В случае успеха возвращает 201, в случае пустого или слишком длинного названия - 400
+func (handler Handler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceManager(w, r)
+	if !ok {
+		return
+	}
+	var createReq APICreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	workspace, err := handler.Service.CreateWorkspace(r.Context(), user.ID, createReq.Name)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	result := newAPIWorkspace(workspace)
+	resp.JSONResponse(&result, w, http.StatusCreated)
+}
+
+// GetWorkspaces возвращает рабочие пространства, в которых состоит текущий пользователь, с его ролью в них
+func (handler Handler) GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return
+	}
+	workspaces, err := handler.Service.UserWorkspaces(r.Context(), user.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	items := make([]APIWorkspace, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		items = append(items, newAPIWorkspace(workspace))
+	}
+	resp.JSONResponse(&items, w, http.StatusOK)
+}
+
+// GetWorkspaceMembers возвращает участников рабочего пространства
+// В случае неизвестного пространства (или пространства, в котором пользователь не состоит) возвращает 404
+func (handler Handler) GetWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return
+	}
+	members, err := handler.Service.WorkspaceMembers(r.Context(), user.ID, chi.URLParam(r, "workspaceID"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	items := make([]APIWorkspaceMember, 0, len(members))
+	for _, member := range members {
+		items = append(items, APIWorkspaceMember{UserID: member.UserID, Role: member.Role, AddedAt: member.AddedAt})
+	}
+	resp.JSONResponse(&items, w, http.StatusOK)
+}
+
+// SetWorkspaceMember добавляет пользователя в рабочее пространство с ролью из json либо меняет его роль
+// и возвращает 204. Доступно только владельцам пространства, остальным участникам возвращает 403
+// В случае неизвестной роли возвращает 400, попытки оставить пространство без владельца - 409
+func (handler Handler) SetWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceManager(w, r)
+	if !ok {
+		return
+	}
+	var memberReq APIWorkspaceMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&memberReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := handler.Service.SetWorkspaceMember(
+		r.Context(), user.ID, chi.URLParam(r, "workspaceID"), chi.URLParam(r, "userID"), memberReq.Role,
+	)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveWorkspaceMember исключает пользователя из рабочего пространства и возвращает 204.
+// Участник может покинуть пространство сам, исключать других могут только владельцы
+func (handler Handler) RemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	user, ok := workspaceManager(w, r)
+	if !ok {
+		return
+	}
+	err := handler.Service.RemoveWorkspaceMember(
+		r.Context(), user.ID, chi.URLParam(r, "workspaceID"), chi.URLParam(r, "userID"),
+	)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// workspaceManager возвращает пользователя, которому разрешено управлять рабочими пространствами.
+// Утекший ключ API не должен позволять добавить в пространство постороннего
+func workspaceManager(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	user, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "not authenticated", http.StatusForbidden)
+		return nil, false
+	}
+	if user.APIKeyID != "" {
+		http.Error(w, "workspaces cannot be managed with an api key", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func newAPIWorkspace(workspace service.Workspace) APIWorkspace {
+	return APIWorkspace{
+		ID:        workspace.ID,
+		Name:      workspace.Name,
+		Role:      workspace.Role,
+		CreatedAt: workspace.CreatedAt,
+	}
+}
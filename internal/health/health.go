@@ -0,0 +1,143 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// shutdownComponent - компонент отчета, сообщающий об остановке сервиса
+const shutdownComponent = "shutdown"
+
+var ErrShuttingDown = errors.New("service is shutting down")
+var ErrMissingTable = errors.New("table does not exist")
+
+// Check проверяет работоспособность одного из компонентов сервиса
+type Check func(context.Context) error
+
+type ComponentStatus struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+type component struct {
+	name  string
+	check Check
+}
+
+// Checker проверяет готовность сервиса принимать запросы, опрашивая зарегистрированные компоненты.
+// После начала остановки сервиса сервис считается неготовым независимо от состояния компонентов,
+// чтобы балансировщик успел вывести его из ротации
+type Checker struct {
+	timeout      time.Duration
+	mu           sync.RWMutex
+	components   []component
+	shuttingDown int32
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Register добавляет проверку компонента с заданным именем
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.components = append(c.components, component{name: name, check: check})
+}
+
+// SetShuttingDown переводит сервис в состояние остановки
+func (c *Checker) SetShuttingDown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+func (c *Checker) ShuttingDown() bool {
+	return atomic.LoadInt32(&c.shuttingDown) == 1
+}
+
+// Check параллельно опрашивает компоненты, ограничивая каждую проверку таймаутом
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.RLock()
+	components := make([]component, len(c.components))
+	copy(components, c.components)
+	c.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentStatus, len(components)+1)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, comp := range components {
+		wg.Add(1)
+		go func(comp component) {
+			defer wg.Done()
+			status := c.run(ctx, comp.check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[comp.name] = status
+		}(comp)
+	}
+	wg.Wait()
+
+	shutdown := ComponentStatus{Status: StatusOK}
+	if c.ShuttingDown() {
+		shutdown = ComponentStatus{Status: StatusUnavailable, Error: ErrShuttingDown.Error()}
+	}
+	report.Components[shutdownComponent] = shutdown
+	for _, status := range report.Components {
+		if status.Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	started := time.Now()
+	err := check(ctx)
+	status := ComponentStatus{
+		Status:  StatusOK,
+		Latency: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = StatusUnavailable
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// TablesExist проверяет, что таблицы, создаваемые при инициализации сервиса, существуют в бд
+func TablesExist(db *pgxpool.Pool, tables ...string) Check {
+	return func(ctx context.Context) error {
+		for _, table := range tables {
+			var exists bool
+			if err := db.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%w: %s", ErrMissingTable, table)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckerReportsEveryComponent(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Register("storage", func(context.Context) error { return nil })
+	checker.Register("jobs", func(context.Context) error { return nil })
+
+	report := checker.Check(context.TODO())
+	assert.True(t, report.OK())
+	assert.Equal(t, health.StatusOK, report.Status)
+	require.Len(t, report.Components, 3)
+	for _, name := range []string{"storage", "jobs", "shutdown"} {
+		assert.Equal(t, health.StatusOK, report.Components[name].Status)
+	}
+}
+
+func TestCheckerFailsWhenAnyComponentFails(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Register("storage", func(context.Context) error { return errors.New("connection refused") })
+	checker.Register("jobs", func(context.Context) error { return nil })
+
+	report := checker.Check(context.TODO())
+	assert.False(t, report.OK())
+	assert.Equal(t, health.StatusUnavailable, report.Status)
+	assert.Equal(t, health.StatusUnavailable, report.Components["storage"].Status)
+	assert.Equal(t, "connection refused", report.Components["storage"].Error)
+	assert.Equal(t, health.StatusOK, report.Components["jobs"].Status)
+}
+
+func TestCheckerLimitsCheckDuration(t *testing.T) {
+	checker := health.NewChecker(time.Millisecond * 50)
+	checker.Register("storage", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	started := time.Now()
+	report := checker.Check(context.TODO())
+	assert.Less(t, time.Since(started), time.Millisecond*500)
+	assert.Equal(t, health.StatusUnavailable, report.Components["storage"].Status)
+	assert.GreaterOrEqual(t, report.Components["storage"].Latency, 50.0)
+}
+
+func TestCheckerIsNotReadyWhenShuttingDown(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Register("storage", func(context.Context) error { return nil })
+	assert.False(t, checker.ShuttingDown())
+
+	checker.SetShuttingDown()
+	report := checker.Check(context.TODO())
+	assert.True(t, checker.ShuttingDown())
+	assert.False(t, report.OK())
+	assert.Equal(t, health.StatusUnavailable, report.Components["shutdown"].Status)
+	assert.Equal(t, health.ErrShuttingDown.Error(), report.Components["shutdown"].Error)
+	assert.Equal(t, health.StatusOK, report.Components["storage"].Status)
+}
@@ -0,0 +1,198 @@
+package imports
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/url/shortener"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Progress - снимок состояния импорта на текущий момент
+type Progress struct {
+	ID         string
+	UserID     string
+	Status     Status
+	Processed  int // количество прочитанных записей, включая невалидные
+	Created    int // количество вновь сокращенных ссылок
+	Duplicates int // количество ссылок, сокращенных ранее
+	Skipped    int // количество невалидных записей
+	Err        error
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Import отслеживает прогресс выполнения одного импорта.
+// Методы безопасны для использования из нескольких горутин
+type Import struct {
+	mu       sync.RWMutex
+	progress Progress
+}
+
+func (imp *Import) Progress() Progress {
+	imp.mu.RLock()
+	defer imp.mu.RUnlock()
+	return imp.progress
+}
+
+func (imp *Import) update(fn func(*Progress)) {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+	fn(&imp.progress)
+}
+
+func (imp *Import) start() {
+	imp.update(func(p *Progress) {
+		p.Status = StatusRunning
+	})
+}
+
+// Fail завершает импорт с ошибкой, например если его не удалось поставить в очередь или начать
+func (imp *Import) Fail(err error) {
+	imp.finish(err)
+}
+
+func (imp *Import) finish(err error) {
+	imp.update(func(p *Progress) {
+		if err != nil {
+			p.Status = StatusFailed
+			p.Err = err
+		} else {
+			p.Status = StatusSucceeded
+		}
+		p.FinishedAt = time.Now()
+	})
+}
+
+// Registry хранит состояние последних импортов.
+// При превышении лимита из реестра вытесняются самые старые импорты
+type Registry struct {
+	mu      sync.RWMutex
+	items   map[string]*Import
+	order   []string
+	maxSize int
+}
+
+func NewRegistry(maxSize int) *Registry {
+	return &Registry{
+		items:   make(map[string]*Import),
+		order:   make([]string, 0, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+// Register заводит в реестре новый импорт в статусе queued
+func (reg *Registry) Register(id, userID string) *Import {
+	imp := &Import{
+		progress: Progress{
+			ID:        id,
+			UserID:    userID,
+			Status:    StatusQueued,
+			CreatedAt: time.Now(),
+		},
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for len(reg.order) > 0 && len(reg.order) >= reg.maxSize {
+		delete(reg.items, reg.order[0])
+		reg.order = reg.order[1:]
+	}
+	reg.items[id] = imp
+	reg.order = append(reg.order, id)
+	return imp
+}
+
+// Get возвращает импорт по его идентификатору
+func (reg *Registry) Get(id string) (*Import, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	imp, ok := reg.items[id]
+	return imp, ok
+}
+
+// Importer порционно сохраняет в хранилище ссылки, прочитанные из источника импорта
+type Importer struct {
+	Storage   storage.URLStorer
+	Shortener shortener.Shortener
+	ChunkSize int
+}
+
+// Run выполняет импорт ссылок пользователя, вычитывая их из src
+// и сохраняя в хранилище пачками размером ChunkSize с помощью SaveBatch.
+// Прогресс выполнения отражается в imp по мере сохранения каждой пачки
+func (importer Importer) Run(ctx context.Context, imp *Import, src RecordReader) error {
+	imp.start()
+	err := importer.run(ctx, imp, src)
+	imp.finish(err)
+	return err
+}
+
+func (importer Importer) run(ctx context.Context, imp *Import, src RecordReader) error {
+	userID := imp.Progress().UserID
+	chunk := make([]storage.BatchItem, 0, importer.ChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		longURL, err := src.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			// невалидные записи пропускаем, учитывая их в статистике
+			if errors.Is(err, ErrInvalidRecord) {
+				imp.update(func(p *Progress) {
+					p.Processed++
+					p.Skipped++
+				})
+				continue
+			}
+			return err
+		}
+		shortID := importer.Shortener.Shorten(longURL)
+		chunk = append(chunk, storage.BatchItem{ShortID: shortID, LongURL: longURL, UserID: userID})
+		if len(chunk) >= importer.ChunkSize {
+			if err := importer.saveChunk(ctx, imp, chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		return importer.saveChunk(ctx, imp, chunk)
+	}
+	return nil
+}
+
+func (importer Importer) saveChunk(ctx context.Context, imp *Import, chunk []storage.BatchItem) error {
+	result, err := importer.Storage.SaveBatch(ctx, chunk)
+	if err != nil {
+		return err
+	}
+	// ссылки, уже имевшиеся в хранилище (или повторяющиеся внутри пачки),
+	// сохраняются под ранее выданным коротким идентификатором
+	created := make(map[string]struct{})
+	for _, item := range chunk {
+		if result[item.LongURL] == item.ShortID {
+			created[item.LongURL] = struct{}{}
+		}
+	}
+	imp.update(func(p *Progress) {
+		p.Processed += len(chunk)
+		p.Created += len(created)
+		p.Duplicates += len(chunk) - len(created)
+	})
+	return nil
+}
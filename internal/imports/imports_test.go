@@ -0,0 +1,162 @@
+package imports_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/imports"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/url/shortener"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, src imports.RecordReader) ([]string, int) {
+	urls := make([]string, 0)
+	invalid := 0
+	for {
+		longURL, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, imports.ErrInvalidRecord) {
+			invalid++
+			continue
+		}
+		require.NoError(t, err)
+		urls = append(urls, longURL)
+	}
+	return urls, invalid
+}
+
+func TestReadersParseRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  imports.Format
+		body    string
+		want    []string
+		invalid int
+	}{
+		{
+			name:   "ndjson",
+			format: imports.FormatNDJSON,
+			body:   "{\"original_url\": \"https://go.dev/\"}\n\n{\"original_url\": \"https://ya.ru/\"}\n",
+			want:   []string{"https://go.dev/", "https://ya.ru/"},
+		},
+		{
+			name:    "ndjson with invalid lines",
+			format:  imports.FormatNDJSON,
+			body:    "{\"original_url\": \"https://go.dev/\"}\n]1[\n{\"url\": \"https://ya.ru/\"}\n[]",
+			want:    []string{"https://go.dev/"},
+			invalid: 3,
+		},
+		{
+			name:   "ndjson with too long line",
+			format: imports.FormatNDJSON,
+			body: "{\"original_url\": \"https://go.dev/\"}\n" +
+				"{\"original_url\": \"https://ya.ru/?q=" + strings.Repeat("a", 100*1024) + "\"}\n" +
+				"{\"original_url\": \"https://example.com/\"}",
+			want:    []string{"https://go.dev/", "https://example.com/"},
+			invalid: 1,
+		},
+		{
+			name:    "ndjson ending with too long line",
+			format:  imports.FormatNDJSON,
+			body:    "{\"original_url\": \"https://go.dev/\"}\n" + strings.Repeat("a", 200*1024),
+			want:    []string{"https://go.dev/"},
+			invalid: 1,
+		},
+		{
+			name:   "csv without header",
+			format: imports.FormatCSV,
+			body:   "https://go.dev/,foo\nhttps://ya.ru/\n",
+			want:   []string{"https://go.dev/", "https://ya.ru/"},
+		},
+		{
+			name:    "csv with header",
+			format:  imports.FormatCSV,
+			body:    "short_url,original_url\nfoo,https://go.dev/\nbar,https://ya.ru/\nbaz\nham,\n",
+			want:    []string{"https://go.dev/", "https://ya.ru/"},
+			invalid: 2,
+		},
+		{
+			name:   "empty csv",
+			format: imports.FormatCSV,
+			body:   "",
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := imports.NewReader(tt.format, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			urls, invalid := readAll(t, src)
+			assert.Equal(t, tt.want, urls)
+			assert.Equal(t, tt.invalid, invalid)
+		})
+	}
+}
+
+func TestNewReaderUnknownFormat(t *testing.T) {
+	_, err := imports.NewReader("xml", strings.NewReader(""))
+	assert.ErrorIs(t, err, imports.ErrUnknownFormat)
+}
+
+func TestImporterSavesURLsInChunks(t *testing.T) {
+	ctx := context.TODO()
+	store := storage.NewLocmemURLStorerBackend()
+	store.Set(ctx, "go", "https://go.dev/", "other") // nolint:errcheck
+
+	importer := imports.Importer{Storage: store, Shortener: shortener.NewRandShortener(), ChunkSize: 2}
+	registry := imports.NewRegistry(10)
+	imp := registry.Register("import1", "user1")
+
+	body := "https://go.dev/\nhttps://ya.ru/\n,broken\nhttps://ya.ru/\nhttps://example.com/\n"
+	err := importer.Run(ctx, imp, imports.NewCSVReader(strings.NewReader(body)))
+	require.NoError(t, err)
+
+	progress := imp.Progress()
+	assert.Equal(t, imports.StatusSucceeded, progress.Status)
+	assert.Equal(t, 5, progress.Processed)
+	assert.Equal(t, 2, progress.Created)
+	assert.Equal(t, 2, progress.Duplicates)
+	assert.Equal(t, 1, progress.Skipped)
+	assert.False(t, progress.FinishedAt.IsZero())
+
+	userItems, _ := store.GetURLsByUserID(ctx, "user1")
+	assert.Len(t, userItems, 2)
+}
+
+func TestImporterReportsFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	importer := imports.Importer{
+		Storage:   storage.NewLocmemURLStorerBackend(),
+		Shortener: shortener.NewRandShortener(),
+		ChunkSize: 2,
+	}
+	imp := imports.NewRegistry(10).Register("import1", "user1")
+	err := importer.Run(ctx, imp, imports.NewCSVReader(strings.NewReader("https://go.dev/\n")))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, imports.StatusFailed, imp.Progress().Status)
+	assert.ErrorIs(t, imp.Progress().Err, context.Canceled)
+}
+
+func TestRegistryEvictsOldestImports(t *testing.T) {
+	registry := imports.NewRegistry(2)
+	registry.Register("foo", "user1")
+	registry.Register("bar", "user1")
+	registry.Register("baz", "user2")
+
+	_, found := registry.Get("foo")
+	assert.False(t, found)
+	imp, found := registry.Get("baz")
+	require.True(t, found)
+	assert.Equal(t, "user2", imp.Progress().UserID)
+	assert.Equal(t, imports.StatusQueued, imp.Progress().Status)
+	_, found = registry.Get("bar")
+	assert.True(t, found)
+}
@@ -0,0 +1,173 @@
+package imports
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatNDJSON Format = "ndjson"
+	FormatCSV    Format = "csv"
+)
+
+// csvURLColumn - имя колонки с длинной ссылкой в заголовке csv-файла
+const csvURLColumn = "original_url"
+
+// maxLineSize ограничивает длину одной строки ndjson. Более длинная строка считается невалидной записью
+const maxLineSize = 64 * 1024
+
+var ErrUnknownFormat = errors.New("unknown import format")
+var ErrInvalidRecord = errors.New("invalid import record")
+var errLineTooLong = errors.New("line is too long")
+
+// RecordReader поточно читает длинные ссылки из источника импорта.
+// По окончании источника возвращает io.EOF, в случае невалидной записи - ошибку ErrInvalidRecord,
+// после которой чтение можно продолжить
+type RecordReader interface {
+	Read() (string, error)
+}
+
+type ndjsonRecord struct {
+	OriginalURL string `json:"original_url"`
+}
+
+type ndjsonReader struct {
+	reader *bufio.Reader
+}
+
+// NewNDJSONReader читает ссылки из потока json-объектов вида {"original_url": "..."},
+// разделенных переводом строки. Пустые строки пропускаются
+func NewNDJSONReader(r io.Reader) RecordReader {
+	return &ndjsonReader{reader: bufio.NewReaderSize(r, maxLineSize)}
+}
+
+func (r *ndjsonReader) Read() (string, error) {
+	for {
+		line, err := r.readLine()
+		if errors.Is(err, errLineTooLong) {
+			return "", fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		// последняя строка может не заканчиваться переводом строки
+		text := strings.TrimSpace(string(line))
+		if text == "" {
+			if err != nil {
+				return "", io.EOF
+			}
+			continue
+		}
+		var record ndjsonRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+		}
+		if record.OriginalURL == "" {
+			return "", fmt.Errorf("%w: empty url", ErrInvalidRecord)
+		}
+		return record.OriginalURL, nil
+	}
+}
+
+// readLine возвращает очередную строку. Строка длиннее maxLineSize пропускается целиком
+// с ошибкой errLineTooLong, чтобы чтение можно было продолжить со следующей строки
+func (r *ndjsonReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return line, err
+	}
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = r.reader.ReadSlice('\n')
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return nil, errLineTooLong
+}
+
+type csvReader struct {
+	reader    *csv.Reader
+	column    int
+	preloaded []string
+}
+
+// NewCSVReader читает ссылки из csv. Если первая строка содержит колонку original_url,
+// то она считается заголовком и ссылки берутся из этой колонки, иначе - из первой колонки каждой строки
+func NewCSVReader(r io.Reader) RecordReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return &csvReader{reader: reader, column: -1}
+}
+
+func (r *csvReader) Read() (string, error) {
+	// при первом чтении определяем, с какой колонкой нам работать
+	if r.column < 0 {
+		if err := r.detectColumn(); err != nil {
+			return "", err
+		}
+	}
+	var record []string
+	if r.preloaded != nil {
+		record, r.preloaded = r.preloaded, nil
+	} else {
+		var err error
+		record, err = r.reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", io.EOF
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return "", fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+			}
+			return "", err
+		}
+	}
+	if len(record) <= r.column {
+		return "", fmt.Errorf("%w: missing url column", ErrInvalidRecord)
+	}
+	longURL := strings.TrimSpace(record[r.column])
+	if longURL == "" {
+		return "", fmt.Errorf("%w: empty url", ErrInvalidRecord)
+	}
+	return longURL, nil
+}
+
+func (r *csvReader) detectColumn() error {
+	r.column = 0
+	first, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+		}
+		return err
+	}
+	for i, name := range first {
+		if strings.TrimSpace(name) == csvURLColumn {
+			r.column = i
+			return nil
+		}
+	}
+	// заголовка нет - первая строка является обычной записью
+	r.preloaded = first
+	return nil
+}
+
+// NewReader возвращает ридер для указанного формата импорта
+func NewReader(format Format, r io.Reader) (RecordReader, error) {
+	switch format {
+	case FormatNDJSON:
+		return NewNDJSONReader(r), nil
+	case FormatCSV:
+		return NewCSVReader(r), nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
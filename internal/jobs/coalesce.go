@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+)
+
+var ErrCoalescerClosed = errors.New("delete coalescer is closed")
+
+// URLDeleter удаляет ссылки пользователя. Ему удовлетворяют как хранилище, так и DeleteCoalescer
+type URLDeleter interface {
+	DeleteUserURLs(context.Context, string, ...string) (int, error)
+}
+
+// Состояния запроса в пачке: ожидает сброса, взят в сбрасываемую пачку, отменен вызывающим
+const (
+	requestPending int32 = iota
+	requestClaimed
+	requestAbandoned
+)
+
+type deleteRequest struct {
+	userID   string
+	shortIDs []string
+	result   chan deleteResponse
+	state    *int32
+}
+
+type deleteResponse struct {
+	deleted int
+	err     error
+}
+
+// DeleteCoalescer накапливает запросы на удаление ссылок от разных пользователей
+// и сбрасывает их в хранилище одним вызовом DeleteURLsBatch: по истечении окна Window
+// с момента первого запроса в пачке либо по достижении MaxSize ссылок в ней.
+// Каждый вызывающий дожидается сброса своей пачки и получает количество удаленных им ссылок.
+// Сброс пачки ограничен таймаутом timeout, чтобы зависший вызов хранилища не блокировал последующие пачки
+type DeleteCoalescer struct {
+	store    storage.URLStorer
+	maxSize  int
+	window   time.Duration
+	timeout  time.Duration
+	requests chan deleteRequest
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewDeleteCoalescer(store storage.URLStorer, maxSize int, window, timeout time.Duration) *DeleteCoalescer {
+	coalescer := &DeleteCoalescer{
+		store:    store,
+		maxSize:  maxSize,
+		window:   window,
+		timeout:  timeout,
+		requests: make(chan deleteRequest),
+		done:     make(chan struct{}),
+	}
+	coalescer.wg.Add(1)
+	go coalescer.run()
+	return coalescer
+}
+
+// DeleteUserURLs ставит ссылки пользователя в текущую пачку и дожидается ее сброса в хранилище
+func (coalescer *DeleteCoalescer) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) (int, error) {
+	if len(shortIDs) == 0 {
+		return 0, nil
+	}
+	req := deleteRequest{
+		userID:   userID,
+		shortIDs: shortIDs,
+		result:   make(chan deleteResponse, 1),
+		state:    new(int32),
+	}
+	select {
+	case coalescer.requests <- req:
+	case <-coalescer.done:
+		return 0, ErrCoalescerClosed
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	select {
+	case resp := <-req.result:
+		return resp.deleted, resp.err
+	case <-ctx.Done():
+		// пока пачка не сброшена, запрос можно отозвать, и тогда ссылки удалены не будут.
+		// Если же пачка уже сбрасывается, дожидаемся ее результата, чтобы не сообщить об отмене удаления,
+		// которое на самом деле состоялось
+		if atomic.CompareAndSwapInt32(req.state, requestPending, requestAbandoned) {
+			return 0, ctx.Err()
+		}
+		resp := <-req.result
+		return resp.deleted, resp.err
+	}
+}
+
+// Close сбрасывает накопленную пачку и останавливает прием запросов
+func (coalescer *DeleteCoalescer) Close() {
+	close(coalescer.done)
+	coalescer.wg.Wait()
+}
+
+func (coalescer *DeleteCoalescer) run() {
+	defer coalescer.wg.Done()
+	batch := make([]deleteRequest, 0)
+	size := 0
+	var timer *time.Timer
+	var expired <-chan time.Time
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(batch) > 0 {
+			coalescer.flush(batch)
+		}
+		batch = make([]deleteRequest, 0)
+		size = 0
+	}
+	for {
+		select {
+		case req := <-coalescer.requests:
+			batch = append(batch, req)
+			size += len(req.shortIDs)
+			if timer == nil {
+				timer = time.NewTimer(coalescer.window)
+				expired = timer.C
+			}
+			if size >= coalescer.maxSize {
+				flush()
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			flush()
+		case <-coalescer.done:
+			flush()
+			return
+		}
+	}
+}
+
+// flush удаляет ссылки всей пачки одним вызовом хранилища и раздает результат ее участникам.
+// Запросы, отозванные вызывающими до сброса, в пачку не попадают
+func (coalescer *DeleteCoalescer) flush(batch []deleteRequest) {
+	batch, items := claim(batch)
+	if len(batch) == 0 {
+		return
+	}
+	ctx := context.Background()
+	if coalescer.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, coalescer.timeout)
+		defer cancel()
+	}
+	deleted, err := coalescer.store.DeleteURLsBatch(ctx, items)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("batch_size", len(items)).
+			Error("failed to delete batch of urls")
+		for _, req := range batch {
+			req.result <- deleteResponse{err: err}
+		}
+		return
+	}
+	// одна и та же ссылка может быть запрошена к удалению несколько раз,
+	// поэтому засчитываем ее только первому запросившему
+	isDeleted := make(map[storage.DeleteItem]bool, len(deleted))
+	for _, item := range deleted {
+		isDeleted[item] = true
+	}
+	for _, req := range batch {
+		count := 0
+		for _, shortID := range req.shortIDs {
+			item := storage.DeleteItem{UserID: req.userID, ShortID: shortID}
+			if isDeleted[item] {
+				count++
+				delete(isDeleted, item)
+			}
+		}
+		req.result <- deleteResponse{deleted: count}
+	}
+}
+
+// claim забирает в сбрасываемую пачку запросы, которые еще не отозваны вызывающими
+func claim(batch []deleteRequest) ([]deleteRequest, []storage.DeleteItem) {
+	claimed := make([]deleteRequest, 0, len(batch))
+	items := make([]storage.DeleteItem, 0)
+	for _, req := range batch {
+		if !atomic.CompareAndSwapInt32(req.state, requestPending, requestClaimed) {
+			continue
+		}
+		claimed = append(claimed, req)
+		for _, shortID := range req.shortIDs {
+			items = append(items, storage.DeleteItem{UserID: req.userID, ShortID: shortID})
+		}
+	}
+	return claimed, items
+}
@@ -0,0 +1,166 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/jobs"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage считает вызовы пакетного удаления и может вернуть ошибку либо задержать удаление
+type countingStorage struct {
+	*storage.LocmemURLStorerBackend
+	mu      sync.Mutex
+	batches [][]storage.DeleteItem
+	err     error
+	before  func(context.Context) error
+}
+
+func (cs *countingStorage) DeleteURLsBatch(ctx context.Context, items []storage.DeleteItem) ([]storage.DeleteItem, error) {
+	cs.mu.Lock()
+	cs.batches = append(cs.batches, items)
+	err := cs.err
+	cs.mu.Unlock()
+	if err == nil && cs.before != nil {
+		err = cs.before(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cs.LocmemURLStorerBackend.DeleteURLsBatch(ctx, items)
+}
+
+func newCountingStorage() *countingStorage {
+	ctx := context.TODO()
+	store := &countingStorage{LocmemURLStorerBackend: storage.NewLocmemURLStorerBackend()}
+	store.Set(ctx, "foo", "https://go.dev/", "u1")              // nolint:errcheck
+	store.Set(ctx, "bar", "https://ya.ru/", "u1")               // nolint:errcheck
+	store.Set(ctx, "baz", "https://example.com/", "u2")         // nolint:errcheck
+	store.Set(ctx, "ham", "https://practicum.yandex.ru/", "u3") // nolint:errcheck
+	return store
+}
+
+func TestDeleteCoalescerFlushesRequestsInSingleBatch(t *testing.T) {
+	store := newCountingStorage()
+	coalescer := jobs.NewDeleteCoalescer(store, 100, time.Millisecond*50, time.Second)
+	defer coalescer.Close()
+
+	requests := map[string][]string{
+		"u1": {"foo", "bar", "baz"},
+		"u2": {"baz"},
+		"u3": {"unknown"},
+	}
+	results := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for userID, shortIDs := range requests {
+		wg.Add(1)
+		go func(userID string, shortIDs []string) {
+			defer wg.Done()
+			deleted, err := coalescer.DeleteUserURLs(context.TODO(), userID, shortIDs...)
+			assert.NoError(t, err)
+			mu.Lock()
+			results[userID] = deleted
+			mu.Unlock()
+		}(userID, shortIDs)
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"u1": 2, "u2": 1, "u3": 0}, results)
+	assert.Len(t, store.batches, 1)
+	assert.Len(t, store.batches[0], 5)
+	_, err := store.Get(context.TODO(), "baz")
+	assert.ErrorIs(t, err, storage.ErrURLIsDeleted)
+	_, err = store.Get(context.TODO(), "ham")
+	assert.NoError(t, err)
+}
+
+func TestDeleteCoalescerFlushesFullBatchImmediately(t *testing.T) {
+	store := newCountingStorage()
+	coalescer := jobs.NewDeleteCoalescer(store, 2, time.Hour, time.Second)
+	defer coalescer.Close()
+
+	deleted, err := coalescer.DeleteUserURLs(context.TODO(), "u1", "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+}
+
+func TestDeleteCoalescerReportsStorageErrors(t *testing.T) {
+	store := newCountingStorage()
+	store.err = errors.New("db is down")
+	coalescer := jobs.NewDeleteCoalescer(store, 100, time.Millisecond*10, time.Second)
+
+	_, err := coalescer.DeleteUserURLs(context.TODO(), "u1", "foo")
+	assert.EqualError(t, err, "db is down")
+
+	coalescer.Close()
+	_, err = coalescer.DeleteUserURLs(context.TODO(), "u1", "foo")
+	assert.ErrorIs(t, err, jobs.ErrCoalescerClosed)
+}
+
+func TestDeleteCoalescerBoundsHungStorageCalls(t *testing.T) {
+	store := newCountingStorage()
+	store.before = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	coalescer := jobs.NewDeleteCoalescer(store, 1, time.Hour, time.Millisecond*20)
+	defer coalescer.Close()
+
+	_, err := coalescer.DeleteUserURLs(context.TODO(), "u1", "foo")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// зависший вызов не блокирует следующие пачки
+	_, err = coalescer.DeleteUserURLs(context.TODO(), "u1", "bar")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDeleteCoalescerDropsCancelledRequests(t *testing.T) {
+	store := newCountingStorage()
+	coalescer := jobs.NewDeleteCoalescer(store, 100, time.Millisecond*50, time.Second)
+	defer coalescer.Close()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*5)
+	defer cancel()
+	_, err := coalescer.DeleteUserURLs(ctx, "u1", "foo")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	deleted, err := coalescer.DeleteUserURLs(context.TODO(), "u1", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	// отозванная ссылка не удалена
+	_, err = store.Get(context.TODO(), "foo")
+	assert.NoError(t, err)
+	require.Len(t, store.batches, 1)
+	assert.Equal(t, []storage.DeleteItem{{UserID: "u1", ShortID: "bar"}}, store.batches[0])
+}
+
+func TestDeleteCoalescerReportsFlushedRequestsDespiteCancel(t *testing.T) {
+	store := newCountingStorage()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	store.before = func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}
+	coalescer := jobs.NewDeleteCoalescer(store, 1, time.Hour, time.Second)
+	defer coalescer.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	go func() {
+		<-started
+		cancel()
+		time.Sleep(time.Millisecond * 10)
+		close(release)
+	}()
+	// пачка уже сбрасывается, поэтому вызывающий получает фактический результат удаления
+	deleted, err := coalescer.DeleteUserURLs(ctx, "u1", "foo")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
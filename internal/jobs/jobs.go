@@ -2,13 +2,104 @@ package jobs
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"time"
 
+	"github.com/sergeii/practikum-go-url-shortener/internal/imports"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
-	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
 )
 
-func DeleteUserURLs(store storage.URLStorer, userID string, shortIDs ...string) background.Job {
-	return background.NewJob("delete user URLs", func(ctx context.Context) error {
-		return store.DeleteUserURLs(ctx, userID, shortIDs...)
+// DeleteUserURLsKind - тип джоба удаления ссылок, под которым он хранится в долговременной очереди
+const DeleteUserURLsKind = "delete_user_urls"
+
+// Очереди пула, в которых выполняются джобы. Удаление ссылок выполняется по запросу пользователя,
+// поэтому не должно ждать выполнения долгих импортов
+const (
+	QueueDeletes = "deletes"
+	QueueImports = "imports"
+)
+
+// DeleteResult - результат выполнения джоба удаления ссылок пользователя
+type DeleteResult struct {
+	Deleted int `json:"deleted"`
+}
+
+type deleteUserURLsArgs struct {
+	UserID   string   `json:"user_id"`
+	ShortIDs []string `json:"short_ids"`
+}
+
+// Handlers возвращает описания всех джобов, которые могут выполняться долговременной очередью
+func Handlers(deleter URLDeleter, retry background.RetryPolicy) []background.Handler {
+	return []background.Handler{
+		DeleteUserURLsHandler(deleter, retry),
+	}
+}
+
+// DeleteUserURLsHandler описывает джоб удаления ссылок пользователя. Удаление идемпотентно,
+// поэтому в случае временной ошибки хранилища джоб безопасно повторяется согласно политике retry
+func DeleteUserURLsHandler(deleter URLDeleter, retry background.RetryPolicy) background.Handler {
+	return background.Handler{
+		Kind:  DeleteUserURLsKind,
+		Name:  "delete user URLs",
+		Retry: retry,
+		Queue: QueueDeletes,
+		Func: func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+			var args deleteUserURLsArgs
+			if err := json.Unmarshal(payload, &args); err != nil {
+				return nil, err
+			}
+			deleted, err := deleter.DeleteUserURLs(ctx, args.UserID, args.ShortIDs...)
+			if err != nil {
+				return nil, err
+			}
+			return DeleteResult{Deleted: deleted}, nil
+		},
+	}
+}
+
+// DeleteUserURLs создает джоб удаления ссылок пользователя
+func DeleteUserURLs(
+	deleter URLDeleter, retry background.RetryPolicy, userID string, shortIDs ...string,
+) (background.Job, error) {
+	args := deleteUserURLsArgs{UserID: userID, ShortIDs: shortIDs}
+	job, err := background.NewDurableJob(DeleteUserURLsHandler(deleter, retry), args)
+	if err != nil {
+		return background.Job{}, err
+	}
+	return job.WithOwner(userID), nil
+}
+
+// ImportURLs выполняет импорт ссылок пользователя из временного файла, в который было сохранено тело запроса.
+// Импорт регистрируется в реестре под идентификатором джоба, по которому затем можно узнать его прогресс.
+// По завершении импорта файл удаляется
+func ImportURLs(
+	importer imports.Importer, registry *imports.Registry,
+	userID string, format imports.Format, filename string, timeout time.Duration,
+) (background.Job, *imports.Import) {
+	var imp *imports.Import
+	job := background.NewJob("import URLs", func(ctx context.Context) error {
+		defer func() {
+			if err := os.Remove(filename); err != nil {
+				logging.FromContext(ctx).WithError(err).WithField("filename", filename).
+					Warn("failed to remove import file")
+			}
+		}()
+		file, err := os.Open(filename)
+		if err != nil {
+			imp.Fail(err)
+			return err
+		}
+		defer file.Close()
+		src, err := imports.NewReader(format, file)
+		if err != nil {
+			imp.Fail(err)
+			return err
+		}
+		return importer.Run(ctx, imp, src)
 	})
+	imp = registry.Register(job.ID, userID)
+	return job.WithOwner(userID).WithTimeout(timeout).WithQueue(QueueImports), imp
 }
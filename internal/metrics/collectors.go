@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+)
+
+// QueueDepther сообщает количество джобов, ожидающих выполнения в каждой из очередей
+type QueueDepther interface {
+	QueueDepths() map[string]int
+}
+
+// DurableQueueDepther сообщает количество джобов, ожидающих выполнения в очереди, хранящейся в бд
+type DurableQueueDepther interface {
+	Depth(context.Context) (int, error)
+}
+
+// ZombieCounter сообщает о джобах, продолжающих выполняться после истечения таймаута
+type ZombieCounter interface {
+	Zombies() ([]background.ZombieJob, int)
+}
+
+// queueCollector снимает состояние очередей фоновых задач в момент сбора метрик
+type queueCollector struct {
+	pool         QueueDepther
+	queue        ZombieCounter
+	depth        *prometheus.Desc
+	zombiesNow   *prometheus.Desc
+	zombiesTotal *prometheus.Desc
+}
+
+// RegisterQueue добавляет метрики глубины очередей пула и зомби-джобов очереди queue.
+// Если queue хранится в бд, ее глубина отдается той же метрикой с именем очереди background.DurableQueueName
+func (m *Metrics) RegisterQueue(pool QueueDepther, queue ZombieCounter) {
+	m.Registry.MustRegister(&queueCollector{
+		pool:  pool,
+		queue: queue,
+		depth: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "jobs", "queue_depth"),
+			"Number of background jobs waiting in the queue.",
+			[]string{"queue"}, nil,
+		),
+		zombiesNow: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "jobs", "zombies"),
+			"Number of timed out background jobs that are still running.",
+			nil, nil,
+		),
+		zombiesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "jobs", "zombies_total"),
+			"Number of background jobs that have ever outlived their timeout.",
+			nil, nil,
+		),
+	})
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.depth
+	ch <- c.zombiesNow
+	ch <- c.zombiesTotal
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for queue, depth := range c.pool.QueueDepths() {
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(depth), queue)
+	}
+	if durable, ok := c.queue.(DurableQueueDepther); ok {
+		// недоступность бд не должна ломать отдачу остальных метрик
+		if depth, err := durable.Depth(context.Background()); err != nil {
+			logging.FromContext(context.Background()).WithError(err).Warn("unable to collect durable queue depth")
+		} else {
+			ch <- prometheus.MustNewConstMetric(
+				c.depth, prometheus.GaugeValue, float64(depth), background.DurableQueueName,
+			)
+		}
+	}
+	zombies, total := c.queue.Zombies()
+	ch <- prometheus.MustNewConstMetric(c.zombiesNow, prometheus.GaugeValue, float64(len(zombies)))
+	ch <- prometheus.MustNewConstMetric(c.zombiesTotal, prometheus.CounterValue, float64(total))
+}
+
+// dbCollector отдает статистику пула подключений к бд
+type dbCollector struct {
+	db             *pgxpool.Pool
+	acquiredConns  *prometheus.Desc
+	idleConns      *prometheus.Desc
+	totalConns     *prometheus.Desc
+	maxConns       *prometheus.Desc
+	acquires       *prometheus.Desc
+	acquireSeconds *prometheus.Desc
+	emptyAcquires  *prometheus.Desc
+	canceled       *prometheus.Desc
+}
+
+func dbDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+// RegisterDB добавляет метрики пула подключений к бд
+func (m *Metrics) RegisterDB(db *pgxpool.Pool) {
+	m.Registry.MustRegister(&dbCollector{
+		db:             db,
+		acquiredConns:  dbDesc("acquired_conns", "Number of currently acquired connections."),
+		idleConns:      dbDesc("idle_conns", "Number of currently idle connections."),
+		totalConns:     dbDesc("total_conns", "Total number of connections in the pool."),
+		maxConns:       dbDesc("max_conns", "Maximum size of the pool."),
+		acquires:       dbDesc("acquires_total", "Number of successful connection acquires."),
+		acquireSeconds: dbDesc("acquire_duration_seconds_total", "Total time spent acquiring connections."),
+		emptyAcquires:  dbDesc("empty_acquires_total", "Number of acquires that had to wait for a connection."),
+		canceled:       dbDesc("canceled_acquires_total", "Number of acquires canceled by context."),
+	})
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.acquiredConns, c.idleConns, c.totalConns, c.maxConns,
+		c.acquires, c.acquireSeconds, c.emptyAcquires, c.canceled,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.db.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireSeconds, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceled, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+)
+
+const namespace = "shortener"
+
+// unmatchedRoute - метка маршрута для запросов, не попавших ни в один из маршрутов роутера
+const unmatchedRoute = "unmatched"
+
+// Metrics содержит метрики сервиса в собственном реестре,
+// что позволяет создавать независимые экземпляры, например в тестах
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	HTTPRequests    *prometheus.CounterVec
+	HTTPDuration    *prometheus.HistogramVec
+	Shortened       prometheus.Counter
+	Conflicts       prometheus.Counter
+	Redirects       prometheus.Counter
+	JobDuration     *prometheus.HistogramVec
+	JobFailures     *prometheus.CounterVec
+	StorageDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of handled HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of handled HTTP requests by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		Shortened: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "urls_shortened_total",
+			Help:      "Number of newly shortened URLs.",
+		}),
+		Conflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "urls_conflicts_total",
+			Help:      "Number of attempts to shorten an already shortened URL.",
+		}),
+		Redirects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Number of redirects from short URLs to original URLs.",
+		}),
+		JobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Duration of background job attempts by job name.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"job"}),
+		JobFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_failures_total",
+			Help:      "Number of failed background job attempts by job name.",
+		}, []string{"job"}),
+		StorageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Latency of storage operations by backend, operation and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "operation", "outcome"}),
+	}
+	m.Registry.MustRegister(
+		m.HTTPRequests, m.HTTPDuration,
+		m.Shortened, m.Conflicts, m.Redirects,
+		m.JobDuration, m.JobFailures,
+		m.StorageDuration,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler отдает метрики в текстовом формате prometheus.
+// Сжатием ответа занимается middleware роутера
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{DisableCompression: true})
+}
+
+// Middleware считает запросы и время их обработки в разрезе маршрутов chi.
+// Маршрут берется в виде шаблона (например, /api/jobs/{jobID}), чтобы не плодить метки на каждый url
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+	})
+}
+
+// ObserveShorten учитывает попытку сокращения ссылки: новую ссылку либо конфликт с ранее сокращенной
+func (m *Metrics) ObserveShorten(created bool) {
+	if created {
+		m.Shortened.Inc()
+	} else {
+		m.Conflicts.Inc()
+	}
+}
+
+// ObserveJob реализует background.JobObserver
+func (m *Metrics) ObserveJob(job background.Job, duration time.Duration, err error) {
+	m.JobDuration.WithLabelValues(job.Name).Observe(duration.Seconds())
+	if err != nil {
+		m.JobFailures.WithLabelValues(job.Name).Inc()
+	}
+}
@@ -0,0 +1,180 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sergeii/practikum-go-url-shortener/internal/metrics"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareCountsRequestsByRoutePattern(t *testing.T) {
+	m := metrics.New()
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Route("/api", func(r chi.Router) {
+		r.Get("/jobs/{jobID}", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "job not found", http.StatusNotFound)
+		})
+		r.Post("/shorten", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok")) // nolint:errcheck
+		})
+	})
+
+	for _, path := range []string{"/api/jobs/foo", "/api/jobs/bar"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.HTTPRequests.WithLabelValues("/api/jobs/{jobID}", "GET", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.HTTPRequests.WithLabelValues("/api/shorten", "POST", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.HTTPRequests.WithLabelValues("unmatched", "GET", "404")))
+	assert.Equal(t, 3, testutil.CollectAndCount(m.HTTPDuration))
+}
+
+func TestHandlerExposesMetricsInTextFormat(t *testing.T) {
+	m := metrics.New()
+	m.ObserveShorten(true)
+	m.ObserveShorten(true)
+	m.ObserveShorten(false)
+	m.Redirects.Inc()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, string(body), "shortener_urls_shortened_total 2")
+	assert.Contains(t, string(body), "shortener_urls_conflicts_total 1")
+	assert.Contains(t, string(body), "shortener_redirects_total 1")
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestInstrumentedStorageObservesOperations(t *testing.T) {
+	ctx := context.TODO()
+	m := metrics.New()
+	store := m.InstrumentStorage(storage.NewLocmemURLStorerBackend(), "memory")
+
+	_, err := store.Set(ctx, "go", "https://go.dev/", "user1")
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "go")
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	// отсутствующая ссылка не считается сбоем хранилища, поэтому оба чтения попадают в одну серию
+	assert.Equal(t, 2, testutil.CollectAndCount(m.StorageDuration))
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	assert.Contains(t, string(body),
+		`shortener_storage_operation_duration_seconds_count{backend="memory",operation="get",outcome="ok"} 2`)
+	assert.Contains(t, string(body),
+		`shortener_storage_operation_duration_seconds_count{backend="memory",operation="set",outcome="ok"} 1`)
+}
+
+func TestObserveJobRecordsDurationsAndFailures(t *testing.T) {
+	m := metrics.New()
+	job := background.NewJob("delete user URLs", func(context.Context) error { return nil })
+	m.ObserveJob(job, time.Millisecond*10, nil)
+	m.ObserveJob(job, time.Millisecond*20, errors.New("oops"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.JobFailures.WithLabelValues("delete user URLs")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.JobDuration))
+}
+
+type fakeDurableQueue struct {
+	depth int
+	err   error
+}
+
+func (q fakeDurableQueue) Depth(context.Context) (int, error) {
+	return q.depth, q.err
+}
+
+func (q fakeDurableQueue) Zombies() ([]background.ZombieJob, int) {
+	return nil, 0
+}
+
+func TestQueueCollectorReportsDurableQueueDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		queue    fakeDurableQueue
+		expected string
+	}{
+		{
+			name:  "depth is reported",
+			queue: fakeDurableQueue{depth: 5},
+			expected: `
+# HELP shortener_jobs_queue_depth Number of background jobs waiting in the queue.
+# TYPE shortener_jobs_queue_depth gauge
+shortener_jobs_queue_depth{queue="default"} 0
+shortener_jobs_queue_depth{queue="durable"} 5
+`,
+		},
+		{
+			name:  "unavailable db is skipped",
+			queue: fakeDurableQueue{err: errors.New("connection refused")},
+			expected: `
+# HELP shortener_jobs_queue_depth Number of background jobs waiting in the queue.
+# TYPE shortener_jobs_queue_depth gauge
+shortener_jobs_queue_depth{queue="default"} 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := metrics.New()
+			pool := background.NewPool(background.PoolConfig{
+				Concurrency:   0,
+				DoJobTimeout:  time.Second,
+				AddJobTimeout: time.Second,
+			})
+			defer pool.Close()
+			m.RegisterQueue(pool, tt.queue)
+			err := testutil.GatherAndCompare(m.Registry, strings.NewReader(tt.expected), "shortener_jobs_queue_depth")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestQueueCollectorReportsDepthAndZombies(t *testing.T) {
+	m := metrics.New()
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   0,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		Queues:        []background.QueueConfig{{Name: "deletes", Size: 10}},
+	})
+	defer pool.Close()
+	m.RegisterQueue(pool, pool)
+	for i := 0; i < 3; i++ {
+		job := background.NewJob("test", func(context.Context) error { return nil }).WithQueue("deletes")
+		require.NoError(t, pool.Add(context.TODO(), job))
+	}
+
+	expected := `
+# HELP shortener_jobs_queue_depth Number of background jobs waiting in the queue.
+# TYPE shortener_jobs_queue_depth gauge
+shortener_jobs_queue_depth{queue="default"} 0
+shortener_jobs_queue_depth{queue="deletes"} 3
+# HELP shortener_jobs_zombies Number of timed out background jobs that are still running.
+# TYPE shortener_jobs_zombies gauge
+shortener_jobs_zombies 0
+`
+	err := testutil.GatherAndCompare(
+		m.Registry, strings.NewReader(expected), "shortener_jobs_queue_depth", "shortener_jobs_zombies",
+	)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+)
+
+// instrumentedStorage замеряет время выполнения операций хранилища
+type instrumentedStorage struct {
+	storage.URLStorer
+	backend string
+	m       *Metrics
+}
+
+// InstrumentStorage оборачивает хранилище, замеряя время выполнения его операций с меткой backend
+func (m *Metrics) InstrumentStorage(store storage.URLStorer, backend string) storage.URLStorer {
+	return &instrumentedStorage{URLStorer: store, backend: backend, m: m}
+}
+
+func (s *instrumentedStorage) observe(operation string, started time.Time, err error) {
+	outcome := "ok"
+	// ожидаемые ошибки бизнес-логики не считаем сбоями хранилища
+	if err != nil && !errors.Is(err, storage.ErrURLAlreadyExists) &&
+		!errors.Is(err, storage.ErrURLNotFound) && !errors.Is(err, storage.ErrURLIsDeleted) {
+		outcome = "error"
+	}
+	s.m.StorageDuration.WithLabelValues(s.backend, operation, outcome).Observe(time.Since(started).Seconds())
+}
+
+func (s *instrumentedStorage) Set(ctx context.Context, shortID, longURL, userID string) (string, error) {
+	started := time.Now()
+	result, err := s.URLStorer.Set(ctx, shortID, longURL, userID)
+	s.observe("set", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) Get(ctx context.Context, shortID string) (string, error) {
+	started := time.Now()
+	result, err := s.URLStorer.Get(ctx, shortID)
+	s.observe("get", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetURLsByUserID(ctx context.Context, userID string) (map[string]string, error) {
+	started := time.Now()
+	result, err := s.URLStorer.GetURLsByUserID(ctx, userID)
+	s.observe("get_user_urls", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) IterateUserURLs(ctx context.Context, userID string, fn storage.URLRecordFunc) error {
+	started := time.Now()
+	err := s.URLStorer.IterateUserURLs(ctx, userID, fn)
+	s.observe("iterate_user_urls", started, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) (int, error) {
+	started := time.Now()
+	result, err := s.URLStorer.DeleteUserURLs(ctx, userID, shortIDs...)
+	s.observe("delete_user_urls", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) DeleteURLsBatch(
+	ctx context.Context, items []storage.DeleteItem,
+) ([]storage.DeleteItem, error) {
+	started := time.Now()
+	result, err := s.URLStorer.DeleteURLsBatch(ctx, items)
+	s.observe("delete_batch", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) TransferUserURLs(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	started := time.Now()
+	result, err := s.URLStorer.TransferUserURLs(ctx, fromUserID, toUserID)
+	s.observe("transfer_user_urls", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SaveBatch(ctx context.Context, items []storage.BatchItem) (map[string]string, error) {
+	started := time.Now()
+	result, err := s.URLStorer.SaveBatch(ctx, items)
+	s.observe("save_batch", started, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	started := time.Now()
+	err := s.URLStorer.Ping(ctx)
+	s.observe("ping", started, err)
+	return err
+}
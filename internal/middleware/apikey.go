@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyFromRequest возвращает ключ API из заголовка X-API-Key либо Authorization: Bearer.
+// В Authorization ключом считается только токен с префиксом ключей API
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key, true
+	}
+	return auth.APIKeyFromAuthorization(r.Header.Get("Authorization"))
+}
+
+// requiredScope возвращает разрешение, необходимое для выполнения запроса:
+// читающие методы требуют разрешения read, изменяющие - write
+func requiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.ScopeRead
+	default:
+		return auth.ScopeWrite
+	}
+}
+
+// WithAPIKey возвращает мидлварь, аутентифицирующую серверных клиентов по ключу API.
+// Запросы без ключа передаются дальше для аутентификации по куке, запросы с неверным ключом отклоняются с 401,
+// а с ключом без нужного разрешения - с 403. Должна предшествовать WithAuthentication
+func WithAPIKey(verifier auth.APIKeyVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := apiKeyFromRequest(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, err := verifier.VerifyAPIKey(r.Context(), key)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidAPIKey) {
+					logging.FromContext(r.Context()).WithError(err).Debug("rejected api key")
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+				} else {
+					logging.FromContext(r.Context()).WithError(err).Error("unable to verify api key")
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			logging.AddFields(r.Context(), logrus.Fields{"user_id": user.ID, "api_key_id": user.APIKeyID})
+			if scope := requiredScope(r.Method); !user.HasScope(scope) {
+				http.Error(w, "api key lacks "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			logging.FromContext(r.Context()).Debug("authenticated user by api key")
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), user)))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	mwtest "github.com/sergeii/practikum-go-url-shortener/pkg/testing/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIKey = "sk_0123456789abcdef_secret"
+
+type fakeVerifier map[string]*auth.User
+
+func (v fakeVerifier) VerifyAPIKey(_ context.Context, key string) (*auth.User, error) {
+	if key == "sk_0123456789abcdef_broken" {
+		return nil, errors.New("storage is down")
+	}
+	user, ok := v[key]
+	if !ok {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	return user, nil
+}
+
+func withAPIKeyAndCookie(secretKey []byte, verifier auth.APIKeyVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return middleware.WithAPIKey(verifier)(withAuthentication(secretKey)(next))
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	verifier := fakeVerifier{
+		testAPIKey: {ID: "user1", APIKeyID: "0123456789abcdef", Scopes: []string{auth.ScopeRead}},
+	}
+	tests := []struct {
+		name       string
+		method     string
+		header     string
+		value      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"x-api-key header", http.MethodGet, "X-API-Key", testAPIKey, 200, "Hello, user1"},
+		{"bearer token", http.MethodGet, "Authorization", "Bearer " + testAPIKey, 200, "Hello, user1"},
+		{"bearer is case insensitive", http.MethodGet, "Authorization", "bearer " + testAPIKey, 200, "Hello, user1"},
+		{"missing write scope", http.MethodPost, "X-API-Key", testAPIKey, 403, "api key lacks write scope\n"},
+		{
+			"unknown key", http.MethodGet, "X-API-Key", "sk_0123456789abcdef_unknown",
+			401, "invalid api key\n",
+		},
+		{"malformed key", http.MethodGet, "X-API-Key", "foo", 401, "invalid api key\n"},
+		{
+			"verifier failure", http.MethodGet, "X-API-Key", "sk_0123456789abcdef_broken",
+			500, "storage is down\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretKey := generateSecret()
+			req, _ := http.NewRequest(tt.method, "/", nil)
+			req.Header.Set(tt.header, tt.value)
+			rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAPIKeyAndCookie(secretKey, verifier), req)
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			assert.Equal(t, tt.wantBody, rr.Body.String())
+			// клиент с ключом не получает анонимную куку
+			assert.Empty(t, rr.Header().Get("Set-Cookie"))
+		})
+	}
+}
+
+func TestRequestWithoutAPIKeyFallsBackToCookie(t *testing.T) {
+	secretKey := generateSecret()
+	for _, auth := range []string{"", "Basic Zm9vOmJhcg==", "Bearer sometoken"} {
+		req, _ := http.NewRequest(http.MethodPost, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAPIKeyAndCookie(secretKey, fakeVerifier{}), req)
+		assert.Equal(t, 200, rr.Code, auth)
+		cookie := parseAuthSetCookie(rr)
+		_, userID := verifyAuthCookie(cookie.Value, secretKey)
+		assert.Equal(t, "Hello, "+userID, rr.Body.String(), auth)
+	}
+}
+
+func TestCookieUserHasAllScopes(t *testing.T) {
+	user := &auth.User{ID: "user1"}
+	assert.True(t, user.HasScope(auth.ScopeRead))
+	assert.True(t, user.HasScope(auth.ScopeWrite))
+	keyUser := &auth.User{ID: "user1", APIKeyID: "key1", Scopes: []string{auth.ScopeWrite}}
+	assert.False(t, keyUser.HasScope(auth.ScopeRead))
+	assert.True(t, keyUser.HasScope(auth.ScopeWrite))
+}
@@ -1,51 +1,183 @@
 package middleware
 
 import (
-	"context"
-	"crypto/rand"
 	"encoding/base64"
-	"encoding/hex"
 	"errors"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/keyring"
 	"github.com/sergeii/practikum-go-url-shortener/pkg/security/sign"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/token"
+	"github.com/sirupsen/logrus"
 )
 
-type contextKey int
+const AuthUserCookieName = "auth"
 
-const AuthContextKey contextKey = 0
+// AuthTokenHeader - заголовок ответа, в котором клиенту передается вновь выпущенный токен,
+// чтобы клиенты, аутентифицирующиеся заголовком Authorization, могли его обновить
+const AuthTokenHeader = "X-Auth-Token"
 
-const AuthUserCookieName = "auth"
-const AuthUserCookieExpiration = time.Hour * 24 * 365
-const UserIDLength = 8 // in bytes
+const (
+	DefaultTokenTTL           = time.Hour * 24 * 30
+	DefaultTokenRefreshBefore = time.Hour * 24 * 7
+)
 
 var ErrInvalidCookieValue = errors.New("authentication cookie is corrupt")
 var ErrInvalidUserID = errors.New("authentication cookie has invalid user id")
 var ErrIncorrectCookieSig = errors.New("authentication cookie signature is not correct")
+var ErrLegacyCookieRejected = errors.New("legacy authentication cookies are not accepted")
+var errNoToken = errors.New("no authentication token")
 
-type AuthUser struct {
-	ID string
+// Tokens выпускает и проверяет подписанные токены пользователей. Токен содержит идентификатор пользователя,
+// время выпуска и истечения, а также идентификатор ключа, которым он подписан.
+// Токен, срок действия которого подходит к концу либо подписанный неактивным ключом, подлежит перевыпуску
+type Tokens struct {
+	keys          *keyring.Keyring
+	cookie        CookiePolicy
+	ttl           time.Duration
+	refreshBefore time.Duration
+	acceptLegacy  bool
+	legacyUntil   time.Time
+	now           func() time.Time
 }
 
-// authenticateUser пытается аутентифицировать пользователя
-// по имеющейся у него подписанной нашим секретным ключом куке
-// и получить из значения куки идентификатор пользователя,
-// предварительно провалидировав подпись
-func authenticateUser(r *http.Request, secretKey []byte) (*AuthUser, error) {
-	cookie, err := r.Cookie(AuthUserCookieName)
+// CookiePolicy - атрибуты авторизационной куки
+type CookiePolicy struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	HTTPOnly bool
+	SameSite http.SameSite
+}
+
+// DefaultCookiePolicy возвращает атрибуты куки, недоступной скриптам и сторонним сайтам.
+// Флаг Secure следует устанавливать, если сервис доступен только по HTTPS
+func DefaultCookiePolicy(secure bool) CookiePolicy {
+	return CookiePolicy{
+		Path:     "/",
+		Secure:   secure,
+		HTTPOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+type TokenOption func(*Tokens)
+
+// WithTokenTTL задает срок действия выпускаемых токенов
+func WithTokenTTL(ttl time.Duration) TokenOption {
+	return func(t *Tokens) {
+		t.ttl = ttl
+	}
+}
+
+// WithTokenRefresh задает, за какое время до истечения токен перевыпускается
+func WithTokenRefresh(before time.Duration) TokenOption {
+	return func(t *Tokens) {
+		t.refreshBefore = before
+	}
+}
+
+// WithLegacyCookies разрешает или запрещает куки прежнего формата userID:подпись,
+// не имеющие срока действия. Принятая кука сразу заменяется токеном. По умолчанию такие куки не принимаются
+func WithLegacyCookies(accept bool) TokenOption {
+	return func(t *Tokens) {
+		t.acceptLegacy = accept
+	}
+}
+
+// WithLegacyCookiesUntil задает момент, начиная с которого куки прежнего формата не принимаются,
+// даже если они разрешены WithLegacyCookies. Нулевое время - без ограничения
+func WithLegacyCookiesUntil(deadline time.Time) TokenOption {
+	return func(t *Tokens) {
+		t.legacyUntil = deadline
+	}
+}
+
+// WithCookiePolicy задает атрибуты авторизационной куки
+func WithCookiePolicy(policy CookiePolicy) TokenOption {
+	return func(t *Tokens) {
+		t.cookie = policy
+	}
+}
+
+// WithClock подменяет источник текущего времени, например в тестах
+func WithClock(now func() time.Time) TokenOption {
+	return func(t *Tokens) {
+		t.now = now
+	}
+}
+
+// NewTokens создает выпускающего токены, подписывая их активным ключом из набора keys
+func NewTokens(keys *keyring.Keyring, opts ...TokenOption) *Tokens {
+	t := &Tokens{
+		keys:          keys,
+		cookie:        DefaultCookiePolicy(false),
+		ttl:           DefaultTokenTTL,
+		refreshBefore: DefaultTokenRefreshBefore,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// CookiePolicy возвращает атрибуты авторизационной куки, например для других кук сервиса
+func (t *Tokens) CookiePolicy() CookiePolicy {
+	return t.cookie
+}
+
+// Issue выпускает пользователю токен и возвращает его вместе со временем истечения
+func (t *Tokens) Issue(user *auth.User) (string, time.Time, error) {
+	issuedAt := t.now()
+	expiresAt := issuedAt.Add(t.ttl)
+	active := t.keys.Active()
+	value, err := token.Issue(active.Secret, token.Token{
+		KeyID:     active.ID,
+		Subject:   user.ID,
+		Kind:      user.Kind,
+		IssuedAt:  issuedAt,
+		ExpiresAt: expiresAt,
+	})
 	if err != nil {
-		return nil, err
+		return "", time.Time{}, err
+	}
+	return value, expiresAt, nil
+}
+
+// Authenticate проверяет токен и возвращает пользователя, которому он был выдан,
+// а также признак того, что пользователю следует перевыпустить токен
+func (t *Tokens) Authenticate(value string) (*auth.User, bool, error) {
+	// токен прежнего формата отличается от нового разделителем
+	now := t.now()
+	if strings.Contains(value, ":") {
+		if !t.acceptLegacy || (!t.legacyUntil.IsZero() && !now.Before(t.legacyUntil)) {
+			return nil, false, ErrLegacyCookieRejected
+		}
+		user, err := t.authenticateLegacy(value)
+		return user, true, err
+	}
+	parsed, err := token.Parse(value, t.keys.Lookup, now)
+	if err != nil {
+		return nil, false, err
 	}
+	// после ротации ключей токен переподписывается активным ключом
+	refresh := parsed.ExpiresAt.Sub(now) < t.refreshBefore || parsed.KeyID != t.keys.Active().ID
+	return &auth.User{ID: parsed.Subject, Kind: parsed.Kind}, refresh, nil
+}
 
-	cookieParts := strings.Split(cookie.Value, ":")
+// authenticateLegacy проверяет куку прежнего формата, состоящую из текстового идентификатора пользователя
+// и подписи в формате base64, разделенных двоеточием. Такая кука не содержит идентификатора ключа,
+// поэтому подпись проверяется всеми ключами набора
+func (t *Tokens) authenticateLegacy(value string) (*auth.User, error) {
+	cookieParts := strings.Split(value, ":")
 	if len(cookieParts) != 2 {
 		return nil, ErrInvalidCookieValue
 	}
-	// Авторизационная кука состоит из собственно текстового идентификатора пользователя
-	// и подписи в формате base64, разделенных двоеточием
 	userID := cookieParts[0]
 	// user id не может быть пустым
 	if userID == "" {
@@ -55,68 +187,144 @@ func authenticateUser(r *http.Request, secretKey []byte) (*AuthUser, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, key := range t.keys.Keys() {
+		if sign.New(key.Secret).Verify([]byte(userID), cookieSig) {
+			return &auth.User{ID: userID}, nil
+		}
+	}
+	return nil, ErrIncorrectCookieSig
+}
+
+// tokenFromRequest возвращает токен из заголовка Authorization, либо из авторизационной куки
+func tokenFromRequest(r *http.Request) (string, error) {
+	if value, ok := auth.BearerToken(r.Header.Get("Authorization")); ok {
+		return value, nil
+	}
+	cookie, err := r.Cookie(AuthUserCookieName)
+	if err != nil {
+		return "", errNoToken
+	}
+	return cookie.Value, nil
+}
 
-	signer := sign.New(secretKey)
-	if !signer.Verify([]byte(userID), cookieSig) {
-		return nil, ErrIncorrectCookieSig
+// IssueToken выпускает пользователю токен и передает его клиенту в куке и в заголовке ответа
+func IssueToken(w http.ResponseWriter, tokens *Tokens, user *auth.User) error {
+	value, expiresAt, err := tokens.Issue(user)
+	if err != nil {
+		return err
 	}
+	setCookie(w, &http.Cookie{
+		Name:     AuthUserCookieName,
+		Value:    value,
+		Expires:  expiresAt,
+		Path:     tokens.cookie.Path,
+		Domain:   tokens.cookie.Domain,
+		Secure:   tokens.cookie.Secure,
+		HttpOnly: tokens.cookie.HTTPOnly,
+		SameSite: tokens.cookie.SameSite,
+	})
+	w.Header().Set(AuthTokenHeader, value)
+	return nil
+}
 
-	return &AuthUser{ID: userID}, nil
+// ClearToken удаляет авторизационную куку клиента. Сам токен при этом остается действительным до истечения,
+// так что клиенты, передающие его в заголовке, должны забыть его самостоятельно
+func ClearToken(w http.ResponseWriter, tokens *Tokens) {
+	setCookie(w, &http.Cookie{
+		Name:     AuthUserCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     tokens.cookie.Path,
+		Domain:   tokens.cookie.Domain,
+		Secure:   tokens.cookie.Secure,
+		HttpOnly: tokens.cookie.HTTPOnly,
+		SameSite: tokens.cookie.SameSite,
+	})
 }
 
-// createNewUser генерирует уникальный идентификатор пользователя
-// и возвращает структуру AuthUser с вновь созданным идентификатором
-func createNewUser() (*AuthUser, error) {
-	randomID := make([]byte, UserIDLength)
-	if _, err := rand.Read(randomID); err != nil {
-		log.Printf("unable to generate user id of length %v due to %v\n", UserIDLength, err)
-		return nil, err
+// setCookie устанавливает авторизационную куку, заменяя выставленную ранее в этом же ответе,
+// например при входе анонима, которому мидлварь только что выдала новый токен
+func setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	prefix := cookie.Name + "="
+	kept := w.Header()["Set-Cookie"][:0]
+	for _, value := range w.Header()["Set-Cookie"] {
+		if !strings.HasPrefix(value, prefix) {
+			kept = append(kept, value)
+		}
 	}
-	userID := hex.EncodeToString(randomID)
-	return &AuthUser{ID: userID}, nil
+	w.Header()["Set-Cookie"] = kept
+	http.SetCookie(w, cookie)
 }
 
-// setAuthenticationCookie подписывает идентификатор пользователя секретным ключом
-// и на его основе устанавливает авторизационную куку
-func setAuthenticationCookie(w http.ResponseWriter, secretKey []byte, user *AuthUser) {
-	signer := sign.New(secretKey)
-	signature64 := signer.Sign64([]byte(user.ID))
-	cookieValue := user.ID + ":" + signature64
-	cookieExpiresAt := time.Now().Add(AuthUserCookieExpiration)
-	cookie := http.Cookie{Name: AuthUserCookieName, Value: cookieValue, Expires: cookieExpiresAt}
-	http.SetCookie(w, &cookie)
+// WithAuthentication возвращает функцию-мидлварь для осуществления аутентификации пользователей
+// по подписанному токену из заголовка Authorization: Bearer, либо из авторизационной куки.
+// Анониму выпускается новый токен, токен с истекающим сроком действия перевыпускается
+// Устанавливает в контекст запроса аутентифицированного пользователя, а в логгер запроса - идентификатор пользователя
+// Пользователь, уже аутентифицированный ранее (например, по ключу API), пропускается как есть
+func WithAuthentication(tokens *Tokens) func(http.Handler) http.Handler {
+	return authentication(tokens, true)
 }
 
-// WithAuthentication возвращает функцию-мидлварь для осуществления аутентификации пользователей,
-// используя механизм подписанных секретным ключом кук.
-// Принимает на вход секретный ключ для создания/проверки подписи по алгоритму HMAC
-// Устанавливает в контекст запроса ключ со структурой AuthUser
-func WithAuthentication(secretKey []byte) func(http.Handler) http.Handler {
+// WithOptionalAuthentication, в отличие от WithAuthentication, не создает пользователя для анонима,
+// оставляя запрос без пользователя в контексте. Подходит для эндпоинтов, которым пользователь не нужен,
+// например для переходов по коротким ссылкам
+func WithOptionalAuthentication(tokens *Tokens) func(http.Handler) http.Handler {
+	return authentication(tokens, false)
+}
+
+func authentication(tokens *Tokens, createUsers bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var user *AuthUser
-			// Пробуем прочитать авторизационную куку, провалидировать ее подлинность
-			// и в итоге получить идентификатор пользователя
-			user, err := authenticateUser(r, secretKey)
-			// в случае ошибки чтения пользователя из куки, логируем ее, но никак на это не реагируем,
-			// позволяя коду ниже установить новую куку
-			if err != nil {
-				log.Printf("unable to authenticate user due to %v\n", err)
+			if _, ok := auth.FromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, refresh := authenticateRequest(r, tokens)
+			switch {
+			case user != nil:
+				logging.AddFields(r.Context(), logrus.Fields{"user_id": user.ID})
+				logging.FromContext(r.Context()).Debug("authenticated existing user")
+			case !createUsers:
+				next.ServeHTTP(w, r)
+				return
+			default:
+				// Для анонима генерируем новый идентификатор
+				var err error
+				if user, err = auth.NewUser(); err != nil {
+					logging.FromContext(r.Context()).WithError(err).Error("unable to generate user id")
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				logging.AddFields(r.Context(), logrus.Fields{"user_id": user.ID})
+				logging.FromContext(r.Context()).Debug("created new user")
+				refresh = true
 			}
-			// Для анонима генерируем новый идентификатор
-			if user == nil {
-				user, err = createNewUser()
-				if err != nil {
+			if refresh {
+				if err := IssueToken(w, tokens, user); err != nil {
+					logging.FromContext(r.Context()).WithError(err).Error("unable to issue token")
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
-				log.Printf("created new user with id %s\n", user.ID)
-				setAuthenticationCookie(w, secretKey, user)
-			} else {
-				log.Printf("autheticated existing user with id %s\n", user.ID)
 			}
-			ctx := context.WithValue(r.Context(), AuthContextKey, user)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), user)))
 		})
 	}
 }
+
+// authenticateRequest пробует прочитать токен, провалидировать его подлинность
+// и в итоге получить пользователя, а также признак необходимости перевыпустить токен
+func authenticateRequest(r *http.Request, tokens *Tokens) (*auth.User, bool) {
+	value, err := tokenFromRequest(r)
+	if err != nil {
+		// отсутствие токена ошибкой не считаем
+		return nil, false
+	}
+	user, refresh, err := tokens.Authenticate(value)
+	// в случае ошибки чтения пользователя из токена, логируем ее, но никак на это не реагируем,
+	// позволяя вызывающему коду выпустить новый токен
+	if err != nil {
+		logging.FromContext(r.Context()).WithError(err).Warn("unable to authenticate user")
+		return nil, false
+	}
+	return user, refresh
+}
@@ -1,7 +1,6 @@
 package middleware_test
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -11,8 +10,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
 	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/keyring"
 	mwtest "github.com/sergeii/practikum-go-url-shortener/pkg/testing/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -25,17 +27,21 @@ func generateSecret() []byte {
 }
 
 func HelloIDHandler(w http.ResponseWriter, r *http.Request) {
-	if user, ok := r.Context().Value(middleware.AuthContextKey).(*middleware.AuthUser); ok {
+	if user, ok := auth.FromContext(r.Context()); ok {
 		w.Write([]byte("Hello, " + user.ID)) // nolint:errcheck
 	} else {
 		w.Write([]byte("Hello, anonymous")) // nolint:errcheck
 	}
 }
 
+func withAuthentication(secretKey []byte, opts ...middleware.TokenOption) func(http.Handler) http.Handler {
+	return middleware.WithAuthentication(middleware.NewTokens(keyring.Single(secretKey), opts...))
+}
+
 func TestNewAuthCookieIsSet(t *testing.T) {
 	secretKey := generateSecret()
 	req, _ := http.NewRequest("POST", "/", nil)
-	rr := mwtest.RequestWithMiddleware(HelloIDHandler, middleware.WithAuthentication(secretKey), req)
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey), req)
 
 	cookie := parseAuthSetCookie(rr)
 	cookieIsValid, userID := verifyAuthCookie(cookie.Value, secretKey)
@@ -48,17 +54,219 @@ func TestNewAuthCookieIsSet(t *testing.T) {
 
 func TestPreviousAuthCookieIsAccepted(t *testing.T) {
 	secretKey := generateSecret()
-	userID := "deadbeef"
-	cookieValue := generateAuthCookie(userID, secretKey)
+	cookieValue := generateAuthToken(t, "deadbeef", secretKey)
 	req, _ := http.NewRequest("POST", "/", nil)
 	req.AddCookie(&http.Cookie{Name: "auth", Value: cookieValue})
-	rr := mwtest.RequestWithMiddleware(HelloIDHandler, middleware.WithAuthentication(secretKey), req)
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey), req)
+
+	require.Equal(t, rr.Code, 200)
+	assert.Equal(t, rr.Header().Get("Set-Cookie"), "")
+	assert.Equal(t, rr.Header().Get(middleware.AuthTokenHeader), "")
+	assert.Equal(t, rr.Body.String(), "Hello, deadbeef")
+}
+
+func TestBearerTokenIsAccepted(t *testing.T) {
+	secretKey := generateSecret()
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+generateAuthToken(t, "deadbeef", secretKey))
+	// заголовок имеет приоритет над кукой
+	req.AddCookie(&http.Cookie{Name: "auth", Value: generateAuthToken(t, "cafebabe", secretKey)})
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey), req)
 
 	require.Equal(t, rr.Code, 200)
 	assert.Equal(t, rr.Header().Get("Set-Cookie"), "")
 	assert.Equal(t, rr.Body.String(), "Hello, deadbeef")
 }
 
+func TestLegacyAuthCookieIsUpgraded(t *testing.T) {
+	secretKey := generateSecret()
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: generateAuthCookie("deadbeef", secretKey)})
+	auth := withAuthentication(secretKey, middleware.WithLegacyCookies(true))
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, auth, req)
+
+	require.Equal(t, rr.Code, 200)
+	assert.Equal(t, rr.Body.String(), "Hello, deadbeef")
+	cookie := parseAuthSetCookie(rr)
+	cookieIsValid, userID := verifyAuthCookie(cookie.Value, secretKey)
+	assert.True(t, cookieIsValid)
+	assert.Equal(t, "deadbeef", userID)
+	assert.Equal(t, cookie.Value, rr.Header().Get(middleware.AuthTokenHeader))
+}
+
+func TestLegacyAuthCookieIsRejectedWhenDisabled(t *testing.T) {
+	secretKey := generateSecret()
+	now := time.Now()
+	tests := []struct {
+		name string
+		opts []middleware.TokenOption
+	}{
+		{"disabled", []middleware.TokenOption{middleware.WithLegacyCookies(false)}},
+		{"by default", nil},
+		{
+			"past deadline",
+			[]middleware.TokenOption{
+				middleware.WithLegacyCookies(true),
+				middleware.WithLegacyCookiesUntil(now.Add(-time.Minute)),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "auth", Value: generateAuthCookie("deadbeef", secretKey)})
+			rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey, tt.opts...), req)
+
+			require.Equal(t, rr.Code, 200)
+			_, userID := verifyAuthCookie(parseAuthSetCookie(rr).Value, secretKey)
+			assert.NotEqual(t, "deadbeef", userID)
+			assert.Equal(t, rr.Body.String(), "Hello, "+userID)
+		})
+	}
+}
+
+func TestLegacyAuthCookieIsAcceptedBeforeDeadline(t *testing.T) {
+	secretKey := generateSecret()
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: generateAuthCookie("deadbeef", secretKey)})
+	auth := withAuthentication(
+		secretKey,
+		middleware.WithLegacyCookies(true),
+		middleware.WithLegacyCookiesUntil(time.Now().Add(time.Hour)),
+	)
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, auth, req)
+
+	require.Equal(t, rr.Code, 200)
+	assert.Equal(t, rr.Body.String(), "Hello, deadbeef")
+}
+
+func TestAuthTokenIsResignedAfterKeyRotation(t *testing.T) {
+	oldKey := keyring.Key{ID: "k1", Secret: generateSecret()}
+	newKey := keyring.Key{ID: "k2", Secret: generateSecret()}
+	before, err := keyring.New(oldKey)
+	require.NoError(t, err)
+	after, err := keyring.New(newKey, oldKey)
+	require.NoError(t, err)
+	value, _, err := middleware.NewTokens(before).Issue(&auth.User{ID: "deadbeef"})
+	require.NoError(t, err)
+
+	for _, cookieValue := range []string{value, generateAuthCookie("deadbeef", oldKey.Secret)} {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "auth", Value: cookieValue})
+		tokens := middleware.NewTokens(after, middleware.WithLegacyCookies(true))
+		rr := mwtest.RequestWithMiddleware(HelloIDHandler, middleware.WithAuthentication(tokens), req)
+		require.Equal(t, rr.Code, 200)
+		assert.Equal(t, "Hello, deadbeef", rr.Body.String())
+		// кука переподписана активным ключом и больше не зависит от старого
+		cookie := parseAuthSetCookie(rr)
+		require.NotNil(t, cookie)
+		newOnly, err := keyring.New(newKey)
+		require.NoError(t, err)
+		user, refresh, err := middleware.NewTokens(newOnly).Authenticate(cookie.Value)
+		require.NoError(t, err)
+		assert.False(t, refresh)
+		assert.Equal(t, "deadbeef", user.ID)
+	}
+}
+
+func TestAuthTokenIsRefreshedNearExpiry(t *testing.T) {
+	secretKey := generateSecret()
+	issuedAt := time.Now().Add(-time.Hour * 24 * 25)
+	value, _, err := middleware.NewTokens(keyring.Single(secretKey), middleware.WithClock(func() time.Time {
+		return issuedAt
+	})).Issue(&auth.User{ID: "deadbeef"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		opts        []middleware.TokenOption
+		wantUser    bool
+		wantRefresh bool
+	}{
+		{"valid token far from expiry", []middleware.TokenOption{middleware.WithTokenRefresh(time.Hour)}, true, false},
+		{"valid token near expiry", nil, true, true},
+		{
+			"expired token",
+			[]middleware.TokenOption{middleware.WithClock(func() time.Time { return issuedAt.Add(time.Hour * 24 * 31) })},
+			false, true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "auth", Value: value})
+			rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey, tt.opts...), req)
+			require.Equal(t, rr.Code, 200)
+			if tt.wantUser {
+				assert.Equal(t, "Hello, deadbeef", rr.Body.String())
+			} else {
+				assert.NotEqual(t, "Hello, deadbeef", rr.Body.String())
+			}
+			cookie := parseAuthSetCookie(rr)
+			if !tt.wantRefresh {
+				assert.Nil(t, cookie)
+				return
+			}
+			require.NotNil(t, cookie)
+			assert.NotEqual(t, value, cookie.Value)
+			assert.True(t, cookie.Expires.After(time.Now().Add(time.Hour*24*29)))
+		})
+	}
+}
+
+func TestOptionalAuthentication(t *testing.T) {
+	secretKey := generateSecret()
+	auth := middleware.WithOptionalAuthentication(middleware.NewTokens(keyring.Single(secretKey)))
+
+	// аноним остается анонимом
+	for _, value := range []string{"", "foo:bar"} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if value != "" {
+			req.AddCookie(&http.Cookie{Name: "auth", Value: value})
+		}
+		rr := mwtest.RequestWithMiddleware(HelloIDHandler, auth, req)
+		require.Equal(t, rr.Code, 200)
+		assert.Equal(t, "Hello, anonymous", rr.Body.String())
+		assert.Empty(t, rr.Header().Get("Set-Cookie"))
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: generateAuthToken(t, "deadbeef", secretKey)})
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, auth, req)
+	require.Equal(t, rr.Code, 200)
+	assert.Equal(t, "Hello, deadbeef", rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Set-Cookie"))
+}
+
+func TestAuthCookiePolicy(t *testing.T) {
+	secretKey := generateSecret()
+	req, _ := http.NewRequest("POST", "/", nil)
+	rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey), req)
+	cookie := parseAuthSetCookie(rr)
+	require.NotNil(t, cookie)
+	assert.Equal(t, "/", cookie.Path)
+	assert.True(t, cookie.HttpOnly)
+	assert.False(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	policy := middleware.CookiePolicy{
+		Path:     "/api",
+		Domain:   "short.example",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	req, _ = http.NewRequest("POST", "/", nil)
+	auth := withAuthentication(secretKey, middleware.WithCookiePolicy(policy))
+	rr = mwtest.RequestWithMiddleware(HelloIDHandler, auth, req)
+	cookie = parseAuthSetCookie(rr)
+	require.NotNil(t, cookie)
+	assert.Equal(t, "/api", cookie.Path)
+	assert.Equal(t, "short.example", cookie.Domain)
+	assert.False(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+}
+
 func TestInvalidAuthCookieIsIgnoredNewIsSet(t *testing.T) {
 	secretKey := generateSecret()
 	fakeSecretKey := generateSecret()
@@ -69,7 +277,7 @@ func TestInvalidAuthCookieIsIgnoredNewIsSet(t *testing.T) {
 	}{
 		{
 			name:    "positive case",
-			cookie:  &http.Cookie{Name: "auth", Value: generateAuthCookie("deadbeef", secretKey)},
+			cookie:  &http.Cookie{Name: "auth", Value: generateAuthToken(t, "deadbeef", secretKey)},
 			isValid: true,
 		},
 		{
@@ -118,7 +326,7 @@ func TestInvalidAuthCookieIsIgnoredNewIsSet(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req, _ := http.NewRequest("POST", "/", nil)
 			req.AddCookie(tt.cookie)
-			rr := mwtest.RequestWithMiddleware(HelloIDHandler, middleware.WithAuthentication(secretKey), req)
+			rr := mwtest.RequestWithMiddleware(HelloIDHandler, withAuthentication(secretKey), req)
 			require.Equal(t, rr.Code, 200)
 			if tt.isValid {
 				assert.Equal(t, rr.Header().Get("Set-Cookie"), "")
@@ -153,10 +361,21 @@ func parseAuthSetCookie(rr *httptest.ResponseRecorder) *http.Cookie {
 }
 
 func verifyAuthCookie(cookieValue string, secretKey []byte) (bool, string) {
-	cookieParts := strings.Split(cookieValue, ":")
-	cookieSig, _ := base64.StdEncoding.DecodeString(cookieParts[1])
-	expectedSig := generateAuthCookieSignature(cookieParts[0], secretKey)
-	return bytes.Equal(expectedSig, cookieSig), cookieParts[0]
+	// кука прежнего формата в ответе недопустима
+	if strings.Contains(cookieValue, ":") {
+		return false, ""
+	}
+	user, _, err := middleware.NewTokens(keyring.Single(secretKey)).Authenticate(cookieValue)
+	if err != nil {
+		return false, ""
+	}
+	return true, user.ID
+}
+
+func generateAuthToken(t *testing.T, userID string, secretKey []byte) string {
+	value, _, err := middleware.NewTokens(keyring.Single(secretKey)).Issue(&auth.User{ID: userID})
+	require.NoError(t, err)
+	return value
 }
 
 func generateAuthCookieSignature(userID string, secretKey []byte) []byte {
@@ -169,3 +388,22 @@ func generateAuthCookie(userID string, secretKey []byte) string {
 	sig := generateAuthCookieSignature(userID, secretKey)
 	return userID + ":" + base64.StdEncoding.EncodeToString(sig)
 }
+
+func TestIssueTokenReplacesCookieSetInSameResponse(t *testing.T) {
+	secretKey := generateSecret()
+	tokens := middleware.NewTokens(keyring.Single(secretKey))
+	loginHandler := func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, middleware.IssueToken(w, tokens, &auth.User{ID: "deadbeef"}))
+	}
+	req, _ := http.NewRequest("POST", "/", nil)
+	rr := mwtest.RequestWithMiddleware(loginHandler, middleware.WithAuthentication(tokens), req)
+	require.Len(t, rr.Result().Cookies(), 1)
+	_, userID := verifyAuthCookie(rr.Result().Cookies()[0].Value, secretKey)
+	assert.Equal(t, "deadbeef", userID)
+
+	rr = mwtest.RequestWithMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		middleware.ClearToken(w, tokens)
+	}, middleware.WithAuthentication(tokens), req)
+	require.Len(t, rr.Result().Cookies(), 1)
+	assert.Equal(t, -1, rr.Result().Cookies()[0].MaxAge)
+}
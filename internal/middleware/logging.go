@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// WithLogging возвращает функцию-мидлварь, привязывающую к контексту запроса логгер
+// с идентификатором запроса (см. middleware.RequestID) и пишущую по завершении запроса строку access-лога.
+// Поля, добавленные в логгер по ходу обработки запроса (например, идентификатор пользователя),
+// попадают и в access-лог
+func WithLogging(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			entry := logrus.NewEntry(logger)
+			if requestID := middleware.GetReqID(r.Context()); requestID != "" {
+				entry = entry.WithField("request_id", requestID)
+			}
+			ctx := logging.NewContext(r.Context(), entry)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			logging.FromContext(ctx).WithFields(logrus.Fields{
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"proto":       r.Proto,
+				"remote_addr": r.RemoteAddr,
+				"status":      status,
+				"bytes":       ww.BytesWritten(),
+				"duration_ms": float64(time.Since(started).Microseconds()) / 1000,
+			}).Info("request handled")
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogContainsRequestAndUserIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", logging.FormatJSON)
+	require.NoError(t, err)
+	tokens := middleware.NewTokens(keyring.Single(generateSecret()))
+	token, _, err := tokens.Issue(&auth.User{ID: "deadbeef"})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(chimw.RequestID)
+	router.Use(middleware.WithLogging(logger))
+	router.Use(middleware.WithAuthentication(tokens))
+	router.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("saying hello")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello")) // nolint:errcheck
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("X-Request-Id", "req-42")
+	req.AddCookie(&http.Cookie{Name: "auth", Value: token})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusTeapot, rr.Code)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var handlerRecord, accessRecord map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &handlerRecord))
+	require.NoError(t, json.Unmarshal(lines[1], &accessRecord))
+
+	assert.Equal(t, "saying hello", handlerRecord["msg"])
+	assert.Equal(t, "req-42", handlerRecord["request_id"])
+	assert.Equal(t, "deadbeef", handlerRecord["user_id"])
+
+	assert.Equal(t, "request handled", accessRecord["msg"])
+	assert.Equal(t, "req-42", accessRecord["request_id"])
+	assert.Equal(t, "deadbeef", accessRecord["user_id"])
+	assert.Equal(t, "GET", accessRecord["method"])
+	assert.Equal(t, "/hello", accessRecord["path"])
+	assert.Equal(t, 418.0, accessRecord["status"])
+	assert.Equal(t, 5.0, accessRecord["bytes"])
+}
+
+func TestAuthenticationIsLoggedAtDebugLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", logging.FormatJSON)
+	require.NoError(t, err)
+
+	auth := withAuthentication(generateSecret())
+	handler := middleware.WithLogging(logger)(auth(http.HandlerFunc(HelloIDHandler)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 1)
+	assert.Contains(t, string(lines[0]), "request handled")
+	assert.Contains(t, string(lines[0]), `"user_id":`)
+}
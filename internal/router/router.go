@@ -1,34 +1,66 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sergeii/practikum-go-url-shortener/internal/app"
 	"github.com/sergeii/practikum-go-url-shortener/internal/handlers"
 	mw "github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
 )
 
 func New(theApp *app.App) chi.Router {
+	svc := service.New(theApp)
 	handler := &handlers.Handler{
-		App: theApp,
+		App:     theApp,
+		Service: svc,
 	}
 	router := chi.NewRouter()
+	router.Use(theApp.Metrics.Middleware)
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(mw.WithLogging(theApp.Logger))
+	router.Use(theApp.Tracing.Middleware)
 	router.Use(mw.GzipSupport)
-	router.Use(mw.WithAuthentication(theApp.SecretKey))
+	router.Use(mw.WithAPIKey(svc))
 	router.Use(middleware.Recoverer)
-	router.Route("/", func(r chi.Router) {
-		r.Post("/", handler.ShortenURL)
+	// эндпоинты, которым не нужен пользователь, не заводят его для каждого анонимного запроса
+	router.Group(func(r chi.Router) {
+		r.Use(mw.WithOptionalAuthentication(theApp.Tokens))
 		r.Get("/ping", handler.Ping)
+		r.Get("/healthz", handler.Healthz)
+		r.Get("/readyz", handler.Readyz)
+		r.Get("/auth/oidc/login", handler.OIDCLogin)
+		r.Get("/auth/oidc/callback", handler.OIDCCallback)
+		r.Method(http.MethodGet, "/metrics", theApp.Metrics.Handler())
 		r.Get("/{slug:[a-zA-Z0-9_-]+}", handler.ExpandURL)
 	})
-	router.Route("/api", func(r chi.Router) {
-		r.Post("/shorten", handler.APIShortenURL)
-		r.Post("/shorten/batch", handler.APIShortenBatch)
-		r.Get("/user/urls", handler.GetUserURLs)
-		r.Delete("/user/urls", handler.DeleteUserURLs)
+	router.Group(func(r chi.Router) {
+		r.Use(mw.WithAuthentication(theApp.Tokens))
+		r.Post("/", handler.ShortenURL)
+		r.Route("/api", func(r chi.Router) {
+			r.Post("/shorten", handler.APIShortenURL)
+			r.Post("/shorten/batch", handler.APIShortenBatch)
+			r.Post("/user/signup", handler.Signup)
+			r.Post("/user/login", handler.Login)
+			r.Post("/user/logout", handler.Logout)
+			r.Get("/user/urls", handler.GetUserURLs)
+			r.Get("/user/urls/export", handler.ExportUserURLs)
+			r.Delete("/user/urls", handler.DeleteUserURLs)
+			r.Post("/import", handler.APIImportURLs)
+			r.Get("/import/{importID}", handler.APIGetImport)
+			r.Get("/jobs/{jobID}", handler.GetJobStatus)
+			r.Post("/keys", handler.CreateAPIKey)
+			r.Get("/keys", handler.GetAPIKeys)
+			r.Delete("/keys/{keyID}", handler.RevokeAPIKey)
+			r.Post("/workspaces", handler.CreateWorkspace)
+			r.Get("/workspaces", handler.GetWorkspaces)
+			r.Get("/workspaces/{workspaceID}/members", handler.GetWorkspaceMembers)
+			r.Put("/workspaces/{workspaceID}/members/{userID}", handler.SetWorkspaceMember)
+			r.Delete("/workspaces/{workspaceID}/members/{userID}", handler.RemoveWorkspaceMember)
+		})
 	})
 	return router
 }
@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthMetadataKey - ключ метаданных с токеном пользователя; совпадает с именем авторизационной куки.
+// Токен также принимается в authorization: Bearer <токен>
+const AuthMetadataKey = middleware.AuthUserCookieName
+
+// APIKeyMetadataKey - ключ метаданных с ключом API. Ключ также принимается в authorization: Bearer <ключ>
+const APIKeyMetadataKey = "x-api-key"
+
+var ErrNoToken = errors.New("no auth token in metadata")
+
+// readMethods - методы, для вызова которых ключу API достаточно разрешения read
+var readMethods = map[string]bool{
+	"/shortener.v1.Shortener/Expand":       true,
+	"/shortener.v1.Shortener/ListUserURLs": true,
+	"/shortener.v1.Shortener/Ping":         true,
+}
+
+// withLogging привязывает к контексту вызова логгер с именем метода и пишет строку access-лога
+func withLogging(logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		started := time.Now()
+		ctx = logging.NewContext(ctx, logger.WithField("grpc_method", info.FullMethod))
+		resp, err := handler(ctx, req)
+		entry := logging.FromContext(ctx).WithFields(logrus.Fields{
+			"code":        status.Code(err).String(),
+			"duration_ms": float64(time.Since(started).Microseconds()) / 1000,
+		})
+		if err != nil {
+			entry = entry.WithError(err)
+		}
+		entry.Info("rpc handled")
+		return resp, err
+	}
+}
+
+// withAPIKey аутентифицирует серверных клиентов по ключу API из метаданных, по аналогии с middleware.WithAPIKey.
+// Вызовы без ключа передаются дальше для аутентификации по токену, вызовы с неверным ключом отклоняются
+// с Unauthenticated, а с ключом без нужного разрешения - с PermissionDenied
+func withAPIKey(verifier auth.APIKeyVerifier) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		key, ok := apiKeyFromMetadata(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+		user, err := verifier.VerifyAPIKey(ctx, key)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				logging.FromContext(ctx).WithError(err).Debug("rejected api key")
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			logging.FromContext(ctx).WithError(err).Error("unable to verify api key")
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		logging.AddFields(ctx, logrus.Fields{"user_id": user.ID, "api_key_id": user.APIKeyID})
+		scope := auth.ScopeWrite
+		if readMethods[info.FullMethod] {
+			scope = auth.ScopeRead
+		}
+		if !user.HasScope(scope) {
+			return nil, status.Error(codes.PermissionDenied, "api key lacks "+scope+" scope")
+		}
+		return handler(auth.NewContext(ctx, user), req)
+	}
+}
+
+func apiKeyFromMetadata(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	if values := md.Get(APIKeyMetadataKey); len(values) > 0 && values[0] != "" {
+		return values[0], true
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		return auth.APIKeyFromAuthorization(values[0])
+	}
+	return "", false
+}
+
+// withAuthentication аутентифицирует пользователя по подписанному токену из метаданных, по аналогии
+// с middleware.WithAuthentication. Для анонима создается новый пользователь, чей токен, как и перевыпущенный
+// токен существующего пользователя, отправляется клиенту в заголовке ответа с тем же ключом.
+// Пользователи, уже аутентифицированные по ключу API, пропускаются
+func withAuthentication(tokens *middleware.Tokens) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if _, ok := auth.FromContext(ctx); ok {
+			return handler(ctx, req)
+		}
+		user, refresh, err := authenticateUser(ctx, tokens)
+		if err != nil && !errors.Is(err, ErrNoToken) {
+			logging.FromContext(ctx).WithError(err).Warn("unable to authenticate user")
+		}
+		if user == nil {
+			user, err = auth.NewUser()
+			if err != nil {
+				logging.FromContext(ctx).WithError(err).Error("unable to generate user id")
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			refresh = true
+		}
+		if refresh {
+			token, _, err := tokens.Issue(user)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			if err := grpc.SetHeader(ctx, metadata.Pairs(AuthMetadataKey, token)); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+		logging.AddFields(ctx, logrus.Fields{"user_id": user.ID})
+		ctx = auth.NewContext(ctx, user)
+		return handler(ctx, req)
+	}
+}
+
+// authenticateUser проверяет токен из authorization: Bearer, либо из метаданных с ключом AuthMetadataKey,
+// по аналогии с заголовком Authorization и авторизационной кукой в HTTP API
+func authenticateUser(ctx context.Context, tokens *middleware.Tokens) (*auth.User, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false, ErrNoToken
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		if value, ok := auth.BearerToken(values[0]); ok {
+			return tokens.Authenticate(value)
+		}
+	}
+	values := md.Get(AuthMetadataKey)
+	if len(values) == 0 {
+		return nil, false, ErrNoToken
+	}
+	return tokens.Authenticate(values[0])
+}
@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sergeii/practikum-go-url-shortener/api/pb"
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server реализует gRPC API поверх того же сервисного слоя, что используют HTTP обработчики
+type Server struct {
+	pb.UnimplementedShortenerServer
+	Service *service.Service
+}
+
+// New создает gRPC сервер с зарегистрированным сервисом сокращения ссылок
+func New(theApp *app.App, opts ...grpc.ServerOption) *grpc.Server {
+	svc := service.New(theApp)
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		withLogging(theApp.Logger),
+		theApp.Tracing.UnaryServerInterceptor(),
+		withAPIKey(svc),
+		withAuthentication(theApp.Tokens),
+	))
+	s := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(s, &Server{Service: svc})
+	return s
+}
+
+// GracefulStop дожидается завершения текущих вызовов, но не дольше, чем позволяет контекст.
+// По истечении контекста оставшиеся вызовы прерываются
+func GracefulStop(ctx context.Context, s *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// serviceError переводит ошибку сервиса в gRPC статус
+func serviceError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, service.ErrEmptyURL),
+		errors.Is(err, service.ErrEmptyBatch),
+		errors.Is(err, service.ErrInvalidShortID),
+		errors.Is(err, service.ErrInvalidScope),
+		errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrInvalidWorkspace),
+		errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrInvalidMember),
+		errors.Is(err, service.ErrUnknownMember):
+		code = codes.InvalidArgument
+	case errors.Is(err, service.ErrWorkspaceForbidden):
+		code = codes.PermissionDenied
+	case errors.Is(err, service.ErrInvalidCredentials):
+		code = codes.Unauthenticated
+	case errors.Is(err, service.ErrEmailTaken):
+		code = codes.AlreadyExists
+	case errors.Is(err, service.ErrLastOwner):
+		code = codes.FailedPrecondition
+	// в отличие от HTTP у gRPC нет отдельного кода для удаленных ресурсов
+	case errors.Is(err, service.ErrURLNotFound),
+		errors.Is(err, service.ErrURLDeleted),
+		errors.Is(err, service.ErrJobNotFound),
+		errors.Is(err, service.ErrImportNotFound),
+		errors.Is(err, service.ErrAPIKeyNotFound),
+		errors.Is(err, service.ErrWorkspaceNotFound),
+		errors.Is(err, service.ErrMemberNotFound):
+		code = codes.NotFound
+	// очередь переполнена - клиенту стоит повторить попытку позже
+	case errors.Is(err, service.ErrQueueUnavailable):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+// Shorten создает короткую ссылку; для ранее сокращенной ссылки возвращает прежнюю с created=false
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	result, err := s.Service.Shorten(ctx, authUser(ctx).ID, req.Url)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &pb.ShortenResponse{ShortUrl: result.ShortURL, Created: result.Created}, nil
+}
+
+// ShortenBatch сокращает пачку ссылок, сопоставляя результаты с correlation_id запроса
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	items := make([]service.BatchItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		items = append(items, service.BatchItem{CorrelationID: item.CorrelationId, OriginalURL: item.OriginalUrl})
+	}
+	results, err := s.Service.ShortenBatch(ctx, authUser(ctx).ID, items)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	res := &pb.ShortenBatchResponse{Items: make([]*pb.ShortenBatchResult, 0, len(results))}
+	for _, result := range results {
+		res.Items = append(res.Items, &pb.ShortenBatchResult{
+			CorrelationId: result.CorrelationID,
+			ShortUrl:      result.ShortURL,
+		})
+	}
+	return res, nil
+}
+
+// Expand возвращает оригинальную ссылку по идентификатору короткой
+func (s *Server) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	longURL, err := s.Service.Expand(ctx, req.ShortId)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &pb.ExpandResponse{OriginalUrl: longURL}, nil
+}
+
+// ListUserURLs возвращает все ссылки, сокращенные текущим пользователем
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	items, err := s.Service.UserURLs(ctx, authUser(ctx).ID)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	res := &pb.ListUserURLsResponse{Items: make([]*pb.UserURL, 0, len(items))}
+	for _, item := range items {
+		res.Items = append(res.Items, &pb.UserURL{ShortUrl: item.ShortURL, OriginalUrl: item.OriginalURL})
+	}
+	return res, nil
+}
+
+// DeleteUserURLs ставит в очередь фоновое удаление ссылок пользователя и возвращает идентификатор задачи
+func (s *Server) DeleteUserURLs(
+	ctx context.Context, req *pb.DeleteUserURLsRequest,
+) (*pb.DeleteUserURLsResponse, error) {
+	jobID, err := s.Service.DeleteUserURLs(ctx, authUser(ctx).ID, req.ShortIds)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &pb.DeleteUserURLsResponse{JobId: jobID}, nil
+}
+
+// Ping проверяет доступность хранилища
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.Service.Ping(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pb.PingResponse{}, nil
+}
+
+// authUser возвращает пользователя, установленного интерсептором аутентификации
+func authUser(ctx context.Context) *auth.User {
+	user, _ := auth.FromContext(ctx)
+	if user == nil {
+		return &auth.User{}
+	}
+	return user
+}
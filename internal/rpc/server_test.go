@@ -0,0 +1,223 @@
+package rpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/api/pb"
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/rpc"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func prepareTestClient(t *testing.T) (pb.ShortenerClient, *app.App) {
+	shortener, err := app.New(func(cfg *app.Config) error {
+		cfg.FileStoragePath = ""
+		cfg.DatabaseDSN = ""
+		return nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(shortener.Close)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := rpc.New(shortener)
+	go server.Serve(listener) // nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return pb.NewShortenerClient(conn), shortener
+}
+
+func withToken(shortener *app.App, userID string) context.Context {
+	token, _, _ := shortener.Tokens.Issue(&auth.User{ID: userID})
+	return metadata.AppendToOutgoingContext(context.TODO(), rpc.AuthMetadataKey, token)
+}
+
+func TestShortenAndExpand(t *testing.T) {
+	client, _ := prepareTestClient(t)
+	res, err := client.Shorten(context.TODO(), &pb.ShortenRequest{Url: "https://go.dev/"})
+	require.NoError(t, err)
+	assert.True(t, res.Created)
+	assert.Regexp(t, `^http://localhost:8080/[\w-]+$`, res.ShortUrl)
+
+	// повторное сокращение возвращает ту же ссылку, как и 409 в HTTP API
+	again, err := client.Shorten(context.TODO(), &pb.ShortenRequest{Url: "https://go.dev/"})
+	require.NoError(t, err)
+	assert.False(t, again.Created)
+	assert.Equal(t, res.ShortUrl, again.ShortUrl)
+
+	shortID := res.ShortUrl[len("http://localhost:8080/"):]
+	expanded, err := client.Expand(context.TODO(), &pb.ExpandRequest{ShortId: shortID})
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev/", expanded.OriginalUrl)
+}
+
+func TestShortenRequiresURL(t *testing.T) {
+	client, _ := prepareTestClient(t)
+	_, err := client.Shorten(context.TODO(), &pb.ShortenRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ShortenBatch(context.TODO(), &pb.ShortenBatchRequest{
+		Items: []*pb.ShortenBatchItem{{CorrelationId: "foo"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShortenBatch(t *testing.T) {
+	client, shortener := prepareTestClient(t)
+	res, err := client.ShortenBatch(withToken(shortener, "user1"), &pb.ShortenBatchRequest{
+		Items: []*pb.ShortenBatchItem{
+			{CorrelationId: "foo", OriginalUrl: "https://go.dev/"},
+			{CorrelationId: "bar", OriginalUrl: "https://yandex.ru/"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Items, 2)
+	for _, item := range res.Items {
+		assert.Contains(t, []string{"foo", "bar"}, item.CorrelationId)
+		assert.Regexp(t, `^http://localhost:8080/[\w-]+$`, item.ShortUrl)
+	}
+	items, err := shortener.Storage.GetURLsByUserID(context.TODO(), "user1")
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+}
+
+func TestExpandUnknownOrDeletedURL(t *testing.T) {
+	client, shortener := prepareTestClient(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint:errcheck
+	shortener.Storage.DeleteUserURLs(context.TODO(), "user1", "go")         // nolint:errcheck
+
+	for _, shortID := range []string{"go", "unknown"} {
+		_, err := client.Expand(context.TODO(), &pb.ExpandRequest{ShortId: shortID})
+		assert.Equal(t, codes.NotFound, status.Code(err), shortID)
+	}
+}
+
+func TestNewUserReceivesTokenInHeader(t *testing.T) {
+	client, _ := prepareTestClient(t)
+	var header metadata.MD
+	_, err := client.Shorten(
+		context.TODO(), &pb.ShortenRequest{Url: "https://go.dev/"}, grpc.Header(&header),
+	)
+	require.NoError(t, err)
+	tokens := header.Get(rpc.AuthMetadataKey)
+	require.Len(t, tokens, 1)
+
+	// с выданным токеном пользователь видит свои ссылки и не получает новый токен
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), rpc.AuthMetadataKey, tokens[0])
+	header = nil
+	res, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(rpc.AuthMetadataKey))
+	require.Len(t, res.Items, 1)
+	assert.Equal(t, "https://go.dev/", res.Items[0].OriginalUrl)
+
+	// без токена пользователь новый, и ссылок у него нет
+	res, err = client.ListUserURLs(context.TODO(), &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, res.Items, 0)
+}
+
+func TestTokenIsAcceptedInAuthorizationMetadata(t *testing.T) {
+	client, shortener := prepareTestClient(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint:errcheck
+	token, _, err := shortener.Tokens.Issue(&auth.User{ID: "user1"})
+	require.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), "authorization", "Bearer "+token)
+	var header metadata.MD
+	res, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(rpc.AuthMetadataKey))
+	require.Len(t, res.Items, 1)
+	assert.Equal(t, "https://go.dev/", res.Items[0].OriginalUrl)
+}
+
+func TestInvalidTokenIsReplaced(t *testing.T) {
+	client, _ := prepareTestClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), rpc.AuthMetadataKey, "user1:Zm9vYmFy")
+	var header metadata.MD
+	_, err := client.Ping(ctx, &pb.PingRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Len(t, header.Get(rpc.AuthMetadataKey), 1)
+}
+
+func TestDeleteUserURLs(t *testing.T) {
+	client, shortener := prepareTestClient(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1")       // nolint:errcheck
+	shortener.Storage.Set(context.TODO(), "ya", "https://yandex.ru/", "user1")    // nolint:errcheck
+	shortener.Storage.Set(context.TODO(), "wiki", "https://wikipedia.org/", "u2") // nolint:errcheck
+
+	res, err := client.DeleteUserURLs(
+		withToken(shortener, "user1"), &pb.DeleteUserURLsRequest{ShortIds: []string{"go", "wiki"}},
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.JobId)
+	require.Eventually(t, func() bool {
+		jobStatus, err := shortener.Jobs.Status(context.TODO(), res.JobId)
+		return err == nil && jobStatus.State == background.JobSucceeded
+	}, time.Second, time.Millisecond*10)
+
+	items, err := shortener.Storage.GetURLsByUserID(context.TODO(), "user1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ya": "https://yandex.ru/"}, items)
+	// чужая ссылка не удалена
+	_, err = shortener.Storage.Get(context.TODO(), "wiki")
+	assert.NoError(t, err)
+}
+
+func TestPing(t *testing.T) {
+	client, _ := prepareTestClient(t)
+	_, err := client.Ping(context.TODO(), &pb.PingRequest{})
+	assert.NoError(t, err)
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	client, shortener := prepareTestClient(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint:errcheck
+	svc := service.New(shortener)
+	readKey, err := svc.CreateAPIKey(context.TODO(), "user1", []string{auth.ScopeRead})
+	require.NoError(t, err)
+
+	for _, md := range [][]string{
+		{rpc.APIKeyMetadataKey, readKey.Key},
+		{"authorization", "Bearer " + readKey.Key},
+	} {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(context.TODO(), md...)
+		res, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{}, grpc.Header(&header))
+		require.NoError(t, err, md[0])
+		require.Len(t, res.Items, 1)
+		// клиенту с ключом API токен не выдается
+		assert.Empty(t, header.Get(rpc.AuthMetadataKey))
+
+		// ключу без разрешения write запрещено сокращать ссылки
+		_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://ya.ru/"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), rpc.APIKeyMetadataKey, "sk_unknown_key")
+	_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/oidc"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/password"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const MinPasswordLength = 8
+
+var (
+	ErrInvalidEmail       = errors.New("please provide a valid email")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters long")
+	ErrEmailTaken         = errors.New("email is already registered")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// Account - учетная запись зарегистрированного пользователя
+type Account struct {
+	ID    string
+	Email string
+	// MergedURLs - количество ссылок анонимного пользователя, перешедших к учетной записи
+	MergedURLs int
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// Signup регистрирует учетную запись и передает ей ссылки текущего пользователя current, если тот анонимен
+func (s *Service) Signup(ctx context.Context, current *auth.User, email, plain string) (Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return Account{}, err
+	}
+	if len(plain) < MinPasswordLength {
+		return Account{}, ErrWeakPassword
+	}
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return Account{}, err
+	}
+	user, err := auth.NewUser()
+	if err != nil {
+		return Account{}, err
+	}
+	record := storage.UserRecord{ID: user.ID, Email: email, PasswordHash: hash, CreatedAt: time.Now()}
+	if err := s.App.Users.SaveUser(ctx, record); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			return Account{}, ErrEmailTaken
+		}
+		return Account{}, err
+	}
+	return s.mergeAnonymous(ctx, current, record)
+}
+
+// Login проверяет email и пароль и передает учетной записи ссылки текущего пользователя current, если тот анонимен
+func (s *Service) Login(ctx context.Context, current *auth.User, email, plain string) (Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return Account{}, ErrInvalidCredentials
+	}
+	record, err := s.App.Users.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			return Account{}, err
+		}
+		// проверяем пароль и для несуществующей учетной записи,
+		// чтобы по времени ответа нельзя было узнать, зарегистрирован ли email
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = password.Hash("")
+		})
+		password.Verify(plain, dummyHash) // nolint:errcheck
+		return Account{}, ErrInvalidCredentials
+	}
+	ok, err := password.Verify(plain, record.PasswordHash)
+	if err != nil {
+		return Account{}, err
+	}
+	if !ok {
+		return Account{}, ErrInvalidCredentials
+	}
+	return s.mergeAnonymous(ctx, current, record)
+}
+
+// mergeAnonymous передает учетной записи ссылки пользователя current, только если его токен выдан анониму.
+// Ссылки другой учетной записи или пользователя SSO, как и пользователя неизвестного типа, остаются при нем
+func (s *Service) mergeAnonymous(
+	ctx context.Context, current *auth.User, record storage.UserRecord,
+) (Account, error) {
+	account := Account{ID: record.ID, Email: record.Email}
+	if current == nil || current.Kind != auth.UserAnonymous || current.ID == record.ID {
+		return account, nil
+	}
+	merged, err := s.App.Storage.TransferUserURLs(ctx, current.ID, record.ID)
+	if err != nil {
+		return Account{}, err
+	}
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"anonymous_id": current.ID,
+		"account_id":   record.ID,
+		"merged":       merged,
+	}).Info("merged anonymous user into account")
+	account.MergedURLs = merged
+	return account, nil
+}
+
+// SSOUser возвращает пользователя, вошедшего через провайдер OIDC. Идентификатор выводится из издателя
+// и subject, поэтому при каждом входе пользователь получает доступ к тем же ссылкам.
+// Ссылки анонима ему не переносятся, а его собственные ссылки не переходят учетной записи при входе по паролю
+func (s *Service) SSOUser(ctx context.Context, claims oidc.Claims) *auth.User {
+	digest := sha256.Sum256([]byte(claims.Issuer + "\x00" + claims.Subject))
+	user := &auth.User{ID: hex.EncodeToString(digest[:auth.UserIDLength]), Kind: auth.UserSSO}
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"issuer":  claims.Issuer,
+		"subject": claims.Subject,
+		"user_id": user.ID,
+	}).Info("user logged in with sso")
+	return user
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	// отвергаем адреса с именем вида "Gopher <gopher@go.dev>"
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/oidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func anonymous(userID string) *auth.User {
+	return &auth.User{ID: userID, Kind: auth.UserAnonymous}
+}
+
+func TestSignupMergesAnonymousURLs(t *testing.T) {
+	svc, shortener := newTestService(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "anon1")   // nolint:errcheck
+	shortener.Storage.Set(context.TODO(), "ya", "https://ya.ru/", "somebody") // nolint:errcheck
+
+	account, err := svc.Signup(context.TODO(), anonymous("anon1"), " Gopher@Go.dev ", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher@go.dev", account.Email)
+	assert.Equal(t, 1, account.MergedURLs)
+	assert.NotEqual(t, "anon1", account.ID)
+
+	urls, err := svc.UserURLs(context.TODO(), account.ID)
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://go.dev/", urls[0].OriginalURL)
+
+	// пароль хранится только в виде хеша
+	record, err := shortener.Users.GetUserByEmail(context.TODO(), "gopher@go.dev")
+	require.NoError(t, err)
+	assert.NotContains(t, record.PasswordHash, "correct horse")
+
+	_, err = svc.Signup(context.TODO(), anonymous("anon2"), "gopher@go.dev", "other password")
+	assert.ErrorIs(t, err, service.ErrEmailTaken)
+}
+
+func TestSignupValidation(t *testing.T) {
+	svc, _ := newTestService(t)
+	tests := []struct {
+		email    string
+		password string
+		want     error
+	}{
+		{"", "correct horse", service.ErrInvalidEmail},
+		{"gopher", "correct horse", service.ErrInvalidEmail},
+		{"Gopher <gopher@go.dev>", "correct horse", service.ErrInvalidEmail},
+		{"gopher@go.dev", "", service.ErrWeakPassword},
+		{"gopher@go.dev", "short", service.ErrWeakPassword},
+	}
+	for _, tt := range tests {
+		_, err := svc.Signup(context.TODO(), anonymous("anon1"), tt.email, tt.password)
+		assert.ErrorIs(t, err, tt.want, tt.email)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	svc, shortener := newTestService(t)
+	account, err := svc.Signup(context.TODO(), anonymous("anon1"), "gopher@go.dev", "correct horse")
+	require.NoError(t, err)
+
+	for _, creds := range [][2]string{
+		{"gopher@go.dev", "wrong horse"},
+		{"rustacean@rust-lang.org", "correct horse"},
+		{"gopher", "correct horse"},
+	} {
+		_, err := svc.Login(context.TODO(), anonymous("anon2"), creds[0], creds[1])
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials, creds[0])
+	}
+
+	// ссылки анонима переходят к учетной записи при входе
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "anon2") // nolint:errcheck
+	loggedIn, err := svc.Login(context.TODO(), anonymous("anon2"), "GOPHER@go.dev", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, loggedIn.ID)
+	assert.Equal(t, 1, loggedIn.MergedURLs)
+
+	// а ссылки другой учетной записи - нет
+	other, err := svc.Signup(context.TODO(), anonymous("anon3"), "other@go.dev", "correct horse")
+	require.NoError(t, err)
+	shortener.Storage.Set(context.TODO(), "ya", "https://ya.ru/", other.ID) // nolint:errcheck
+	otherUser := &auth.User{ID: other.ID, Kind: auth.UserAccount}
+	loggedIn, err = svc.Login(context.TODO(), otherUser, "gopher@go.dev", "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, 0, loggedIn.MergedURLs)
+	urls, err := svc.UserURLs(context.TODO(), other.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+func TestLoginKeepsURLsOfNonAnonymousUsers(t *testing.T) {
+	svc, shortener := newTestService(t)
+	_, err := svc.Signup(context.TODO(), nil, "gopher@go.dev", "correct horse")
+	require.NoError(t, err)
+	ssoUser := svc.SSOUser(context.TODO(), oidc.Claims{Issuer: "https://sso.example.com", Subject: "gopher"})
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", ssoUser.ID) // nolint:errcheck
+	// пользователь, чей тип не известен, например с токеном, выпущенным до появления типов
+	shortener.Storage.Set(context.TODO(), "ya", "https://ya.ru/", "unknown1") // nolint:errcheck
+
+	for _, current := range []*auth.User{ssoUser, {ID: "unknown1"}} {
+		loggedIn, err := svc.Login(context.TODO(), current, "gopher@go.dev", "correct horse")
+		require.NoError(t, err)
+		assert.Equal(t, 0, loggedIn.MergedURLs)
+		urls, err := svc.UserURLs(context.TODO(), current.ID)
+		require.NoError(t, err)
+		assert.Len(t, urls, 1)
+	}
+}
+
+func TestSSOUserIsStableForSubject(t *testing.T) {
+	svc, _ := newTestService(t)
+	gopher := oidc.Claims{Issuer: "https://sso.example.com", Subject: "gopher"}
+	user := svc.SSOUser(context.TODO(), gopher)
+	assert.Len(t, user.ID, 2*auth.UserIDLength)
+	assert.Equal(t, user.ID, svc.SSOUser(context.TODO(), gopher).ID)
+
+	// тот же subject другого провайдера - другой пользователь
+	other := svc.SSOUser(context.TODO(), oidc.Claims{Issuer: "https://idp.example.com", Subject: "gopher"})
+	assert.NotEqual(t, user.ID, other.ID)
+}
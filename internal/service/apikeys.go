@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/apikey"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+)
+
+var (
+	ErrInvalidScope   = errors.New("please provide api key scopes: read, write")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey - ключ API пользователя. Сам ключ (Key) известен только в момент создания
+type APIKey struct {
+	ID        string
+	Key       string
+	Scopes    []string
+	CreatedAt time.Time
+}
+
+// CreateAPIKey выпускает пользователю ключ API с заданными разрешениями
+func (s *Service) CreateAPIKey(ctx context.Context, userID string, scopes []string) (APIKey, error) {
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key, err := apikey.Generate()
+	if err != nil {
+		return APIKey{}, err
+	}
+	record := storage.APIKeyRecord{
+		ID:        key.ID,
+		UserID:    userID,
+		Hash:      key.Hash(),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := s.App.APIKeys.SaveAPIKey(ctx, record); err != nil {
+		return APIKey{}, err
+	}
+	return APIKey{ID: key.ID, Key: key.String(), Scopes: scopes, CreatedAt: record.CreatedAt}, nil
+}
+
+// APIKeys возвращает действующие ключи пользователя
+func (s *Service) APIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	records, err := s.App.APIKeys.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, APIKey{ID: record.ID, Scopes: record.Scopes, CreatedAt: record.CreatedAt})
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey отзывает ключ пользователя; последующие запросы с ним будут отклонены
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	if err := s.App.APIKeys.RevokeAPIKey(ctx, userID, keyID); err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// VerifyAPIKey проверяет ключ API и возвращает его владельца с разрешениями ключа.
+// Реализует auth.APIKeyVerifier
+func (s *Service) VerifyAPIKey(ctx context.Context, token string) (*auth.User, error) {
+	key, err := apikey.Parse(token)
+	if err != nil {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	record, err := s.App.APIKeys.GetAPIKey(ctx, key.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return nil, auth.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if record.IsRevoked() || !key.Matches(record.Hash) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	return &auth.User{ID: record.UserID, APIKeyID: record.ID, Scopes: record.Scopes}, nil
+}
+
+// normalizeScopes проверяет разрешения и убирает повторы
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	seen := make(map[string]bool, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope != auth.ScopeRead && scope != auth.ScopeWrite {
+			return nil, ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
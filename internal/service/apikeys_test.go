@@ -0,0 +1,61 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/auth"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAndVerifyAPIKey(t *testing.T) {
+	svc, shortener := newTestService(t)
+	key, err := svc.CreateAPIKey(context.TODO(), "user1", []string{"read", "write", "read"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read", "write"}, key.Scopes)
+	assert.Contains(t, key.Key, key.ID)
+
+	// в хранилище попадает только хеш ключа
+	record, err := shortener.APIKeys.GetAPIKey(context.TODO(), key.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, key.Key, record.Hash)
+
+	user, err := svc.VerifyAPIKey(context.TODO(), key.Key)
+	require.NoError(t, err)
+	assert.Equal(t, &auth.User{ID: "user1", APIKeyID: key.ID, Scopes: []string{"read", "write"}}, user)
+
+	// подделанный секрет при известном идентификаторе
+	_, err = svc.VerifyAPIKey(context.TODO(), "sk_"+key.ID+"_forged")
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+	_, err = svc.VerifyAPIKey(context.TODO(), "garbage")
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+}
+
+func TestCreateAPIKeyRequiresKnownScopes(t *testing.T) {
+	svc, _ := newTestService(t)
+	for _, scopes := range [][]string{nil, {}, {"admin"}, {"read", "delete"}} {
+		_, err := svc.CreateAPIKey(context.TODO(), "user1", scopes)
+		assert.ErrorIs(t, err, service.ErrInvalidScope, scopes)
+	}
+}
+
+func TestRevokedAPIKeyIsRejected(t *testing.T) {
+	svc, _ := newTestService(t)
+	key, err := svc.CreateAPIKey(context.TODO(), "user1", []string{"read"})
+	require.NoError(t, err)
+	other, err := svc.CreateAPIKey(context.TODO(), "user1", []string{"write"})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, svc.RevokeAPIKey(context.TODO(), "user2", key.ID), service.ErrAPIKeyNotFound)
+	require.NoError(t, svc.RevokeAPIKey(context.TODO(), "user1", key.ID))
+	_, err = svc.VerifyAPIKey(context.TODO(), key.Key)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+
+	keys, err := svc.APIKeys(context.TODO(), "user1")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, other.ID, keys[0].ID)
+	assert.Empty(t, keys[0].Key)
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/internal/exports"
+	"github.com/sergeii/practikum-go-url-shortener/internal/imports"
+	"github.com/sergeii/practikum-go-url-shortener/internal/jobs"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+)
+
+// Ошибки сервиса, по которым транспорт (HTTP, gRPC) выбирает код ответа
+var (
+	ErrEmptyURL         = errors.New("please provide a url to shorten")
+	ErrEmptyBatch       = errors.New("please provide a list of urls to shorten")
+	ErrInvalidShortID   = errors.New("invalid short url")
+	ErrURLNotFound      = errors.New("url not found")
+	ErrURLDeleted       = errors.New("url is deleted")
+	ErrJobNotFound      = errors.New("job not found")
+	ErrImportNotFound   = errors.New("import not found")
+	ErrQueueUnavailable = errors.New("unable to enqueue job")
+)
+
+// Service содержит бизнес-логику сервиса, не зависящую от транспорта
+type Service struct {
+	App *app.App
+}
+
+func New(theApp *app.App) *Service {
+	return &Service{App: theApp}
+}
+
+// ShortenResult - результат сокращения ссылки. Created ложно, если ссылка была сокращена ранее
+type ShortenResult struct {
+	ShortURL string
+	Created  bool
+}
+
+// BatchItem - ссылка для пакетного сокращения с идентификатором, по которому клиент сопоставит результат
+type BatchItem struct {
+	CorrelationID string
+	OriginalURL   string
+}
+
+type BatchResult struct {
+	CorrelationID string
+	ShortURL      string
+}
+
+type UserURL struct {
+	ShortURL    string
+	OriginalURL string
+}
+
+// ShortURL строит короткую ссылку по идентификатору, используя настройки базового URL сервиса
+func (s *Service) ShortURL(shortID string) string {
+	baseURL := s.App.Config.BaseURL
+	shortURL := &url.URL{
+		Scheme: baseURL.Scheme,
+		Host:   baseURL.Host,
+		Path:   strings.TrimRight(baseURL.Path, "/") + "/" + shortID,
+	}
+	return shortURL.String()
+}
+
+// Shorten сокращает ссылку от имени пользователя.
+// Если ссылка уже была сокращена, возвращает ранее выданную короткую ссылку
+func (s *Service) Shorten(ctx context.Context, userID, longURL string) (ShortenResult, error) {
+	if longURL == "" {
+		return ShortenResult{}, ErrEmptyURL
+	}
+	created := true
+	proposedShortID := s.App.Tracing.Shorten(ctx, s.App.Shortener, longURL)
+	// При попытке сохранить уже сокращенный урл можем получить конфликт
+	// и актуальный для этой ссылки короткий идентификатор
+	actualShortID, err := s.App.Storage.Set(ctx, proposedShortID, longURL, userID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrURLAlreadyExists) {
+			return ShortenResult{}, err
+		}
+		created = false
+	}
+	s.App.Metrics.ObserveShorten(created)
+	return ShortenResult{ShortURL: s.ShortURL(actualShortID), Created: created}, nil
+}
+
+// ShortenBatch сокращает пачку ссылок за одно обращение к хранилищу.
+// Пустые ссылки пропускаются; если не осталось ни одной, возвращает ErrEmptyBatch
+func (s *Service) ShortenBatch(ctx context.Context, userID string, items []BatchItem) ([]BatchResult, error) {
+	batchItems := make([]storage.BatchItem, 0, len(items))
+	correlationIDs := make(map[string]string)
+	for _, item := range items {
+		if item.OriginalURL == "" {
+			continue
+		}
+		shortID := s.App.Tracing.Shorten(ctx, s.App.Shortener, item.OriginalURL)
+		batchItems = append(batchItems, storage.BatchItem{ShortID: shortID, LongURL: item.OriginalURL, UserID: userID})
+		// запоминаем соответствие correlation и сокращаемой ссылки для последующего ответа
+		correlationIDs[item.CorrelationID] = item.OriginalURL
+	}
+	if len(batchItems) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	savedItems, err := s.App.Storage.SaveBatch(ctx, batchItems)
+	if err != nil {
+		return nil, err
+	}
+	// ссылка сокращена заново, если сохранена под предложенным нами идентификатором
+	for _, item := range batchItems {
+		s.App.Metrics.ObserveShorten(savedItems[item.LongURL] == item.ShortID)
+	}
+	results := make([]BatchResult, 0, len(correlationIDs))
+	for correlationID, originalURL := range correlationIDs {
+		results = append(results, BatchResult{
+			CorrelationID: correlationID,
+			ShortURL:      s.ShortURL(savedItems[originalURL]),
+		})
+	}
+	return results, nil
+}
+
+// Expand возвращает оригинальную ссылку по идентификатору короткой
+func (s *Service) Expand(ctx context.Context, shortID string) (string, error) {
+	if shortID == "" {
+		return "", ErrInvalidShortID
+	}
+	longURL, err := s.App.Storage.Get(ctx, shortID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrURLNotFound):
+			return "", ErrURLNotFound
+		case errors.Is(err, storage.ErrURLIsDeleted):
+			return "", ErrURLDeleted
+		default:
+			return "", err
+		}
+	}
+	s.App.Metrics.Redirects.Inc()
+	return longURL, nil
+}
+
+// UserURLs возвращает все действующие ссылки, сокращенные пользователем
+func (s *Service) UserURLs(ctx context.Context, userID string) ([]UserURL, error) {
+	items, err := s.App.Storage.GetURLsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]UserURL, 0, len(items))
+	for shortID, longURL := range items {
+		urls = append(urls, UserURL{ShortURL: s.ShortURL(shortID), OriginalURL: longURL})
+	}
+	return urls, nil
+}
+
+// ExportUserURLs поточно передает в fn все ссылки пользователя, включая удаленные
+func (s *Service) ExportUserURLs(ctx context.Context, userID string, fn func(exports.Item) error) error {
+	return s.App.Storage.IterateUserURLs(ctx, userID, func(record storage.URLRecord) error {
+		return fn(exports.Item{
+			ShortURL:    s.ShortURL(record.ShortID),
+			OriginalURL: record.LongURL,
+			CreatedAt:   record.CreatedAt,
+			IsDeleted:   record.IsDeleted,
+		})
+	})
+}
+
+// DeleteUserURLs ставит в очередь фоновое удаление ссылок пользователя и возвращает идентификатор джоба
+func (s *Service) DeleteUserURLs(ctx context.Context, userID string, shortIDs []string) (string, error) {
+	job, err := jobs.DeleteUserURLs(s.App.Deleter, s.App.RetryPolicy, userID, shortIDs...)
+	if err != nil {
+		return "", err
+	}
+	if err := s.App.Jobs.Add(ctx, job); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrQueueUnavailable, err)
+	}
+	return job.ID, nil
+}
+
+// JobStatus возвращает статус джоба, поставленного в очередь пользователем либо в рабочем пространстве,
+// в котором он состоит. Чужие джобы для пользователя не существуют
+func (s *Service) JobStatus(ctx context.Context, userID, jobID string) (background.JobStatus, error) {
+	status, err := s.App.Jobs.Status(ctx, jobID)
+	if err != nil {
+		if errors.Is(err, background.ErrJobNotFound) {
+			return background.JobStatus{}, ErrJobNotFound
+		}
+		return background.JobStatus{}, err
+	}
+	if status.Owner != userID && !s.isWorkspaceMember(ctx, status.Owner, userID) {
+		return background.JobStatus{}, ErrJobNotFound
+	}
+	return status, nil
+}
+
+// ImportURLs запускает фоновый импорт ссылок из файла и возвращает его начальный прогресс.
+// Ссылки достаются ownerID - пользователю либо рабочему пространству, см. URLOwner.
+// Сервис становится владельцем файла: он будет удален по завершении импорта, либо сразу в случае ошибки
+func (s *Service) ImportURLs(
+	ctx context.Context, ownerID string, format imports.Format, filename string,
+) (imports.Progress, error) {
+	importer := imports.Importer{
+		Storage:   s.App.Storage,
+		Shortener: s.App.Shortener,
+		ChunkSize: s.App.Config.ImportChunkSize,
+	}
+	job, imp := jobs.ImportURLs(importer, s.App.Imports, ownerID, format, filename, s.App.Config.ImportJobTimeout)
+	// импорт читает локальный временный файл, поэтому выполняется пулом текущего процесса
+	if err := s.App.LocalJobs.Add(ctx, job); err != nil {
+		// задача не попала в очередь - файл больше никому не нужен
+		imp.Fail(err)
+		os.Remove(filename) // nolint:errcheck
+		return imports.Progress{}, fmt.Errorf("%w: %s", ErrQueueUnavailable, err)
+	}
+	return imp.Progress(), nil
+}
+
+// ImportProgress возвращает прогресс импорта, запущенного пользователем либо в его рабочем пространстве
+func (s *Service) ImportProgress(ctx context.Context, userID, importID string) (imports.Progress, error) {
+	imp, found := s.App.Imports.Get(importID)
+	if !found {
+		return imports.Progress{}, ErrImportNotFound
+	}
+	progress := imp.Progress()
+	if progress.UserID != userID && !s.isWorkspaceMember(ctx, progress.UserID, userID) {
+		return imports.Progress{}, ErrImportNotFound
+	}
+	return progress, nil
+}
+
+// Ping проверяет доступность хранилища
+func (s *Service) Ping(ctx context.Context) error {
+	return s.App.Storage.Ping(ctx)
+}
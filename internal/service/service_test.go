@@ -0,0 +1,170 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/internal/exports"
+	"github.com/sergeii/practikum-go-url-shortener/internal/imports"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T) (*service.Service, *app.App) {
+	shortener, err := app.New(func(cfg *app.Config) error {
+		cfg.FileStoragePath = ""
+		cfg.DatabaseDSN = ""
+		return nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(shortener.Close)
+	return service.New(shortener), shortener
+}
+
+func TestShortenReportsDuplicates(t *testing.T) {
+	svc, _ := newTestService(t)
+	first, err := svc.Shorten(context.TODO(), "user1", "https://go.dev/")
+	require.NoError(t, err)
+	assert.True(t, first.Created)
+	assert.Regexp(t, `^http://localhost:8080/[\w-]+$`, first.ShortURL)
+
+	second, err := svc.Shorten(context.TODO(), "user2", "https://go.dev/")
+	require.NoError(t, err)
+	assert.False(t, second.Created)
+	assert.Equal(t, first.ShortURL, second.ShortURL)
+
+	_, err = svc.Shorten(context.TODO(), "user1", "")
+	assert.ErrorIs(t, err, service.ErrEmptyURL)
+}
+
+func TestShortURLRespectsBaseURLPath(t *testing.T) {
+	svc, shortener := newTestService(t)
+	shortener.Config.BaseURL.Path = "/go/"
+	assert.Equal(t, "http://localhost:8080/go/foo", svc.ShortURL("foo"))
+}
+
+func TestShortenBatchMatchesCorrelationIDs(t *testing.T) {
+	svc, _ := newTestService(t)
+	existing, err := svc.Shorten(context.TODO(), "user1", "https://go.dev/")
+	require.NoError(t, err)
+
+	results, err := svc.ShortenBatch(context.TODO(), "user1", []service.BatchItem{
+		{CorrelationID: "go", OriginalURL: "https://go.dev/"},
+		{CorrelationID: "ya", OriginalURL: "https://yandex.ru/"},
+		{CorrelationID: "empty", OriginalURL: ""},
+	})
+	require.NoError(t, err)
+	byCorrelation := make(map[string]string)
+	for _, result := range results {
+		byCorrelation[result.CorrelationID] = result.ShortURL
+	}
+	assert.Len(t, byCorrelation, 2)
+	assert.Equal(t, existing.ShortURL, byCorrelation["go"])
+	assert.NotEmpty(t, byCorrelation["ya"])
+
+	_, err = svc.ShortenBatch(context.TODO(), "user1", []service.BatchItem{{CorrelationID: "empty"}})
+	assert.ErrorIs(t, err, service.ErrEmptyBatch)
+}
+
+func TestExpand(t *testing.T) {
+	svc, shortener := newTestService(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1")    // nolint:errcheck
+	shortener.Storage.Set(context.TODO(), "ya", "https://yandex.ru/", "user1") // nolint:errcheck
+	shortener.Storage.DeleteUserURLs(context.TODO(), "user1", "ya")            // nolint:errcheck
+
+	longURL, err := svc.Expand(context.TODO(), "go")
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev/", longURL)
+
+	tests := []struct {
+		shortID string
+		want    error
+	}{
+		{"ya", service.ErrURLDeleted},
+		{"unknown", service.ErrURLNotFound},
+		{"", service.ErrInvalidShortID},
+	}
+	for _, tt := range tests {
+		_, err := svc.Expand(context.TODO(), tt.shortID)
+		assert.ErrorIs(t, err, tt.want, tt.shortID)
+	}
+}
+
+func TestUserURLsAndExport(t *testing.T) {
+	svc, shortener := newTestService(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1")          // nolint:errcheck
+	shortener.Storage.Set(context.TODO(), "ya", "https://yandex.ru/", "user1")       // nolint:errcheck
+	shortener.Storage.Set(context.TODO(), "wiki", "https://wikipedia.org/", "user2") // nolint:errcheck
+	shortener.Storage.DeleteUserURLs(context.TODO(), "user1", "ya")                  // nolint:errcheck
+
+	urls, err := svc.UserURLs(context.TODO(), "user1")
+	require.NoError(t, err)
+	assert.Equal(t, []service.UserURL{{ShortURL: "http://localhost:8080/go", OriginalURL: "https://go.dev/"}}, urls)
+
+	// выгрузка включает удаленные ссылки
+	var exported []exports.Item
+	err = svc.ExportUserURLs(context.TODO(), "user1", func(item exports.Item) error {
+		exported = append(exported, item)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+	for _, item := range exported {
+		assert.Equal(t, item.ShortURL == "http://localhost:8080/ya", item.IsDeleted, item.ShortURL)
+	}
+}
+
+func TestDeleteUserURLsJobIsVisibleToOwnerOnly(t *testing.T) {
+	svc, shortener := newTestService(t)
+	shortener.Storage.Set(context.TODO(), "go", "https://go.dev/", "user1") // nolint:errcheck
+
+	jobID, err := svc.DeleteUserURLs(context.TODO(), "user1", []string{"go"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		status, err := svc.JobStatus(context.TODO(), "user1", jobID)
+		return err == nil && status.State == background.JobSucceeded
+	}, time.Second, time.Millisecond*10)
+
+	_, err = svc.JobStatus(context.TODO(), "user2", jobID)
+	assert.ErrorIs(t, err, service.ErrJobNotFound)
+	_, err = svc.JobStatus(context.TODO(), "user1", "unknown")
+	assert.ErrorIs(t, err, service.ErrJobNotFound)
+	_, err = svc.Expand(context.TODO(), "go")
+	assert.ErrorIs(t, err, service.ErrURLDeleted)
+}
+
+func TestDeleteUserURLsReportsFullQueue(t *testing.T) {
+	svc, shortener := newTestService(t)
+	// закрытая очередь не принимает новые джобы
+	shortener.Jobs.Shutdown(context.TODO()) // nolint:errcheck
+	_, err := svc.DeleteUserURLs(context.TODO(), "user1", []string{"go"})
+	assert.ErrorIs(t, err, service.ErrQueueUnavailable)
+}
+
+func TestImportURLs(t *testing.T) {
+	svc, shortener := newTestService(t)
+	f, err := os.CreateTemp(t.TempDir(), "*")
+	require.NoError(t, err)
+	f.WriteString(`{"original_url":"https://go.dev/"}` + "\n") // nolint:errcheck
+	f.Close()
+
+	progress, err := svc.ImportURLs(context.TODO(), "user1", imports.FormatNDJSON, f.Name())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		progress, err := svc.ImportProgress(context.TODO(), "user1", progress.ID)
+		return err == nil && progress.Status == imports.StatusSucceeded
+	}, time.Second, time.Millisecond*10)
+
+	_, err = svc.ImportProgress(context.TODO(), "user2", progress.ID)
+	assert.ErrorIs(t, err, service.ErrImportNotFound)
+	urls, err := shortener.Storage.GetURLsByUserID(context.TODO(), "user1")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	// временный файл удален по завершении импорта
+	assert.NoFileExists(t, f.Name())
+}
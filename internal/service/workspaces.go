@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+)
+
+// Роли участников рабочего пространства: просматривать ссылки, также сокращать и удалять их,
+// также управлять участниками
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = storage.OwnerRole
+)
+
+// WorkspaceIDPrefix отличает идентификаторы рабочих пространств, которые владеют ссылками наравне с пользователями,
+// от идентификаторов пользователей
+const WorkspaceIDPrefix = "ws-"
+
+const MaxWorkspaceNameLength = 100
+
+var (
+	ErrWorkspaceNotFound  = errors.New("workspace not found")
+	ErrWorkspaceForbidden = errors.New("insufficient role in workspace")
+	ErrInvalidWorkspace   = errors.New("please provide a workspace name of up to 100 characters")
+	ErrInvalidRole        = errors.New("please provide a role: owner, editor, viewer")
+	ErrInvalidMember      = errors.New("please provide a user id")
+	ErrMemberNotFound     = errors.New("workspace member not found")
+	ErrUnknownMember      = errors.New("only registered users can be added to a workspace")
+	ErrLastOwner          = errors.New("workspace must have at least one owner")
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// Workspace - рабочее пространство и роль в нем текущего пользователя
+type Workspace struct {
+	ID        string
+	Name      string
+	Role      string
+	CreatedAt time.Time
+}
+
+type WorkspaceMember struct {
+	UserID  string
+	Role    string
+	AddedAt time.Time
+}
+
+// CreateWorkspace создает рабочее пространство, владельцем которого становится пользователь
+func (s *Service) CreateWorkspace(ctx context.Context, userID, name string) (Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > MaxWorkspaceNameLength {
+		return Workspace{}, ErrInvalidWorkspace
+	}
+	randomID := make([]byte, 8)
+	if _, err := rand.Read(randomID); err != nil {
+		return Workspace{}, err
+	}
+	now := time.Now()
+	record := storage.WorkspaceRecord{
+		ID:        WorkspaceIDPrefix + hex.EncodeToString(randomID),
+		Name:      name,
+		CreatedAt: now,
+	}
+	owner := storage.MemberRecord{WorkspaceID: record.ID, UserID: userID, Role: RoleOwner, CreatedAt: now}
+	if err := s.App.Workspaces.CreateWorkspace(ctx, record, owner); err != nil {
+		return Workspace{}, err
+	}
+	return Workspace{ID: record.ID, Name: record.Name, Role: RoleOwner, CreatedAt: record.CreatedAt}, nil
+}
+
+// UserWorkspaces возвращает рабочие пространства, в которых состоит пользователь
+func (s *Service) UserWorkspaces(ctx context.Context, userID string) ([]Workspace, error) {
+	memberships, err := s.App.Workspaces.GetUserMemberships(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	workspaces := make([]Workspace, 0, len(memberships))
+	for _, m := range memberships {
+		workspaces = append(workspaces, Workspace{
+			ID:        m.Workspace.ID,
+			Name:      m.Workspace.Name,
+			Role:      m.Role,
+			CreatedAt: m.Workspace.CreatedAt,
+		})
+	}
+	return workspaces, nil
+}
+
+// WorkspaceMembers возвращает участников рабочего пространства. Доступно любому участнику
+func (s *Service) WorkspaceMembers(ctx context.Context, userID, workspaceID string) ([]WorkspaceMember, error) {
+	if err := s.authorizeWorkspace(ctx, userID, workspaceID, RoleViewer); err != nil {
+		return nil, err
+	}
+	records, err := s.App.Workspaces.GetMembers(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]WorkspaceMember, 0, len(records))
+	for _, record := range records {
+		members = append(members, WorkspaceMember{UserID: record.UserID, Role: record.Role, AddedAt: record.CreatedAt})
+	}
+	return members, nil
+}
+
+// SetWorkspaceMember добавляет пользователя memberID в рабочее пространство либо меняет его роль.
+// Добавить можно только зарегистрированного пользователя. Доступно только владельцам
+func (s *Service) SetWorkspaceMember(ctx context.Context, userID, workspaceID, memberID, role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return ErrInvalidRole
+	}
+	if memberID == "" || strings.HasPrefix(memberID, WorkspaceIDPrefix) {
+		return ErrInvalidMember
+	}
+	if err := s.authorizeWorkspace(ctx, userID, workspaceID, RoleOwner); err != nil {
+		return err
+	}
+	if err := s.ensureMemberExists(ctx, workspaceID, memberID); err != nil {
+		return err
+	}
+	// последнего владельца хранилище не даст лишить роли, даже при одновременных изменениях
+	err := s.App.Workspaces.SaveMember(ctx, storage.MemberRecord{
+		WorkspaceID: workspaceID,
+		UserID:      memberID,
+		Role:        role,
+		CreatedAt:   time.Now(),
+	})
+	switch {
+	case errors.Is(err, storage.ErrWorkspaceNotFound):
+		return ErrWorkspaceNotFound
+	case errors.Is(err, storage.ErrLastOwner):
+		return ErrLastOwner
+	}
+	return err
+}
+
+// RemoveWorkspaceMember исключает пользователя memberID из рабочего пространства.
+// Исключать других участников могут только владельцы, покинуть пространство может любой участник
+func (s *Service) RemoveWorkspaceMember(ctx context.Context, userID, workspaceID, memberID string) error {
+	need := RoleOwner
+	if memberID == userID {
+		need = RoleViewer
+	}
+	if err := s.authorizeWorkspace(ctx, userID, workspaceID, need); err != nil {
+		return err
+	}
+	err := s.App.Workspaces.DeleteMember(ctx, workspaceID, memberID)
+	switch {
+	case errors.Is(err, storage.ErrMemberNotFound):
+		return ErrMemberNotFound
+	case errors.Is(err, storage.ErrLastOwner):
+		return ErrLastOwner
+	}
+	return err
+}
+
+// URLOwner возвращает владельца ссылок, с которыми работает пользователь: рабочее пространство workspaceID,
+// если у пользователя в нем есть роль не ниже role, либо самого пользователя, если пространство не указано
+func (s *Service) URLOwner(ctx context.Context, userID, workspaceID, role string) (string, error) {
+	if workspaceID == "" {
+		return userID, nil
+	}
+	if err := s.authorizeWorkspace(ctx, userID, workspaceID, role); err != nil {
+		return "", err
+	}
+	return workspaceID, nil
+}
+
+// ensureMemberExists не дает добавить в рабочее пространство несуществующего пользователя, например с опечаткой
+// в идентификаторе. Роль участников, уже состоящих в пространстве, можно менять без проверки
+func (s *Service) ensureMemberExists(ctx context.Context, workspaceID, memberID string) error {
+	_, err := s.App.Workspaces.GetMember(ctx, workspaceID, memberID)
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrMemberNotFound) {
+		return err
+	}
+	if _, err := s.App.Users.GetUser(ctx, memberID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return ErrUnknownMember
+		}
+		return err
+	}
+	return nil
+}
+
+// authorizeWorkspace проверяет, что у пользователя есть роль не ниже need.
+// Для не-участников рабочее пространство не существует
+func (s *Service) authorizeWorkspace(ctx context.Context, userID, workspaceID, need string) error {
+	member, err := s.App.Workspaces.GetMember(ctx, workspaceID, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return ErrWorkspaceNotFound
+		}
+		return err
+	}
+	if roleRanks[member.Role] < roleRanks[need] {
+		return ErrWorkspaceForbidden
+	}
+	return nil
+}
+
+// isWorkspaceMember сообщает, что ownerID - рабочее пространство, в котором состоит пользователь
+func (s *Service) isWorkspaceMember(ctx context.Context, ownerID, userID string) bool {
+	if !strings.HasPrefix(ownerID, WorkspaceIDPrefix) {
+		return false
+	}
+	return s.authorizeWorkspace(ctx, userID, ownerID, RoleViewer) == nil
+}
@@ -0,0 +1,140 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/internal/service"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerUsers регистрирует учетные записи, которые можно добавлять в рабочие пространства
+func registerUsers(t *testing.T, shortener *app.App, userIDs ...string) {
+	for _, userID := range userIDs {
+		record := storage.UserRecord{ID: userID, Email: userID + "@go.dev"}
+		require.NoError(t, shortener.Users.SaveUser(context.TODO(), record))
+	}
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	svc, shortener := newTestService(t)
+	registerUsers(t, shortener, "editor1", "viewer1", "editor2")
+	ctx := context.TODO()
+	workspace, err := svc.CreateWorkspace(ctx, "owner1", " Marketing ")
+	require.NoError(t, err)
+	assert.Equal(t, "Marketing", workspace.Name)
+	assert.Equal(t, service.RoleOwner, workspace.Role)
+	assert.True(t, strings.HasPrefix(workspace.ID, service.WorkspaceIDPrefix))
+
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "editor1", service.RoleEditor))
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "viewer1", service.RoleViewer))
+
+	tests := []struct {
+		userID string
+		role   string
+		want   error
+	}{
+		{"owner1", service.RoleOwner, nil},
+		{"editor1", service.RoleEditor, nil},
+		{"editor1", service.RoleOwner, service.ErrWorkspaceForbidden},
+		{"viewer1", service.RoleViewer, nil},
+		{"viewer1", service.RoleEditor, service.ErrWorkspaceForbidden},
+		{"stranger", service.RoleViewer, service.ErrWorkspaceNotFound},
+	}
+	for _, tt := range tests {
+		owner, err := svc.URLOwner(ctx, tt.userID, workspace.ID, tt.role)
+		if tt.want != nil {
+			assert.ErrorIs(t, err, tt.want, tt.userID+" as "+tt.role)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, workspace.ID, owner)
+	}
+	// без рабочего пространства пользователь работает со своими ссылками
+	owner, err := svc.URLOwner(ctx, "stranger", "", service.RoleOwner)
+	require.NoError(t, err)
+	assert.Equal(t, "stranger", owner)
+
+	// управлять участниками могут только владельцы
+	err = svc.SetWorkspaceMember(ctx, "editor1", workspace.ID, "editor2", service.RoleEditor)
+	assert.ErrorIs(t, err, service.ErrWorkspaceForbidden)
+	err = svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "editor2", "admin")
+	assert.ErrorIs(t, err, service.ErrInvalidRole)
+	err = svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, workspace.ID, service.RoleEditor)
+	assert.ErrorIs(t, err, service.ErrInvalidMember)
+	// незарегистрированного пользователя, например с опечаткой в идентификаторе, добавить нельзя
+	err = svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "edtior2", service.RoleEditor)
+	assert.ErrorIs(t, err, service.ErrUnknownMember)
+	members, err := svc.WorkspaceMembers(ctx, "owner1", workspace.ID)
+	require.NoError(t, err)
+	assert.Len(t, members, 3)
+
+	workspaces, err := svc.UserWorkspaces(ctx, "viewer1")
+	require.NoError(t, err)
+	require.Len(t, workspaces, 1)
+	assert.Equal(t, service.RoleViewer, workspaces[0].Role)
+}
+
+func TestWorkspaceKeepsAnOwner(t *testing.T) {
+	svc, shortener := newTestService(t)
+	registerUsers(t, shortener, "owner2", "viewer1", "viewer2")
+	ctx := context.TODO()
+	workspace, err := svc.CreateWorkspace(ctx, "owner1", "Marketing")
+	require.NoError(t, err)
+
+	err = svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "owner1", service.RoleEditor)
+	assert.ErrorIs(t, err, service.ErrLastOwner)
+	err = svc.RemoveWorkspaceMember(ctx, "owner1", workspace.ID, "owner1")
+	assert.ErrorIs(t, err, service.ErrLastOwner)
+
+	// с появлением второго владельца первый может уйти
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "owner2", service.RoleOwner))
+	require.NoError(t, svc.RemoveWorkspaceMember(ctx, "owner1", workspace.ID, "owner1"))
+	_, err = svc.WorkspaceMembers(ctx, "owner1", workspace.ID)
+	assert.ErrorIs(t, err, service.ErrWorkspaceNotFound)
+
+	// участник может покинуть пространство сам, но не исключить другого
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner2", workspace.ID, "viewer1", service.RoleViewer))
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner2", workspace.ID, "viewer2", service.RoleViewer))
+	err = svc.RemoveWorkspaceMember(ctx, "viewer1", workspace.ID, "viewer2")
+	assert.ErrorIs(t, err, service.ErrWorkspaceForbidden)
+	require.NoError(t, svc.RemoveWorkspaceMember(ctx, "viewer1", workspace.ID, "viewer1"))
+	err = svc.RemoveWorkspaceMember(ctx, "owner2", workspace.ID, "viewer1")
+	assert.ErrorIs(t, err, service.ErrMemberNotFound)
+
+	members, err := svc.WorkspaceMembers(ctx, "viewer2", workspace.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "owner2", members[0].UserID)
+	assert.Equal(t, "viewer2", members[1].UserID)
+}
+
+func TestWorkspaceNameValidation(t *testing.T) {
+	svc, _ := newTestService(t)
+	for _, name := range []string{"", "   ", strings.Repeat("я", service.MaxWorkspaceNameLength+1)} {
+		_, err := svc.CreateWorkspace(context.TODO(), "owner1", name)
+		assert.ErrorIs(t, err, service.ErrInvalidWorkspace)
+	}
+	_, err := svc.CreateWorkspace(context.TODO(), "owner1", strings.Repeat("я", service.MaxWorkspaceNameLength))
+	assert.NoError(t, err)
+}
+
+func TestWorkspaceJobsAreVisibleToMembers(t *testing.T) {
+	svc, shortener := newTestService(t)
+	registerUsers(t, shortener, "viewer1")
+	ctx := context.TODO()
+	workspace, err := svc.CreateWorkspace(ctx, "owner1", "Marketing")
+	require.NoError(t, err)
+	require.NoError(t, svc.SetWorkspaceMember(ctx, "owner1", workspace.ID, "viewer1", service.RoleViewer))
+
+	jobID, err := svc.DeleteUserURLs(ctx, workspace.ID, []string{"go"})
+	require.NoError(t, err)
+	_, err = svc.JobStatus(ctx, "viewer1", jobID)
+	assert.NoError(t, err)
+	_, err = svc.JobStatus(ctx, "stranger", jobID)
+	assert.ErrorIs(t, err, service.ErrJobNotFound)
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStorage оборачивает каждую операцию хранилища в дочерний span
+type tracedStorage struct {
+	storage.URLStorer
+	backend string
+	tracer  trace.Tracer
+}
+
+// InstrumentStorage оборачивает хранилище, создавая span на каждую его операцию
+func (t *Tracing) InstrumentStorage(store storage.URLStorer, backend string) storage.URLStorer {
+	return &tracedStorage{URLStorer: store, backend: backend, tracer: t.tracer}
+}
+
+func (s *tracedStorage) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return s.tracer.Start(
+		ctx, "URLStorer."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.backend", s.backend)),
+	)
+}
+
+func end(span trace.Span, err error) {
+	// ожидаемые ошибки бизнес-логики не считаем сбоями хранилища
+	if err != nil && !errors.Is(err, storage.ErrURLAlreadyExists) &&
+		!errors.Is(err, storage.ErrURLNotFound) && !errors.Is(err, storage.ErrURLIsDeleted) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStorage) Set(ctx context.Context, shortID, longURL, userID string) (string, error) {
+	ctx, span := s.start(ctx, "Set")
+	result, err := s.URLStorer.Set(ctx, shortID, longURL, userID)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) Get(ctx context.Context, shortID string) (string, error) {
+	ctx, span := s.start(ctx, "Get")
+	result, err := s.URLStorer.Get(ctx, shortID)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) GetURLsByUserID(ctx context.Context, userID string) (map[string]string, error) {
+	ctx, span := s.start(ctx, "GetURLsByUserID")
+	result, err := s.URLStorer.GetURLsByUserID(ctx, userID)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) IterateUserURLs(ctx context.Context, userID string, fn storage.URLRecordFunc) error {
+	ctx, span := s.start(ctx, "IterateUserURLs")
+	err := s.URLStorer.IterateUserURLs(ctx, userID, fn)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) (int, error) {
+	ctx, span := s.start(ctx, "DeleteUserURLs")
+	result, err := s.URLStorer.DeleteUserURLs(ctx, userID, shortIDs...)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) DeleteURLsBatch(
+	ctx context.Context, items []storage.DeleteItem,
+) ([]storage.DeleteItem, error) {
+	ctx, span := s.start(ctx, "DeleteURLsBatch")
+	span.SetAttributes(attribute.Int("storage.batch_size", len(items)))
+	result, err := s.URLStorer.DeleteURLsBatch(ctx, items)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) TransferUserURLs(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	ctx, span := s.start(ctx, "TransferUserURLs")
+	result, err := s.URLStorer.TransferUserURLs(ctx, fromUserID, toUserID)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) SaveBatch(ctx context.Context, items []storage.BatchItem) (map[string]string, error) {
+	ctx, span := s.start(ctx, "SaveBatch")
+	span.SetAttributes(attribute.Int("storage.batch_size", len(items)))
+	result, err := s.URLStorer.SaveBatch(ctx, items)
+	end(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping")
+	err := s.URLStorer.Ping(ctx)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/url/shortener"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterMemory = "memory" // спаны сохраняются в памяти процесса; используется в тестах
+)
+
+const serviceName = "shortener"
+const instrumentationName = "github.com/sergeii/practikum-go-url-shortener"
+
+// unmatchedRoute - имя маршрута для запросов, не попавших ни в один из маршрутов роутера
+const unmatchedRoute = "unmatched"
+
+var ErrUnknownExporter = errors.New("unknown tracing exporter")
+
+type Config struct {
+	Exporter    string
+	Endpoint    string // адрес OTLP коллектора в формате host:port
+	Insecure    bool   // отправлять спаны в коллектор без TLS
+	SampleRatio float64
+}
+
+// Tracing создает спаны запросов, обращений к хранилищу и фоновых джобов
+// и передает их настроенному экспортеру
+type Tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	memory     *tracetest.InMemoryExporter
+	// exporting - настроен ли экспортер, которому нужно отправить спаны при остановке
+	exporting    bool
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+func New(ctx context.Context, cfg Config) (*Tracing, error) {
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	t := &Tracing{
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		opts = append(opts, sdktrace.WithSampler(sdktrace.NeverSample()))
+	case ExporterOTLP:
+		clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		t.exporting = true
+		opts = append(
+			opts,
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		)
+	case ExporterMemory:
+		t.memory = tracetest.NewInMemoryExporter()
+		t.exporting = true
+		opts = append(opts, sdktrace.WithSyncer(t.memory), sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	default:
+		return nil, ErrUnknownExporter
+	}
+	t.provider = sdktrace.NewTracerProvider(opts...)
+	t.tracer = t.provider.Tracer(instrumentationName)
+	return t, nil
+}
+
+func (t *Tracing) Tracer() trace.Tracer {
+	return t.tracer
+}
+
+// Spans возвращает завершенные спаны, сохраненные экспортером memory
+func (t *Tracing) Spans() tracetest.SpanStubs {
+	if t.memory == nil {
+		return nil
+	}
+	return t.memory.GetSpans()
+}
+
+// Shutdown отправляет накопленные спаны и останавливает экспортер.
+// Без экспортера ничего не делает; повторные вызовы возвращают результат первого
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if !t.exporting {
+		return nil
+	}
+	t.shutdownOnce.Do(func() {
+		t.shutdownErr = t.provider.Shutdown(ctx)
+	})
+	return t.shutdownErr
+}
+
+// Middleware начинает span на каждый запрос, продолжая трассу из заголовков traceparent/tracestate.
+// Имя спана содержит шаблон маршрута chi, а идентификатор трассы добавляется в логгер запроса
+func (t *Tracing) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(
+			ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.Path)),
+		)
+		defer span.End()
+		if sc := span.SpanContext(); sc.IsSampled() {
+			logging.AddFields(ctx, logrus.Fields{"trace_id": sc.TraceID().String()})
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRouteKey.String(route), semconv.HTTPStatusCodeKey.Int(status))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	})
+}
+
+// Shorten генерирует короткий идентификатор ссылки в рамках отдельного спана
+func (t *Tracing) Shorten(ctx context.Context, s shortener.Shortener, longURL string) string {
+	_, span := t.tracer.Start(ctx, "Shortener.Shorten")
+	defer span.End()
+	return s.Shorten(longURL)
+}
+
+// InjectJob реализует background.JobTracer.
+// Метаданные копируются, чтобы не изменять карту, разделяемую с другими копиями джоба
+func (t *Tracing) InjectJob(ctx context.Context, job *background.Job) {
+	carrier := propagation.MapCarrier{}
+	for key, value := range job.Metadata {
+		carrier[key] = value
+	}
+	t.propagator.Inject(ctx, carrier)
+	job.Metadata = carrier
+}
+
+// StartJob реализует background.JobTracer
+func (t *Tracing) StartJob(ctx context.Context, job background.Job) (context.Context, func(error)) {
+	ctx = t.propagator.Extract(ctx, propagation.MapCarrier(job.Metadata))
+	ctx, span := t.tracer.Start(
+		ctx, "job "+job.Name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("job.id", job.ID),
+			attribute.String("job.queue", job.Queue),
+			attribute.Int("job.attempt", job.Attempt),
+		),
+	)
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
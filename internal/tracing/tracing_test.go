@@ -0,0 +1,164 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sergeii/practikum-go-url-shortener/internal/tracing"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/url/shortener"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracing(t *testing.T) *tracing.Tracing {
+	tr, err := tracing.New(context.TODO(), tracing.Config{Exporter: tracing.ExporterMemory})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		tr.Shutdown(context.TODO()) // nolint:errcheck
+	})
+	return tr
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func TestUnknownExporter(t *testing.T) {
+	_, err := tracing.New(context.TODO(), tracing.Config{Exporter: "zipkin"})
+	assert.ErrorIs(t, err, tracing.ErrUnknownExporter)
+}
+
+func TestNoneExporterDoesNotRecordSpans(t *testing.T) {
+	tr, err := tracing.New(context.TODO(), tracing.Config{Exporter: tracing.ExporterNone})
+	require.NoError(t, err)
+	_, span := tr.Tracer().Start(context.TODO(), "test")
+	span.End()
+	assert.False(t, span.IsRecording())
+	assert.Len(t, tr.Spans(), 0)
+}
+
+func TestShutdownIsIdempotent(t *testing.T) {
+	for _, exporter := range []string{tracing.ExporterNone, tracing.ExporterMemory} {
+		t.Run(exporter, func(t *testing.T) {
+			tr, err := tracing.New(context.TODO(), tracing.Config{Exporter: exporter})
+			require.NoError(t, err)
+			assert.NoError(t, tr.Shutdown(context.TODO()))
+			assert.NoError(t, tr.Shutdown(context.TODO()))
+		})
+	}
+}
+
+func TestMiddlewareNamesSpanAfterRoutePattern(t *testing.T) {
+	tr := newTestTracing(t)
+	store := tr.InstrumentStorage(storage.NewLocmemURLStorerBackend(), "memory")
+	router := chi.NewRouter()
+	router.Use(tr.Middleware)
+	router.Get("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		shortID := tr.Shorten(r.Context(), shortener.NewShaShortener(), "https://go.dev/")
+		store.Get(r.Context(), shortID) // nolint:errcheck
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	// запрос продолжает трассу вызывающего сервиса
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := tr.Spans()
+	require.Len(t, spans, 3)
+	server, ok := findSpan(spans, "GET /{slug}")
+	require.True(t, ok)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Contains(t, server.Attributes, semconv.HTTPRouteKey.String("/{slug}"))
+	assert.Contains(t, server.Attributes, semconv.HTTPStatusCodeKey.Int(404))
+	// клиентские ошибки не являются ошибками сервера
+	assert.Equal(t, codes.Unset, server.Status.Code)
+
+	for _, name := range []string{"Shortener.Shorten", "URLStorer.Get"} {
+		child, ok := findSpan(spans, name)
+		require.True(t, ok, name)
+		assert.Equal(t, server.SpanContext.TraceID(), child.SpanContext.TraceID())
+		assert.Equal(t, server.SpanContext.SpanID(), child.Parent.SpanID())
+	}
+	// отсутствие ссылки не считается сбоем хранилища
+	get, _ := findSpan(spans, "URLStorer.Get")
+	assert.Equal(t, codes.Unset, get.Status.Code)
+}
+
+func TestJobContinuesTraceOfEnqueuingRequest(t *testing.T) {
+	tr := newTestTracing(t)
+	store := tr.InstrumentStorage(storage.NewLocmemURLStorerBackend(), "memory")
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		HistorySize:   10,
+		Tracer:        tr,
+	})
+	defer pool.Close()
+
+	ctx, requestSpan := tr.Tracer().Start(context.TODO(), "request")
+	job := background.NewJob("delete user URLs", func(ctx context.Context) error {
+		_, err := store.DeleteUserURLs(ctx, "user1", "foo")
+		return err
+	})
+	require.NoError(t, pool.Add(ctx, job))
+	requestSpan.End()
+	require.Eventually(t, func() bool {
+		_, ok := findSpan(tr.Spans(), "job delete user URLs")
+		return ok
+	}, time.Second, time.Millisecond*10)
+
+	spans := tr.Spans()
+	jobSpan, _ := findSpan(spans, "job delete user URLs")
+	assert.Equal(t, trace.SpanKindConsumer, jobSpan.SpanKind)
+	assert.Equal(t, requestSpan.SpanContext().TraceID(), jobSpan.SpanContext.TraceID())
+	assert.Equal(t, requestSpan.SpanContext().SpanID(), jobSpan.Parent.SpanID())
+	deleteSpan, ok := findSpan(spans, "URLStorer.DeleteUserURLs")
+	require.True(t, ok)
+	assert.Equal(t, jobSpan.SpanContext.SpanID(), deleteSpan.Parent.SpanID())
+}
+
+func TestInjectJobDoesNotShareMetadata(t *testing.T) {
+	tr := newTestTracing(t)
+	job := background.NewJob("test", func(context.Context) error { return nil })
+	job.Metadata = map[string]string{"foo": "bar"}
+	original := job.Metadata
+
+	ctx, span := tr.Tracer().Start(context.TODO(), "request")
+	defer span.End()
+	tr.InjectJob(ctx, &job)
+	assert.Equal(t, "bar", job.Metadata["foo"])
+	assert.NotEmpty(t, job.Metadata["traceparent"])
+	assert.NotContains(t, original, "traceparent")
+}
+
+func TestStartJobRecordsError(t *testing.T) {
+	tr := newTestTracing(t)
+	job := background.NewJob("test", func(context.Context) error { return nil })
+	_, end := tr.StartJob(context.TODO(), job)
+	end(storage.ErrURLNotFound)
+
+	spans := tr.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "exception", spans[0].Events[0].Name)
+}
@@ -2,149 +2,478 @@ package background
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
 )
 
 var ErrAddJobTimeout = errors.New("failed to add new job in time")
+var ErrShuttingDown = errors.New("job queue is shutting down")
 
 const queueBufferMultiplier = 2
+const defaultHistorySize = 1000
 
 type PoolConfig struct {
-	Concurrency   int
-	DoJobTimeout  time.Duration
-	AddJobTimeout time.Duration
+	Concurrency    int // количество общих воркеров, обслуживающих все очереди пула в порядке их приоритета
+	DoJobTimeout   time.Duration
+	AddJobTimeout  time.Duration
+	HistorySize    int // количество последних джобов, статус которых хранится в пуле
+	DeadLetterSize int // количество последних окончательно упавших джобов, доступных для повтора
+	MaxZombies     int // при стольких зомби-джобах пул перестает принимать новые джобы; 0 - без ограничения
+	Queues         []QueueConfig
+	Observer       JobObserver
+	Tracer         JobTracer
+	Logger         logrus.FieldLogger // по умолчанию - логгер сервиса по умолчанию
+}
+
+// JobObserver получает сведения о каждой попытке выполнения джоба, например для сбора метрик
+type JobObserver interface {
+	ObserveJob(job Job, duration time.Duration, err error)
+}
+
+// JobTracer связывает выполнение джоба с трассой запроса, поставившего его в очередь
+type JobTracer interface {
+	// InjectJob сохраняет контекст трассировки в метаданные ставящегося в очередь джоба
+	InjectJob(ctx context.Context, job *Job)
+	// StartJob начинает span попытки выполнения джоба, продолжая трассу из его метаданных.
+	// Возвращаемая функция завершает span с результатом попытки
+	StartJob(ctx context.Context, job Job) (context.Context, func(err error))
 }
 
 type JobFunc func(context.Context) error
 
+// ValueJobFunc - функция джоба, возвращающая помимо ошибки результат выполнения,
+// который затем доступен в статусе джоба
+type ValueJobFunc func(context.Context) (interface{}, error)
+
 type Job struct {
-	ID   string
-	Name string
-	do   JobFunc
+	ID      string
+	Name    string
+	Owner   string
+	Attempt int    // номер текущей попытки выполнения, начиная с 1
+	Queue   string // имя очереди пула, в которой выполняется джоб
+	// Kind и Payload заданы у джобов, созданных NewDurableJob
+	Kind    string
+	Payload json.RawMessage
+	// Metadata переносит вместе с джобом сквозные данные, например контекст трассировки
+	Metadata map[string]string
+	do       ValueJobFunc
+	timeout  time.Duration
+	retry    RetryPolicy
 }
 
 type JobResult struct {
-	Job Job
-	Err error
+	Job   Job
+	Value interface{}
+	Err   error
 }
 
 func NewJob(name string, jobFunc JobFunc) Job {
+	return NewValueJob(name, func(ctx context.Context) (interface{}, error) {
+		return nil, jobFunc(ctx)
+	})
+}
+
+func NewValueJob(name string, jobFunc ValueJobFunc) Job {
 	return Job{
-		ID:   uuid.New().String(),
-		Name: name,
-		do:   jobFunc,
+		ID:      uuid.New().String(),
+		Name:    name,
+		Attempt: 1,
+		do:      jobFunc,
 	}
 }
 
+// WithOwner возвращает копию джоба с указанием владельца, например пользователя, поставившего его в очередь
+func (job Job) WithOwner(owner string) Job {
+	job.Owner = owner
+	return job
+}
+
+// WithQueue возвращает копию джоба, выполняемого в указанной очереди пула
+func (job Job) WithQueue(queue string) Job {
+	job.Queue = queue
+	return job
+}
+
+// WithTimeout возвращает копию джоба с собственным ограничением времени выполнения,
+// которое используется воркером вместо общего таймаута пула
+func (job Job) WithTimeout(timeout time.Duration) Job {
+	job.timeout = timeout
+	return job
+}
+
+// WithRetry возвращает копию джоба, который в случае ошибки будет повторно выполнен согласно политике
+func (job Job) WithRetry(policy RetryPolicy) Job {
+	job.retry = policy
+	return job
+}
+
 func (job Job) Do(ctx context.Context) JobResult {
-	maybeErr := job.do(ctx)
-	return JobResult{Job: job, Err: maybeErr}
+	value, maybeErr := job.do(ctx)
+	return JobResult{Job: job, Value: value, Err: maybeErr}
 }
 
 type Worker struct {
 	JobTimeout time.Duration
+	Observer   JobObserver
+	Tracer     JobTracer
+	Logger     logrus.FieldLogger
+	zombies    *zombies
+}
+
+func (worker Worker) Work(ctx context.Context, job Job) (result JobResult) {
+	startedAt := time.Now()
+	if worker.Tracer != nil {
+		var end func(error)
+		ctx, end = worker.Tracer.StartJob(ctx, job)
+		defer func() { end(result.Err) }()
+	}
+	result = worker.work(ctx, job)
+	if worker.Observer != nil {
+		worker.Observer.ObserveJob(job, time.Since(startedAt), result.Err)
+	}
+	return result
 }
 
-func (worker Worker) Work(ctx context.Context, job Job) JobResult {
-	ctx, cancel := context.WithTimeout(ctx, worker.JobTimeout)
+func (worker Worker) work(ctx context.Context, job Job) JobResult {
+	timeout := worker.JobTimeout
+	if job.timeout > 0 {
+		timeout = job.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	// хотя мы и передаем контекст с таймайуом мы не можем гарантировать
-	// что джоб вовремя остановится, поэтому запускаем ее в горутине и сами отслеживаем время выполнения
-	resultCh := make(chan JobResult)
+	// что джоб вовремя остановится, поэтому запускаем ее в горутине и сами отслеживаем время выполнения.
+	// Канал буферизован, чтобы горутина опоздавшего джоба не зависла навечно на записи результата.
+	// Результат отправляется под той же блокировкой, под которой джоб помечается завершенным,
+	// поэтому джоб, успевший завершиться к истечению таймаута, не считается зомби
+	resultCh := make(chan JobResult, 1)
+	var mu sync.Mutex
+	exited, abandoned := false, false
+	startedAt := time.Now()
+	logger := jobLogger(worker.Logger, job)
 	go func() {
-		log.Printf("starting job %s [%s]", job.Name, job.ID)
-		resultCh <- job.Do(ctx)
+		logger.Debug("starting job")
+		result := job.Do(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		exited = true
+		resultCh <- result
+		if abandoned {
+			logger.WithField("duration", time.Since(startedAt).String()).Warn("zombie job exited")
+			if worker.zombies != nil {
+				worker.zombies.remove(job.ID)
+			}
+		}
 	}()
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("deadline exceeded for job %s [%s]", job.Name, job.ID)
-			return JobResult{Job: job, Err: ctx.Err()}
-		case result := <-resultCh:
-			log.Printf("finished job %s [%s]; err? %v", job.Name, job.ID, result.Err)
-			return result
+	select {
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		// джоб мог завершиться одновременно с истечением таймаута
+		if exited {
+			return <-resultCh
 		}
+		abandoned = true
+		logger.Warn("deadline exceeded for job; it is still running as a zombie")
+		if worker.zombies != nil {
+			worker.zombies.add(ZombieJob{ID: job.ID, Name: job.Name, StartedAt: startedAt, AbandonedAt: time.Now()})
+		}
+		return JobResult{Job: job, Err: ctx.Err()}
+	case result := <-resultCh:
+		logger.Debug("finished job")
+		return result
 	}
 }
 
 type Pool struct {
-	queue chan Job
-	cfg   PoolConfig
-	done  chan struct{}
+	queues       []*namedQueue // упорядочены по убыванию приоритета
+	queuesByName map[string]*namedQueue
+	cfg          PoolConfig
+	done         chan struct{}
+	closeOnce    sync.Once
+	history      *history
+	deadLetters  *deadLetters
+	zombies      *zombies
+	// pending - количество принятых пулом, но еще не завершенных джобов, включая ожидающие повтора.
+	// После начала остановки пул перестает принимать джобы, а drained закрывается, как только pending достигнет нуля
+	mu        sync.Mutex
+	pending   int
+	stopping  bool
+	drained   chan struct{}
+	drainOnce sync.Once
 }
 
 func NewPool(cfg PoolConfig) *Pool {
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = defaultHistorySize
+	}
+	if cfg.DeadLetterSize <= 0 {
+		cfg.DeadLetterSize = defaultDeadLetterSize
+	}
+	cfg.Logger = defaultLogger(cfg.Logger)
 	done := make(chan struct{})
-	queue := make(chan Job, cfg.Concurrency*queueBufferMultiplier)
-	results := make(chan JobResult, cfg.Concurrency*queueBufferMultiplier)
+	queues, queuesByName := newQueues(cfg)
+	workers := cfg.Concurrency
+	for _, queue := range queues {
+		workers += queue.Concurrency
+	}
+	results := make(chan JobResult, workers*queueBufferMultiplier)
 	pool := Pool{
-		done:  done,
-		cfg:   cfg,
-		queue: queue,
+		done:         done,
+		cfg:          cfg,
+		queues:       queues,
+		queuesByName: queuesByName,
+		history:      newHistory(cfg.HistorySize),
+		deadLetters:  newDeadLetters(cfg.DeadLetterSize),
+		drained:      make(chan struct{}),
+		zombies:      newZombies(),
 	}
 
 	// инициализируем воркеров и управляем каналами в отдельной горутине
 	go func() {
 		wg := &sync.WaitGroup{}
 		ctx, cancel := context.WithCancel(context.Background())
+		for _, queue := range queues {
+			for i := 0; i < queue.Concurrency; i++ {
+				wg.Add(1)
+				go pool.addWorker(ctx, wg, dedicatedPicker(queue), results)
+			}
+		}
 		for i := 0; i < cfg.Concurrency; i++ {
 			wg.Add(1)
-			go pool.addWorker(ctx, wg, queue, results)
+			go pool.addWorker(ctx, wg, sharedPicker(queues), results)
 		}
 		<-done
 		cancel()
 		wg.Wait()
-		close(queue)
+		// очередь не закрываем, поскольку в нее еще могут писать отложенные повторы джобов
 		close(results)
 	}()
 
-	// читаем из канала с результами исполнения джобов и пишем их статус в лог
+	// читаем из канала с результами исполнения джобов, обновляем их статус и пишем его в лог
 	go func() {
 		for result := range results {
-			if result.Err != nil {
-				log.Printf("job %s [%s] returned an error: %s", result.Job.Name, result.Job.ID, result.Err)
-			} else {
-				log.Printf("job %s [%s] succeeded", result.Job.Name, result.Job.ID)
-			}
+			pool.handleResult(result)
 		}
 	}()
 
 	return &pool
 }
 
+// handleResult обрабатывает результат выполнения джоба: упавший джоб либо повторяется
+// согласно его политике, либо, исчерпав все попытки, попадает в список мертвых джобов
+func (pool *Pool) handleResult(result JobResult) {
+	job := result.Job
+	logger := pool.jobLogger(job)
+	if result.Err == nil {
+		pool.history.finish(result)
+		logger.Info("job succeeded")
+		pool.release()
+		return
+	}
+	if job.retry.shouldRetry(job.Attempt, result.Err) {
+		delay := job.retry.Delay(job.Attempt)
+		logger.WithError(result.Err).WithField("retry_in", delay.String()).Warn("job failed; will retry")
+		pool.history.retry(result, time.Now().Add(delay))
+		job.Attempt++
+		pool.schedule(job, delay)
+		return
+	}
+	logger.WithError(result.Err).Error("job failed; no attempts left")
+	pool.history.finish(result)
+	pool.deadLetters.push(DeadJob{Job: job, Err: result.Err, FailedAt: time.Now()})
+	pool.release()
+}
+
+// schedule возвращает джоб в очередь по прошествии задержки, если пул к тому моменту не закрыт
+func (pool *Pool) schedule(job Job, delay time.Duration) {
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-pool.done:
+			return
+		}
+		select {
+		case pool.queueFor(job).jobs <- job:
+			pool.jobLogger(job).Debug("re-enqueued job")
+		case <-pool.done:
+		}
+	}()
+}
+
+// Add ставит джоб в очередь. После начала остановки пула возвращает ErrShuttingDown,
+// а при превышении лимита зомби-джобов - ErrTooManyZombies
 func (pool *Pool) Add(ctx context.Context, job Job) error {
+	logger := pool.jobLogger(job)
+	if pool.zombies.tooMany(pool.cfg.MaxZombies) {
+		logger.WithField("zombies", pool.zombies.count()).Warn("refused to add job due to zombie jobs")
+		return ErrTooManyZombies
+	}
+	if err := pool.acquire(); err != nil {
+		logger.WithError(err).Warn("refused to add job")
+		return err
+	}
+	if pool.cfg.Tracer != nil {
+		pool.cfg.Tracer.InjectJob(ctx, &job)
+	}
 	ctx, cancel := context.WithTimeout(ctx, pool.cfg.AddJobTimeout)
 	defer cancel()
+	// регистрируем джоб до его попадания в очередь, иначе воркер может взять его раньше
+	pool.history.add(job)
 	select {
 	case <-ctx.Done():
-		log.Printf("failed to add job %s [%s] due to blocked queue", job.Name, job.ID)
+		logger.Warn("failed to add job due to blocked queue")
+		pool.history.remove(job.ID)
+		pool.release()
 		return ErrAddJobTimeout
-	case pool.queue <- job:
-		log.Printf("enqueued job %s [%s]", job.Name, job.ID)
+	case pool.queueFor(job).jobs <- job:
+		logger.Debug("enqueued job")
 		return nil
 	}
 }
 
+// Status возвращает статус джоба по его идентификатору
+// Статус доступен для последних HistorySize джобов, добавленных в пул
+func (pool *Pool) Status(ctx context.Context, jobID string) (JobStatus, error) {
+	status, found := pool.history.get(jobID)
+	if !found {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return status, nil
+}
+
+// DeadJobs возвращает последние DeadLetterSize джобов, исчерпавших все попытки выполнения
+func (pool *Pool) DeadJobs(ctx context.Context) ([]DeadJob, error) {
+	return pool.deadLetters.list(), nil
+}
+
+// Replay повторно ставит в очередь мертвый джоб, начиная отсчет попыток заново
+// В случае неизвестного джоба возвращает ErrJobNotFound
+func (pool *Pool) Replay(ctx context.Context, jobID string) error {
+	dead, found := pool.deadLetters.pop(jobID)
+	if !found {
+		return ErrJobNotFound
+	}
+	job := dead.Job
+	job.Attempt = 1
+	if err := pool.Add(ctx, job); err != nil {
+		// не удалось поставить в очередь - возвращаем джоб в список, чтобы его можно было повторить позже
+		pool.deadLetters.push(dead)
+		return err
+	}
+	return nil
+}
+
+// Zombies возвращает джобы, продолжающие выполняться после истечения таймаута,
+// а также общее количество джобов, когда-либо ставших зомби
+func (pool *Pool) Zombies() ([]ZombieJob, int) {
+	return pool.zombies.list()
+}
+
+// Shutdown корректно останавливает пул: новые джобы больше не принимаются,
+// а уже принятые (в том числе ожидающие повтора) выполняются до истечения контекста.
+// Возвращает количество джобов, которые так и не были выполнены к моменту истечения контекста
+func (pool *Pool) Shutdown(ctx context.Context) (int, error) {
+	pool.mu.Lock()
+	pool.stopping = true
+	if pool.pending == 0 {
+		pool.drainOnce.Do(func() { close(pool.drained) })
+	}
+	pool.mu.Unlock()
+
+	var err error
+	select {
+	case <-pool.drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	pool.mu.Lock()
+	abandoned := pool.pending
+	pool.mu.Unlock()
+	// прерываем выполнение оставшихся джобов
+	pool.Close()
+	return abandoned, err
+}
+
+// Close немедленно останавливает пул, прерывая выполняемые джобы
+// Джобы, оставшиеся в очереди, не будут выполнены
 func (pool *Pool) Close() {
-	close(pool.done)
+	pool.mu.Lock()
+	pool.stopping = true
+	pool.mu.Unlock()
+	pool.closeOnce.Do(func() { close(pool.done) })
+}
+
+// acquire учитывает новый джоб, если пул еще принимает джобы
+func (pool *Pool) acquire() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.stopping {
+		return ErrShuttingDown
+	}
+	pool.pending++
+	return nil
+}
+
+// release отмечает завершение джоба, принятого пулом
+func (pool *Pool) release() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.pending--
+	if pool.stopping && pool.pending == 0 {
+		pool.drainOnce.Do(func() { close(pool.drained) })
+	}
 }
 
-func (pool *Pool) addWorker(ctx context.Context, wg *sync.WaitGroup, queue <-chan Job, results chan<- JobResult) {
-	worker := Worker{JobTimeout: pool.cfg.DoJobTimeout}
+func (pool *Pool) addWorker(
+	ctx context.Context, wg *sync.WaitGroup, next func(context.Context) (Job, bool), results chan<- JobResult,
+) {
+	worker := Worker{
+		JobTimeout: pool.cfg.DoJobTimeout,
+		Observer:   pool.cfg.Observer,
+		Tracer:     pool.cfg.Tracer,
+		Logger:     pool.cfg.Logger,
+		zombies:    pool.zombies,
+	}
 	defer wg.Done()
 	for {
-		select {
-		case job := <-queue:
-			log.Printf("obtained new job %s [%s] from queue", job.Name, job.ID)
-			results <- worker.Work(ctx, job)
-		case <-ctx.Done():
-			log.Printf("worker exited due to canceled context")
+		job, ok := next(ctx)
+		if !ok {
+			pool.cfg.Logger.Debug("worker exited due to canceled context")
 			return
 		}
+		pool.jobLogger(job).Debug("obtained new job")
+		pool.history.start(job)
+		results <- worker.Work(ctx, job)
+	}
+}
+
+// jobLogger дополняет логгер пула полями джоба и его очереди
+func (pool *Pool) jobLogger(job Job) logrus.FieldLogger {
+	return jobLogger(pool.cfg.Logger, job).WithField("queue", pool.queueFor(job).Name)
+}
+
+// jobLogger дополняет логгер полями джоба
+func jobLogger(logger logrus.FieldLogger, job Job) logrus.FieldLogger {
+	return defaultLogger(logger).WithFields(logrus.Fields{
+		"job_id":   job.ID,
+		"job_name": job.Name,
+		"attempt":  job.Attempt,
+	})
+}
+
+// defaultLogger возвращает logger, а если он не задан - логгер сервиса по умолчанию
+func defaultLogger(logger logrus.FieldLogger) logrus.FieldLogger {
+	if logger == nil {
+		return logging.FromContext(context.Background())
 	}
+	return logger
 }
@@ -2,11 +2,17 @@ package background_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBackgroundJobProcessing(t *testing.T) {
@@ -27,9 +33,11 @@ func TestBackgroundJobProcessing(t *testing.T) {
 	}
 
 	go func() {
-		<-time.After(time.Millisecond * 50)
+		// канал закрывается только после завершения всех джобов, чтобы они не писали в закрытый канал
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		pool.Shutdown(ctx) // nolint:errcheck
 		close(ch)
-		pool.Close()
 	}()
 
 	sum := 0
@@ -40,3 +48,499 @@ func TestBackgroundJobProcessing(t *testing.T) {
 	assert.Len(t, numbers, 100)
 	assert.Equal(t, 5050, sum)
 }
+
+func TestBackgroundJobStatusIsTracked(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Millisecond * 500,
+		AddJobTimeout: time.Second,
+	})
+	defer pool.Close()
+
+	release := make(chan struct{})
+	blocking := background.NewJob("blocking", func(context.Context) error {
+		<-release
+		return nil
+	}).WithOwner("user1")
+	valued := background.NewValueJob("valued", func(context.Context) (interface{}, error) {
+		return 42, nil
+	})
+	failing := background.NewJob("failing", func(context.Context) error {
+		return errors.New("oops")
+	})
+	for _, job := range []background.Job{blocking, valued, failing} {
+		require.NoError(t, pool.Add(context.TODO(), job))
+	}
+
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), blocking.ID)
+		return status.State == background.JobRunning
+	}, time.Second, time.Millisecond*5)
+	status, err := pool.Status(context.TODO(), valued.ID)
+	require.NoError(t, err)
+	assert.Equal(t, background.JobQueued, status.State)
+	assert.Equal(t, "valued", status.Name)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), failing.ID)
+		return status.State == background.JobFailed
+	}, time.Second, time.Millisecond*5)
+
+	status, _ = pool.Status(context.TODO(), blocking.ID)
+	assert.Equal(t, background.JobSucceeded, status.State)
+	assert.Equal(t, "user1", status.Owner)
+	assert.False(t, status.FinishedAt.Before(status.StartedAt))
+	status, _ = pool.Status(context.TODO(), valued.ID)
+	assert.Equal(t, background.JobSucceeded, status.State)
+	assert.Equal(t, 42, status.Value)
+	status, _ = pool.Status(context.TODO(), failing.ID)
+	assert.EqualError(t, status.Err, "oops")
+
+	_, err = pool.Status(context.TODO(), "unknown")
+	assert.ErrorIs(t, err, background.ErrJobNotFound)
+}
+
+func TestBackgroundJobHistoryIsBounded(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Millisecond * 500,
+		AddJobTimeout: time.Second,
+		HistorySize:   2,
+	})
+	defer pool.Close()
+	jobs := make([]background.Job, 0, 3)
+	for i := 0; i < 3; i++ {
+		job := background.NewJob("test", func(context.Context) error { return nil })
+		require.NoError(t, pool.Add(context.TODO(), job))
+		jobs = append(jobs, job)
+	}
+	_, err := pool.Status(context.TODO(), jobs[0].ID)
+	assert.ErrorIs(t, err, background.ErrJobNotFound)
+	_, err = pool.Status(context.TODO(), jobs[1].ID)
+	assert.NoError(t, err)
+	_, err = pool.Status(context.TODO(), jobs[2].ID)
+	assert.NoError(t, err)
+}
+
+func TestBackgroundJobIsNotTrackedWhenQueueIsFull(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   0,
+		DoJobTimeout:  time.Millisecond * 500,
+		AddJobTimeout: time.Millisecond * 10,
+	})
+	defer pool.Close()
+	job := background.NewJob("test", func(context.Context) error { return nil })
+	assert.ErrorIs(t, pool.Add(context.TODO(), job), background.ErrAddJobTimeout)
+	_, err := pool.Status(context.TODO(), job.ID)
+	assert.ErrorIs(t, err, background.ErrJobNotFound)
+}
+
+func TestPoolImplementsQueue(t *testing.T) {
+	var queue background.Queue = background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Millisecond * 500,
+		AddJobTimeout: time.Second,
+	})
+	defer queue.Close()
+	handler := background.Handler{
+		Kind: "double",
+		Name: "double",
+		Func: func(_ context.Context, payload json.RawMessage) (interface{}, error) {
+			var x int
+			if err := json.Unmarshal(payload, &x); err != nil {
+				return nil, err
+			}
+			return x * 2, nil
+		},
+	}
+	job, err := background.NewDurableJob(handler, 21)
+	require.NoError(t, err)
+	require.NoError(t, queue.Add(context.TODO(), job))
+	require.Eventually(t, func() bool {
+		status, _ := queue.Status(context.TODO(), job.ID)
+		return status.State == background.JobSucceeded
+	}, time.Second, time.Millisecond*5)
+	status, _ := queue.Status(context.TODO(), job.ID)
+	assert.Equal(t, 42, status.Value)
+}
+
+func TestRetryPolicyDelayGrowsExponentially(t *testing.T) {
+	policy := background.RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     time.Millisecond * 100,
+		MaxBackoff:  time.Second,
+	}
+	assert.Equal(t, time.Millisecond*100, policy.Delay(1))
+	assert.Equal(t, time.Millisecond*200, policy.Delay(2))
+	assert.Equal(t, time.Millisecond*400, policy.Delay(3))
+	assert.Equal(t, time.Millisecond*800, policy.Delay(4))
+	assert.Equal(t, time.Second, policy.Delay(5))
+	assert.Equal(t, time.Second, policy.Delay(9))
+
+	policy.Multiplier = 3
+	assert.Equal(t, time.Millisecond*900, policy.Delay(3))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(2)
+		assert.GreaterOrEqual(t, delay, time.Millisecond*150)
+		assert.LessOrEqual(t, delay, time.Millisecond*450)
+	}
+}
+
+func deadJobs(t *testing.T, pool *background.Pool) []background.DeadJob {
+	items, err := pool.DeadJobs(context.TODO())
+	assert.NoError(t, err)
+	return items
+}
+
+func TestBackgroundJobIsRetriedUntilSuccess(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   2,
+		DoJobTimeout:  time.Millisecond * 500,
+		AddJobTimeout: time.Second,
+	})
+	defer pool.Close()
+
+	var mu sync.Mutex
+	calls := 0
+	job := background.NewJob("flaky", func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	}).WithRetry(background.RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond * 10})
+	require.NoError(t, pool.Add(context.TODO(), job))
+
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), job.ID)
+		return status.State == background.JobSucceeded
+	}, time.Second, time.Millisecond*5)
+	status, _ := pool.Status(context.TODO(), job.ID)
+	assert.Equal(t, 3, status.Attempts)
+	assert.NoError(t, status.Err)
+	assert.Len(t, deadJobs(t, pool), 0)
+}
+
+func TestBackgroundJobIsDeadAfterExhaustedRetries(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Millisecond * 500,
+		AddJobTimeout: time.Second,
+	})
+	defer pool.Close()
+
+	errPermanent := errors.New("permanent")
+	errTransient := errors.New("transient")
+	policy := background.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond * 10,
+		Retryable: func(err error) bool {
+			return errors.Is(err, errTransient)
+		},
+	}
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	shouldFail := true
+	newJob := func(name string, err error) background.Job {
+		return background.NewJob(name, func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[name]++
+			if shouldFail {
+				return err
+			}
+			return nil
+		}).WithRetry(policy)
+	}
+	exhausted := newJob("exhausted", errTransient)
+	permanent := newJob("permanent", errPermanent)
+	require.NoError(t, pool.Add(context.TODO(), exhausted))
+	require.NoError(t, pool.Add(context.TODO(), permanent))
+
+	require.Eventually(t, func() bool {
+		return len(deadJobs(t, pool)) == 2
+	}, time.Second, time.Millisecond*5)
+
+	mu.Lock()
+	assert.Equal(t, 3, attempts["exhausted"])
+	assert.Equal(t, 1, attempts["permanent"])
+	shouldFail = false
+	mu.Unlock()
+
+	deadIDs := make([]string, 0, 2)
+	for _, dead := range deadJobs(t, pool) {
+		deadIDs = append(deadIDs, dead.Job.ID)
+		assert.Error(t, dead.Err)
+		assert.False(t, dead.FailedAt.IsZero())
+	}
+	assert.ElementsMatch(t, []string{exhausted.ID, permanent.ID}, deadIDs)
+	status, _ := pool.Status(context.TODO(), exhausted.ID)
+	assert.Equal(t, background.JobFailed, status.State)
+	assert.Equal(t, 3, status.Attempts)
+	assert.ErrorIs(t, status.Err, errTransient)
+
+	// мертвый джоб можно повторить, и он начнет отсчет попыток заново
+	require.NoError(t, pool.Replay(context.TODO(), exhausted.ID))
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), exhausted.ID)
+		return status.State == background.JobSucceeded
+	}, time.Second, time.Millisecond*5)
+	status, _ = pool.Status(context.TODO(), exhausted.ID)
+	assert.Equal(t, 1, status.Attempts)
+	require.Len(t, deadJobs(t, pool), 1)
+	assert.Equal(t, permanent.ID, deadJobs(t, pool)[0].Job.ID)
+
+	assert.ErrorIs(t, pool.Replay(context.TODO(), exhausted.ID), background.ErrJobNotFound)
+}
+
+func TestPoolShutdownDrainsQueuedJobs(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+	})
+	var mu sync.Mutex
+	done := 0
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		job := background.NewJob("slow", func(context.Context) error {
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			return nil
+		})
+		require.NoError(t, pool.Add(context.TODO(), job))
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		close(release)
+	}()
+	abandoned, err := pool.Shutdown(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 0, abandoned)
+	mu.Lock()
+	assert.Equal(t, 2, done)
+	mu.Unlock()
+
+	job := background.NewJob("late", func(context.Context) error { return nil })
+	assert.ErrorIs(t, pool.Add(context.TODO(), job), background.ErrShuttingDown)
+	_, err = pool.Status(context.TODO(), job.ID)
+	assert.ErrorIs(t, err, background.ErrJobNotFound)
+}
+
+func TestPoolShutdownReportsAbandonedJobs(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second * 5,
+		AddJobTimeout: time.Second,
+	})
+	release := make(chan struct{})
+	defer close(release)
+	for i := 0; i < 2; i++ {
+		job := background.NewJob("stuck", func(ctx context.Context) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return ctx.Err()
+		})
+		require.NoError(t, pool.Add(context.TODO(), job))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	abandoned, err := pool.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, abandoned)
+	// повторная остановка безопасна
+	pool.Close()
+}
+
+func TestPoolDedicatedQueueIsNotStarvedByOtherJobs(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		Queues: []background.QueueConfig{
+			{Name: "slow", Concurrency: 0, Size: 10},
+			{Name: "fast", Concurrency: 1},
+		},
+	})
+	defer pool.Close()
+	release := make(chan struct{})
+	defer close(release)
+	// общий воркер занят долгими джобами
+	for i := 0; i < 3; i++ {
+		job := background.NewJob("slow", func(context.Context) error {
+			<-release
+			return nil
+		}).WithQueue("slow")
+		require.NoError(t, pool.Add(context.TODO(), job))
+	}
+	fast := background.NewJob("fast", func(context.Context) error { return nil }).WithQueue("fast")
+	require.NoError(t, pool.Add(context.TODO(), fast))
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), fast.ID)
+		return status.State == background.JobSucceeded
+	}, time.Second, time.Millisecond*5)
+	assert.Equal(t, map[string]int{"slow": 2, "fast": 0, background.DefaultQueue: 0}, pool.QueueDepths())
+}
+
+func TestPoolSharedWorkersPreferHigherPriorityQueues(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		Queues: []background.QueueConfig{
+			{Name: "low", Priority: 0, Size: 10},
+			{Name: "high", Priority: 10, Size: 10},
+		},
+	})
+	defer pool.Close()
+
+	release := make(chan struct{})
+	blocking := background.NewJob("blocking", func(context.Context) error {
+		<-release
+		return nil
+	})
+	require.NoError(t, pool.Add(context.TODO(), blocking))
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), blocking.ID)
+		return status.State == background.JobRunning
+	}, time.Second, time.Millisecond*5)
+
+	var mu sync.Mutex
+	order := make([]string, 0)
+	newJob := func(name, queue string) background.Job {
+		return background.NewJob(name, func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}).WithQueue(queue)
+	}
+	require.NoError(t, pool.Add(context.TODO(), newJob("low1", "low")))
+	require.NoError(t, pool.Add(context.TODO(), newJob("low2", "low")))
+	require.NoError(t, pool.Add(context.TODO(), newJob("high1", "high")))
+	// джоб неизвестной очереди выполняется в очереди по умолчанию
+	require.NoError(t, pool.Add(context.TODO(), newJob("unknown", "unknown")))
+	assert.Equal(t, map[string]int{"low": 2, "high": 1, background.DefaultQueue: 1}, pool.QueueDepths())
+
+	close(release)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 4
+	}, time.Second, time.Millisecond*5)
+	assert.Equal(t, "high1", order[0])
+	assert.ElementsMatch(t, []string{"high1", "low1", "low2", "unknown"}, order)
+}
+
+func TestTimedOutJobIsTrackedAsZombieUntilItExits(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Millisecond * 20,
+		AddJobTimeout: time.Millisecond * 50,
+		MaxZombies:    1,
+	})
+	defer pool.Close()
+	release := make(chan struct{})
+	// джоб игнорирует отмену контекста
+	stubborn := background.NewJob("stubborn", func(context.Context) error {
+		<-release
+		return nil
+	})
+	require.NoError(t, pool.Add(context.TODO(), stubborn))
+	require.Eventually(t, func() bool {
+		status, _ := pool.Status(context.TODO(), stubborn.ID)
+		return status.State == background.JobFailed
+	}, time.Second, time.Millisecond*5)
+	status, _ := pool.Status(context.TODO(), stubborn.ID)
+	assert.ErrorIs(t, status.Err, context.DeadlineExceeded)
+
+	zombies, total := pool.Zombies()
+	require.Len(t, zombies, 1)
+	assert.Equal(t, stubborn.ID, zombies[0].ID)
+	assert.Equal(t, 1, total)
+
+	// пока зомби выполняется, пул не принимает новые джобы
+	job := background.NewJob("test", func(context.Context) error { return nil })
+	assert.ErrorIs(t, pool.Add(context.TODO(), job), background.ErrTooManyZombies)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		zombies, _ := pool.Zombies()
+		return len(zombies) == 0
+	}, time.Second, time.Millisecond*5)
+	_, total = pool.Zombies()
+	assert.Equal(t, 1, total)
+	assert.NoError(t, pool.Add(context.TODO(), job))
+}
+
+func TestWorkerReturnsResultOfCooperativeJob(t *testing.T) {
+	worker := background.Worker{JobTimeout: time.Millisecond * 10}
+	job := background.NewJob("cooperative", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	result := worker.Work(context.TODO(), job)
+	assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+}
+
+func TestPoolReportsSaturationWhenQueueIsFull(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   0,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Millisecond * 10,
+		HistorySize:   10,
+		Queues:        []background.QueueConfig{{Name: "deletes", Size: 2}, {Name: "default", Size: 5}},
+	})
+	defer pool.Close()
+	assert.NoError(t, pool.CheckSaturation())
+
+	for i := 0; i < 2; i++ {
+		job := background.NewJob("test", func(context.Context) error { return nil }).WithQueue("deletes")
+		require.NoError(t, pool.Add(context.TODO(), job))
+	}
+	err := pool.CheckSaturation()
+	assert.ErrorIs(t, err, background.ErrQueueSaturated)
+	assert.Contains(t, err.Error(), "deletes")
+}
+
+func TestPoolLogsJobFailuresWithLevelAndFields(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		Logger:        logger,
+	})
+	defer pool.Close()
+
+	job := background.NewJob("flaky", func(context.Context) error {
+		return errors.New("oops")
+	}).WithRetry(background.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+	require.NoError(t, pool.Add(context.TODO(), job))
+	require.Eventually(t, func() bool {
+		return len(deadJobs(t, pool)) == 1
+	}, time.Second, time.Millisecond*5)
+
+	levels := make(map[string]logrus.Level)
+	for _, entry := range hook.AllEntries() {
+		if entry.Level > logrus.InfoLevel {
+			continue
+		}
+		assert.Equal(t, job.ID, entry.Data["job_id"], entry.Message)
+		assert.Equal(t, "default", entry.Data["queue"], entry.Message)
+		levels[entry.Message] = entry.Level
+	}
+	assert.Equal(t, map[string]logrus.Level{
+		"job failed; will retry":       logrus.WarnLevel,
+		"job failed; no attempts left": logrus.ErrorLevel,
+	}, levels)
+}
@@ -0,0 +1,469 @@
+package background
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPollInterval = time.Second
+
+// DurableQueueName - имя, под которым долговременная очередь фигурирует в логах и метриках
+const DurableQueueName = "durable"
+
+// leaseMargin добавляется к таймауту джоба при его захвате воркером.
+// Если воркер не отчитался о джобе до окончания аренды (например, реплика упала),
+// джоб снова становится доступен для выполнения
+const leaseMargin = time.Second * 30
+
+const initJobsSQL = `
+CREATE TABLE IF NOT EXISTS jobs (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    name TEXT NOT NULL,
+    owner TEXT NOT NULL DEFAULT '',
+    payload JSONB NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INT NOT NULL DEFAULT 0,
+    result JSONB,
+    last_error TEXT,
+    run_at timestamptz NOT NULL DEFAULT NOW(),
+    locked_until timestamptz,
+    enqueued_at timestamptz NOT NULL DEFAULT NOW(),
+    started_at timestamptz,
+    finished_at timestamptz
+);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS jobs_pending_idx ON jobs (run_at) WHERE status IN ('queued', 'retrying', 'running');
+`
+
+type DurableQueueConfig struct {
+	Concurrency   int
+	DoJobTimeout  time.Duration
+	AddJobTimeout time.Duration
+	PollInterval  time.Duration // как часто свободный воркер проверяет наличие новых джобов
+	MaxZombies    int           // при стольких зомби-джобах реплика перестает захватывать новые джобы; 0 - без ограничения
+	Observer      JobObserver
+	Tracer        JobTracer
+	Logger        logrus.FieldLogger // по умолчанию - логгер сервиса по умолчанию
+}
+
+// DurableQueue - очередь фоновых задач, хранящаяся в таблице jobs в postgres.
+// Джобы переживают перезапуск сервиса и разделяются между всеми его репликами:
+// воркеры захватывают джобы с помощью SELECT ... FOR UPDATE SKIP LOCKED.
+// В очередь могут быть поставлены только джобы, созданные NewDurableJob,
+// чей тип зарегистрирован в очереди
+type DurableQueue struct {
+	db       *pgxpool.Pool
+	cfg      DurableQueueConfig
+	handlers map[string]Handler
+	zombies  *zombies
+	done     chan struct{}
+	wg       sync.WaitGroup
+	// ctx отменяется при принудительной остановке, прерывая выполняемые джобы
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	mu        sync.Mutex
+	running   int
+	stopping  bool
+}
+
+func NewDurableQueue(db *pgxpool.Pool, cfg DurableQueueConfig, handlers ...Handler) (*DurableQueue, error) {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	cfg.Logger = defaultLogger(cfg.Logger).WithField("queue", DurableQueueName)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.AddJobTimeout)
+	defer cancel()
+	if _, err := db.Exec(ctx, initJobsSQL); err != nil {
+		return nil, err
+	}
+	queue := &DurableQueue{
+		db:       db,
+		cfg:      cfg,
+		handlers: make(map[string]Handler),
+		zombies:  newZombies(),
+		done:     make(chan struct{}),
+	}
+	queue.ctx, queue.cancel = context.WithCancel(context.Background())
+	for _, handler := range handlers {
+		queue.handlers[handler.Kind] = handler
+	}
+	for i := 0; i < cfg.Concurrency; i++ {
+		queue.wg.Add(1)
+		go queue.work()
+	}
+	return queue, nil
+}
+
+func (queue *DurableQueue) Add(ctx context.Context, job Job) error {
+	queue.mu.Lock()
+	stopping := queue.stopping
+	queue.mu.Unlock()
+	if stopping {
+		return ErrShuttingDown
+	}
+	if job.Kind == "" {
+		return ErrJobNotDurable
+	}
+	if _, ok := queue.handlers[job.Kind]; !ok {
+		return fmt.Errorf("%w: unknown job kind %s", ErrJobNotDurable, job.Kind)
+	}
+	if queue.cfg.Tracer != nil {
+		queue.cfg.Tracer.InjectJob(ctx, &job)
+	}
+	metadata, err := encodeMetadata(job.Metadata)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	_, err = queue.db.Exec(
+		ctx,
+		"INSERT INTO jobs (id, kind, name, owner, payload, metadata) VALUES ($1, $2, $3, $4, $5, $6)",
+		job.ID, job.Kind, job.Name, job.Owner, job.Payload, metadata,
+	)
+	if err != nil {
+		jobLogger(queue.cfg.Logger, job).WithError(err).Error("failed to add job")
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrAddJobTimeout
+		}
+		return err
+	}
+	jobLogger(queue.cfg.Logger, job).Debug("enqueued job")
+	return nil
+}
+
+func (queue *DurableQueue) Status(ctx context.Context, jobID string) (JobStatus, error) {
+	var status JobStatus
+	var state string
+	var result []byte
+	var lastError *string
+	var startedAt, finishedAt, runAt *time.Time
+
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	err := queue.db.QueryRow(
+		ctx,
+		"SELECT id, name, owner, status, attempts, result, last_error, enqueued_at, started_at, finished_at, run_at "+
+			"FROM jobs WHERE id = $1",
+		jobID,
+	).Scan(
+		&status.ID, &status.Name, &status.Owner, &state, &status.Attempts,
+		&result, &lastError, &status.EnqueuedAt, &startedAt, &finishedAt, &runAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return JobStatus{}, ErrJobNotFound
+		}
+		return JobStatus{}, err
+	}
+	status.State = JobState(state)
+	if result != nil {
+		status.Value = json.RawMessage(result)
+	}
+	if lastError != nil {
+		status.Err = errors.New(*lastError)
+	}
+	if startedAt != nil {
+		status.StartedAt = *startedAt
+	}
+	if finishedAt != nil {
+		status.FinishedAt = *finishedAt
+	}
+	if status.State == JobRetrying && runAt != nil {
+		status.NextAttemptAt = *runAt
+	}
+	return status, nil
+}
+
+func (queue *DurableQueue) DeadJobs(ctx context.Context) ([]DeadJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	rows, err := queue.db.Query(
+		ctx,
+		"SELECT id, kind, name, owner, payload, attempts, COALESCE(last_error, ''), finished_at "+
+			"FROM jobs WHERE status = $1 ORDER BY finished_at",
+		string(JobFailed),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]DeadJob, 0)
+	for rows.Next() {
+		var dead DeadJob
+		var lastError string
+		err := rows.Scan(
+			&dead.Job.ID, &dead.Job.Kind, &dead.Job.Name, &dead.Job.Owner,
+			&dead.Job.Payload, &dead.Job.Attempt, &lastError, &dead.FailedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		dead.Err = errors.New(lastError)
+		items = append(items, dead)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (queue *DurableQueue) Replay(ctx context.Context, jobID string) error {
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	result, err := queue.db.Exec(
+		ctx,
+		"UPDATE jobs SET status = $1, attempts = 0, run_at = NOW(), started_at = NULL, finished_at = NULL "+
+			"WHERE id = $2 AND status = $3",
+		string(JobQueued), jobID, string(JobFailed),
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Zombies возвращает джобы, продолжающие выполняться после истечения таймаута,
+// а также общее количество джобов, когда-либо ставших зомби
+func (queue *DurableQueue) Zombies() ([]ZombieJob, int) {
+	return queue.zombies.list()
+}
+
+// Depth возвращает количество джобов, ожидающих захвата воркером, в том числе ожидающих повторной попытки
+func (queue *DurableQueue) Depth(ctx context.Context) (int, error) {
+	var depth int
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	err := queue.db.QueryRow(
+		ctx, "SELECT COUNT(*) FROM jobs WHERE status IN ($1, $2)", string(JobQueued), string(JobRetrying),
+	).Scan(&depth)
+	if err != nil {
+		return 0, err
+	}
+	return depth, nil
+}
+
+// CheckSaturation сообщает о превышении лимита зомби-джобов, при котором реплика перестает захватывать джобы.
+// Размер самой очереди в бд не ограничен
+func (queue *DurableQueue) CheckSaturation() error {
+	if queue.zombies.tooMany(queue.cfg.MaxZombies) {
+		return fmt.Errorf("%w: %d zombie jobs", ErrQueueSaturated, queue.zombies.count())
+	}
+	return nil
+}
+
+// Shutdown прекращает прием и захват новых джобов и дожидается завершения выполняемых до истечения контекста.
+// Джобы, оставшиеся в очереди, не теряются: они будут выполнены этой или другой репликой после перезапуска,
+// поэтому брошенными считаются только джобы, выполнение которых было прервано
+func (queue *DurableQueue) Shutdown(ctx context.Context) (int, error) {
+	queue.stop()
+	stopped := make(chan struct{})
+	go func() {
+		queue.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return 0, nil
+	case <-ctx.Done():
+	}
+	queue.mu.Lock()
+	abandoned := queue.running
+	queue.mu.Unlock()
+	// прерванные джобы снова станут доступны для выполнения по истечении их аренды
+	queue.cancel()
+	<-stopped
+	return abandoned, ctx.Err()
+}
+
+// Close останавливает воркеров, дожидаясь завершения выполняемых ими джобов.
+// Джобы, оставшиеся в очереди, будут выполнены после следующего запуска
+func (queue *DurableQueue) Close() {
+	queue.stop()
+	queue.wg.Wait()
+	queue.cancel()
+}
+
+func (queue *DurableQueue) stop() {
+	queue.mu.Lock()
+	queue.stopping = true
+	queue.mu.Unlock()
+	queue.closeOnce.Do(func() { close(queue.done) })
+}
+
+func (queue *DurableQueue) work() {
+	defer queue.wg.Done()
+	ctx := queue.ctx
+	for {
+		// сначала разбираем все доступные джобы, и только затем ждем появления новых
+		job, found, err := queue.claim(ctx)
+		if err != nil {
+			queue.cfg.Logger.WithError(err).Error("failed to obtain job from durable queue")
+		}
+		if found {
+			queue.setRunning(1)
+			queue.process(ctx, job)
+			queue.setRunning(-1)
+			continue
+		}
+		select {
+		case <-queue.done:
+			queue.cfg.Logger.Debug("worker exited due to closed queue")
+			return
+		case <-time.After(queue.cfg.PollInterval):
+		}
+	}
+}
+
+// claim захватывает один готовый к выполнению джоб, продлевая его аренду на время выполнения
+// Помимо новых и ожидающих повтора джобов, захватываются джобы, аренда которых истекла
+func (queue *DurableQueue) claim(ctx context.Context) (Job, bool, error) {
+	var job Job
+	select {
+	case <-queue.done:
+		return job, false, nil
+	default:
+	}
+	// джобы остаются в очереди и будут выполнены другими репликами либо после завершения зомби
+	if queue.zombies.tooMany(queue.cfg.MaxZombies) {
+		return job, false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	// тип джоба еще неизвестен, поэтому берем аренду с запасом на самый долгий из зарегистрированных джобов
+	lease := queue.maxJobTimeout() + leaseMargin
+	var metadata []byte
+	err := queue.db.QueryRow(
+		ctx,
+		`UPDATE jobs SET status = 'running', attempts = attempts + 1, started_at = NOW(),
+		    locked_until = NOW() + $1::bigint * interval '1 millisecond'
+		WHERE id = (
+		    SELECT id FROM jobs
+		    WHERE (status IN ('queued', 'retrying') AND run_at <= NOW())
+		       OR (status = 'running' AND locked_until < NOW())
+		    ORDER BY run_at
+		    FOR UPDATE SKIP LOCKED
+		    LIMIT 1
+		)
+		RETURNING id, kind, name, owner, payload, metadata, attempts`,
+		lease.Milliseconds(),
+	).Scan(&job.ID, &job.Kind, &job.Name, &job.Owner, &job.Payload, &metadata, &job.Attempt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return job, false, nil
+		}
+		return job, false, err
+	}
+	// испорченные метаданные не должны мешать выполнению джоба
+	if err := json.Unmarshal(metadata, &job.Metadata); err != nil {
+		jobLogger(queue.cfg.Logger, job).WithError(err).Warn("failed to decode job metadata")
+	}
+	return job, true, nil
+}
+
+func encodeMetadata(metadata map[string]string) ([]byte, error) {
+	if metadata == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(metadata)
+}
+
+func (queue *DurableQueue) setRunning(delta int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.running += delta
+}
+
+func (queue *DurableQueue) maxJobTimeout() time.Duration {
+	timeout := queue.cfg.DoJobTimeout
+	for _, handler := range queue.handlers {
+		if handler.Timeout > timeout {
+			timeout = handler.Timeout
+		}
+	}
+	return timeout
+}
+
+// process выполняет захваченный джоб и сохраняет результат его выполнения
+func (queue *DurableQueue) process(ctx context.Context, job Job) {
+	jobLogger(queue.cfg.Logger, job).Debug("obtained new job")
+	handler, ok := queue.handlers[job.Kind]
+	if !ok {
+		// джоб мог быть поставлен в очередь репликой с более новой версией сервиса
+		queue.complete(ctx, JobResult{Job: job, Err: fmt.Errorf("unknown job kind %s", job.Kind)}, handler)
+		return
+	}
+	payload := job.Payload
+	job.do = func(ctx context.Context) (interface{}, error) {
+		return handler.Func(ctx, payload)
+	}
+	job.retry = handler.Retry
+	job.timeout = handler.Timeout
+	worker := Worker{
+		JobTimeout: queue.cfg.DoJobTimeout,
+		Observer:   queue.cfg.Observer,
+		Tracer:     queue.cfg.Tracer,
+		Logger:     queue.cfg.Logger,
+		zombies:    queue.zombies,
+	}
+	queue.complete(ctx, worker.Work(ctx, job), handler)
+}
+
+func (queue *DurableQueue) complete(ctx context.Context, result JobResult, handler Handler) {
+	var err error
+	job := result.Job
+	logger := jobLogger(queue.cfg.Logger, job)
+	ctx, cancel := context.WithTimeout(ctx, queue.cfg.AddJobTimeout)
+	defer cancel()
+	switch {
+	case result.Err == nil:
+		var value []byte
+		if result.Value != nil {
+			if value, err = json.Marshal(result.Value); err != nil {
+				logger.WithError(err).Error("failed to encode job result")
+			}
+		}
+		logger.Info("job succeeded")
+		_, err = queue.db.Exec(
+			ctx,
+			"UPDATE jobs SET status = $1, result = $2, last_error = NULL, finished_at = NOW() WHERE id = $3",
+			string(JobSucceeded), value, job.ID,
+		)
+	case handler.Retry.shouldRetry(job.Attempt, result.Err):
+		delay := handler.Retry.Delay(job.Attempt)
+		logger.WithError(result.Err).WithField("retry_in", delay.String()).Warn("job failed; will retry")
+		_, err = queue.db.Exec(
+			ctx,
+			"UPDATE jobs SET status = $1, last_error = $2, run_at = NOW() + $3::bigint * interval '1 millisecond' "+
+				"WHERE id = $4",
+			string(JobRetrying), result.Err.Error(), delay.Milliseconds(), job.ID,
+		)
+	default:
+		logger.WithError(result.Err).Error("job failed; no attempts left")
+		_, err = queue.db.Exec(
+			ctx,
+			"UPDATE jobs SET status = $1, last_error = $2, finished_at = NOW() WHERE id = $3",
+			string(JobFailed), result.Err.Error(), job.ID,
+		)
+	}
+	// если сохранить результат не удалось, джоб будет выполнен повторно после истечения аренды
+	if err != nil {
+		logger.WithError(err).Error("failed to save job result")
+	}
+}
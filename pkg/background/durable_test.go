@@ -0,0 +1,184 @@
+package background_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getTestDB подключается к бд в рандомно сгенерированной схеме,
+// чтобы таблица jobs не пересекалась с другими тестами
+func getTestDB(t *testing.T) *pgxpool.Pool {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("Skipping test because DB is not configured")
+	}
+	schema := random.String(5, "abcdefghijklmnopqrstuvwxyz")
+	db, err := pgxpool.Connect(context.TODO(), dsn)
+	require.NoError(t, err)
+	_, err = db.Exec(context.TODO(), "CREATE SCHEMA "+schema)
+	db.Close()
+	require.NoError(t, err)
+
+	db, err = pgxpool.Connect(context.TODO(), dsn+"?search_path="+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Exec(context.TODO(), "DROP SCHEMA "+schema+" CASCADE") // nolint:errcheck
+		db.Close()
+	})
+	return db
+}
+
+func TestDurableQueueProcessesJobs(t *testing.T) {
+	db := getTestDB(t)
+	calls := make(chan int, 10)
+	handler := background.Handler{
+		Kind: "flaky",
+		Name: "flaky",
+		Retry: background.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond * 10,
+		},
+		Func: func(_ context.Context, payload json.RawMessage) (interface{}, error) {
+			var x int
+			if err := json.Unmarshal(payload, &x); err != nil {
+				return nil, err
+			}
+			calls <- x
+			if len(calls) < 2 {
+				return nil, errors.New("transient")
+			}
+			return map[string]int{"value": x * 2}, nil
+		},
+	}
+	queue, err := background.NewDurableQueue(db, background.DurableQueueConfig{
+		Concurrency:   2,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		PollInterval:  time.Millisecond * 10,
+	}, handler)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	job, err := background.NewDurableJob(handler, 21)
+	require.NoError(t, err)
+	job = job.WithOwner("user1")
+	require.NoError(t, queue.Add(context.TODO(), job))
+
+	require.Eventually(t, func() bool {
+		status, _ := queue.Status(context.TODO(), job.ID)
+		return status.State == background.JobSucceeded
+	}, time.Second*5, time.Millisecond*10)
+	status, err := queue.Status(context.TODO(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Attempts)
+	assert.Equal(t, "user1", status.Owner)
+	assert.JSONEq(t, `{"value": 42}`, string(status.Value.(json.RawMessage)))
+
+	_, err = queue.Status(context.TODO(), "unknown")
+	assert.ErrorIs(t, err, background.ErrJobNotFound)
+}
+
+func TestDurableQueueRejectsNonDurableJobs(t *testing.T) {
+	db := getTestDB(t)
+	queue, err := background.NewDurableQueue(db, background.DurableQueueConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+	})
+	require.NoError(t, err)
+	defer queue.Close()
+
+	job := background.NewJob("test", func(context.Context) error { return nil })
+	assert.ErrorIs(t, queue.Add(context.TODO(), job), background.ErrJobNotDurable)
+
+	durable, err := background.NewDurableJob(background.Handler{Kind: "unknown", Name: "unknown"}, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, queue.Add(context.TODO(), durable), background.ErrJobNotDurable)
+}
+
+func TestDurableQueueDepthCountsUnclaimedJobs(t *testing.T) {
+	db := getTestDB(t)
+	handler := background.Handler{
+		Kind: "noop",
+		Name: "noop",
+		Func: func(context.Context, json.RawMessage) (interface{}, error) {
+			return nil, nil
+		},
+	}
+	// без воркеров джобы остаются в очереди
+	queue, err := background.NewDurableQueue(db, background.DurableQueueConfig{
+		Concurrency:   0,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+	}, handler)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	depth, err := queue.Depth(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 0, depth)
+	for i := 0; i < 3; i++ {
+		job, err := background.NewDurableJob(handler, i)
+		require.NoError(t, err)
+		require.NoError(t, queue.Add(context.TODO(), job))
+	}
+	depth, err = queue.Depth(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 3, depth)
+}
+
+func TestDurableQueueKeepsDeadJobsForReplay(t *testing.T) {
+	db := getTestDB(t)
+	fail := make(chan bool, 1)
+	fail <- true
+	handler := background.Handler{
+		Kind: "once",
+		Name: "once",
+		Func: func(context.Context, json.RawMessage) (interface{}, error) {
+			select {
+			case <-fail:
+				return nil, errors.New("permanent")
+			default:
+				return nil, nil
+			}
+		},
+	}
+	queue, err := background.NewDurableQueue(db, background.DurableQueueConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+		PollInterval:  time.Millisecond * 10,
+	}, handler)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	job, err := background.NewDurableJob(handler, nil)
+	require.NoError(t, err)
+	require.NoError(t, queue.Add(context.TODO(), job))
+
+	require.Eventually(t, func() bool {
+		dead, _ := queue.DeadJobs(context.TODO())
+		return len(dead) == 1
+	}, time.Second*5, time.Millisecond*10)
+	dead, err := queue.DeadJobs(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, dead[0].Job.ID)
+	assert.EqualError(t, dead[0].Err, "permanent")
+
+	require.NoError(t, queue.Replay(context.TODO(), job.ID))
+	require.Eventually(t, func() bool {
+		status, _ := queue.Status(context.TODO(), job.ID)
+		return status.State == background.JobSucceeded
+	}, time.Second*5, time.Millisecond*10)
+	assert.ErrorIs(t, queue.Replay(context.TODO(), job.ID), background.ErrJobNotFound)
+}
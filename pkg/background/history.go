@@ -0,0 +1,136 @@
+package background
+
+import (
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobRetrying  JobState = "retrying"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// isFinal сообщает, завершено ли выполнение джоба
+func (state JobState) isFinal() bool {
+	return state == JobSucceeded || state == JobFailed
+}
+
+// JobStatus - состояние джоба на текущий момент
+type JobStatus struct {
+	ID            string
+	Name          string
+	Owner         string
+	State         JobState
+	Attempts      int
+	Value         interface{}
+	Err           error // ошибка последней попытки
+	EnqueuedAt    time.Time
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	NextAttemptAt time.Time
+}
+
+// history хранит статусы последних добавленных в пул джобов.
+// При превышении лимита вытесняются самые старые записи
+type history struct {
+	mu      sync.RWMutex
+	items   map[string]*JobStatus
+	order   []string
+	maxSize int
+}
+
+func newHistory(maxSize int) *history {
+	return &history{
+		items:   make(map[string]*JobStatus),
+		order:   make([]string, 0, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+func (h *history) add(job Job) {
+	// повторно добавленный джоб начинает историю заново
+	h.remove(job.ID)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for len(h.order) > 0 && len(h.order) >= h.maxSize {
+		delete(h.items, h.order[0])
+		h.order = h.order[1:]
+	}
+	h.items[job.ID] = &JobStatus{
+		ID:         job.ID,
+		Name:       job.Name,
+		Owner:      job.Owner,
+		State:      JobQueued,
+		EnqueuedAt: time.Now(),
+	}
+	h.order = append(h.order, job.ID)
+}
+
+func (h *history) remove(jobID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.items[jobID]; !ok {
+		return
+	}
+	delete(h.items, jobID)
+	for i, id := range h.order {
+		if id == jobID {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (h *history) start(job Job) {
+	h.update(job.ID, func(status *JobStatus) {
+		status.State = JobRunning
+		status.Attempts = job.Attempt
+		status.StartedAt = time.Now()
+		status.NextAttemptAt = time.Time{}
+	})
+}
+
+func (h *history) retry(result JobResult, nextAttemptAt time.Time) {
+	h.update(result.Job.ID, func(status *JobStatus) {
+		status.State = JobRetrying
+		status.Err = result.Err
+		status.NextAttemptAt = nextAttemptAt
+	})
+}
+
+func (h *history) finish(result JobResult) {
+	h.update(result.Job.ID, func(status *JobStatus) {
+		if result.Err != nil {
+			status.State = JobFailed
+		} else {
+			status.State = JobSucceeded
+		}
+		status.Value = result.Value
+		status.Err = result.Err
+		status.FinishedAt = time.Now()
+	})
+}
+
+// update изменяет статус джоба, если он еще не вытеснен из истории
+func (h *history) update(jobID string, fn func(*JobStatus)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if status, ok := h.items[jobID]; ok {
+		fn(status)
+	}
+}
+
+func (h *history) get(jobID string) (JobStatus, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	status, ok := h.items[jobID]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return *status, true
+}
@@ -0,0 +1,63 @@
+package background
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrJobNotDurable = errors.New("job cannot be stored in a durable queue")
+var ErrQueueSaturated = errors.New("job queue is saturated")
+
+// Queue - очередь фоновых задач. Реализуется как пулом воркеров с очередью в памяти (Pool),
+// так и долговременной очередью в postgres (DurableQueue)
+type Queue interface {
+	// Add ставит джоб в очередь
+	Add(context.Context, Job) error
+	// Status возвращает статус джоба по его идентификатору, либо ErrJobNotFound
+	Status(context.Context, string) (JobStatus, error)
+	// DeadJobs возвращает джобы, исчерпавшие все попытки выполнения
+	DeadJobs(context.Context) ([]DeadJob, error)
+	// Replay повторно ставит в очередь мертвый джоб
+	Replay(context.Context, string) error
+	// Shutdown прекращает прием новых джобов и дожидается выполнения текущих до истечения контекста,
+	// возвращая количество джобов, выполнение которых было прервано или так и не началось
+	Shutdown(context.Context) (int, error)
+	// Zombies возвращает джобы, продолжающие выполняться после истечения таймаута,
+	// и общее количество джобов, когда-либо ставших зомби
+	Zombies() ([]ZombieJob, int)
+	// CheckSaturation возвращает ErrQueueSaturated, если очередь не в состоянии принимать новые джобы
+	CheckSaturation() error
+	Close()
+}
+
+// HandlerFunc выполняет джоб по его сериализованным аргументам
+type HandlerFunc func(context.Context, json.RawMessage) (interface{}, error)
+
+// Handler описывает тип джоба, который может быть сохранен в долговременную очередь
+// и восстановлен из нее по своему типу Kind и аргументам
+type Handler struct {
+	Kind    string
+	Name    string
+	Retry   RetryPolicy
+	Timeout time.Duration // если не задан, используется общий таймаут очереди
+	Queue   string        // очередь пула, в которой выполняется джоб; долговременная очередь ее не учитывает
+	Func    HandlerFunc
+}
+
+// NewDurableJob создает джоб заданного типа с аргументами payload, сериализуемыми в json.
+// Такой джоб может быть выполнен как пулом в памяти, так и долговременной очередью
+func NewDurableJob(handler Handler, payload interface{}) (Job, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+	job := NewValueJob(handler.Name, func(ctx context.Context) (interface{}, error) {
+		return handler.Func(ctx, encoded)
+	})
+	job.Kind = handler.Kind
+	job.Payload = encoded
+	job.Queue = handler.Queue
+	return job.WithRetry(handler.Retry).WithTimeout(handler.Timeout), nil
+}
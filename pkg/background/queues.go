@@ -0,0 +1,125 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DefaultQueue - очередь, в которую попадают джобы без явно указанной очереди
+const DefaultQueue = "default"
+
+// QueueConfig описывает именованную очередь пула.
+// Concurrency воркеров пула закреплены за очередью и выполняют только ее джобы,
+// благодаря чему долгие джобы одной очереди не могут занять всех воркеров пула.
+// Priority определяет порядок, в котором общие воркеры пула разбирают очереди: чем выше, тем раньше
+type QueueConfig struct {
+	Name        string
+	Concurrency int
+	Priority    int
+	Size        int // размер буфера очереди; по умолчанию зависит от количества обслуживающих ее воркеров
+}
+
+type namedQueue struct {
+	QueueConfig
+	jobs chan Job
+}
+
+// newQueues создает очереди пула, упорядоченные по убыванию приоритета.
+// Очередь по умолчанию создается всегда, даже если она не указана в настройках
+func newQueues(cfg PoolConfig) ([]*namedQueue, map[string]*namedQueue) {
+	configs := make([]QueueConfig, 0, len(cfg.Queues)+1)
+	hasDefault := false
+	for _, qcfg := range cfg.Queues {
+		if qcfg.Name == DefaultQueue {
+			hasDefault = true
+		}
+		configs = append(configs, qcfg)
+	}
+	if !hasDefault {
+		configs = append(configs, QueueConfig{Name: DefaultQueue})
+	}
+	queues := make([]*namedQueue, 0, len(configs))
+	byName := make(map[string]*namedQueue, len(configs))
+	for _, qcfg := range configs {
+		size := qcfg.Size
+		if size <= 0 {
+			size = (qcfg.Concurrency + cfg.Concurrency) * queueBufferMultiplier
+		}
+		queue := &namedQueue{QueueConfig: qcfg, jobs: make(chan Job, size)}
+		queues = append(queues, queue)
+		byName[qcfg.Name] = queue
+	}
+	sort.SliceStable(queues, func(i, j int) bool {
+		return queues[i].Priority > queues[j].Priority
+	})
+	return queues, byName
+}
+
+// queueFor возвращает очередь джоба. Джобы с неизвестной пулу очередью попадают в очередь по умолчанию
+func (pool *Pool) queueFor(job Job) *namedQueue {
+	if queue, ok := pool.queuesByName[job.Queue]; ok {
+		return queue
+	}
+	return pool.queuesByName[DefaultQueue]
+}
+
+// QueueDepths возвращает количество джобов, ожидающих выполнения в каждой из очередей пула
+func (pool *Pool) QueueDepths() map[string]int {
+	depths := make(map[string]int, len(pool.queues))
+	for _, queue := range pool.queues {
+		depths[queue.Name] = len(queue.jobs)
+	}
+	return depths
+}
+
+// CheckSaturation сообщает о заполненной очереди пула либо о превышении лимита зомби-джобов
+func (pool *Pool) CheckSaturation() error {
+	if pool.zombies.tooMany(pool.cfg.MaxZombies) {
+		return fmt.Errorf("%w: %d zombie jobs", ErrQueueSaturated, pool.zombies.count())
+	}
+	for _, queue := range pool.queues {
+		if len(queue.jobs) >= cap(queue.jobs) {
+			return fmt.Errorf("%w: queue %s is full", ErrQueueSaturated, queue.Name)
+		}
+	}
+	return nil
+}
+
+// dedicatedPicker возвращает функцию получения джоба для воркера, закрепленного за очередью
+func dedicatedPicker(queue *namedQueue) func(context.Context) (Job, bool) {
+	return func(ctx context.Context) (Job, bool) {
+		select {
+		case job := <-queue.jobs:
+			return job, true
+		case <-ctx.Done():
+			return Job{}, false
+		}
+	}
+}
+
+// sharedPicker возвращает функцию получения джоба для общего воркера пула:
+// сначала проверяются очереди с наибольшим приоритетом, а при отсутствии джобов
+// воркер ждет появления джоба в любой из очередей
+func sharedPicker(queues []*namedQueue) func(context.Context) (Job, bool) {
+	return func(ctx context.Context) (Job, bool) {
+		for _, queue := range queues {
+			select {
+			case job := <-queue.jobs:
+				return job, true
+			default:
+			}
+		}
+		cases := make([]reflect.SelectCase, 0, len(queues)+1)
+		for _, queue := range queues {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(queue.jobs)})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+		chosen, value, _ := reflect.Select(cases)
+		if chosen == len(queues) {
+			return Job{}, false
+		}
+		return value.Interface().(Job), true
+	}
+}
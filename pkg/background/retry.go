@@ -0,0 +1,108 @@
+package background
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const defaultDeadLetterSize = 1000
+const defaultBackoffMultiplier = 2
+
+var ErrJobNotFound = errors.New("job not found")
+
+// RetryPolicy описывает повторное выполнение джоба, завершившегося с ошибкой.
+// Задержка перед каждой следующей попыткой растет экспоненциально, начиная с Backoff,
+// но не превышает MaxBackoff. Jitter (от 0 до 1) задает долю случайного отклонения задержки,
+// чтобы одновременно упавшие джобы не повторялись одновременно
+type RetryPolicy struct {
+	MaxAttempts int // общее количество попыток, включая первую
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Multiplier  float64 // по умолчанию задержка удваивается
+	Jitter      float64
+	// Retryable решает, имеет ли смысл повторять джоб после данной ошибки.
+	// Если не задана, повторяется любая ошибка
+	Retryable func(error) bool
+}
+
+// shouldRetry сообщает, нужно ли повторить джоб после неудачной попытки с номером attempt
+func (policy RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= policy.MaxAttempts {
+		return false
+	}
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return true
+}
+
+// Delay вычисляет задержку перед попыткой, следующей за попыткой с номером attempt
+func (policy RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+	delay := float64(policy.Backoff) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 && delay > float64(policy.MaxBackoff) {
+		delay = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+		// случайное отклонение в пределах [-Jitter; +Jitter] от задержки
+		delay += delay * policy.Jitter * (2*rand.Float64() - 1) // nolint:gosec
+	}
+	return time.Duration(delay)
+}
+
+// DeadJob - джоб, исчерпавший все попытки выполнения
+type DeadJob struct {
+	Job      Job
+	Err      error
+	FailedAt time.Time
+}
+
+// deadLetters хранит последние джобы, выполнение которых окончательно не удалось
+// При превышении лимита вытесняются самые старые из них
+type deadLetters struct {
+	mu      sync.Mutex
+	items   []DeadJob
+	maxSize int
+}
+
+func newDeadLetters(maxSize int) *deadLetters {
+	return &deadLetters{
+		items:   make([]DeadJob, 0),
+		maxSize: maxSize,
+	}
+}
+
+func (dl *deadLetters) push(dead DeadJob) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if len(dl.items) >= dl.maxSize {
+		dl.items = dl.items[len(dl.items)-dl.maxSize+1:]
+	}
+	dl.items = append(dl.items, dead)
+}
+
+func (dl *deadLetters) pop(jobID string) (DeadJob, bool) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	for i, dead := range dl.items {
+		if dead.Job.ID == jobID {
+			dl.items = append(dl.items[:i], dl.items[i+1:]...)
+			return dead, true
+		}
+	}
+	return DeadJob{}, false
+}
+
+func (dl *deadLetters) list() []DeadJob {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	items := make([]DeadJob, len(dl.items))
+	copy(items, dl.items)
+	return items
+}
@@ -0,0 +1,187 @@
+package background
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// cronSearchLimit ограничивает поиск следующего запуска для выражений, которые никогда не срабатывают (например, 30 февраля)
+const cronSearchLimit = 5 // years
+
+// Schedule вычисляет время следующего запуска повторяющегося джоба
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+// Every возвращает расписание с запуском через равные промежутки времени
+func Every(interval time.Duration) Schedule {
+	return intervalSchedule{interval: interval}
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule - расписание в формате cron из 5 полей: минуты, часы, день месяца, месяц и день недели
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // битовые маски допустимых значений
+	domAny, dowAny                bool
+	loc                           *time.Location
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // минуты
+	{0, 23}, // часы
+	{1, 31}, // день месяца
+	{1, 12}, // месяц
+	{0, 7},  // день недели, 0 и 7 - воскресенье
+}
+
+// ParseCron разбирает cron-выражение вида "*/5 * * * *".
+// Поля поддерживают списки (1,15), диапазоны (1-5), шаги (*/10, 0-30/5) и звездочку.
+// Также поддерживаются сокращения @hourly, @daily, @weekly, @monthly и @every <duration>
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSchedule, expr)
+		}
+		return Every(interval), nil
+	}
+	switch expr {
+	case "@hourly":
+		expr = "0 * * * *"
+	case "@daily", "@midnight":
+		expr = "0 0 * * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("%w: expected %d fields in %q", ErrInvalidSchedule, len(cronFields), expr)
+	}
+	masks := make([]uint64, len(fields))
+	for i, field := range fields {
+		mask, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, expr)
+		}
+		masks[i] = mask
+	}
+	// воскресенье может быть указано как 0, так и 7
+	dow := masks[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+	return &cronSchedule{
+		minute: masks[0],
+		hour:   masks[1],
+		dom:    masks[2],
+		month:  masks[3],
+		dow:    dow,
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+		loc:    time.UTC,
+	}, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr := part, ""
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			rng, stepStr = part[:idx], part[idx+1:]
+		}
+		start, end := bounds.min, bounds.max
+		if rng != "*" {
+			limits := strings.SplitN(rng, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(limits[0]); err != nil {
+				return 0, ErrInvalidSchedule
+			}
+			switch {
+			case len(limits) == 2:
+				if end, err = strconv.Atoi(limits[1]); err != nil {
+					return 0, ErrInvalidSchedule
+				}
+			case stepStr == "":
+				end = start
+			}
+			// при указании шага без диапазона ("5/15") значения берутся до конца диапазона поля
+		}
+		step := 1
+		if stepStr != "" {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, ErrInvalidSchedule
+			}
+		}
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, ErrInvalidSchedule
+		}
+		for v := start; v <= end; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// Next возвращает ближайшее время запуска строго после t с точностью до минуты
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchLimit, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t.In(origLoc)
+	}
+	return time.Time{}
+}
+
+// dayMatches проверяет день месяца и день недели. Как и в cron, если ограничены оба поля,
+// достаточно совпадения любого из них
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowMatch
+	case s.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
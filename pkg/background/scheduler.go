@@ -0,0 +1,247 @@
+package background
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+const initLeasesSQL = `
+CREATE TABLE IF NOT EXISTS schedule_leases (
+    name TEXT PRIMARY KEY,
+    holder TEXT NOT NULL,
+    locked_until timestamptz NOT NULL,
+    last_job_id TEXT NOT NULL DEFAULT ''
+);
+`
+
+// ErrLockerRequiresDurableQueue - с DatabaseLocker запуск может достаться любой реплике, поэтому
+// статус предыдущего джоба расписания должен быть виден всем репликам, а не только поставившей его
+var ErrLockerRequiresDurableQueue = errors.New("database locker requires a durable job queue")
+
+// ScheduledJob описывает повторяющийся джоб. NewJob вызывается при каждом запуске по расписанию
+type ScheduledJob struct {
+	Name     string
+	Schedule Schedule
+	NewJob   func() (Job, error)
+}
+
+// Locker распределяет запуски по расписанию между репликами сервиса
+type Locker interface {
+	// Acquire захватывает аренду расписания name на время ttl и возвращает идентификатор джоба,
+	// поставленного по нему последним. Если аренда удерживается другой репликой, возвращает false
+	Acquire(ctx context.Context, name string, ttl time.Duration) (string, bool, error)
+	// Record запоминает идентификатор джоба, поставленного по расписанию
+	Record(ctx context.Context, name string, jobID string) error
+}
+
+type scheduleEntry struct {
+	ScheduledJob
+	next time.Time
+}
+
+// Scheduler ставит повторяющиеся джобы в очередь согласно их расписанию.
+// Очередной запуск пропускается, если предыдущий джоб того же расписания еще не выполнен.
+// С помощью Locker на основе бд каждый запуск выполняет только одна из реплик
+type Scheduler struct {
+	queue     Queue
+	locker    Locker
+	timeout   time.Duration
+	logger    logrus.FieldLogger
+	entries   []*scheduleEntry
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewScheduler создает планировщик, ставящий джобы в очередь queue.
+// Если locker не задан, запуски не согласуются с другими репликами.
+// DatabaseLocker можно использовать только с DurableQueue
+func NewScheduler(queue Queue, locker Locker, timeout time.Duration) (*Scheduler, error) {
+	if locker == nil {
+		locker = NewLocalLocker()
+	}
+	if _, ok := locker.(*DatabaseLocker); ok {
+		if _, durable := queue.(*DurableQueue); !durable {
+			return nil, ErrLockerRequiresDurableQueue
+		}
+	}
+	return &Scheduler{
+		queue:   queue,
+		locker:  locker,
+		timeout: timeout,
+		logger:  defaultLogger(nil),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// WithLogger задает логгер планировщика. Должен вызываться до Start
+func (s *Scheduler) WithLogger(logger logrus.FieldLogger) *Scheduler {
+	s.logger = defaultLogger(logger)
+	return s
+}
+
+// Register добавляет повторяющийся джоб. Должен вызываться до Start
+func (s *Scheduler) Register(job ScheduledJob) {
+	s.entries = append(s.entries, &scheduleEntry{ScheduledJob: job})
+}
+
+// Start запускает планировщик в отдельной горутине
+func (s *Scheduler) Start() {
+	now := time.Now()
+	for _, entry := range s.entries {
+		entry.next = entry.Schedule.Next(now)
+	}
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Close останавливает планировщик. Уже поставленные джобы продолжают выполняться
+func (s *Scheduler) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+	for {
+		var earliest time.Time
+		for _, entry := range s.entries {
+			if !entry.next.IsZero() && (earliest.IsZero() || entry.next.Before(earliest)) {
+				earliest = entry.next
+			}
+		}
+		// запланированных запусков нет - ждем остановки
+		if earliest.IsZero() {
+			<-s.done
+			return
+		}
+		timer := time.NewTimer(time.Until(earliest))
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			for _, entry := range s.entries {
+				if entry.next.IsZero() || entry.next.After(now) {
+					continue
+				}
+				next := entry.Schedule.Next(now)
+				s.enqueue(entry, now, next)
+				entry.next = next
+			}
+		}
+	}
+}
+
+// enqueue ставит джоб расписания в очередь, если аренда запуска досталась этой реплике
+// и предыдущий джоб расписания уже завершен
+func (s *Scheduler) enqueue(entry *scheduleEntry, now, next time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	// аренды хватает, чтобы другие реплики пропустили этот запуск, но не следующий
+	ttl := next.Sub(now) / 2
+	logger := s.logger.WithField("schedule", entry.Name)
+	lastJobID, ok, err := s.locker.Acquire(ctx, entry.Name, ttl)
+	if err != nil {
+		logger.WithError(err).Error("failed to acquire schedule lease")
+		return
+	}
+	if !ok {
+		logger.Debug("schedule is run by another replica")
+		return
+	}
+	if lastJobID != "" {
+		status, err := s.queue.Status(ctx, lastJobID)
+		if err == nil && !status.State.isFinal() {
+			logger.WithFields(logrus.Fields{"job_id": lastJobID, "state": status.State}).
+				Info("skipping schedule because previous job is not finished")
+			return
+		}
+	}
+	job, err := entry.NewJob()
+	if err != nil {
+		logger.WithError(err).Error("failed to create scheduled job")
+		return
+	}
+	logger = logger.WithField("job_id", job.ID)
+	if err := s.queue.Add(ctx, job); err != nil {
+		logger.WithError(err).Error("failed to enqueue scheduled job")
+		return
+	}
+	if err := s.locker.Record(ctx, entry.Name, job.ID); err != nil {
+		logger.WithError(err).Error("failed to record scheduled job")
+	}
+}
+
+// LocalLocker не согласует запуски с другими репликами и лишь запоминает последние джобы расписаний
+type LocalLocker struct {
+	mu   sync.Mutex
+	jobs map[string]string
+}
+
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{jobs: make(map[string]string)}
+}
+
+func (locker *LocalLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	return locker.jobs[name], true, nil
+}
+
+func (locker *LocalLocker) Record(ctx context.Context, name string, jobID string) error {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	locker.jobs[name] = jobID
+	return nil
+}
+
+// DatabaseLocker хранит аренды расписаний в таблице schedule_leases.
+// Аренду запуска получает реплика, первой захватившая истекшую аренду
+type DatabaseLocker struct {
+	db     *pgxpool.Pool
+	holder string
+}
+
+func NewDatabaseLocker(ctx context.Context, db *pgxpool.Pool) (*DatabaseLocker, error) {
+	if _, err := db.Exec(ctx, initLeasesSQL); err != nil {
+		return nil, err
+	}
+	return &DatabaseLocker{db: db, holder: uuid.New().String()}, nil
+}
+
+func (locker *DatabaseLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	var lastJobID string
+	err := locker.db.QueryRow(
+		ctx,
+		`INSERT INTO schedule_leases (name, holder, locked_until)
+		VALUES ($1, $2, NOW() + $3::bigint * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, locked_until = EXCLUDED.locked_until
+		WHERE schedule_leases.locked_until <= NOW()
+		RETURNING last_job_id`,
+		name, locker.holder, ttl.Milliseconds(),
+	).Scan(&lastJobID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return lastJobID, true, nil
+}
+
+func (locker *DatabaseLocker) Record(ctx context.Context, name string, jobID string) error {
+	_, err := locker.db.Exec(
+		ctx,
+		"UPDATE schedule_leases SET last_job_id = $1 WHERE name = $2 AND holder = $3",
+		jobID, name, locker.holder,
+	)
+	return err
+}
@@ -0,0 +1,166 @@
+package background_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/background"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronNext(t *testing.T) {
+	from := time.Date(2022, time.March, 14, 10, 17, 30, 0, time.UTC) // понедельник
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2022, time.March, 14, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2022, time.March, 14, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2022, time.March, 14, 10, 25, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2022, time.March, 15, 3, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2022, time.March, 14, 13, 0, 0, 0, time.UTC)},
+		{"30 8 1,15 * *", time.Date(2022, time.March, 15, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2022, time.March, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2022, time.March, 18, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2022, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", from.Add(time.Second * 90)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := background.ParseCron(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, schedule.Next(from))
+		})
+	}
+}
+
+func TestParseCronInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *", "@every -1s"} {
+		_, err := background.ParseCron(expr)
+		assert.ErrorIs(t, err, background.ErrInvalidSchedule, expr)
+	}
+}
+
+func TestSchedulerEnqueuesRecurringJobs(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   1,
+		DoJobTimeout:  time.Second,
+		AddJobTimeout: time.Second,
+	})
+	defer pool.Close()
+	scheduler, err := background.NewScheduler(pool, nil, time.Second)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	runs := 0
+	scheduler.Register(background.ScheduledJob{
+		Name:     "tick",
+		Schedule: background.Every(time.Millisecond * 10),
+		NewJob: func() (background.Job, error) {
+			return background.NewJob("tick", func(context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				runs++
+				return nil
+			}), nil
+		},
+	})
+	scheduler.Start()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs >= 3
+	}, time.Second, time.Millisecond*5)
+	scheduler.Close()
+}
+
+func TestSchedulerSkipsRunWhilePreviousJobIsActive(t *testing.T) {
+	pool := background.NewPool(background.PoolConfig{
+		Concurrency:   2,
+		DoJobTimeout:  time.Second * 5,
+		AddJobTimeout: time.Second,
+	})
+	defer pool.Close()
+	scheduler, err := background.NewScheduler(pool, nil, time.Second)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	created := 0
+	release := make(chan struct{})
+	scheduler.Register(background.ScheduledJob{
+		Name:     "slow",
+		Schedule: background.Every(time.Millisecond * 10),
+		NewJob: func() (background.Job, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			created++
+			return background.NewJob("slow", func(context.Context) error {
+				<-release
+				return nil
+			}), nil
+		},
+	})
+	scheduler.Start()
+	defer scheduler.Close()
+
+	time.Sleep(time.Millisecond * 100)
+	mu.Lock()
+	assert.Equal(t, 1, created)
+	mu.Unlock()
+
+	// после завершения джоба расписание возобновляется
+	close(release)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return created > 1
+	}, time.Second, time.Millisecond*5)
+}
+
+func TestDatabaseLockerGrantsLeaseToSingleHolder(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.TODO()
+	first, err := background.NewDatabaseLocker(ctx, db)
+	require.NoError(t, err)
+	second, err := background.NewDatabaseLocker(ctx, db)
+	require.NoError(t, err)
+
+	lastJobID, ok, err := first.Acquire(ctx, "purge", time.Millisecond*200)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "", lastJobID)
+	require.NoError(t, first.Record(ctx, "purge", "job1"))
+
+	_, ok, err = second.Acquire(ctx, "purge", time.Millisecond*200)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// по истечении аренды ее может получить другая реплика
+	time.Sleep(time.Millisecond * 250)
+	lastJobID, ok, err = second.Acquire(ctx, "purge", time.Millisecond*200)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "job1", lastJobID)
+}
+
+func TestDatabaseLockerRequiresDurableQueue(t *testing.T) {
+	db := getTestDB(t)
+	locker, err := background.NewDatabaseLocker(context.TODO(), db)
+	require.NoError(t, err)
+	pool := background.NewPool(background.PoolConfig{Concurrency: 1, DoJobTimeout: time.Second})
+	defer pool.Close()
+	// джобы пула в памяти не видны другим репликам, и запуски по расписанию могли бы пересекаться
+	_, err = background.NewScheduler(pool, locker, time.Second)
+	assert.ErrorIs(t, err, background.ErrLockerRequiresDurableQueue)
+
+	queue, err := background.NewDurableQueue(db, background.DurableQueueConfig{Concurrency: 1, DoJobTimeout: time.Second})
+	require.NoError(t, err)
+	defer queue.Close()
+	_, err = background.NewScheduler(queue, locker, time.Second)
+	assert.NoError(t, err)
+}
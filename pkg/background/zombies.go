@@ -0,0 +1,68 @@
+package background
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrTooManyZombies = errors.New("too many zombie jobs")
+
+// ZombieJob - джоб, не остановившийся по истечении своего таймаута.
+// Воркер уже сообщил о его неудаче, но горутина джоба продолжает выполняться
+type ZombieJob struct {
+	ID          string
+	Name        string
+	StartedAt   time.Time
+	AbandonedAt time.Time
+}
+
+// zombies отслеживает горутины джобов, которые продолжают выполняться после истечения таймаута
+type zombies struct {
+	mu    sync.Mutex
+	items map[string]ZombieJob
+	total int
+}
+
+func newZombies() *zombies {
+	return &zombies{items: make(map[string]ZombieJob)}
+}
+
+func (z *zombies) add(zombie ZombieJob) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.items[zombie.ID] = zombie
+	z.total++
+}
+
+func (z *zombies) remove(jobID string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	delete(z.items, jobID)
+}
+
+func (z *zombies) count() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return len(z.items)
+}
+
+// list возвращает выполняющиеся зомби-джобы и общее количество джобов, когда-либо ставших зомби
+func (z *zombies) list() ([]ZombieJob, int) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	items := make([]ZombieJob, 0, len(z.items))
+	for _, zombie := range z.items {
+		items = append(items, zombie)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].AbandonedAt.Before(items[j].AbandonedAt)
+	})
+	return items, z.total
+}
+
+// tooMany сообщает, превышен ли лимит одновременно выполняющихся зомби-джобов (0 - без лимита)
+func (z *zombies) tooMany(limit int) bool {
+	return limit > 0 && z.count() >= limit
+}
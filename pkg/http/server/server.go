@@ -2,22 +2,51 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 )
 
 const defaultShutdownTimeout = 10 // seconds
 
 type serverConfig struct {
 	shutdownTimeout time.Duration
+	shutdownHooks   []ShutdownHook
+	drainHooks      []DrainHook
+	drainDelay      time.Duration
+	certFile        string
+	keyFile         string
+	redirectAddr    string
+	http2           HTTP2Settings
+	logger          logrus.FieldLogger
+}
+
+// HTTP2Settings - настройки HTTP/2, который используется при работе по HTTPS
+type HTTP2Settings struct {
+	Disabled             bool
+	MaxConcurrentStreams uint32        // 0 - значение по умолчанию (250)
+	IdleTimeout          time.Duration // 0 - совпадает с IdleTimeout сервера
 }
 
+// DrainHook вызывается сразу после получения сигнала остановки, пока сервер еще принимает запросы,
+// например, чтобы сообщить балансировщику о неготовности сервиса
+type DrainHook func()
+
+// ShutdownHook вызывается при корректной остановке сервера после того, как он перестал принимать запросы.
+// Хуки вызываются по очереди в порядке добавления, и каждый получает равную долю времени,
+// оставшегося от таймаута остановки. Ошибка одного хука не мешает вызову следующих
+type ShutdownHook func(context.Context) error
+
 type Option func(*serverConfig)
 
 func WithShutdownTimeout(timeout time.Duration) Option {
@@ -26,41 +55,220 @@ func WithShutdownTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithShutdownHook добавляет хук, вызываемый при корректной остановке сервера
+func WithShutdownHook(hook ShutdownHook) Option {
+	return func(c *serverConfig) {
+		c.shutdownHooks = append(c.shutdownHooks, hook)
+	}
+}
+
+// WithDrainHook добавляет хук, вызываемый сразу после получения сигнала остановки
+func WithDrainHook(hook DrainHook) Option {
+	return func(c *serverConfig) {
+		c.drainHooks = append(c.drainHooks, hook)
+	}
+}
+
+// WithDrainDelay задает время, в течение которого сервер продолжает принимать запросы
+// после получения сигнала остановки. Задержка не входит в таймаут остановки
+func WithDrainDelay(delay time.Duration) Option {
+	return func(c *serverConfig) {
+		c.drainDelay = delay
+	}
+}
+
+// WithTLS включает HTTPS с сертификатом и ключом из файлов.
+// Изменения файлов подхватываются без перезапуска сервера
+func WithTLS(certFile, keyFile string) Option {
+	return func(c *serverConfig) {
+		c.certFile = certFile
+		c.keyFile = keyFile
+	}
+}
+
+// WithRedirectFromHTTP запускает на addr дополнительный HTTP сервер,
+// перенаправляющий все запросы на HTTPS. Имеет смысл только вместе с WithTLS
+func WithRedirectFromHTTP(addr string) Option {
+	return func(c *serverConfig) {
+		c.redirectAddr = addr
+	}
+}
+
+// WithHTTP2 задает настройки HTTP/2
+func WithHTTP2(settings HTTP2Settings) Option {
+	return func(c *serverConfig) {
+		c.http2 = settings
+	}
+}
+
+// WithLogger задает логгер сервера. По умолчанию используется логгер сервиса по умолчанию
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(c *serverConfig) {
+		c.logger = logger
+	}
+}
+
 func Start(server *http.Server, opts ...Option) error {
 	cfg := serverConfig{
 		shutdownTimeout: time.Second * defaultShutdownTimeout,
+		logger:          logging.FromContext(context.Background()),
 	}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
+	if err := configureTLS(server, &cfg); err != nil {
+		return fmt.Errorf("failed to configure TLS due to: %w", err)
+	}
+	var redirect *http.Server
+	if cfg.redirectAddr != "" {
+		redirect = &http.Server{
+			Addr:              cfg.redirectAddr,
+			Handler:           RedirectHandler(server.Addr),
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+		}
+	}
 
 	shutdown := make(chan os.Signal, 1)
-	fatal := make(chan error, 1)
+	fatal := make(chan error, 2)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(shutdown)
 
+	useTLS := server.TLSConfig != nil
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if useTLS {
+			// сертификат отдается через TLSConfig.GetCertificate
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			fatal <- err
 		}
 	}()
-	log.Printf("Server started at %s with settings:\n%+v\n", server.Addr, cfg)
+	if redirect != nil {
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal <- err
+			}
+		}()
+		cfg.logger.WithField("address", redirect.Addr).Info("redirecting http requests to https")
+	}
+	cfg.logger.WithFields(logrus.Fields{
+		"address":          server.Addr,
+		"tls":              useTLS,
+		"shutdown_timeout": cfg.shutdownTimeout.String(),
+		"drain_delay":      cfg.drainDelay.String(),
+	}).Info("server started")
 
 	select {
 	case err := <-fatal:
+		if redirect != nil {
+			redirect.Close() // nolint:errcheck
+		}
+		server.Close() // nolint:errcheck
 		return fmt.Errorf("failed to listen and serve due to: %w", err)
 	case <-shutdown:
-		return stopGracefully(server, &cfg)
+		drain(&cfg)
+		return stopGracefully(server, redirect, &cfg)
+	}
+}
+
+// configureTLS включает HTTPS с перечитываемым сертификатом и применяет настройки HTTP/2
+func configureTLS(server *http.Server, cfg *serverConfig) error {
+	if cfg.certFile == "" && cfg.keyFile == "" {
+		return nil
+	}
+	reloader, err := NewCertReloader(cfg.certFile, cfg.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.WithLogger(cfg.logger)
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.http2.Disabled {
+		// пустой, но не nil словарь отключает автоматическое включение HTTP/2
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+	return http2.ConfigureServer(server, &http2.Server{
+		MaxConcurrentStreams: cfg.http2.MaxConcurrentStreams,
+		IdleTimeout:          cfg.http2.IdleTimeout,
+	})
+}
+
+func drain(cfg *serverConfig) {
+	for _, hook := range cfg.drainHooks {
+		hook()
+	}
+	if cfg.drainDelay > 0 {
+		cfg.logger.WithField("drain_delay", cfg.drainDelay.String()).Info("draining the server")
+		time.Sleep(cfg.drainDelay)
 	}
 }
 
-func stopGracefully(server *http.Server, cfg *serverConfig) error {
+// stopGracefully останавливает серверы и вызывает все хуки остановки, даже если часть из них завершилась ошибкой,
+// чтобы, например, сбой остановки gRPC не помешал дождаться фоновых задач
+func stopGracefully(server, redirect *http.Server, cfg *serverConfig) error {
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
 	defer cancel()
 
-	log.Print("Stopping the server...")
+	var errs shutdownErrors
+	cfg.logger.Info("stopping the server")
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("redirect server shutdown failed due to: %w", err))
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
-		return fmt.Errorf("server shutdown failed due to: %w", err)
+		errs = append(errs, fmt.Errorf("server shutdown failed due to: %w", err))
+	} else {
+		cfg.logger.Info("stopped the server")
+	}
+	for i, hook := range cfg.shutdownHooks {
+		hookCtx, hookCancel := shareDeadline(ctx, len(cfg.shutdownHooks)-i)
+		err := hook(hookCtx)
+		hookCancel()
+		if err != nil {
+			cfg.logger.WithError(err).Error("shutdown hook failed")
+			errs = append(errs, fmt.Errorf("shutdown hook failed due to: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
 	}
-	log.Print("Stopped the server successfully")
 	return nil
 }
+
+// shareDeadline возвращает контекст с равной долей времени, оставшегося до истечения ctx,
+// поделенного между remaining хуками. Время, не использованное хуком, достается следующим
+func shareDeadline(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+}
+
+// shutdownErrors - ошибки, накопленные при остановке сервера
+type shutdownErrors []error
+
+func (errs shutdownErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is позволяет проверить с помощью errors.Is любую из накопленных ошибок
+func (errs shutdownErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
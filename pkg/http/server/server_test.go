@@ -0,0 +1,225 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/http/server"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/testing/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func httpsClient(pool *x509.CertPool) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: time.Second,
+	}
+}
+
+func certSerial(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return parsed.SerialNumber.String()
+}
+
+func TestCertReloaderPicksUpChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := certs.Generate(t, dir, "localhost")
+	reloader, err := server.NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	oldSerial := certSerial(t, cert)
+
+	// выпускаем новый сертификат на место старого
+	certs.Generate(t, dir, "localhost")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.Eventually(t, func() bool {
+		cert, err := reloader.GetCertificate(nil)
+		require.NoError(t, err)
+		return certSerial(t, cert) != oldSerial
+	}, time.Second*3, time.Millisecond*100)
+}
+
+func TestCertReloaderKeepsCertificateOnBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := certs.Generate(t, dir, "localhost")
+	reloader, err := server.NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cert, _ := reloader.GetCertificate(nil)
+	oldSerial := certSerial(t, cert)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	time.Sleep(time.Millisecond * 1100)
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, oldSerial, certSerial(t, cert))
+}
+
+func TestNewCertReloaderRequiresValidFiles(t *testing.T) {
+	_, err := server.NewCertReloader("/nonexistent/cert.pem", "/nonexistent/key.pem")
+	assert.Error(t, err)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		url       string
+		want      string
+	}{
+		{
+			"custom port", "localhost:8443",
+			"http://example.com/api/shorten?foo=bar", "https://example.com:8443/api/shorten?foo=bar",
+		},
+		{"default port", ":443", "http://example.com:8080/go", "https://example.com/go"},
+		{"ip address", "0.0.0.0:8443", "http://127.0.0.1:8080/", "https://127.0.0.1:8443/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.RedirectHandler(tt.httpsAddr).ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.url, nil))
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tt.want, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestStartServesHTTPSWithRedirect(t *testing.T) {
+	certFile, keyFile := certs.Generate(t, t.TempDir(), "127.0.0.1")
+	httpsAddr, redirectAddr := freeAddr(t), freeAddr(t)
+	svr := &http.Server{
+		Addr: httpsAddr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto)) // nolint:errcheck
+		}),
+	}
+	drained := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Start(
+			svr,
+			server.WithTLS(certFile, keyFile),
+			server.WithRedirectFromHTTP(redirectAddr),
+			server.WithDrainHook(func() { close(drained) }),
+		)
+	}()
+
+	client := httpsClient(certs.Pool(t, certFile))
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("https://" + httpsAddr + "/")
+		return err == nil
+	}, time.Second*2, time.Millisecond*20)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noFollow.Get("http://" + redirectAddr + "/go?x=1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	assert.Equal(t, "https://127.0.0.1:"+port+"/go?x=1", resp.Header.Get("Location"))
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("server did not stop")
+	}
+	<-drained
+}
+
+func TestStartWithHTTP2Disabled(t *testing.T) {
+	certFile, keyFile := certs.Generate(t, t.TempDir(), "127.0.0.1")
+	addr := freeAddr(t)
+	svr := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Start(
+			svr, server.WithTLS(certFile, keyFile), server.WithHTTP2(server.HTTP2Settings{Disabled: true}),
+		)
+	}()
+
+	client := httpsClient(certs.Pool(t, certFile))
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("https://" + addr + "/")
+		return err == nil
+	}, time.Second*2, time.Millisecond*20)
+	resp.Body.Close()
+	assert.Equal(t, 1, resp.ProtoMajor)
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	assert.NoError(t, <-stopped)
+}
+
+func TestStartFailsWithMissingCertificate(t *testing.T) {
+	svr := &http.Server{Addr: freeAddr(t)}
+	err := server.Start(svr, server.WithTLS("/nonexistent/cert.pem", "/nonexistent/key.pem"))
+	assert.Error(t, err)
+}
+
+func TestStartRunsEveryShutdownHook(t *testing.T) {
+	svr := &http.Server{Addr: freeAddr(t), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	errStuck := errors.New("stuck")
+	var drainBudget time.Duration
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Start(
+			svr,
+			server.WithShutdownTimeout(time.Millisecond*400),
+			// первый хук выбирает всю свою долю таймаута и завершается ошибкой
+			server.WithShutdownHook(func(ctx context.Context) error {
+				<-ctx.Done()
+				return errStuck
+			}),
+			server.WithShutdownHook(func(ctx context.Context) error {
+				deadline, _ := ctx.Deadline()
+				drainBudget = time.Until(deadline)
+				return nil
+			}),
+		)
+	}()
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + svr.Addr + "/") // nolint:noctx
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err == nil
+	}, time.Second*2, time.Millisecond*20)
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	err := <-stopped
+	assert.ErrorIs(t, err, errStuck)
+	// второй хук вызван несмотря на ошибку первого и получил свою долю таймаута
+	assert.Greater(t, int64(drainBudget), int64(time.Millisecond*100))
+}
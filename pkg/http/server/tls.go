@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadCheckInterval - как часто при установке соединений проверяется, не изменились ли файлы сертификата
+const reloadCheckInterval = time.Second
+
+// CertReloader отдает TLS сертификат из файлов и перечитывает их при изменении,
+// что позволяет обновлять сертификат без перезапуска сервера
+type CertReloader struct {
+	certFile  string
+	keyFile   string
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+	logger    logrus.FieldLogger
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logging.FromContext(context.Background()),
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// WithLogger задает логгер, в который пишутся результаты перечитывания сертификата
+func (reloader *CertReloader) WithLogger(logger logrus.FieldLogger) *CertReloader {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	reloader.logger = logger
+	return reloader
+}
+
+// GetCertificate подходит для использования в tls.Config.
+// Если новые файлы сертификата не удалось загрузить, продолжает отдавать прежний сертификат
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	if time.Since(reloader.checkedAt) >= reloadCheckInterval {
+		reloader.checkedAt = time.Now()
+		if modTime, err := reloader.latestModTime(); err == nil && modTime.After(reloader.modTime) {
+			logger := reloader.logger.WithField("cert_file", reloader.certFile)
+			if err := reloader.loadLocked(); err != nil {
+				logger.WithError(err).Error("failed to reload certificate")
+			} else {
+				logger.Info("reloaded certificate")
+			}
+		}
+	}
+	return reloader.cert, nil
+}
+
+func (reloader *CertReloader) load() error {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	return reloader.loadLocked()
+}
+
+func (reloader *CertReloader) loadLocked() error {
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.cert = &cert
+	reloader.modTime = modTime
+	reloader.checkedAt = time.Now()
+	return nil
+}
+
+// latestModTime возвращает время последнего изменения сертификата или ключа
+func (reloader *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, filename := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// RedirectHandler перенаправляет запросы на тот же хост по HTTPS.
+// httpsAddr - адрес HTTPS сервера, из которого берется порт; стандартный порт 443 в ссылке опускается
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		// 308 в отличие от 301 сохраняет метод и тело запроса
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+var ErrUnknownFormat = errors.New("unknown log format")
+
+type contextKey int
+
+const loggerContextKey contextKey = 0
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = logrus.StandardLogger()
+)
+
+// New создает логгер с заданными уровнем (debug, info, warn, error) и форматом записей (json, logfmt)
+func New(out io.Writer, level, format string) (*logrus.Logger, error) {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetLevel(lvl)
+	switch format {
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case FormatLogfmt:
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return nil, ErrUnknownFormat
+	}
+	return logger, nil
+}
+
+// SetDefault задает логгер, используемый вне контекста запроса.
+// Записи стандартного пакета log также перенаправляются в него, чтобы весь вывод сервиса был в одном формате
+func SetDefault(logger *logrus.Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = logger
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer())
+}
+
+func getDefault() *logrus.Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// scope хранит логгер запроса. Поля могут добавляться по мере обработки запроса,
+// например, после аутентификации пользователя, и становятся видны всем, у кого есть контекст
+type scope struct {
+	mu    sync.RWMutex
+	entry *logrus.Entry
+}
+
+// NewContext возвращает контекст с привязанным к нему логгером
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerContextKey, &scope{entry: entry})
+}
+
+// FromContext возвращает логгер, привязанный к контексту, либо логгер по умолчанию
+func FromContext(ctx context.Context) *logrus.Entry {
+	if s, ok := ctx.Value(loggerContextKey).(*scope); ok {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.entry
+	}
+	return logrus.NewEntry(getDefault())
+}
+
+// AddFields дополняет логгер контекста полями, которые попадут во все последующие его записи
+func AddFields(ctx context.Context, fields logrus.Fields) {
+	if s, ok := ctx.Value(loggerContextKey).(*scope); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.entry = s.entry.WithFields(fields)
+	}
+}
@@ -0,0 +1,74 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"json", logging.FormatJSON, `"msg":"hello"`},
+		{"logfmt", logging.FormatLogfmt, `msg=hello`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := logging.New(&buf, "info", tt.format)
+			require.NoError(t, err)
+			logger.WithField("user_id", "deadbeef").Info("hello")
+			assert.Contains(t, buf.String(), tt.want)
+			assert.Contains(t, buf.String(), "deadbeef")
+		})
+	}
+}
+
+func TestNewLoggerRespectsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "warn", logging.FormatJSON)
+	require.NoError(t, err)
+	logger.Info("skipped")
+	logger.Debug("skipped")
+	logger.Warn("written")
+	assert.NotContains(t, buf.String(), "skipped")
+	assert.Contains(t, buf.String(), "written")
+}
+
+func TestNewLoggerInvalidSettings(t *testing.T) {
+	_, err := logging.New(&bytes.Buffer{}, "info", "xml")
+	assert.ErrorIs(t, err, logging.ErrUnknownFormat)
+	_, err = logging.New(&bytes.Buffer{}, "loud", logging.FormatJSON)
+	assert.Error(t, err)
+}
+
+func TestContextLoggerCollectsFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", logging.FormatJSON)
+	require.NoError(t, err)
+	ctx := logging.NewContext(context.TODO(), logger.WithField("request_id", "req-1"))
+	logging.AddFields(ctx, logrus.Fields{"user_id": "deadbeef"})
+	logging.FromContext(ctx).Info("hello")
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "req-1", record["request_id"])
+	assert.Equal(t, "deadbeef", record["user_id"])
+	assert.Equal(t, "info", record["level"])
+}
+
+func TestFromContextWithoutLoggerReturnsDefault(t *testing.T) {
+	entry := logging.FromContext(context.TODO())
+	require.NotNil(t, entry)
+	// добавление полей в контекст без логгера ничего не делает
+	logging.AddFields(context.TODO(), logrus.Fields{"user_id": "deadbeef"})
+}
@@ -0,0 +1,460 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDiscovery         = errors.New("unable to discover oidc provider")
+	ErrTokenExchange     = errors.New("unable to exchange authorization code")
+	ErrInvalidIDToken    = errors.New("invalid id token")
+	ErrUnknownSigningKey = errors.New("id token is signed with unknown key")
+)
+
+// DefaultKeysRefreshInterval - как часто по умолчанию разрешено запрашивать ключи провайдера заново
+const DefaultKeysRefreshInterval = time.Minute
+
+// Config - настройки клиента OIDC
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// HTTPClient - клиент для запросов к провайдеру; по умолчанию http.DefaultClient
+	HTTPClient *http.Client
+	// ClockSkew - допустимое расхождение часов с провайдером при проверке срока действия токена
+	ClockSkew time.Duration
+	// KeysRefreshInterval - минимальный интервал между запросами ключей провайдера, вызванными токеном
+	// с неизвестным идентификатором ключа; по умолчанию DefaultKeysRefreshInterval
+	KeysRefreshInterval time.Duration
+}
+
+// Claims - утверждения id token, по которым сервис узнает пользователя
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Email    string
+	Audience []string
+	Nonce    string
+	IssuedAt time.Time
+	Expiry   time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider - клиент провайдера OIDC, выполняющий вход по authorization code с PKCE.
+// Настройки провайдера запрашиваются при первом обращении, так что недоступность провайдера
+// не мешает запуску сервиса
+type Provider struct {
+	cfg  Config
+	now  func() time.Time
+	mu   sync.Mutex
+	meta *discovery
+	keys map[string]*rsa.PublicKey
+	// keysFetchedAt - время последнего успешного запроса ключей
+	keysFetchedAt time.Time
+	// выполняющиеся запросы к провайдеру, результата которых ждут остальные обратившиеся
+	discovering *call
+	fetching    *call
+}
+
+// call - запрос к провайдеру, выполняемый одним из обратившихся без удержания мьютекса провайдера.
+// Остальные обратившиеся ждут его завершения, не делая собственных запросов
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+func newCall() *call {
+	return &call{done: make(chan struct{})}
+}
+
+func (c *call) finish(err error) {
+	c.err = err
+	close(c.done)
+}
+
+// wait ждет завершения запроса, но не дольше, чем позволяет контекст вызывающего
+func (c *call) wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func New(cfg Config) *Provider {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.KeysRefreshInterval == 0 {
+		cfg.KeysRefreshInterval = DefaultKeysRefreshInterval
+	}
+	return &Provider{cfg: cfg, now: time.Now}
+}
+
+// WithClock подменяет источник текущего времени, например в тестах
+func (p *Provider) WithClock(now func() time.Time) *Provider {
+	p.now = now
+	return p
+}
+
+// Issuer возвращает адрес провайдера
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// AuthCodeURL возвращает адрес страницы входа провайдера, откуда пользователь вернется на redirectURL
+// с кодом авторизации и тем же state. Провайдер положит nonce в id token, а verifier понадобится при обмене кода
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.scopes(), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange обменивает код авторизации на id token и возвращает его проверенные утверждения
+func (p *Provider) Exchange(ctx context.Context, code, redirectURL, verifier, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.doJSON(req, &tokens); err != nil {
+		return Claims{}, fmt.Errorf("%w: %s", ErrTokenExchange, err)
+	}
+	if tokens.IDToken == "" {
+		return Claims{}, fmt.Errorf("%w: no id token in response", ErrTokenExchange)
+	}
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify проверяет подпись, издателя, получателя, срок действия и nonce id token
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+	var header struct {
+		Alg   string `json:"alg"`
+		KeyID string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	// принимаем только асимметричную подпись провайдера, исключая подмену алгоритма на none или HS256
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidIDToken, header.Alg)
+	}
+	key, err := p.signingKey(ctx, meta, header.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, fmt.Errorf("%w: signature is not correct", ErrInvalidIDToken)
+	}
+	var raw rawClaims
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return Claims{}, err
+	}
+	claims := raw.claims()
+	if err := p.validate(meta, claims, nonce); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+func (p *Provider) validate(meta *discovery, claims Claims, nonce string) error {
+	switch {
+	case claims.Issuer != meta.Issuer:
+		return fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIDToken, claims.Issuer)
+	case !contains(claims.Audience, p.cfg.ClientID):
+		return fmt.Errorf("%w: token is issued for another client", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case !p.now().Before(claims.Expiry.Add(p.cfg.ClockSkew)):
+		return fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+func (p *Provider) scopes() []string {
+	scopes := []string{"openid"}
+	for _, scope := range p.cfg.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// discover запрашивает и запоминает настройки провайдера. В случае неудачи запрос повторится при следующем входе.
+// Одновременные обращения ждут результата одного запроса
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	if p.meta != nil {
+		meta := p.meta
+		p.mu.Unlock()
+		return meta, nil
+	}
+	c := p.discovering
+	if c == nil {
+		c = newCall()
+		p.discovering = c
+		p.mu.Unlock()
+		meta, err := p.fetchDiscovery(ctx)
+		p.mu.Lock()
+		if err == nil {
+			p.meta = meta
+		}
+		p.discovering = nil
+		p.mu.Unlock()
+		c.finish(err)
+	} else {
+		p.mu.Unlock()
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.meta, nil
+}
+
+func (p *Provider) fetchDiscovery(ctx context.Context) (*discovery, error) {
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDiscovery, err)
+	}
+	var meta discovery
+	if err := p.doJSON(req, &meta); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDiscovery, err)
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %s does not match configured %s", ErrDiscovery, meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete provider metadata", ErrDiscovery)
+	}
+	return &meta, nil
+}
+
+// signingKey возвращает ключ провайдера по идентификатору. Неизвестный ключ мог появиться
+// после ротации ключей провайдера, поэтому в этом случае набор ключей запрашивается заново,
+// но не чаще раза в KeysRefreshInterval, чтобы токенами с выдуманным ключом нельзя было завалить провайдера
+func (p *Provider) signingKey(ctx context.Context, meta *discovery, keyID string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	if key, ok := p.keys[keyID]; ok {
+		p.mu.Unlock()
+		return key, nil
+	}
+	c := p.fetching
+	if c == nil {
+		if p.keys != nil && p.now().Sub(p.keysFetchedAt) < p.cfg.KeysRefreshInterval {
+			p.mu.Unlock()
+			return nil, ErrUnknownSigningKey
+		}
+		c = newCall()
+		p.fetching = c
+		p.mu.Unlock()
+		p.refreshKeys(ctx, meta.JWKSURI, c)
+	} else {
+		p.mu.Unlock()
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+// refreshKeys запрашивает набор ключей провайдера и завершает запрос c
+func (p *Provider) refreshKeys(ctx context.Context, jwksURI string, c *call) {
+	keys, err := p.fetchKeys(ctx, jwksURI)
+	p.mu.Lock()
+	if err == nil {
+		p.keys = keys
+		p.keysFetchedAt = p.now()
+	}
+	p.fetching = nil
+	p.mu.Unlock()
+	c.finish(err)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.doJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to fetch provider keys: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func (p *Provider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+type rawClaims struct {
+	Issuer   string          `json:"iss"`
+	Subject  string          `json:"sub"`
+	Email    string          `json:"email"`
+	Audience json.RawMessage `json:"aud"`
+	Nonce    string          `json:"nonce"`
+	IssuedAt int64           `json:"iat"`
+	Expiry   int64           `json:"exp"`
+}
+
+func (raw rawClaims) claims() Claims {
+	// получатель токена - строка, либо список строк
+	var audience []string
+	if err := json.Unmarshal(raw.Audience, &audience); err != nil {
+		var single string
+		if json.Unmarshal(raw.Audience, &single) == nil {
+			audience = []string{single}
+		}
+	}
+	return Claims{
+		Issuer:   raw.Issuer,
+		Subject:  raw.Subject,
+		Email:    raw.Email,
+		Audience: audience,
+		Nonce:    raw.Nonce,
+		IssuedAt: time.Unix(raw.IssuedAt, 0),
+		Expiry:   time.Unix(raw.Expiry, 0),
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RandomString возвращает случайную строку для state, nonce и PKCE verifier
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,307 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/oidc"
+	oidctest "github.com/sergeii/practikum-go-url-shortener/pkg/testing/oidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/callback"
+
+func newProvider(idp *oidctest.Provider) *oidc.Provider {
+	return oidc.New(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		Scopes:       []string{"email"},
+	})
+}
+
+// authorize проходит страницу входа провайдера и возвращает код авторизации
+func authorize(t *testing.T, authURL, wantState string) string {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL) // nolint:noctx
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, wantState, location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	provider := newProvider(idp)
+	ctx := context.TODO()
+
+	authURL, err := provider.AuthCodeURL(ctx, redirectURL, "state1", "nonce1", "verifier1")
+	require.NoError(t, err)
+	params, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "openid email", params.Query().Get("scope"))
+	assert.Equal(t, "S256", params.Query().Get("code_challenge_method"))
+	assert.NotContains(t, authURL, "verifier1")
+
+	code := authorize(t, authURL, "state1")
+	claims, err := provider.Exchange(ctx, code, redirectURL, "verifier1", "nonce1")
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), claims.Issuer)
+	assert.Equal(t, "gopher", claims.Subject)
+	assert.Equal(t, "gopher@go.dev", claims.Email)
+
+	// код одноразовый
+	_, err = provider.Exchange(ctx, code, redirectURL, "verifier1", "nonce1")
+	assert.ErrorIs(t, err, oidc.ErrTokenExchange)
+}
+
+func TestExchangeRequiresMatchingVerifier(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	provider := newProvider(idp)
+	authURL, err := provider.AuthCodeURL(context.TODO(), redirectURL, "state1", "nonce1", "verifier1")
+	require.NoError(t, err)
+	code := authorize(t, authURL, "state1")
+	_, err = provider.Exchange(context.TODO(), code, redirectURL, "stolen", "nonce1")
+	assert.ErrorIs(t, err, oidc.ErrTokenExchange)
+}
+
+func TestExchangeRequiresClientSecret(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	provider := oidc.New(oidc.Config{Issuer: idp.Issuer(), ClientID: "shortener", ClientSecret: "wrong"})
+	authURL, err := provider.AuthCodeURL(context.TODO(), redirectURL, "state1", "nonce1", "verifier1")
+	require.NoError(t, err)
+	code := authorize(t, authURL, "state1")
+	_, err = provider.Exchange(context.TODO(), code, redirectURL, "verifier1", "nonce1")
+	assert.ErrorIs(t, err, oidc.ErrTokenExchange)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	other := oidctest.New(t, "shortener", "s3cr3t")
+	provider := newProvider(idp)
+
+	valid := idp.SignIDToken(t, idp.Claims("nonce1"))
+	_, err := provider.Verify(context.TODO(), valid, "nonce1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		want  error
+	}{
+		{"wrong nonce", valid, "nonce2", oidc.ErrInvalidIDToken},
+		{"malformed", "foo.bar", "nonce1", oidc.ErrInvalidIDToken},
+		{"tampered", valid[:len(valid)-4] + "AAAA", "nonce1", oidc.ErrInvalidIDToken},
+		{"unsigned", "eyJhbGciOiJub25lIn0.e30.", "nonce1", oidc.ErrInvalidIDToken},
+		{
+			"another client",
+			idp.SignIDToken(t, withClaim(idp.Claims("nonce1"), "aud", []string{"someone-else"})),
+			"nonce1",
+			oidc.ErrInvalidIDToken,
+		},
+		{
+			"another issuer",
+			idp.SignIDToken(t, withClaim(idp.Claims("nonce1"), "iss", other.Issuer())),
+			"nonce1",
+			oidc.ErrInvalidIDToken,
+		},
+		{
+			"expired",
+			idp.SignIDToken(t, withClaim(idp.Claims("nonce1"), "exp", time.Now().Add(-time.Hour).Unix())),
+			"nonce1",
+			oidc.ErrInvalidIDToken,
+		},
+		{
+			"no subject",
+			idp.SignIDToken(t, withClaim(idp.Claims("nonce1"), "sub", "")),
+			"nonce1",
+			oidc.ErrInvalidIDToken,
+		},
+		// ключ того же идентификатора, но чужого провайдера
+		{"foreign key", other.SignIDToken(t, idp.Claims("nonce1")), "nonce1", oidc.ErrInvalidIDToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.Verify(context.TODO(), tt.token, tt.nonce)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestVerifyAudienceList(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	provider := newProvider(idp)
+	token := idp.SignIDToken(t, withClaim(idp.Claims("nonce1"), "aud", []string{"other", "shortener"}))
+	claims, err := provider.Verify(context.TODO(), token, "nonce1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "shortener"}, claims.Audience)
+}
+
+func TestVerifyHonorsClock(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	token := idp.SignIDToken(t, idp.Claims("nonce1"))
+	provider := newProvider(idp).WithClock(func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	})
+	_, err := provider.Verify(context.TODO(), token, "nonce1")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
+
+func TestDiscoveryFailure(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	provider := oidc.New(oidc.Config{Issuer: idp.Issuer() + "/missing", ClientID: "shortener"})
+	_, err := provider.AuthCodeURL(context.TODO(), redirectURL, "state1", "nonce1", "verifier1")
+	assert.ErrorIs(t, err, oidc.ErrDiscovery)
+
+	// провайдер, сообщающий чужой адрес, также отвергается
+	provider = oidc.New(oidc.Config{Issuer: idp.Issuer() + "/", ClientID: "shortener"})
+	_, err = provider.AuthCodeURL(context.TODO(), redirectURL, "state1", "nonce1", "verifier1")
+	assert.ErrorIs(t, err, oidc.ErrDiscovery)
+}
+
+// countingTransport считает запросы к провайдеру по пути
+type countingTransport struct {
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ct.mu.Lock()
+	ct.requests[req.URL.Path]++
+	ct.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (ct *countingTransport) count(path string) int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.requests[path]
+}
+
+func TestConcurrentVerifyFetchesProviderOnce(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	transport := &countingTransport{requests: make(map[string]int)}
+	provider := oidc.New(oidc.Config{
+		Issuer:     idp.Issuer(),
+		ClientID:   idp.ClientID,
+		HTTPClient: &http.Client{Transport: transport},
+	})
+	token := idp.SignIDToken(t, idp.Claims("nonce1"))
+
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.Verify(context.TODO(), token, "nonce1"); err != nil {
+				atomic.AddInt32(&failed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&failed))
+	assert.Equal(t, 1, transport.count("/.well-known/openid-configuration"))
+	assert.Equal(t, 1, transport.count("/jwks"))
+}
+
+// stallingTransport задерживает запросы ключей провайдера до закрытия release
+type stallingTransport struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (st *stallingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/jwks" {
+		close(st.started)
+		<-st.release
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSlowKeyFetchDoesNotBlockProvider(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	transport := &stallingTransport{started: make(chan struct{}), release: make(chan struct{})}
+	provider := oidc.New(oidc.Config{
+		Issuer:     idp.Issuer(),
+		ClientID:   idp.ClientID,
+		HTTPClient: &http.Client{Transport: transport},
+	})
+	verified := make(chan error, 1)
+	go func() {
+		_, err := provider.Verify(context.TODO(), idp.SignIDToken(t, idp.Claims("nonce1")), "nonce1")
+		verified <- err
+	}()
+	<-transport.started
+
+	// пока ключи запрашиваются, вход других пользователей не ждет этого запроса
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := provider.AuthCodeURL(ctx, redirectURL, "state1", "nonce1", "verifier1")
+	assert.NoError(t, err)
+	// ожидающий запроса ключей уходит по истечении своего контекста
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+	_, err = provider.Verify(expired, idp.SignIDToken(t, idp.Claims("nonce1")), "nonce1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(transport.release)
+	assert.NoError(t, <-verified)
+}
+
+func TestUnknownKeyRefetchIsRateLimited(t *testing.T) {
+	idp := oidctest.New(t, "shortener", "s3cr3t")
+	transport := &countingTransport{requests: make(map[string]int)}
+	now := time.Now()
+	provider := oidc.New(oidc.Config{
+		Issuer:     idp.Issuer(),
+		ClientID:   idp.ClientID,
+		HTTPClient: &http.Client{Transport: transport},
+	}).WithClock(func() time.Time {
+		return now
+	})
+	_, err := provider.Verify(context.TODO(), idp.SignIDToken(t, idp.Claims("nonce1")), "nonce1")
+	require.NoError(t, err)
+	require.Equal(t, 1, transport.count("/jwks"))
+
+	// токен с выдуманным ключом не заставляет запрашивать ключи при каждой проверке
+	valid := strings.Split(idp.SignIDToken(t, idp.Claims("nonce1")), ".")
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"forged"}`))
+	forged := header + "." + valid[1] + "." + valid[2]
+	for i := 0; i < 3; i++ {
+		_, err = provider.Verify(context.TODO(), forged, "nonce1")
+		assert.ErrorIs(t, err, oidc.ErrUnknownSigningKey)
+	}
+	assert.Equal(t, 1, transport.count("/jwks"))
+
+	// но по прошествии интервала ключи запрашиваются заново, например после ротации у провайдера
+	now = now.Add(oidc.DefaultKeysRefreshInterval)
+	_, err = provider.Verify(context.TODO(), forged, "nonce1")
+	assert.ErrorIs(t, err, oidc.ErrUnknownSigningKey)
+	assert.Equal(t, 2, transport.count("/jwks"))
+	_, err = provider.Verify(context.TODO(), forged, "nonce1")
+	assert.ErrorIs(t, err, oidc.ErrUnknownSigningKey)
+	assert.Equal(t, 2, transport.count("/jwks"))
+}
+
+func withClaim(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	claims[name] = value
+	return claims
+}
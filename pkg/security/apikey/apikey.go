@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Prefix отличает ключи API от прочих токенов и облегчает их поиск в логах и репозиториях
+const Prefix = "sk"
+
+const (
+	idLength     = 8  // in bytes
+	secretLength = 32 // in bytes
+)
+
+var ErrMalformedKey = errors.New("malformed api key")
+
+// Key - ключ API вида sk_<id>_<secret>. Идентификатор позволяет найти ключ в хранилище,
+// секрет же хранится только в виде хеша и показывается владельцу единожды при создании ключа
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Generate создает новый ключ со случайными идентификатором и секретом
+func Generate() (Key, error) {
+	id := make([]byte, idLength)
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+	return Key{
+		ID:     hex.EncodeToString(id),
+		Secret: base64.RawURLEncoding.EncodeToString(secret),
+	}, nil
+}
+
+func (k Key) String() string {
+	return Prefix + "_" + k.ID + "_" + k.Secret
+}
+
+// Hash возвращает хеш секрета ключа для хранения.
+// Секрет обладает достаточной энтропией, поэтому медленная функция хеширования не нужна
+func (k Key) Hash() string {
+	sum := sha256.Sum256([]byte(k.Secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches сравнивает секрет ключа с сохраненным хешем за постоянное время
+func (k Key) Matches(hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.Hash()), []byte(hash)) == 1
+}
+
+// Parse разбирает ключ, предъявленный клиентом
+func Parse(s string) (Key, error) {
+	parts := strings.Split(s, "_")
+	// секрет в base64url может сам содержать подчеркивания
+	if len(parts) < 3 || parts[0] != Prefix {
+		return Key{}, ErrMalformedKey
+	}
+	key := Key{ID: parts[1], Secret: strings.Join(parts[2:], "_")}
+	if len(key.ID) != idLength*2 || key.Secret == "" {
+		return Key{}, ErrMalformedKey
+	}
+	if _, err := hex.DecodeString(key.ID); err != nil {
+		return Key{}, ErrMalformedKey
+	}
+	return key, nil
+}
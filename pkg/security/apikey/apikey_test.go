@@ -0,0 +1,46 @@
+package apikey_test
+
+import (
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/apikey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedKeyRoundTrips(t *testing.T) {
+	key, err := apikey.Generate()
+	require.NoError(t, err)
+	parsed, err := apikey.Parse(key.String())
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+	assert.True(t, parsed.Matches(key.Hash()))
+
+	other, err := apikey.Generate()
+	require.NoError(t, err)
+	assert.NotEqual(t, key.ID, other.ID)
+	assert.False(t, other.Matches(key.Hash()))
+}
+
+func TestParseSecretWithUnderscores(t *testing.T) {
+	key, err := apikey.Parse("sk_0123456789abcdef_foo_bar")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", key.ID)
+	assert.Equal(t, "foo_bar", key.Secret)
+}
+
+func TestParseMalformedKey(t *testing.T) {
+	tests := []string{
+		"",
+		"sk_0123456789abcdef",
+		"sk_0123456789abcdef_",
+		"pk_0123456789abcdef_secret",
+		"sk_0123456789_secret",
+		"sk_0123456789abcdeg_secret",
+		"user1:Zm9vYmFy",
+	}
+	for _, tt := range tests {
+		_, err := apikey.Parse(tt)
+		assert.ErrorIs(t, err, apikey.ErrMalformedKey, tt)
+	}
+}
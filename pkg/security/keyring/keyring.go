@@ -0,0 +1,180 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/sign"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/token"
+)
+
+var (
+	ErrNoKeys       = errors.New("keyring has no keys")
+	ErrMalformedKey = errors.New("malformed key")
+	ErrDuplicateKey = errors.New("duplicate key id")
+)
+
+// Key - секретный ключ с идентификатором, по которому его можно найти при проверке подписи
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring - набор ключей, из которых первый используется для подписи,
+// а остальные (например, ключи до ротации) принимаются только при проверке
+type Keyring struct {
+	keys      []Key
+	byID      map[string][]byte
+	ephemeral bool
+}
+
+// New создает набор ключей, где active - ключ для подписи
+func New(active Key, others ...Key) (*Keyring, error) {
+	keys := append([]Key{active}, others...)
+	byID := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, ErrMalformedKey
+		}
+		if _, ok := byID[key.ID]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateKey, key.ID)
+		}
+		byID[key.ID] = key.Secret
+	}
+	return &Keyring{keys: keys, byID: byID}, nil
+}
+
+// Single создает набор из единственного ключа, идентификатором которого служит его отпечаток
+func Single(secret []byte) *Keyring {
+	return &Keyring{
+		keys: []Key{{ID: token.Fingerprint(secret), Secret: secret}},
+		byID: map[string][]byte{token.Fingerprint(secret): secret},
+	}
+}
+
+// Ephemeral создает набор из случайного ключа, живущего не дольше процесса
+func Ephemeral(length int) (*Keyring, error) {
+	secret := make([]byte, length)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	kr := Single(secret)
+	kr.ephemeral = true
+	return kr, nil
+}
+
+// Parse разбирает набор ключей вида id1:hex1,id2:hex2, где первый ключ - активный.
+// Одиночный ключ без идентификатора получает идентификатор по отпечатку
+func Parse(s string) (*Keyring, error) {
+	return parse(strings.Split(s, ","))
+}
+
+// Load читает набор ключей из файла, по одному ключу id:hex на строку.
+// Первый ключ в файле - активный; пустые строки и строки, начинающиеся с #, пропускаются
+func Load(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read читает набор ключей в формате Load
+func Read(r io.Reader) (*Keyring, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parse(lines)
+}
+
+func parse(items []string) (*Keyring, error) {
+	keys := make([]Key, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, err := parseKey(item)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return New(keys[0], keys[1:]...)
+}
+
+func parseKey(item string) (Key, error) {
+	id, secretHex := "", item
+	if i := strings.Index(item, ":"); i >= 0 {
+		id, secretHex = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if id == "" {
+			return Key{}, ErrMalformedKey
+		}
+	}
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil || len(secret) == 0 {
+		return Key{}, ErrMalformedKey
+	}
+	if id == "" {
+		id = token.Fingerprint(secret)
+	}
+	return Key{ID: id, Secret: secret}, nil
+}
+
+// Active возвращает ключ для подписи
+func (kr *Keyring) Active() Key {
+	return kr.keys[0]
+}
+
+// Lookup возвращает секрет ключа по его идентификатору
+func (kr *Keyring) Lookup(id string) ([]byte, bool) {
+	secret, ok := kr.byID[id]
+	return secret, ok
+}
+
+// Keys возвращает все ключи, начиная с активного
+func (kr *Keyring) Keys() []Key {
+	keys := make([]Key, len(kr.keys))
+	copy(keys, kr.keys)
+	return keys
+}
+
+// IsEphemeral сообщает, что ключ сгенерирован на время жизни процесса
+// и подписанные им токены не переживут перезапуск
+func (kr *Keyring) IsEphemeral() bool {
+	return kr.ephemeral
+}
+
+// Signer возвращает подписывающего HMAC-SHA256 активным ключом
+func (kr *Keyring) Signer() *sign.Signer {
+	active := kr.Active()
+	return sign.NewWithAlgorithm(sign.NewHMACSHA256(active.Secret), active.ID)
+}
+
+// Verifier возвращает проверяющего подписи, сделанные любым ключом из набора
+func (kr *Keyring) Verifier() *sign.Verifier {
+	signers := make([]*sign.Signer, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		signers = append(signers, sign.NewWithAlgorithm(sign.NewHMACSHA256(key.Secret), key.ID))
+	}
+	return sign.NewVerifier(signers...)
+}
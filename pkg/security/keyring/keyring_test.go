@@ -0,0 +1,101 @@
+package keyring_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/keyring"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/sign"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	kr, err := keyring.Parse("2022-05:00112233, 2022-01:aabbccdd")
+	require.NoError(t, err)
+	assert.Equal(t, keyring.Key{ID: "2022-05", Secret: []byte{0x00, 0x11, 0x22, 0x33}}, kr.Active())
+	secret, ok := kr.Lookup("2022-01")
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0xaa, 0xbb, 0xcc, 0xdd}, secret)
+	_, ok = kr.Lookup("2021-12")
+	assert.False(t, ok)
+	assert.Len(t, kr.Keys(), 2)
+	assert.False(t, kr.IsEphemeral())
+}
+
+func TestParseSingleKeyWithoutID(t *testing.T) {
+	kr, err := keyring.Parse("00112233")
+	require.NoError(t, err)
+	assert.Equal(t, token.Fingerprint([]byte{0x00, 0x11, 0x22, 0x33}), kr.Active().ID)
+	assert.Equal(t, keyring.Single([]byte{0x00, 0x11, 0x22, 0x33}).Active(), kr.Active())
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  error
+	}{
+		{"empty", "", keyring.ErrNoKeys},
+		{"only separators", " , ", keyring.ErrNoKeys},
+		{"not hex", "k1:foobar", keyring.ErrMalformedKey},
+		{"empty secret", "k1:", keyring.ErrMalformedKey},
+		{"empty id", ":00112233", keyring.ErrMalformedKey},
+		{"duplicate id", "k1:0011,k1:2233", keyring.ErrDuplicateKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keyring.Parse(tt.value)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := strings.Join([]string{
+		"# текущий ключ",
+		"k2:00112233",
+		"",
+		"# ключ до ротации",
+		"k1:aabbccdd",
+	}, "\n")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	kr, err := keyring.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", kr.Active().ID)
+	_, ok := kr.Lookup("k1")
+	assert.True(t, ok)
+
+	_, err = keyring.Load(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEphemeral(t *testing.T) {
+	kr, err := keyring.Ephemeral(32)
+	require.NoError(t, err)
+	assert.True(t, kr.IsEphemeral())
+	assert.Len(t, kr.Active().Secret, 32)
+	other, err := keyring.Ephemeral(32)
+	require.NoError(t, err)
+	assert.NotEqual(t, kr.Active(), other.Active())
+}
+
+func TestSignerAndVerifierAcrossRotation(t *testing.T) {
+	old, err := keyring.Parse("2022-01:aabbccdd")
+	require.NoError(t, err)
+	rotated, err := keyring.Parse("2022-05:00112233,2022-01:aabbccdd")
+	require.NoError(t, err)
+
+	oldSig := old.Signer().SignPrefixed([]byte("data"))
+	newSig := rotated.Signer().SignPrefixed([]byte("data"))
+	assert.True(t, strings.HasPrefix(newSig, "hs256:2022-05:"))
+	assert.NoError(t, rotated.Verifier().Verify([]byte("data"), oldSig))
+	assert.NoError(t, rotated.Verifier().Verify([]byte("data"), newSig))
+	assert.ErrorIs(t, old.Verifier().Verify([]byte("data"), newSig), sign.ErrUnknownKey)
+	assert.ErrorIs(t, rotated.Verifier().Verify([]byte("other"), oldSig), sign.ErrInvalidSignature)
+}
@@ -0,0 +1,54 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := password.Hash("correct horse battery staple")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	ok, err := password.Verify("correct horse battery staple", encoded)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = password.Verify("correct horse battery stapler", encoded)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// одинаковые пароли дают разные хеши за счет соли
+	other, err := password.Hash("correct horse battery staple")
+	require.NoError(t, err)
+	assert.NotEqual(t, encoded, other)
+}
+
+func TestVerifyUsesParamsFromHash(t *testing.T) {
+	weak := password.Params{Memory: 1024, Time: 1, Threads: 1, SaltLength: 8, KeyLength: 16}
+	encoded, err := password.HashWithParams("secret", weak)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	ok, err := password.Verify("secret", encoded)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestVerifyMalformedHash(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"secret",
+		"$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=foo,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$",
+	} {
+		_, err := password.Verify("secret", encoded)
+		assert.ErrorIs(t, err, password.ErrMalformedHash, encoded)
+	}
+}
@@ -1,40 +1,129 @@
 package sign
 
 import (
-	"bytes"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
+	"errors"
 	"hash"
+	"strings"
+	"sync"
 )
 
+// Названия алгоритмов, указываемые в префиксе подписи
+const (
+	HMACSHA256 = "hs256"
+	HMACSHA512 = "hs512"
+	Ed25519    = "ed25519"
+)
+
+var (
+	ErrMalformedSignature = errors.New("malformed signature")
+	ErrUnknownKey         = errors.New("signature is made with unknown key")
+	ErrInvalidSignature   = errors.New("signature is not correct")
+)
+
+// Algorithm подписывает данные и проверяет подписи. Реализации безопасны для использования из нескольких горутин
+type Algorithm interface {
+	Name() string
+	Sign(data []byte) []byte
+	Verify(data, signature []byte) bool
+}
+
+type hmacAlgorithm struct {
+	name string
+	// hash.Hash не допускает конкурентного использования, поэтому каждая подпись берет свой экземпляр из пула
+	pool sync.Pool
+}
+
+// NewHMACSHA256 возвращает алгоритм HMAC-SHA256 с секретом secret
+func NewHMACSHA256(secret []byte) Algorithm {
+	return newHMAC(HMACSHA256, sha256.New, secret)
+}
+
+// NewHMACSHA512 возвращает алгоритм HMAC-SHA512 с секретом secret
+func NewHMACSHA512(secret []byte) Algorithm {
+	return newHMAC(HMACSHA512, sha512.New, secret)
+}
+
+func newHMAC(name string, h func() hash.Hash, secret []byte) *hmacAlgorithm {
+	alg := &hmacAlgorithm{name: name}
+	alg.pool.New = func() interface{} {
+		return hmac.New(h, secret)
+	}
+	return alg
+}
+
+func (alg *hmacAlgorithm) Name() string {
+	return alg.name
+}
+
+func (alg *hmacAlgorithm) Sign(data []byte) []byte {
+	mac := alg.pool.Get().(hash.Hash) // nolint:forcetypeassert
+	defer alg.pool.Put(mac)
+	mac.Reset()
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Verify сравнивает подписи за постоянное время, не раскрывая через тайминги совпавший префикс подписи
+func (alg *hmacAlgorithm) Verify(data, signature []byte) bool {
+	return hmac.Equal(alg.Sign(data), signature)
+}
+
+type ed25519Algorithm struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// NewEd25519 возвращает алгоритм Ed25519 с закрытым ключом private
+func NewEd25519(private ed25519.PrivateKey) Algorithm {
+	return &ed25519Algorithm{
+		private: private,
+		public:  private.Public().(ed25519.PublicKey), // nolint:forcetypeassert
+	}
+}
+
+func (alg *ed25519Algorithm) Name() string {
+	return Ed25519
+}
+
+func (alg *ed25519Algorithm) Sign(data []byte) []byte {
+	return ed25519.Sign(alg.private, data)
+}
+
+func (alg *ed25519Algorithm) Verify(data, signature []byte) bool {
+	return ed25519.Verify(alg.public, data, signature)
+}
+
+// Signer подписывает данные алгоритмом, привязанным к ключу с идентификатором
 type Signer struct {
-	secret []byte
-	hasher hash.Hash
+	alg   Algorithm
+	keyID string
 }
 
+// New возвращает подписывающего HMAC-SHA256 с секретом secret и пустым идентификатором ключа
 func New(secret []byte) *Signer {
-	return &Signer{
-		secret: secret,
-		hasher: hmac.New(sha256.New, secret),
-	}
+	return NewWithAlgorithm(NewHMACSHA256(secret), "")
+}
+
+// NewWithAlgorithm возвращает подписывающего алгоритмом alg ключом с идентификатором keyID
+func NewWithAlgorithm(alg Algorithm, keyID string) *Signer {
+	return &Signer{alg: alg, keyID: keyID}
 }
 
 func (s *Signer) Sign(data []byte) []byte {
-	s.hasher.Write(data)
-	signature := s.hasher.Sum(nil)
-	s.hasher.Reset()
-	return signature
+	return s.alg.Sign(data)
 }
 
 func (s *Signer) Sign64(data []byte) string {
-	signature := s.Sign(data)
-	return base64.StdEncoding.EncodeToString(signature)
+	return base64.StdEncoding.EncodeToString(s.Sign(data))
 }
 
 func (s *Signer) Verify(data, actualSig []byte) bool {
-	expectedSig := s.Sign(data)
-	return bytes.Equal(expectedSig, actualSig)
+	return s.alg.Verify(data, actualSig)
 }
 
 func (s *Signer) Verify64(data []byte, actualSig64 string) (bool, error) {
@@ -42,6 +131,44 @@ func (s *Signer) Verify64(data []byte, actualSig64 string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	expectedSig := s.Sign(data)
-	return bytes.Equal(expectedSig, actualSig), nil
+	return s.Verify(data, actualSig), nil
+}
+
+// SignPrefixed возвращает подпись вида алгоритм:идентификатор ключа:подпись в base64 (url-safe),
+// по префиксу которой Verifier выбирает ключ для проверки
+func (s *Signer) SignPrefixed(data []byte) string {
+	return s.alg.Name() + ":" + s.keyID + ":" + base64.RawURLEncoding.EncodeToString(s.Sign(data))
+}
+
+// Verifier проверяет подписи с префиксом, выбирая подписывающего по алгоритму и идентификатору ключа
+type Verifier struct {
+	signers map[string]*Signer
+}
+
+func NewVerifier(signers ...*Signer) *Verifier {
+	v := &Verifier{signers: make(map[string]*Signer, len(signers))}
+	for _, s := range signers {
+		v.signers[s.alg.Name()+":"+s.keyID] = s
+	}
+	return v
+}
+
+// Verify проверяет подпись, полученную методом Signer.SignPrefixed
+func (v *Verifier) Verify(data []byte, signature string) error {
+	i := strings.LastIndex(signature, ":")
+	if i < 0 || strings.Count(signature, ":") != 2 {
+		return ErrMalformedSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature[i+1:])
+	if err != nil {
+		return ErrMalformedSignature
+	}
+	s, ok := v.signers[signature[:i]]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !s.Verify(data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
 }
@@ -0,0 +1,147 @@
+package sign_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/sign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func algorithms(t testing.TB) []sign.Algorithm {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return []sign.Algorithm{
+		sign.NewHMACSHA256(secret),
+		sign.NewHMACSHA512(secret),
+		sign.NewEd25519(private),
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, alg := range algorithms(t) {
+		t.Run(alg.Name(), func(t *testing.T) {
+			signer := sign.NewWithAlgorithm(alg, "k1")
+			sig := signer.Sign([]byte("user1"))
+			assert.True(t, signer.Verify([]byte("user1"), sig))
+			assert.False(t, signer.Verify([]byte("user2"), sig))
+			assert.False(t, signer.Verify([]byte("user1"), sig[:len(sig)-1]))
+			assert.False(t, signer.Verify([]byte("user1"), nil))
+
+			ok, err := signer.Verify64([]byte("user1"), signer.Sign64([]byte("user1")))
+			require.NoError(t, err)
+			assert.True(t, ok)
+			_, err = signer.Verify64([]byte("user1"), "!!!")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewIsCompatibleWithHMACSHA256(t *testing.T) {
+	// подписи, выданные до появления выбора алгоритмов, остаются действительными
+	assert.Equal(t,
+		"w3WTM4sBSTNG6FWXdD3Q0GUNqYtPeNlCgsyF/pgzxow=",
+		sign.New(secret).Sign64([]byte("user1")),
+	)
+}
+
+func TestSignerIsSafeForConcurrentUse(t *testing.T) {
+	for _, alg := range algorithms(t) {
+		signer := sign.NewWithAlgorithm(alg, "k1")
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					data := []byte(fmt.Sprintf("user%d-%d", i, j))
+					assert.True(t, signer.Verify(data, signer.Sign(data)))
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+func TestVerifierSelectsKeyByPrefix(t *testing.T) {
+	oldSigner := sign.NewWithAlgorithm(sign.NewHMACSHA256([]byte("old")), "k1")
+	newSigner := sign.NewWithAlgorithm(sign.NewHMACSHA512([]byte("new")), "k2")
+	verifier := sign.NewVerifier(oldSigner, newSigner)
+
+	oldSig := oldSigner.SignPrefixed([]byte("user1"))
+	newSig := newSigner.SignPrefixed([]byte("user1"))
+	assert.True(t, strings.HasPrefix(oldSig, "hs256:k1:"))
+	assert.True(t, strings.HasPrefix(newSig, "hs512:k2:"))
+	assert.NoError(t, verifier.Verify([]byte("user1"), oldSig))
+	assert.NoError(t, verifier.Verify([]byte("user1"), newSig))
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	unknown := sign.NewWithAlgorithm(sign.NewEd25519(private), "k1").SignPrefixed([]byte("user1"))
+	sigPart := newSig[strings.LastIndex(newSig, ":")+1:]
+
+	tests := []struct {
+		name      string
+		data      string
+		signature string
+		want      error
+	}{
+		{"other data", "user2", newSig, sign.ErrInvalidSignature},
+		{"unknown algorithm for key", "user1", unknown, sign.ErrUnknownKey},
+		{"swapped key id", "user1", "hs512:k1:" + sigPart, sign.ErrUnknownKey},
+		{"signature of other key", "user1", "hs256:k1:" + sigPart, sign.ErrInvalidSignature},
+		{"no prefix", "user1", sigPart, sign.ErrMalformedSignature},
+		{"extra separator", "user1", "hs512:k2:x:" + sigPart, sign.ErrMalformedSignature},
+		{"bad encoding", "user1", "hs512:k2:!!!", sign.ErrMalformedSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, verifier.Verify([]byte(tt.data), tt.signature), tt.want)
+		})
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	data := []byte("0123456789abcdef")
+	for _, alg := range algorithms(b) {
+		signer := sign.NewWithAlgorithm(alg, "k1")
+		b.Run(alg.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				signer.Sign(data)
+			}
+		})
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	data := []byte("0123456789abcdef")
+	for _, alg := range algorithms(b) {
+		signer := sign.NewWithAlgorithm(alg, "k1")
+		sig := signer.Sign(data)
+		b.Run(alg.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				signer.Verify(data, sig)
+			}
+		})
+	}
+}
+
+func BenchmarkSignParallel(b *testing.B) {
+	data := []byte("0123456789abcdef")
+	signer := sign.New(secret)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			signer.Sign(data)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/sign"
+)
+
+// Токен совместим с JWT: заголовок и утверждения в json, подписанные HMAC-SHA256
+const (
+	algorithm = "HS256"
+	tokenType = "JWT"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnknownKey       = errors.New("token is signed with unknown key")
+	ErrInvalidSignature = errors.New("token signature is not correct")
+	ErrExpired          = errors.New("token has expired")
+)
+
+type header struct {
+	Alg   string `json:"alg"`
+	Typ   string `json:"typ"`
+	KeyID string `json:"kid"`
+}
+
+type claims struct {
+	Subject   string `json:"sub"`
+	Kind      string `json:"kind,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Token - утверждения токена: кому, когда и до какого времени он выдан, и каким ключом подписан.
+// Kind - произвольный тип субъекта, например способ, которым пользователь вошел в сервис
+type Token struct {
+	KeyID     string
+	Subject   string
+	Kind      string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// KeyFunc возвращает секретный ключ по его идентификатору
+type KeyFunc func(keyID string) ([]byte, bool)
+
+var encoding = base64.RawURLEncoding
+
+// Fingerprint возвращает идентификатор ключа, не раскрывающий сам ключ
+func Fingerprint(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Issue подписывает токен ключом secret
+func Issue(secret []byte, t Token) (string, error) {
+	h, err := json.Marshal(header{Alg: algorithm, Typ: tokenType, KeyID: t.KeyID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims{
+		Subject:   t.Subject,
+		Kind:      t.Kind,
+		IssuedAt:  t.IssuedAt.Unix(),
+		ExpiresAt: t.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	signature := sign.New(secret).Sign([]byte(unsigned))
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+// Parse проверяет подпись и срок действия токена на момент now и возвращает его утверждения
+func Parse(s string, keys KeyFunc, now time.Time) (Token, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return Token{}, ErrMalformedToken
+	}
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return Token{}, err
+	}
+	if h.Alg != algorithm {
+		return Token{}, ErrMalformedToken
+	}
+	secret, ok := keys(h.KeyID)
+	if !ok {
+		return Token{}, ErrUnknownKey
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return Token{}, ErrMalformedToken
+	}
+	if !sign.New(secret).Verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return Token{}, ErrInvalidSignature
+	}
+	var c claims
+	if err := decodePart(parts[1], &c); err != nil {
+		return Token{}, err
+	}
+	if c.Subject == "" {
+		return Token{}, ErrMalformedToken
+	}
+	t := Token{
+		KeyID:     h.KeyID,
+		Subject:   c.Subject,
+		Kind:      c.Kind,
+		IssuedAt:  time.Unix(c.IssuedAt, 0),
+		ExpiresAt: time.Unix(c.ExpiresAt, 0),
+	}
+	if !now.Before(t.ExpiresAt) {
+		return Token{}, ErrExpired
+	}
+	return t, nil
+}
+
+func decodePart(part string, v interface{}) error {
+	raw, err := encoding.DecodeString(part)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package token_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/security/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	secret = []byte("0123456789abcdef0123456789abcdef")
+	other  = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func keys(keyID string) ([]byte, bool) {
+	switch keyID {
+	case token.Fingerprint(secret):
+		return secret, true
+	case token.Fingerprint(other):
+		return other, true
+	default:
+		return nil, false
+	}
+}
+
+func issue(t *testing.T, key []byte, issuedAt time.Time) string {
+	s, err := token.Issue(key, token.Token{
+		KeyID:     token.Fingerprint(key),
+		Subject:   "user1",
+		Kind:      "anonymous",
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestIssueAndParse(t *testing.T) {
+	issuedAt := time.Unix(1650000000, 0)
+	s := issue(t, secret, issuedAt)
+
+	// заголовок читается любой библиотекой JWT
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(s, ".")[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"alg":"HS256","typ":"JWT","kid":"`+token.Fingerprint(secret)+`"}`, string(header))
+
+	parsed, err := token.Parse(s, keys, issuedAt.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, token.Token{
+		KeyID:     token.Fingerprint(secret),
+		Subject:   "user1",
+		Kind:      "anonymous",
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(time.Hour),
+	}, parsed)
+}
+
+func TestParseRejectsExpiredToken(t *testing.T) {
+	issuedAt := time.Unix(1650000000, 0)
+	s := issue(t, secret, issuedAt)
+	_, err := token.Parse(s, keys, issuedAt.Add(time.Hour))
+	assert.ErrorIs(t, err, token.ErrExpired)
+}
+
+func TestParseRejectsInvalidTokens(t *testing.T) {
+	now := time.Now()
+	valid := issue(t, secret, now)
+	parts := strings.Split(valid, ".")
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","iat":1,"exp":9999999999}`))
+	// токен, подписанный другим известным ключом, но с подменой идентификатора ключа
+	forgedParts := strings.Split(issue(t, other, now), ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"empty", "", token.ErrMalformedToken},
+		{"legacy cookie", "user1:Zm9vYmFy", token.ErrMalformedToken},
+		{"bad header", "foo." + parts[1] + "." + parts[2], token.ErrMalformedToken},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!!!", token.ErrMalformedToken},
+		{"tampered claims", parts[0] + "." + tampered + "." + parts[2], token.ErrInvalidSignature},
+		{"swapped key id", parts[0] + "." + forgedParts[1] + "." + forgedParts[2], token.ErrInvalidSignature},
+		{"unknown key", issue(t, []byte("unknown"), now), token.ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := token.Parse(tt.token, keys, now)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found in the storage")
+
+// APIKeyRecord - ключ API пользователя. Секрет ключа хранится только в виде хеша
+type APIKeyRecord struct {
+	ID        string
+	UserID    string
+	Hash      string
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt time.Time
+}
+
+func (r APIKeyRecord) IsRevoked() bool {
+	return !r.RevokedAt.IsZero()
+}
+
+type APIKeyStorer interface {
+	SaveAPIKey(context.Context, APIKeyRecord) error
+	// GetAPIKey возвращает ключ, в том числе отозванный, либо ErrAPIKeyNotFound
+	GetAPIKey(context.Context, string) (APIKeyRecord, error)
+	// ListAPIKeys возвращает действующие ключи пользователя в порядке их создания
+	ListAPIKeys(context.Context, string) ([]APIKeyRecord, error)
+	// RevokeAPIKey отзывает действующий ключ пользователя, либо возвращает ErrAPIKeyNotFound
+	RevokeAPIKey(context.Context, string, string) error
+	Cleanup()
+}
+
+type LocmemAPIKeyStorerBackend struct {
+	keys map[string]APIKeyRecord
+	mu   sync.RWMutex
+}
+
+func NewLocmemAPIKeyStorerBackend() *LocmemAPIKeyStorerBackend {
+	return &LocmemAPIKeyStorerBackend{keys: make(map[string]APIKeyRecord)}
+}
+
+func (backend *LocmemAPIKeyStorerBackend) SaveAPIKey(ctx context.Context, record APIKeyRecord) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.keys[record.ID] = record
+	return nil
+}
+
+func (backend *LocmemAPIKeyStorerBackend) GetAPIKey(ctx context.Context, keyID string) (APIKeyRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	record, found := backend.keys[keyID]
+	if !found {
+		return APIKeyRecord{}, ErrAPIKeyNotFound
+	}
+	return record, nil
+}
+
+func (backend *LocmemAPIKeyStorerBackend) ListAPIKeys(ctx context.Context, userID string) ([]APIKeyRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	records := make([]APIKeyRecord, 0)
+	for _, record := range backend.keys {
+		if record.UserID == userID && !record.IsRevoked() {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+func (backend *LocmemAPIKeyStorerBackend) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	record, found := backend.keys[keyID]
+	if !found || record.UserID != userID || record.IsRevoked() {
+		return ErrAPIKeyNotFound
+	}
+	record.RevokedAt = time.Now()
+	backend.keys[keyID] = record
+	return nil
+}
+
+func (backend *LocmemAPIKeyStorerBackend) Cleanup() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.keys = make(map[string]APIKeyRecord)
+}
+
+// snapshot возвращает копию всех ключей, в том числе отозванных
+func (backend *LocmemAPIKeyStorerBackend) snapshot() []APIKeyRecord {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	records := make([]APIKeyRecord, 0, len(backend.keys))
+	for _, record := range backend.keys {
+		records = append(records, record)
+	}
+	return records
+}
+
+// restore заменяет все ключи ключами из копии
+func (backend *LocmemAPIKeyStorerBackend) restore(records []APIKeyRecord) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.keys = make(map[string]APIKeyRecord, len(records))
+	for _, record := range records {
+		backend.keys[record.ID] = record
+	}
+}
+
+// FileAPIKeyStorerBackend хранит ключи API в памяти и записывает их на диск при каждом изменении.
+// Используется вместе с файловым хранилищем ссылок, чтобы выданные ключи переживали перезапуск сервиса
+type FileAPIKeyStorerBackend struct {
+	*LocmemAPIKeyStorerBackend
+	filename string
+	mu       sync.Mutex
+}
+
+func NewFileAPIKeyStorerBackend(filename string) (*FileAPIKeyStorerBackend, error) {
+	backend := &FileAPIKeyStorerBackend{
+		LocmemAPIKeyStorerBackend: NewLocmemAPIKeyStorerBackend(),
+		filename:                  filename,
+	}
+	var records []APIKeyRecord
+	if err := loadJSON(filename, &records); err != nil {
+		return nil, err
+	}
+	backend.restore(records)
+	return backend, nil
+}
+
+func (backend *FileAPIKeyStorerBackend) SaveAPIKey(ctx context.Context, record APIKeyRecord) error {
+	return backend.update(func() error {
+		return backend.LocmemAPIKeyStorerBackend.SaveAPIKey(ctx, record)
+	})
+}
+
+func (backend *FileAPIKeyStorerBackend) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	return backend.update(func() error {
+		return backend.LocmemAPIKeyStorerBackend.RevokeAPIKey(ctx, userID, keyID)
+	})
+}
+
+// update применяет изменение и перезаписывает файл. Если записать файл не удалось, изменение откатывается
+func (backend *FileAPIKeyStorerBackend) update(change func() error) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	before := backend.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := dumpJSON(backend.filename, backend.snapshot()); err != nil {
+		backend.restore(before)
+		return err
+	}
+	return nil
+}
+
+// Cleanup удаляет ключи из памяти и с диска
+func (backend *FileAPIKeyStorerBackend) Cleanup() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.LocmemAPIKeyStorerBackend.Cleanup()
+	if err := os.Remove(backend.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		panic(err)
+	}
+}
+
+const initAPIKeysSQL = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    revoked_at timestamptz,
+    CHECK (user_id <> '')
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys(user_id);
+`
+
+type DatabaseAPIKeyStorerBackend struct {
+	DB      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewDatabaseAPIKeyStorerBackend(db *pgxpool.Pool, timeout time.Duration) (*DatabaseAPIKeyStorerBackend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := db.Exec(ctx, initAPIKeysSQL); err != nil {
+		return nil, err
+	}
+	return &DatabaseAPIKeyStorerBackend{db, timeout}, nil
+}
+
+func (backend DatabaseAPIKeyStorerBackend) SaveAPIKey(ctx context.Context, record APIKeyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	_, err := backend.DB.Exec(
+		ctx,
+		"INSERT INTO api_keys (id, user_id, hash, scopes, created_at) VALUES($1, $2, $3, $4, $5)",
+		record.ID, record.UserID, record.Hash, record.Scopes, record.CreatedAt,
+	)
+	return err
+}
+
+func (backend DatabaseAPIKeyStorerBackend) GetAPIKey(ctx context.Context, keyID string) (APIKeyRecord, error) {
+	var record APIKeyRecord
+	var revokedAt *time.Time
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	err := backend.DB.QueryRow(
+		ctx, "SELECT id, user_id, hash, scopes, created_at, revoked_at FROM api_keys WHERE id = $1", keyID,
+	).Scan(&record.ID, &record.UserID, &record.Hash, &record.Scopes, &record.CreatedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return APIKeyRecord{}, ErrAPIKeyNotFound
+		}
+		return APIKeyRecord{}, err
+	}
+	if revokedAt != nil {
+		record.RevokedAt = *revokedAt
+	}
+	return record, nil
+}
+
+func (backend DatabaseAPIKeyStorerBackend) ListAPIKeys(ctx context.Context, userID string) ([]APIKeyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	rows, err := backend.DB.Query(
+		ctx,
+		"SELECT id, user_id, hash, scopes, created_at FROM api_keys "+
+			"WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at, id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]APIKeyRecord, 0)
+	for rows.Next() {
+		var record APIKeyRecord
+		if err := rows.Scan(&record.ID, &record.UserID, &record.Hash, &record.Scopes, &record.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (backend DatabaseAPIKeyStorerBackend) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	tag, err := backend.DB.Exec(
+		ctx,
+		"UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		keyID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Cleanup отчищает таблицу с ключами API
+func (backend DatabaseAPIKeyStorerBackend) Cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), backend.timeout)
+	defer cancel()
+	if _, err := backend.DB.Exec(ctx, "TRUNCATE TABLE api_keys"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,122 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getAPIKeyStorages(t *testing.T) map[string]storage.APIKeyStorer {
+	fileStorage, err := storage.NewFileAPIKeyStorerBackend(filepath.Join(t.TempDir(), "apikeys"))
+	require.NoError(t, err)
+	storages := map[string]storage.APIKeyStorer{
+		"memory": storage.NewLocmemAPIKeyStorerBackend(),
+		"file":   fileStorage,
+	}
+	shortener, err := app.New()
+	require.NoError(t, err)
+	t.Cleanup(shortener.Close)
+	if shortener.DB != nil {
+		dbStorage, err := storage.NewDatabaseAPIKeyStorerBackend(shortener.DB, shortener.Config.DatabaseQueryTimeout)
+		require.NoError(t, err)
+		t.Cleanup(dbStorage.Cleanup)
+		storages["database"] = dbStorage
+	}
+	return storages
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	ctx := context.TODO()
+	for name, theStorage := range getAPIKeyStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now().Truncate(time.Millisecond)
+			records := []storage.APIKeyRecord{
+				{ID: "key1", UserID: "user1", Hash: "h1", Scopes: []string{"read"}, CreatedAt: now},
+				{ID: "key2", UserID: "user1", Hash: "h2", Scopes: []string{"read", "write"}, CreatedAt: now.Add(time.Second)},
+				{ID: "key3", UserID: "user2", Hash: "h3", Scopes: []string{"write"}, CreatedAt: now},
+			}
+			for _, record := range records {
+				require.NoError(t, theStorage.SaveAPIKey(ctx, record))
+			}
+
+			record, err := theStorage.GetAPIKey(ctx, "key2")
+			require.NoError(t, err)
+			assert.Equal(t, "user1", record.UserID)
+			assert.Equal(t, "h2", record.Hash)
+			assert.Equal(t, []string{"read", "write"}, record.Scopes)
+			assert.False(t, record.IsRevoked())
+			_, err = theStorage.GetAPIKey(ctx, "unknown")
+			assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+			keys, err := theStorage.ListAPIKeys(ctx, "user1")
+			require.NoError(t, err)
+			require.Len(t, keys, 2)
+			assert.Equal(t, "key1", keys[0].ID)
+			assert.Equal(t, "key2", keys[1].ID)
+
+			// чужой ключ отозвать нельзя
+			assert.ErrorIs(t, theStorage.RevokeAPIKey(ctx, "user1", "key3"), storage.ErrAPIKeyNotFound)
+			require.NoError(t, theStorage.RevokeAPIKey(ctx, "user1", "key1"))
+			// повторно тоже
+			assert.ErrorIs(t, theStorage.RevokeAPIKey(ctx, "user1", "key1"), storage.ErrAPIKeyNotFound)
+
+			record, err = theStorage.GetAPIKey(ctx, "key1")
+			require.NoError(t, err)
+			assert.True(t, record.IsRevoked())
+			keys, err = theStorage.ListAPIKeys(ctx, "user1")
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+			assert.Equal(t, "key2", keys[0].ID)
+		})
+	}
+}
+
+func TestFileAPIKeyStorageSurvivesRestart(t *testing.T) {
+	ctx := context.TODO()
+	filename := filepath.Join(t.TempDir(), "apikeys")
+	theStorage, err := storage.NewFileAPIKeyStorerBackend(filename)
+	require.NoError(t, err)
+	now := time.Now().Truncate(time.Millisecond)
+	require.NoError(t, theStorage.SaveAPIKey(ctx, storage.APIKeyRecord{
+		ID: "key1", UserID: "user1", Hash: "h1", Scopes: []string{"read"}, CreatedAt: now,
+	}))
+	require.NoError(t, theStorage.SaveAPIKey(ctx, storage.APIKeyRecord{
+		ID: "key2", UserID: "user1", Hash: "h2", Scopes: []string{"write"}, CreatedAt: now,
+	}))
+	require.NoError(t, theStorage.RevokeAPIKey(ctx, "user1", "key2"))
+
+	// ключи и их отзыв записаны на диск сразу, без закрытия хранилища
+	reopened, err := storage.NewFileAPIKeyStorerBackend(filename)
+	require.NoError(t, err)
+	record, err := reopened.GetAPIKey(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "h1", record.Hash)
+	assert.Equal(t, []string{"read"}, record.Scopes)
+	assert.True(t, now.Equal(record.CreatedAt))
+	record, err = reopened.GetAPIKey(ctx, "key2")
+	require.NoError(t, err)
+	assert.True(t, record.IsRevoked())
+	keys, err := reopened.ListAPIKeys(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "key1", keys[0].ID)
+
+	reopened.Cleanup()
+	_, err = os.Stat(filename)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFileAPIKeyStorageWontStartWithBrokenJSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "apikeys")
+	require.NoError(t, os.WriteFile(filename, []byte(`[{foo: "bar"}]`), 0600))
+	theStorage, err := storage.NewFileAPIKeyStorerBackend(filename)
+	assert.Nil(t, theStorage)
+	assert.Error(t, err)
+}
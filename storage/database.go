@@ -3,13 +3,13 @@ package storage
 import (
 	"context"
 	"errors"
-	"log"
 	"time"
 
-	"github.com/lib/pq"
-
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/lib/pq"
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
 )
 
 type DatabaseURLStorerBackend struct {
@@ -17,6 +17,9 @@ type DatabaseURLStorerBackend struct {
 	timeout time.Duration
 }
 
+// iteratePageSize - количество строк, выбираемых за один запрос при поточном обходе ссылок
+const iteratePageSize = 1000
+
 type conn interface {
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 }
@@ -107,7 +110,7 @@ func (backend DatabaseURLStorerBackend) GetURLsByUserID(ctx context.Context, use
 		ctx, "SELECT short_id, original_url FROM urls WHERE user_id = $1 AND is_deleted = false", userID,
 	)
 	if err != nil {
-		log.Printf("failed to query urls of user %s due to %v\n", userID, err)
+		logging.FromContext(ctx).WithError(err).WithField("user_id", userID).Error("failed to query user urls")
 		return nil, err
 	}
 	defer rows.Close()
@@ -116,31 +119,153 @@ func (backend DatabaseURLStorerBackend) GetURLsByUserID(ctx context.Context, use
 	for rows.Next() {
 		err = rows.Scan(&shortURL, &longURL)
 		if err != nil {
-			log.Printf("failed to read urls of user %s due to %v\n", userID, err)
+			logging.FromContext(ctx).WithError(err).WithField("user_id", userID).Error("failed to read user urls")
 			return nil, err
 		}
 		items[shortURL] = longURL
 	}
 	err = rows.Err()
 	if err != nil {
-		log.Printf("failed to fetch urls of user %s due to %v\n", userID, err)
+		logging.FromContext(ctx).WithError(err).WithField("user_id", userID).Error("failed to fetch user urls")
 		return nil, err
 	}
 	return items, nil
 }
 
-func (backend DatabaseURLStorerBackend) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) error {
+// IterateUserURLs обходит все ссылки пользователя, включая удаленные, в порядке их создания.
+// Ссылки выбираются страницами по iteratePageSize строк с использованием keyset-пагинации,
+// поэтому таймаут запроса применяется к каждой странице отдельно, а не ко всему обходу
+func (backend DatabaseURLStorerBackend) IterateUserURLs(ctx context.Context, userID string, fn URLRecordFunc) error {
+	lastRowID := 0
+	for {
+		page, nextRowID, err := backend.getUserURLsPage(ctx, userID, lastRowID)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("user_id", userID).Error("failed to iterate user urls")
+			return err
+		}
+		for _, record := range page {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(page) < iteratePageSize {
+			return nil
+		}
+		lastRowID = nextRowID
+	}
+}
+
+func (backend DatabaseURLStorerBackend) getUserURLsPage(
+	ctx context.Context, userID string, afterRowID int,
+) ([]URLRecord, int, error) {
+	var rowID int
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+
+	rows, err := backend.DB.Query(
+		ctx,
+		"SELECT id, short_id, original_url, created_at, is_deleted FROM urls "+
+			"WHERE user_id = $1 AND id > $2 ORDER BY id LIMIT $3",
+		userID, afterRowID, iteratePageSize,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	page := make([]URLRecord, 0, iteratePageSize)
+	for rows.Next() {
+		record := URLRecord{UserID: userID}
+		if err := rows.Scan(&rowID, &record.ShortID, &record.LongURL, &record.CreatedAt, &record.IsDeleted); err != nil {
+			return nil, 0, err
+		}
+		page = append(page, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return page, rowID, nil
+}
+
+// DeleteUserURLs помечает удаленными ссылки пользователя и возвращает количество удаленных ссылок.
+// Ранее удаленные ссылки не учитываются
+func (backend DatabaseURLStorerBackend) DeleteUserURLs(
+	ctx context.Context, userID string, shortIDs ...string,
+) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
 	defer cancel()
 
-	sql := "UPDATE urls SET is_deleted = true WHERE user_id = $1 AND short_id = ANY($2)"
+	sql := "UPDATE urls SET is_deleted = true WHERE user_id = $1 AND short_id = ANY($2) AND is_deleted = false"
 	result, err := backend.DB.Exec(ctx, sql, userID, pq.Array(shortIDs))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	log.Printf("deleted %d urls for user %s", result.RowsAffected(), userID)
-	return nil
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"user_id": userID,
+		"deleted": result.RowsAffected(),
+	}).Debug("deleted user urls")
+	return int(result.RowsAffected()), nil
+}
+
+// TransferUserURLs передает ссылки пользователя fromUserID, включая удаленные, пользователю toUserID
+func (backend DatabaseURLStorerBackend) TransferUserURLs(
+	ctx context.Context, fromUserID, toUserID string,
+) (int, error) {
+	if fromUserID == "" || toUserID == "" {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	result, err := backend.DB.Exec(ctx, "UPDATE urls SET user_id = $2 WHERE user_id = $1", fromUserID, toUserID)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// DeleteURLsBatch помечает удаленными ссылки сразу нескольких пользователей одним запросом
+// и возвращает те из них, что были удалены этим вызовом
+func (backend DatabaseURLStorerBackend) DeleteURLsBatch(ctx context.Context, items []DeleteItem) ([]DeleteItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+
+	userIDs := make([]string, 0, len(items))
+	shortIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		// не позволяем анонимам удалять ссылки других анонимов
+		if item.UserID == "" {
+			continue
+		}
+		userIDs = append(userIDs, item.UserID)
+		shortIDs = append(shortIDs, item.ShortID)
+	}
+	sql := "UPDATE urls SET is_deleted = true " +
+		"FROM unnest($1::text[], $2::text[]) AS d(user_id, short_id) " +
+		"WHERE urls.user_id = d.user_id AND urls.short_id = d.short_id AND urls.is_deleted = false " +
+		"RETURNING urls.user_id, urls.short_id"
+	rows, err := backend.DB.Query(ctx, sql, pq.Array(userIDs), pq.Array(shortIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deleted := make([]DeleteItem, 0)
+	for rows.Next() {
+		var item DeleteItem
+		if err := rows.Scan(&item.UserID, &item.ShortID); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"deleted":    len(deleted),
+		"batch_size": len(items),
+	}).Debug("deleted batch of urls")
+	return deleted, nil
 }
 
 func (backend DatabaseURLStorerBackend) SaveBatch(ctx context.Context, items []BatchItem) (map[string]string, error) {
@@ -156,7 +281,7 @@ func (backend DatabaseURLStorerBackend) SaveBatch(ctx context.Context, items []B
 	defer func(ctx context.Context) {
 		err := tx.Rollback(ctx)
 		if err != nil {
-			log.Printf("failed to rollback transaction due to %v", err)
+			logging.FromContext(ctx).WithError(err).Error("failed to rollback transaction")
 		}
 	}(ctx)
 
@@ -195,7 +320,7 @@ func (backend DatabaseURLStorerBackend) Ping(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
 	defer cancel()
 	if err := backend.DB.Ping(ctx); err != nil {
-		log.Printf("failed to ping database because of %s\n", err)
+		logging.FromContext(ctx).WithError(err).Error("failed to ping database")
 		return err
 	}
 	return nil
@@ -1,9 +1,47 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgconn"
 )
 
 var ErrURLNotFound = errors.New("URL not found in the storage")
 var ErrURLAlreadyExists = errors.New("URL already exists in the storage")
 var ErrURLIsDeleted = errors.New("URL has been deleted")
+
+// transientPgErrorCodes - коды ошибок postgres, после которых операцию имеет смысл повторить
+var transientPgErrorCodes = map[string]struct{}{
+	"40001": {}, // serialization_failure
+	"40P01": {}, // deadlock_detected
+	"53300": {}, // too_many_connections
+	"57P01": {}, // admin_shutdown
+	"57P02": {}, // crash_shutdown
+	"57P03": {}, // cannot_connect_now
+}
+
+// IsRetryable сообщает, является ли ошибка хранилища временной,
+// например обрывом соединения с бд или превышением времени ожидания запроса,
+// то есть имеет ли смысл повторить операцию позже
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// класс 08 - connection exception
+		if strings.HasPrefix(pgErr.Code, "08") {
+			return true
+		}
+		_, transient := transientPgErrorCodes[pgErr.Code]
+		return transient
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
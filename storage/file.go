@@ -4,16 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/pkg/logging"
+	"github.com/sirupsen/logrus"
 )
 
 type FileURLItem struct {
 	LongURL   string
 	UserID    string
 	IsDeleted bool
+	CreatedAt time.Time
 }
 
 type FileURLStorerBackend struct {
@@ -21,9 +27,27 @@ type FileURLStorerBackend struct {
 	cache    map[string]FileURLItem
 	created  map[string]string
 	mu       sync.RWMutex
+	logger   logrus.FieldLogger
+}
+
+type FileOption func(*FileURLStorerBackend)
+
+// WithFileLogger задает логгер хранилища. По умолчанию используется логгер сервиса по умолчанию
+func WithFileLogger(logger logrus.FieldLogger) FileOption {
+	return func(backend *FileURLStorerBackend) {
+		backend.logger = logger
+	}
 }
 
-func NewFileURLStorerBackend(filename string) (*FileURLStorerBackend, error) {
+func NewFileURLStorerBackend(filename string, opts ...FileOption) (*FileURLStorerBackend, error) {
+	backend := FileURLStorerBackend{
+		filename: filename,
+		logger:   logging.FromContext(context.Background()),
+	}
+	for _, opt := range opts {
+		opt(&backend)
+	}
+	logger := backend.logger.WithField("filename", filename)
 	cache := make(map[string]FileURLItem)
 	created := make(map[string]string) // служебная мапа, хранящая ссылки в формате URL -> Short ID
 	// Считываем с диска записи, сохраненные ранее, и заполняем ими кэш,
@@ -32,9 +56,9 @@ func NewFileURLStorerBackend(filename string) (*FileURLStorerBackend, error) {
 	if err != nil {
 		// Если файл не найден, то ничего страшного - это ожидаемое поведение при первом запуске сервиса
 		if os.IsNotExist(err) {
-			log.Printf("file %s not found; will start with empty Storage\n", filename)
+			logger.Info("storage file not found; will start with empty storage")
 		} else {
-			log.Printf("error opening %s: %s\n", filename, err)
+			logger.WithError(err).Error("unable to open storage file")
 			return nil, err
 		}
 	} else {
@@ -43,9 +67,9 @@ func NewFileURLStorerBackend(filename string) (*FileURLStorerBackend, error) {
 		if err := decoder.Decode(&cache); err != nil {
 			// Файл пустой - ожидаемое поведение
 			if errors.Is(err, io.EOF) {
-				log.Printf("file is empty %s; will start with empty Storage\n", filename)
+				logger.Info("storage file is empty; will start with empty storage")
 			} else {
-				log.Printf("unable to populate Storage from %s due to %s\n", filename, err)
+				logger.WithError(err).Error("unable to populate storage from file")
 				return nil, err
 			}
 		} else {
@@ -55,11 +79,8 @@ func NewFileURLStorerBackend(filename string) (*FileURLStorerBackend, error) {
 			}
 		}
 	}
-	backend := FileURLStorerBackend{
-		filename: filename,
-		cache:    cache,
-		created:  created,
-	}
+	backend.cache = cache
+	backend.created = created
 	return &backend, nil
 }
 
@@ -70,7 +91,7 @@ func (backend *FileURLStorerBackend) Set(ctx context.Context, shortID, longURL,
 	if exists {
 		return actualShortID, ErrURLAlreadyExists
 	}
-	backend.cache[shortID] = FileURLItem{LongURL: longURL, UserID: userID}
+	backend.cache[shortID] = FileURLItem{LongURL: longURL, UserID: userID, CreatedAt: time.Now()}
 	backend.created[longURL] = shortID
 	return shortID, nil
 }
@@ -102,21 +123,91 @@ func (backend *FileURLStorerBackend) GetURLsByUserID(ctx context.Context, userID
 	return items, nil
 }
 
-func (backend *FileURLStorerBackend) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) error {
+// IterateUserURLs обходит все ссылки пользователя, включая удаленные, в порядке их создания.
+// Обход выполняется по снимку ссылок пользователя, поэтому fn может работать сколь угодно долго,
+// не блокируя запись в хранилище
+func (backend *FileURLStorerBackend) IterateUserURLs(ctx context.Context, userID string, fn URLRecordFunc) error {
+	backend.mu.RLock()
+	records := make([]URLRecord, 0)
+	for shortID, item := range backend.cache {
+		if userID != "" && item.UserID == userID {
+			records = append(records, URLRecord{
+				ShortID:   shortID,
+				LongURL:   item.LongURL,
+				UserID:    item.UserID,
+				CreatedAt: item.CreatedAt,
+				IsDeleted: item.IsDeleted,
+			})
+		}
+	}
+	backend.mu.RUnlock()
+	sortURLRecords(records)
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (backend *FileURLStorerBackend) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) (int, error) {
 	backend.mu.Lock()
 	defer backend.mu.Unlock()
 	// не позволяем анонимам удалять ссылки других анонимов
 	if userID == "" {
-		return nil
+		return 0, nil
 	}
+	deleted := 0
 	for _, shortID := range shortIDs {
-		if item, ok := backend.cache[shortID]; ok && item.UserID == userID {
+		if item, ok := backend.cache[shortID]; ok && item.UserID == userID && !item.IsDeleted {
 			item.IsDeleted = true
 			backend.cache[shortID] = item
 			delete(backend.created, item.LongURL)
+			deleted++
 		}
 	}
-	return nil
+	return deleted, nil
+}
+
+// DeleteURLsBatch помечает удаленными ссылки сразу нескольких пользователей
+// и возвращает те из них, что были удалены этим вызовом
+func (backend *FileURLStorerBackend) DeleteURLsBatch(ctx context.Context, items []DeleteItem) ([]DeleteItem, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	deleted := make([]DeleteItem, 0)
+	for _, di := range items {
+		if di.UserID == "" {
+			continue
+		}
+		if item, ok := backend.cache[di.ShortID]; ok && item.UserID == di.UserID && !item.IsDeleted {
+			item.IsDeleted = true
+			backend.cache[di.ShortID] = item
+			delete(backend.created, item.LongURL)
+			deleted = append(deleted, di)
+		}
+	}
+	return deleted, nil
+}
+
+// TransferUserURLs передает ссылки пользователя fromUserID, включая удаленные, пользователю toUserID
+func (backend *FileURLStorerBackend) TransferUserURLs(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if fromUserID == "" || toUserID == "" {
+		return 0, nil
+	}
+	transferred := 0
+	for shortID, item := range backend.cache {
+		if item.UserID == fromUserID {
+			item.UserID = toUserID
+			backend.cache[shortID] = item
+			transferred++
+		}
+	}
+	return transferred, nil
 }
 
 func (backend *FileURLStorerBackend) SaveBatch(ctx context.Context, items []BatchItem) (map[string]string, error) {
@@ -128,7 +219,7 @@ func (backend *FileURLStorerBackend) SaveBatch(ctx context.Context, items []Batc
 		if val, exists := backend.created[item.LongURL]; exists {
 			result[item.LongURL] = val
 		} else {
-			backend.cache[item.ShortID] = FileURLItem{LongURL: item.LongURL, UserID: item.UserID}
+			backend.cache[item.ShortID] = FileURLItem{LongURL: item.LongURL, UserID: item.UserID, CreatedAt: time.Now()}
 			backend.created[item.LongURL] = item.ShortID
 			result[item.LongURL] = item.ShortID
 		}
@@ -153,13 +244,49 @@ func (backend *FileURLStorerBackend) Close() error {
 	// который будет использован при следующем старте программы
 	file, err := os.OpenFile(backend.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
-		log.Printf("unable to open file %s for dumping Storage due to %s\n", backend.filename, err)
-		return err
+		return fmt.Errorf("unable to open file %s for dumping storage: %w", backend.filename, err)
 	}
 	defer file.Close()
 	if err := json.NewEncoder(file).Encode(&backend.cache); err != nil {
-		log.Printf("unable to dump Storage to %s due to %s\n", backend.filename, err)
+		return fmt.Errorf("unable to dump storage to %s: %w", backend.filename, err)
+	}
+	return nil
+}
+
+// loadJSON читает из файла значение v, сохраненное dumpJSON. Отсутствующий или пустой файл оставляет v как есть
+func loadJSON(filename string, v interface{}) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		// при первом запуске файла еще нет
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("unable to read %s: %w", filename, err)
+	}
+	return nil
+}
+
+// dumpJSON атомарно заменяет содержимое файла значением v: записывает его во временный файл и переименовывает его,
+// так что при сбое на диске остается либо прежнее, либо новое содержимое
+func dumpJSON(filename string, v interface{}) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create file for dumping %s: %w", filename, err)
+	}
+	defer os.Remove(file.Name()) // nolint:errcheck
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to dump to %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("unable to dump to %s: %w", file.Name(), err)
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return fmt.Errorf("unable to replace %s: %w", filename, err)
+	}
 	return nil
 }
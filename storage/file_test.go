@@ -273,8 +273,13 @@ func TestBatchDeleteUserURLsFromFileStorage(t *testing.T) {
 	theStorage.Set(ctx, "ya", "https://ya.ru", "u3")                 // nolint: errcheck
 	theStorage.Set(ctx, "bar", "https://practicum.yandex.ru/", "u1") // nolint: errcheck
 
-	err := theStorage.DeleteUserURLs(ctx, "u1", "wiki", "go", "foo", "ya", "bar", "unknown")
+	deleted, err := theStorage.DeleteUserURLs(ctx, "u1", "wiki", "go", "foo", "ya", "bar", "unknown")
 	assert.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	// повторное удаление ничего не удаляет
+	deleted, err = theStorage.DeleteUserURLs(ctx, "u1", "wiki", "go")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
 
 	u1Items, _ := theStorage.GetURLsByUserID(ctx, "u1")
 	assert.Len(t, u1Items, 0)
@@ -305,3 +310,94 @@ func TestFileStoragePing(t *testing.T) {
 	defer closeFunc()
 	assert.Nil(t, theStorage.Ping(context.TODO()))
 }
+
+func TestIterateUserURLsFromFileStorage(t *testing.T) {
+	ctx := context.TODO()
+	f, _ := os.CreateTemp("", "*")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	firstStorage, _ := storage.NewFileURLStorerBackend(f.Name())
+	firstStorage.Set(ctx, "foo", "https://practicum.yandex.ru/", "user1") // nolint: errcheck
+	firstStorage.Set(ctx, "bar", "https://go.dev/", "user1")              // nolint: errcheck
+	firstStorage.Set(ctx, "baz", "https://google.com/", "user2")          // nolint: errcheck
+	firstStorage.DeleteUserURLs(ctx, "user1", "foo")                      // nolint: errcheck
+	firstStorage.Close()
+
+	// дата создания и признак удаления переживают перезапуск
+	secondStorage, _ := storage.NewFileURLStorerBackend(f.Name())
+	records := make([]storage.URLRecord, 0)
+	err := secondStorage.IterateUserURLs(ctx, "user1", func(record storage.URLRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "foo", records[0].ShortID)
+	assert.True(t, records[0].IsDeleted)
+	assert.False(t, records[0].CreatedAt.IsZero())
+	assert.Equal(t, "bar", records[1].ShortID)
+	assert.Equal(t, "https://go.dev/", records[1].LongURL)
+	assert.False(t, records[1].IsDeleted)
+	assert.True(t, records[0].CreatedAt.Before(records[1].CreatedAt))
+}
+
+func TestDeleteURLsBatchFromFileStorage(t *testing.T) {
+	ctx := context.TODO()
+	theStorage, closeFunc := getTestFileStorage()
+	defer closeFunc()
+	theStorage.Set(ctx, "wiki", "https://wikipedia.org/", "u1") // nolint: errcheck
+	theStorage.Set(ctx, "go", "https://go.dev/", "u1")          // nolint: errcheck
+	theStorage.Set(ctx, "foo", "https://example.com/", "u2")    // nolint: errcheck
+	theStorage.Set(ctx, "ya", "https://ya.ru", "u3")            // nolint: errcheck
+
+	// ссылки разных пользователей удаляются одним вызовом, чужие ссылки не затрагиваются
+	deleted, err := theStorage.DeleteURLsBatch(ctx, []storage.DeleteItem{
+		{UserID: "u1", ShortID: "wiki"},
+		{UserID: "u1", ShortID: "foo"},
+		{UserID: "u2", ShortID: "foo"},
+		{UserID: "u2", ShortID: "unknown"},
+		{UserID: "", ShortID: "ya"},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []storage.DeleteItem{{UserID: "u1", ShortID: "wiki"}, {UserID: "u2", ShortID: "foo"}}, deleted)
+
+	// повторное удаление ничего не удаляет
+	deleted, err = theStorage.DeleteURLsBatch(ctx, []storage.DeleteItem{
+		{UserID: "u1", ShortID: "wiki"},
+		{UserID: "u1", ShortID: "go"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.DeleteItem{{UserID: "u1", ShortID: "go"}}, deleted)
+
+	_, err = theStorage.Get(ctx, "foo")
+	assert.ErrorIs(t, storage.ErrURLIsDeleted, err)
+	url, err := theStorage.Get(ctx, "ya")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", url)
+}
+
+func TestTransferUserURLsInFileStorage(t *testing.T) {
+	ctx := context.TODO()
+	theStorage, closeFunc := getTestFileStorage()
+	defer closeFunc()
+	theStorage.Set(ctx, "wiki", "https://wikipedia.org/", "u1") // nolint: errcheck
+	theStorage.Set(ctx, "go", "https://go.dev/", "u1")          // nolint: errcheck
+	theStorage.Set(ctx, "foo", "https://example.com/", "u2")    // nolint: errcheck
+	theStorage.DeleteUserURLs(ctx, "u1", "wiki")                // nolint: errcheck
+
+	// удаленные ссылки тоже переходят к новому владельцу
+	transferred, err := theStorage.TransferUserURLs(ctx, "u1", "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transferred)
+	urls, err := theStorage.GetURLsByUserID(ctx, "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"go": "https://go.dev/", "foo": "https://example.com/"}, urls)
+	urls, err = theStorage.GetURLsByUserID(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, urls)
+
+	transferred, err = theStorage.TransferUserURLs(ctx, "u1", "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, transferred)
+}
@@ -6,7 +6,11 @@ type URLStorer interface {
 	Set(context.Context, string, string, string) (string, error)
 	Get(context.Context, string) (string, error)
 	GetURLsByUserID(context.Context, string) (map[string]string, error)
-	DeleteUserURLs(context.Context, string, ...string) error
+	IterateUserURLs(context.Context, string, URLRecordFunc) error
+	DeleteUserURLs(context.Context, string, ...string) (int, error)
+	DeleteURLsBatch(context.Context, []DeleteItem) ([]DeleteItem, error)
+	// TransferUserURLs передает все ссылки одного пользователя другому и возвращает их количество
+	TransferUserURLs(context.Context, string, string) (int, error)
 	SaveBatch(context.Context, []BatchItem) (map[string]string, error)
 	Ping(context.Context) error
 	Cleanup()
@@ -3,12 +3,14 @@ package storage
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type LocURLItem struct {
 	LongURL   string
 	UserID    string
 	IsDeleted bool
+	CreatedAt time.Time
 }
 
 type LocmemURLStorerBackend struct {
@@ -33,7 +35,7 @@ func (backend *LocmemURLStorerBackend) Set(ctx context.Context, shortID, longURL
 	if exists {
 		return actualShortID, ErrURLAlreadyExists
 	}
-	backend.Storage[shortID] = LocURLItem{LongURL: longURL, UserID: userID}
+	backend.Storage[shortID] = LocURLItem{LongURL: longURL, UserID: userID, CreatedAt: time.Now()}
 	backend.created[longURL] = shortID
 	return shortID, nil
 }
@@ -65,21 +67,91 @@ func (backend *LocmemURLStorerBackend) GetURLsByUserID(ctx context.Context, user
 	return items, nil
 }
 
-func (backend *LocmemURLStorerBackend) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) error {
+// IterateUserURLs обходит все ссылки пользователя, включая удаленные, в порядке их создания.
+// Обход выполняется по снимку ссылок пользователя, поэтому fn может работать сколь угодно долго,
+// не блокируя запись в хранилище
+func (backend *LocmemURLStorerBackend) IterateUserURLs(ctx context.Context, userID string, fn URLRecordFunc) error {
+	backend.mu.RLock()
+	records := make([]URLRecord, 0)
+	for shortID, item := range backend.Storage {
+		if userID != "" && item.UserID == userID {
+			records = append(records, URLRecord{
+				ShortID:   shortID,
+				LongURL:   item.LongURL,
+				UserID:    item.UserID,
+				CreatedAt: item.CreatedAt,
+				IsDeleted: item.IsDeleted,
+			})
+		}
+	}
+	backend.mu.RUnlock()
+	sortURLRecords(records)
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (backend *LocmemURLStorerBackend) DeleteUserURLs(ctx context.Context, userID string, shortIDs ...string) (int, error) {
 	backend.mu.Lock()
 	defer backend.mu.Unlock()
 	// не позволяем анонимам удалять ссылки других анонимов
 	if userID == "" {
-		return nil
+		return 0, nil
 	}
+	deleted := 0
 	for _, shortID := range shortIDs {
-		if item, ok := backend.Storage[shortID]; ok && item.UserID == userID {
+		if item, ok := backend.Storage[shortID]; ok && item.UserID == userID && !item.IsDeleted {
 			item.IsDeleted = true
 			backend.Storage[shortID] = item
 			delete(backend.created, item.LongURL)
+			deleted++
 		}
 	}
-	return nil
+	return deleted, nil
+}
+
+// DeleteURLsBatch помечает удаленными ссылки сразу нескольких пользователей
+// и возвращает те из них, что были удалены этим вызовом
+func (backend *LocmemURLStorerBackend) DeleteURLsBatch(ctx context.Context, items []DeleteItem) ([]DeleteItem, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	deleted := make([]DeleteItem, 0)
+	for _, di := range items {
+		if di.UserID == "" {
+			continue
+		}
+		if item, ok := backend.Storage[di.ShortID]; ok && item.UserID == di.UserID && !item.IsDeleted {
+			item.IsDeleted = true
+			backend.Storage[di.ShortID] = item
+			delete(backend.created, item.LongURL)
+			deleted = append(deleted, di)
+		}
+	}
+	return deleted, nil
+}
+
+// TransferUserURLs передает ссылки пользователя fromUserID, включая удаленные, пользователю toUserID
+func (backend *LocmemURLStorerBackend) TransferUserURLs(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if fromUserID == "" || toUserID == "" {
+		return 0, nil
+	}
+	transferred := 0
+	for shortID, item := range backend.Storage {
+		if item.UserID == fromUserID {
+			item.UserID = toUserID
+			backend.Storage[shortID] = item
+			transferred++
+		}
+	}
+	return transferred, nil
 }
 
 func (backend *LocmemURLStorerBackend) SaveBatch(ctx context.Context, items []BatchItem) (map[string]string, error) {
@@ -91,7 +163,7 @@ func (backend *LocmemURLStorerBackend) SaveBatch(ctx context.Context, items []Ba
 		if val, exists := backend.created[item.LongURL]; exists {
 			result[item.LongURL] = val
 		} else {
-			backend.Storage[item.ShortID] = LocURLItem{LongURL: item.LongURL, UserID: item.UserID}
+			backend.Storage[item.ShortID] = LocURLItem{LongURL: item.LongURL, UserID: item.UserID, CreatedAt: time.Now()}
 			backend.created[item.LongURL] = item.ShortID
 			result[item.LongURL] = item.ShortID
 		}
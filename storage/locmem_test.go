@@ -2,10 +2,12 @@ package storage_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/sergeii/practikum-go-url-shortener/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSaveURLToLocmemStorage(t *testing.T) {
@@ -97,3 +99,101 @@ func TestLocmemStoragePing(t *testing.T) {
 	theStorage := storage.NewLocmemURLStorerBackend()
 	assert.Nil(t, theStorage.Ping(context.TODO()))
 }
+
+func TestIterateUserURLsFromLocmemStorage(t *testing.T) {
+	ctx := context.TODO()
+	theStorage := storage.NewLocmemURLStorerBackend()
+
+	theStorage.Set(ctx, "foo", "https://practicum.yandex.ru/", "user1") // nolint: errcheck
+	theStorage.Set(ctx, "bar", "https://go.dev/", "user1")              // nolint: errcheck
+	theStorage.Set(ctx, "baz", "https://google.com/", "user2")          // nolint: errcheck
+	theStorage.DeleteUserURLs(ctx, "user1", "bar")                      // nolint: errcheck
+
+	records := make([]storage.URLRecord, 0)
+	err := theStorage.IterateUserURLs(ctx, "user1", func(record storage.URLRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "foo", records[0].ShortID)
+	assert.Equal(t, "https://practicum.yandex.ru/", records[0].LongURL)
+	assert.False(t, records[0].IsDeleted)
+	assert.False(t, records[0].CreatedAt.IsZero())
+	assert.Equal(t, "bar", records[1].ShortID)
+	assert.True(t, records[1].IsDeleted)
+
+	// ошибка из колбэка прерывает обход
+	errStop := errors.New("stop")
+	calls := 0
+	err = theStorage.IterateUserURLs(ctx, "user1", func(record storage.URLRecord) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+
+	err = theStorage.IterateUserURLs(ctx, "", func(record storage.URLRecord) error {
+		t.Fatal("anonymous user has no urls")
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestDeleteURLsBatchFromLocmemStorage(t *testing.T) {
+	ctx := context.TODO()
+	theStorage := storage.NewLocmemURLStorerBackend()
+	theStorage.Set(ctx, "wiki", "https://wikipedia.org/", "u1") // nolint: errcheck
+	theStorage.Set(ctx, "go", "https://go.dev/", "u1")          // nolint: errcheck
+	theStorage.Set(ctx, "foo", "https://example.com/", "u2")    // nolint: errcheck
+	theStorage.Set(ctx, "ya", "https://ya.ru", "u3")            // nolint: errcheck
+
+	// ссылки разных пользователей удаляются одним вызовом, чужие ссылки не затрагиваются
+	deleted, err := theStorage.DeleteURLsBatch(ctx, []storage.DeleteItem{
+		{UserID: "u1", ShortID: "wiki"},
+		{UserID: "u1", ShortID: "foo"},
+		{UserID: "u2", ShortID: "foo"},
+		{UserID: "u2", ShortID: "unknown"},
+		{UserID: "", ShortID: "ya"},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []storage.DeleteItem{{UserID: "u1", ShortID: "wiki"}, {UserID: "u2", ShortID: "foo"}}, deleted)
+
+	// повторное удаление ничего не удаляет
+	deleted, err = theStorage.DeleteURLsBatch(ctx, []storage.DeleteItem{
+		{UserID: "u1", ShortID: "wiki"},
+		{UserID: "u1", ShortID: "go"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.DeleteItem{{UserID: "u1", ShortID: "go"}}, deleted)
+
+	_, err = theStorage.Get(ctx, "foo")
+	assert.ErrorIs(t, storage.ErrURLIsDeleted, err)
+	url, err := theStorage.Get(ctx, "ya")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", url)
+}
+
+func TestTransferUserURLsInLocmemStorage(t *testing.T) {
+	ctx := context.TODO()
+	theStorage := storage.NewLocmemURLStorerBackend()
+	theStorage.Set(ctx, "wiki", "https://wikipedia.org/", "u1") // nolint: errcheck
+	theStorage.Set(ctx, "go", "https://go.dev/", "u1")          // nolint: errcheck
+	theStorage.Set(ctx, "foo", "https://example.com/", "u2")    // nolint: errcheck
+	theStorage.DeleteUserURLs(ctx, "u1", "wiki")                // nolint: errcheck
+
+	// удаленные ссылки тоже переходят к новому владельцу
+	transferred, err := theStorage.TransferUserURLs(ctx, "u1", "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transferred)
+	urls, err := theStorage.GetURLsByUserID(ctx, "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"go": "https://go.dev/", "foo": "https://example.com/"}, urls)
+	urls, err = theStorage.GetURLsByUserID(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Empty(t, urls)
+
+	transferred, err = theStorage.TransferUserURLs(ctx, "u1", "u2")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, transferred)
+}
@@ -1,7 +1,41 @@
 package storage
 
+import (
+	"sort"
+	"time"
+)
+
 type BatchItem struct {
 	ShortID string
 	LongURL string
 	UserID  string
 }
+
+// DeleteItem - ссылка, которую пользователь просит удалить
+type DeleteItem struct {
+	UserID  string
+	ShortID string
+}
+
+// URLRecord - полная информация о сокращенной ссылке, включая удаленные
+type URLRecord struct {
+	ShortID   string
+	LongURL   string
+	UserID    string
+	CreatedAt time.Time
+	IsDeleted bool
+}
+
+// URLRecordFunc вызывается для каждой ссылки при их поточном обходе.
+// Возврат ошибки прерывает обход
+type URLRecordFunc func(URLRecord) error
+
+// sortURLRecords упорядочивает ссылки по времени создания
+func sortURLRecords(records []URLRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].ShortID < records[j].ShortID
+		}
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrUserNotFound = errors.New("user not found in the storage")
+var ErrUserExists = errors.New("user with this email already exists")
+
+// UserRecord - учетная запись зарегистрированного пользователя. Пароль хранится только в виде хеша
+type UserRecord struct {
+	ID           string
+	Email        string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+type UserStorer interface {
+	// SaveUser сохраняет учетную запись, либо возвращает ErrUserExists, если email уже занят
+	SaveUser(context.Context, UserRecord) error
+	// GetUser возвращает учетную запись по идентификатору пользователя, либо ErrUserNotFound
+	GetUser(context.Context, string) (UserRecord, error)
+	// GetUserByEmail возвращает учетную запись по email, либо ErrUserNotFound
+	GetUserByEmail(context.Context, string) (UserRecord, error)
+	Cleanup()
+}
+
+type LocmemUserStorerBackend struct {
+	users   map[string]UserRecord
+	byEmail map[string]string
+	mu      sync.RWMutex
+}
+
+func NewLocmemUserStorerBackend() *LocmemUserStorerBackend {
+	return &LocmemUserStorerBackend{
+		users:   make(map[string]UserRecord),
+		byEmail: make(map[string]string),
+	}
+}
+
+func (backend *LocmemUserStorerBackend) SaveUser(ctx context.Context, record UserRecord) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if _, exists := backend.byEmail[record.Email]; exists {
+		return ErrUserExists
+	}
+	backend.users[record.ID] = record
+	backend.byEmail[record.Email] = record.ID
+	return nil
+}
+
+func (backend *LocmemUserStorerBackend) GetUser(ctx context.Context, userID string) (UserRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	record, found := backend.users[userID]
+	if !found {
+		return UserRecord{}, ErrUserNotFound
+	}
+	return record, nil
+}
+
+func (backend *LocmemUserStorerBackend) GetUserByEmail(ctx context.Context, email string) (UserRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	userID, found := backend.byEmail[email]
+	if !found {
+		return UserRecord{}, ErrUserNotFound
+	}
+	return backend.users[userID], nil
+}
+
+func (backend *LocmemUserStorerBackend) Cleanup() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.users = make(map[string]UserRecord)
+	backend.byEmail = make(map[string]string)
+}
+
+// deleteUser удаляет учетную запись; используется для отката неудавшегося сохранения
+func (backend *LocmemUserStorerBackend) deleteUser(record UserRecord) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	delete(backend.users, record.ID)
+	delete(backend.byEmail, record.Email)
+}
+
+// snapshot возвращает копию всех учетных записей
+func (backend *LocmemUserStorerBackend) snapshot() []UserRecord {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	records := make([]UserRecord, 0, len(backend.users))
+	for _, record := range backend.users {
+		records = append(records, record)
+	}
+	return records
+}
+
+// FileUserStorerBackend хранит учетные записи в памяти и записывает их на диск при каждой регистрации.
+// Используется вместе с файловым хранилищем ссылок, чтобы учетные записи, которым переданы ссылки,
+// переживали перезапуск сервиса
+type FileUserStorerBackend struct {
+	*LocmemUserStorerBackend
+	filename string
+	mu       sync.Mutex
+}
+
+func NewFileUserStorerBackend(filename string) (*FileUserStorerBackend, error) {
+	backend := &FileUserStorerBackend{
+		LocmemUserStorerBackend: NewLocmemUserStorerBackend(),
+		filename:                filename,
+	}
+	var records []UserRecord
+	if err := loadJSON(filename, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		backend.users[record.ID] = record
+		backend.byEmail[record.Email] = record.ID
+	}
+	return backend, nil
+}
+
+// SaveUser сохраняет учетную запись и перезаписывает файл. Если записать файл не удалось,
+// учетная запись не сохраняется
+func (backend *FileUserStorerBackend) SaveUser(ctx context.Context, record UserRecord) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if err := backend.LocmemUserStorerBackend.SaveUser(ctx, record); err != nil {
+		return err
+	}
+	if err := dumpJSON(backend.filename, backend.snapshot()); err != nil {
+		backend.deleteUser(record)
+		return err
+	}
+	return nil
+}
+
+// Cleanup удаляет учетные записи из памяти и с диска
+func (backend *FileUserStorerBackend) Cleanup() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.LocmemUserStorerBackend.Cleanup()
+	if err := os.Remove(backend.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		panic(err)
+	}
+}
+
+const initUsersSQL = `
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    CHECK (id <> ''),
+    CHECK (email <> '')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_uniq_idx ON users (email);
+`
+
+type DatabaseUserStorerBackend struct {
+	DB      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewDatabaseUserStorerBackend(db *pgxpool.Pool, timeout time.Duration) (*DatabaseUserStorerBackend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := db.Exec(ctx, initUsersSQL); err != nil {
+		return nil, err
+	}
+	return &DatabaseUserStorerBackend{db, timeout}, nil
+}
+
+func (backend DatabaseUserStorerBackend) SaveUser(ctx context.Context, record UserRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	tag, err := backend.DB.Exec(
+		ctx,
+		"INSERT INTO users (id, email, password_hash, created_at) VALUES($1, $2, $3, $4) "+
+			"ON CONFLICT (email) DO NOTHING",
+		record.ID, record.Email, record.PasswordHash, record.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserExists
+	}
+	return nil
+}
+
+func (backend DatabaseUserStorerBackend) GetUser(ctx context.Context, userID string) (UserRecord, error) {
+	return backend.getUser(ctx, "id", userID)
+}
+
+func (backend DatabaseUserStorerBackend) GetUserByEmail(ctx context.Context, email string) (UserRecord, error) {
+	return backend.getUser(ctx, "email", email)
+}
+
+// getUser ищет учетную запись по значению уникальной колонки column
+func (backend DatabaseUserStorerBackend) getUser(ctx context.Context, column, value string) (UserRecord, error) {
+	var record UserRecord
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	err := backend.DB.QueryRow(
+		ctx, "SELECT id, email, password_hash, created_at FROM users WHERE "+column+" = $1", value,
+	).Scan(&record.ID, &record.Email, &record.PasswordHash, &record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return UserRecord{}, ErrUserNotFound
+		}
+		return UserRecord{}, err
+	}
+	return record, nil
+}
+
+// Cleanup отчищает таблицу с учетными записями
+func (backend DatabaseUserStorerBackend) Cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), backend.timeout)
+	defer cancel()
+	if _, err := backend.DB.Exec(ctx, "TRUNCATE TABLE users"); err != nil {
+		panic(err)
+	}
+}
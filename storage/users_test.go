@@ -0,0 +1,99 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getUserStorages(t *testing.T) map[string]storage.UserStorer {
+	fileStorage, err := storage.NewFileUserStorerBackend(filepath.Join(t.TempDir(), "users"))
+	require.NoError(t, err)
+	storages := map[string]storage.UserStorer{
+		"memory": storage.NewLocmemUserStorerBackend(),
+		"file":   fileStorage,
+	}
+	shortener, err := app.New()
+	require.NoError(t, err)
+	t.Cleanup(shortener.Close)
+	if shortener.DB != nil {
+		dbStorage, err := storage.NewDatabaseUserStorerBackend(shortener.DB, shortener.Config.DatabaseQueryTimeout)
+		require.NoError(t, err)
+		t.Cleanup(dbStorage.Cleanup)
+		storages["database"] = dbStorage
+	}
+	return storages
+}
+
+func TestUserLifecycle(t *testing.T) {
+	ctx := context.TODO()
+	for name, theStorage := range getUserStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			record := storage.UserRecord{
+				ID:           "user1",
+				Email:        "gopher@go.dev",
+				PasswordHash: "hash",
+				CreatedAt:    time.Now().Truncate(time.Millisecond),
+			}
+			require.NoError(t, theStorage.SaveUser(ctx, record))
+
+			byID, err := theStorage.GetUser(ctx, "user1")
+			require.NoError(t, err)
+			assert.Equal(t, record.Email, byID.Email)
+			assert.True(t, record.CreatedAt.Equal(byID.CreatedAt))
+			byEmail, err := theStorage.GetUserByEmail(ctx, "gopher@go.dev")
+			require.NoError(t, err)
+			assert.Equal(t, "user1", byEmail.ID)
+			assert.Equal(t, "hash", byEmail.PasswordHash)
+
+			// email занят
+			err = theStorage.SaveUser(ctx, storage.UserRecord{ID: "user2", Email: "gopher@go.dev", PasswordHash: "h"})
+			assert.ErrorIs(t, err, storage.ErrUserExists)
+
+			_, err = theStorage.GetUser(ctx, "user2")
+			assert.ErrorIs(t, err, storage.ErrUserNotFound)
+			_, err = theStorage.GetUserByEmail(ctx, "rustacean@rust-lang.org")
+			assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		})
+	}
+}
+
+func TestFileUserStorageSurvivesRestart(t *testing.T) {
+	ctx := context.TODO()
+	filename := filepath.Join(t.TempDir(), "users")
+	theStorage, err := storage.NewFileUserStorerBackend(filename)
+	require.NoError(t, err)
+	require.NoError(t, theStorage.SaveUser(ctx, storage.UserRecord{ID: "user1", Email: "gopher@go.dev"}))
+	err = theStorage.SaveUser(ctx, storage.UserRecord{ID: "user2", Email: "gopher@go.dev"})
+	require.ErrorIs(t, err, storage.ErrUserExists)
+
+	// учетная запись записана на диск сразу, без закрытия хранилища
+	reopened, err := storage.NewFileUserStorerBackend(filename)
+	require.NoError(t, err)
+	record, err := reopened.GetUserByEmail(ctx, "gopher@go.dev")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", record.ID)
+	_, err = reopened.GetUser(ctx, "user2")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	err = reopened.SaveUser(ctx, storage.UserRecord{ID: "user3", Email: "gopher@go.dev"})
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	reopened.Cleanup()
+	_, err = os.Stat(filename)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFileUserStorageWontStartWithBrokenJSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, os.WriteFile(filename, []byte(`[{foo: "bar"}]`), 0600))
+	theStorage, err := storage.NewFileUserStorerBackend(filename)
+	assert.Nil(t, theStorage)
+	assert.Error(t, err)
+}
@@ -0,0 +1,552 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrWorkspaceNotFound = errors.New("workspace not found in the storage")
+var ErrMemberNotFound = errors.New("workspace member not found in the storage")
+var ErrLastOwner = errors.New("workspace must keep at least one owner")
+
+// OwnerRole - роль участника, без которого не может остаться рабочее пространство
+const OwnerRole = "owner"
+
+// WorkspaceRecord - рабочее пространство, ссылки которого принадлежат всем его участникам
+type WorkspaceRecord struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// MemberRecord - участие пользователя в рабочем пространстве с ролью
+type MemberRecord struct {
+	WorkspaceID string
+	UserID      string
+	Role        string
+	CreatedAt   time.Time
+}
+
+// Membership - рабочее пространство и роль в нем пользователя
+type Membership struct {
+	Workspace WorkspaceRecord
+	Role      string
+}
+
+type WorkspaceStorer interface {
+	// CreateWorkspace сохраняет рабочее пространство вместе с его первым участником
+	CreateWorkspace(context.Context, WorkspaceRecord, MemberRecord) error
+	// GetWorkspace возвращает рабочее пространство по идентификатору, либо ErrWorkspaceNotFound
+	GetWorkspace(context.Context, string) (WorkspaceRecord, error)
+	// GetUserMemberships возвращает рабочие пространства, в которых состоит пользователь, в порядке создания
+	GetUserMemberships(context.Context, string) ([]Membership, error)
+	// GetMember возвращает участника рабочего пространства, либо ErrMemberNotFound
+	GetMember(ctx context.Context, workspaceID, userID string) (MemberRecord, error)
+	// GetMembers возвращает участников рабочего пространства в порядке их добавления
+	GetMembers(context.Context, string) ([]MemberRecord, error)
+	// SaveMember добавляет участника либо меняет его роль. Для несуществующего пространства - ErrWorkspaceNotFound,
+	// а если участник - последний владелец пространства и лишается этой роли, то ErrLastOwner
+	SaveMember(context.Context, MemberRecord) error
+	// DeleteMember удаляет участника из рабочего пространства, либо возвращает ErrMemberNotFound.
+	// Последнего владельца пространства удалить нельзя - ErrLastOwner
+	DeleteMember(ctx context.Context, workspaceID, userID string) error
+	Cleanup()
+}
+
+type LocmemWorkspaceStorerBackend struct {
+	workspaces map[string]WorkspaceRecord
+	members    map[string]map[string]MemberRecord // рабочее пространство -> пользователь -> участие
+	mu         sync.RWMutex
+}
+
+func NewLocmemWorkspaceStorerBackend() *LocmemWorkspaceStorerBackend {
+	return &LocmemWorkspaceStorerBackend{
+		workspaces: make(map[string]WorkspaceRecord),
+		members:    make(map[string]map[string]MemberRecord),
+	}
+}
+
+func (backend *LocmemWorkspaceStorerBackend) CreateWorkspace(
+	ctx context.Context, workspace WorkspaceRecord, owner MemberRecord,
+) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.workspaces[workspace.ID] = workspace
+	backend.members[workspace.ID] = map[string]MemberRecord{owner.UserID: owner}
+	return nil
+}
+
+func (backend *LocmemWorkspaceStorerBackend) GetWorkspace(ctx context.Context, id string) (WorkspaceRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	workspace, found := backend.workspaces[id]
+	if !found {
+		return WorkspaceRecord{}, ErrWorkspaceNotFound
+	}
+	return workspace, nil
+}
+
+func (backend *LocmemWorkspaceStorerBackend) GetUserMemberships(
+	ctx context.Context, userID string,
+) ([]Membership, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	memberships := make([]Membership, 0)
+	for workspaceID, members := range backend.members {
+		if member, ok := members[userID]; ok {
+			memberships = append(memberships, Membership{Workspace: backend.workspaces[workspaceID], Role: member.Role})
+		}
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		a, b := memberships[i].Workspace, memberships[j].Workspace
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.ID < b.ID
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	return memberships, nil
+}
+
+func (backend *LocmemWorkspaceStorerBackend) GetMember(
+	ctx context.Context, workspaceID, userID string,
+) (MemberRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	member, found := backend.members[workspaceID][userID]
+	if !found {
+		return MemberRecord{}, ErrMemberNotFound
+	}
+	return member, nil
+}
+
+func (backend *LocmemWorkspaceStorerBackend) GetMembers(
+	ctx context.Context, workspaceID string,
+) ([]MemberRecord, error) {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	members := make([]MemberRecord, 0, len(backend.members[workspaceID]))
+	for _, member := range backend.members[workspaceID] {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].CreatedAt.Equal(members[j].CreatedAt) {
+			return members[i].UserID < members[j].UserID
+		}
+		return members[i].CreatedAt.Before(members[j].CreatedAt)
+	})
+	return members, nil
+}
+
+func (backend *LocmemWorkspaceStorerBackend) SaveMember(ctx context.Context, member MemberRecord) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	members, found := backend.members[member.WorkspaceID]
+	if !found {
+		return ErrWorkspaceNotFound
+	}
+	// при смене роли участник сохраняет время добавления
+	if existing, ok := members[member.UserID]; ok {
+		if member.Role != OwnerRole && isLastOwner(members, existing) {
+			return ErrLastOwner
+		}
+		member.CreatedAt = existing.CreatedAt
+	}
+	members[member.UserID] = member
+	return nil
+}
+
+func (backend *LocmemWorkspaceStorerBackend) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	member, found := backend.members[workspaceID][userID]
+	if !found {
+		return ErrMemberNotFound
+	}
+	if isLastOwner(backend.members[workspaceID], member) {
+		return ErrLastOwner
+	}
+	delete(backend.members[workspaceID], userID)
+	return nil
+}
+
+// isLastOwner сообщает, что member - единственный владелец среди участников members
+func isLastOwner(members map[string]MemberRecord, member MemberRecord) bool {
+	if member.Role != OwnerRole {
+		return false
+	}
+	for _, other := range members {
+		if other.Role == OwnerRole && other.UserID != member.UserID {
+			return false
+		}
+	}
+	return true
+}
+
+func (backend *LocmemWorkspaceStorerBackend) Cleanup() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.workspaces = make(map[string]WorkspaceRecord)
+	backend.members = make(map[string]map[string]MemberRecord)
+}
+
+// workspacesSnapshot - рабочие пространства и их участники в виде, пригодном для записи на диск
+type workspacesSnapshot struct {
+	Workspaces []WorkspaceRecord
+	Members    []MemberRecord
+}
+
+// snapshot возвращает копию всех рабочих пространств и их участников
+func (backend *LocmemWorkspaceStorerBackend) snapshot() workspacesSnapshot {
+	backend.mu.RLock()
+	defer backend.mu.RUnlock()
+	state := workspacesSnapshot{
+		Workspaces: make([]WorkspaceRecord, 0, len(backend.workspaces)),
+		Members:    make([]MemberRecord, 0),
+	}
+	for _, workspace := range backend.workspaces {
+		state.Workspaces = append(state.Workspaces, workspace)
+	}
+	for _, members := range backend.members {
+		for _, member := range members {
+			state.Members = append(state.Members, member)
+		}
+	}
+	return state
+}
+
+// restore заменяет рабочие пространства и их участников сохраненными в снимке
+func (backend *LocmemWorkspaceStorerBackend) restore(state workspacesSnapshot) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.workspaces = make(map[string]WorkspaceRecord, len(state.Workspaces))
+	backend.members = make(map[string]map[string]MemberRecord, len(state.Workspaces))
+	for _, workspace := range state.Workspaces {
+		backend.workspaces[workspace.ID] = workspace
+		backend.members[workspace.ID] = make(map[string]MemberRecord)
+	}
+	for _, member := range state.Members {
+		if members, ok := backend.members[member.WorkspaceID]; ok {
+			members[member.UserID] = member
+		}
+	}
+}
+
+// FileWorkspaceStorerBackend хранит рабочие пространства в памяти и записывает их на диск при каждом изменении.
+// Используется вместе с файловым хранилищем ссылок, чтобы ссылки рабочих пространств
+// не теряли участников после перезапуска сервиса
+type FileWorkspaceStorerBackend struct {
+	*LocmemWorkspaceStorerBackend
+	filename string
+	mu       sync.Mutex
+}
+
+func NewFileWorkspaceStorerBackend(filename string) (*FileWorkspaceStorerBackend, error) {
+	backend := &FileWorkspaceStorerBackend{
+		LocmemWorkspaceStorerBackend: NewLocmemWorkspaceStorerBackend(),
+		filename:                     filename,
+	}
+	var state workspacesSnapshot
+	if err := loadJSON(filename, &state); err != nil {
+		return nil, err
+	}
+	backend.restore(state)
+	return backend, nil
+}
+
+func (backend *FileWorkspaceStorerBackend) CreateWorkspace(
+	ctx context.Context, workspace WorkspaceRecord, owner MemberRecord,
+) error {
+	return backend.update(func() error {
+		return backend.LocmemWorkspaceStorerBackend.CreateWorkspace(ctx, workspace, owner)
+	})
+}
+
+func (backend *FileWorkspaceStorerBackend) SaveMember(ctx context.Context, member MemberRecord) error {
+	return backend.update(func() error {
+		return backend.LocmemWorkspaceStorerBackend.SaveMember(ctx, member)
+	})
+}
+
+func (backend *FileWorkspaceStorerBackend) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	return backend.update(func() error {
+		return backend.LocmemWorkspaceStorerBackend.DeleteMember(ctx, workspaceID, userID)
+	})
+}
+
+// update применяет изменение и перезаписывает файл. Если записать файл не удалось, изменение откатывается
+func (backend *FileWorkspaceStorerBackend) update(change func() error) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	before := backend.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := dumpJSON(backend.filename, backend.snapshot()); err != nil {
+		backend.restore(before)
+		return err
+	}
+	return nil
+}
+
+// Cleanup удаляет рабочие пространства из памяти и с диска
+func (backend *FileWorkspaceStorerBackend) Cleanup() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.LocmemWorkspaceStorerBackend.Cleanup()
+	if err := os.Remove(backend.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		panic(err)
+	}
+}
+
+const initWorkspacesSQL = `
+CREATE TABLE IF NOT EXISTS workspaces (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    CHECK (id <> ''),
+    CHECK (name <> '')
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id TEXT NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    role TEXT NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id),
+    CHECK (user_id <> '')
+);
+
+CREATE INDEX IF NOT EXISTS workspace_members_user_id_idx ON workspace_members (user_id);
+`
+
+type DatabaseWorkspaceStorerBackend struct {
+	DB      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewDatabaseWorkspaceStorerBackend(
+	db *pgxpool.Pool, timeout time.Duration,
+) (*DatabaseWorkspaceStorerBackend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := db.Exec(ctx, initWorkspacesSQL); err != nil {
+		return nil, err
+	}
+	return &DatabaseWorkspaceStorerBackend{db, timeout}, nil
+}
+
+func (backend DatabaseWorkspaceStorerBackend) CreateWorkspace(
+	ctx context.Context, workspace WorkspaceRecord, owner MemberRecord,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	tx, err := backend.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+	if _, err := tx.Exec(
+		ctx,
+		"INSERT INTO workspaces (id, name, created_at) VALUES($1, $2, $3)",
+		workspace.ID, workspace.Name, workspace.CreatedAt,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES($1, $2, $3, $4)",
+		owner.WorkspaceID, owner.UserID, owner.Role, owner.CreatedAt,
+	); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (backend DatabaseWorkspaceStorerBackend) GetWorkspace(ctx context.Context, id string) (WorkspaceRecord, error) {
+	var workspace WorkspaceRecord
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	err := backend.DB.QueryRow(
+		ctx, "SELECT id, name, created_at FROM workspaces WHERE id = $1", id,
+	).Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return WorkspaceRecord{}, ErrWorkspaceNotFound
+		}
+		return WorkspaceRecord{}, err
+	}
+	return workspace, nil
+}
+
+func (backend DatabaseWorkspaceStorerBackend) GetUserMemberships(
+	ctx context.Context, userID string,
+) ([]Membership, error) {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	rows, err := backend.DB.Query(
+		ctx,
+		"SELECT w.id, w.name, w.created_at, m.role FROM workspace_members m "+
+			"JOIN workspaces w ON w.id = m.workspace_id WHERE m.user_id = $1 ORDER BY w.created_at, w.id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	memberships := make([]Membership, 0)
+	for rows.Next() {
+		var m Membership
+		if err := rows.Scan(&m.Workspace.ID, &m.Workspace.Name, &m.Workspace.CreatedAt, &m.Role); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+func (backend DatabaseWorkspaceStorerBackend) GetMember(
+	ctx context.Context, workspaceID, userID string,
+) (MemberRecord, error) {
+	member := MemberRecord{WorkspaceID: workspaceID, UserID: userID}
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	err := backend.DB.QueryRow(
+		ctx,
+		"SELECT role, created_at FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, userID,
+	).Scan(&member.Role, &member.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return MemberRecord{}, ErrMemberNotFound
+		}
+		return MemberRecord{}, err
+	}
+	return member, nil
+}
+
+func (backend DatabaseWorkspaceStorerBackend) GetMembers(
+	ctx context.Context, workspaceID string,
+) ([]MemberRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	rows, err := backend.DB.Query(
+		ctx,
+		"SELECT user_id, role, created_at FROM workspace_members WHERE workspace_id = $1 ORDER BY created_at, user_id",
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]MemberRecord, 0)
+	for rows.Next() {
+		member := MemberRecord{WorkspaceID: workspaceID}
+		if err := rows.Scan(&member.UserID, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (backend DatabaseWorkspaceStorerBackend) SaveMember(ctx context.Context, member MemberRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	tx, err := backend.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+	if found, err := lockWorkspace(ctx, tx, member.WorkspaceID); err != nil {
+		return err
+	} else if !found {
+		return ErrWorkspaceNotFound
+	}
+	if _, err := tx.Exec(
+		ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES($1, $2, $3, $4) "+
+			"ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role",
+		member.WorkspaceID, member.UserID, member.Role, member.CreatedAt,
+	); err != nil {
+		return err
+	}
+	if err := ensureOwnerLeft(ctx, tx, member.WorkspaceID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (backend DatabaseWorkspaceStorerBackend) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, backend.timeout)
+	defer cancel()
+	tx, err := backend.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+	if found, err := lockWorkspace(ctx, tx, workspaceID); err != nil {
+		return err
+	} else if !found {
+		return ErrMemberNotFound
+	}
+	tag, err := tx.Exec(
+		ctx, "DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", workspaceID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMemberNotFound
+	}
+	if err := ensureOwnerLeft(ctx, tx, workspaceID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// lockWorkspace блокирует строку рабочего пространства до конца транзакции, так что одновременные изменения
+// его участников выполняются по очереди и не могут вместе лишить пространство всех владельцев
+func lockWorkspace(ctx context.Context, tx pgx.Tx, workspaceID string) (bool, error) {
+	var id string
+	err := tx.QueryRow(ctx, "SELECT id FROM workspaces WHERE id = $1 FOR UPDATE", workspaceID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ensureOwnerLeft возвращает ErrLastOwner, если после изменения в транзакции у пространства не осталось владельцев
+func ensureOwnerLeft(ctx context.Context, tx pgx.Tx, workspaceID string) error {
+	var hasOwner bool
+	err := tx.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND role = $2)",
+		workspaceID, OwnerRole,
+	).Scan(&hasOwner)
+	if err != nil {
+		return err
+	}
+	if !hasOwner {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// Cleanup отчищает таблицы с рабочими пространствами и их участниками
+func (backend DatabaseWorkspaceStorerBackend) Cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), backend.timeout)
+	defer cancel()
+	if _, err := backend.DB.Exec(ctx, "TRUNCATE TABLE workspace_members, workspaces"); err != nil {
+		panic(err)
+	}
+}
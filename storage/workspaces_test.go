@@ -0,0 +1,190 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sergeii/practikum-go-url-shortener/internal/app"
+	"github.com/sergeii/practikum-go-url-shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getWorkspaceStorages(t *testing.T) map[string]storage.WorkspaceStorer {
+	fileStorage, err := storage.NewFileWorkspaceStorerBackend(filepath.Join(t.TempDir(), "workspaces"))
+	require.NoError(t, err)
+	storages := map[string]storage.WorkspaceStorer{
+		"memory": storage.NewLocmemWorkspaceStorerBackend(),
+		"file":   fileStorage,
+	}
+	shortener, err := app.New()
+	require.NoError(t, err)
+	t.Cleanup(shortener.Close)
+	if shortener.DB != nil {
+		dbStorage, err := storage.NewDatabaseWorkspaceStorerBackend(shortener.DB, shortener.Config.DatabaseQueryTimeout)
+		require.NoError(t, err)
+		t.Cleanup(dbStorage.Cleanup)
+		storages["database"] = dbStorage
+	}
+	return storages
+}
+
+func TestWorkspaceLifecycle(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now().Truncate(time.Millisecond)
+	for name, theStorage := range getWorkspaceStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			marketing := storage.WorkspaceRecord{ID: "ws1", Name: "Marketing", CreatedAt: now}
+			owner := storage.MemberRecord{WorkspaceID: "ws1", UserID: "user1", Role: "owner", CreatedAt: now}
+			require.NoError(t, theStorage.CreateWorkspace(ctx, marketing, owner))
+			sales := storage.WorkspaceRecord{ID: "ws2", Name: "Sales", CreatedAt: now.Add(time.Second)}
+			require.NoError(t, theStorage.CreateWorkspace(ctx, sales, storage.MemberRecord{
+				WorkspaceID: "ws2", UserID: "user2", Role: "owner", CreatedAt: now.Add(time.Second),
+			}))
+
+			workspace, err := theStorage.GetWorkspace(ctx, "ws1")
+			require.NoError(t, err)
+			assert.Equal(t, "Marketing", workspace.Name)
+			_, err = theStorage.GetWorkspace(ctx, "ws3")
+			assert.ErrorIs(t, err, storage.ErrWorkspaceNotFound)
+
+			editor := storage.MemberRecord{WorkspaceID: "ws1", UserID: "user2", Role: "editor", CreatedAt: now.Add(time.Minute)}
+			require.NoError(t, theStorage.SaveMember(ctx, editor))
+			err = theStorage.SaveMember(ctx, storage.MemberRecord{WorkspaceID: "ws3", UserID: "user2", Role: "viewer"})
+			assert.ErrorIs(t, err, storage.ErrWorkspaceNotFound)
+
+			memberships, err := theStorage.GetUserMemberships(ctx, "user2")
+			require.NoError(t, err)
+			require.Len(t, memberships, 2)
+			assert.Equal(t, "Marketing", memberships[0].Workspace.Name)
+			assert.Equal(t, "editor", memberships[0].Role)
+			assert.Equal(t, "owner", memberships[1].Role)
+
+			// смена роли не меняет порядок участников
+			require.NoError(t, theStorage.SaveMember(ctx, storage.MemberRecord{
+				WorkspaceID: "ws1", UserID: "user2", Role: "viewer", CreatedAt: now.Add(-time.Hour),
+			}))
+			members, err := theStorage.GetMembers(ctx, "ws1")
+			require.NoError(t, err)
+			require.Len(t, members, 2)
+			assert.Equal(t, "user1", members[0].UserID)
+			assert.Equal(t, "owner", members[0].Role)
+			assert.Equal(t, "user2", members[1].UserID)
+
+			// единственного владельца нельзя ни понизить, ни удалить
+			err = theStorage.SaveMember(ctx, storage.MemberRecord{WorkspaceID: "ws1", UserID: "user1", Role: "editor"})
+			assert.ErrorIs(t, err, storage.ErrLastOwner)
+			assert.ErrorIs(t, theStorage.DeleteMember(ctx, "ws1", "user1"), storage.ErrLastOwner)
+			member, err := theStorage.GetMember(ctx, "ws1", "user1")
+			require.NoError(t, err)
+			assert.Equal(t, "owner", member.Role)
+
+			member, err = theStorage.GetMember(ctx, "ws1", "user2")
+			require.NoError(t, err)
+			assert.Equal(t, "viewer", member.Role)
+			require.NoError(t, theStorage.DeleteMember(ctx, "ws1", "user2"))
+			_, err = theStorage.GetMember(ctx, "ws1", "user2")
+			assert.ErrorIs(t, err, storage.ErrMemberNotFound)
+			assert.ErrorIs(t, theStorage.DeleteMember(ctx, "ws1", "user2"), storage.ErrMemberNotFound)
+
+			memberships, err = theStorage.GetUserMemberships(ctx, "user3")
+			require.NoError(t, err)
+			assert.Empty(t, memberships)
+		})
+	}
+}
+
+func TestWorkspaceKeepsOwnerUnderConcurrentChanges(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+	for name, theStorage := range getWorkspaceStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				workspaceID := fmt.Sprintf("ws%d", i)
+				require.NoError(t, theStorage.CreateWorkspace(
+					ctx,
+					storage.WorkspaceRecord{ID: workspaceID, Name: "Marketing", CreatedAt: now},
+					storage.MemberRecord{WorkspaceID: workspaceID, UserID: "user1", Role: "owner", CreatedAt: now},
+				))
+				require.NoError(t, theStorage.SaveMember(ctx, storage.MemberRecord{
+					WorkspaceID: workspaceID, UserID: "user2", Role: "owner", CreatedAt: now,
+				}))
+				// два владельца одновременно понижают и удаляют друг друга: один из них должен остаться
+				var wg sync.WaitGroup
+				errs := make([]error, 2)
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					errs[0] = theStorage.SaveMember(ctx, storage.MemberRecord{
+						WorkspaceID: workspaceID, UserID: "user1", Role: "viewer", CreatedAt: now,
+					})
+				}()
+				go func() {
+					defer wg.Done()
+					errs[1] = theStorage.DeleteMember(ctx, workspaceID, "user2")
+				}()
+				wg.Wait()
+
+				members, err := theStorage.GetMembers(ctx, workspaceID)
+				require.NoError(t, err)
+				owners := 0
+				for _, member := range members {
+					if member.Role == "owner" {
+						owners++
+					}
+				}
+				assert.Equal(t, 1, owners)
+				if errs[0] == nil {
+					assert.ErrorIs(t, errs[1], storage.ErrLastOwner)
+				} else {
+					assert.ErrorIs(t, errs[0], storage.ErrLastOwner)
+					assert.NoError(t, errs[1])
+				}
+			}
+		})
+	}
+}
+
+func TestFileWorkspaceStorageSurvivesRestart(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now().Truncate(time.Millisecond)
+	filename := filepath.Join(t.TempDir(), "workspaces")
+	theStorage, err := storage.NewFileWorkspaceStorerBackend(filename)
+	require.NoError(t, err)
+	require.NoError(t, theStorage.CreateWorkspace(
+		ctx,
+		storage.WorkspaceRecord{ID: "ws1", Name: "Marketing", CreatedAt: now},
+		storage.MemberRecord{WorkspaceID: "ws1", UserID: "user1", Role: "owner", CreatedAt: now},
+	))
+	require.NoError(t, theStorage.SaveMember(ctx, storage.MemberRecord{
+		WorkspaceID: "ws1", UserID: "user2", Role: "editor", CreatedAt: now.Add(time.Second),
+	}))
+	require.NoError(t, theStorage.SaveMember(ctx, storage.MemberRecord{
+		WorkspaceID: "ws1", UserID: "user3", Role: "viewer", CreatedAt: now.Add(time.Minute),
+	}))
+	require.NoError(t, theStorage.DeleteMember(ctx, "ws1", "user3"))
+
+	// изменения записаны на диск сразу, без закрытия хранилища
+	reopened, err := storage.NewFileWorkspaceStorerBackend(filename)
+	require.NoError(t, err)
+	workspace, err := reopened.GetWorkspace(ctx, "ws1")
+	require.NoError(t, err)
+	assert.Equal(t, "Marketing", workspace.Name)
+	members, err := reopened.GetMembers(ctx, "ws1")
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "user1", members[0].UserID)
+	assert.Equal(t, "owner", members[0].Role)
+	assert.Equal(t, "user2", members[1].UserID)
+	assert.Equal(t, "editor", members[1].Role)
+	assert.ErrorIs(t, reopened.DeleteMember(ctx, "ws1", "user1"), storage.ErrLastOwner)
+
+	reopened.Cleanup()
+	_, err = os.Stat(filename)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}